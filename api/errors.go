@@ -3,18 +3,34 @@ package api
 import "errors"
 
 var (
-	ErrInvalidRequest = errors.New("invalid request")
-	ErrInternal       = errors.New("internal error")
-	ErrUnauthorized   = errors.New("unauthorized")
+	ErrInvalidRequest   = errors.New("invalid request")
+	ErrInternal         = errors.New("internal error")
+	ErrUnauthorized     = errors.New("unauthorized")
+	ErrForbidden        = errors.New("forbidden")
+	ErrMethodNotAllowed = errors.New("method not allowed")
 
 	ErrBasicTokenRequired    = errors.New("basic token is required")
 	ErrCouldNotDecodeToken   = errors.New("could not decode provided token")
 	ErrMetadataNotProvided   = errors.New("metadata not provided")
 	ErrAuthorizationRequired = errors.New("authorization header is required")
+	ErrInvalidToken          = errors.New("session token is invalid or expired")
+	ErrRefreshTokenRequired  = errors.New("refresh_token is required")
+	ErrAccountLocked         = errors.New("too many failed login attempts, try again later")
+	ErrInvalidAPIKey         = errors.New("API key is invalid or revoked")
+	ErrInsufficientScope     = errors.New("API key does not grant access to this operation")
+	ErrAccountDisabled       = errors.New("account is disabled")
+	ErrOTPRequired           = errors.New("two-factor authentication code is required")
+	ErrInvalidOTP            = errors.New("two-factor authentication code is invalid")
+	ErrOIDCNotConfigured     = errors.New("single sign-on is not configured")
+	ErrInvalidOIDCState      = errors.New("single sign-on state is invalid or expired")
+	ErrOIDCLoginFailed       = errors.New("single sign-on login failed")
 
 	ErrNickAlreadyUsed       = errors.New("this nick is already used")
 	ErrNickRequired          = errors.New("nick is required")
+	ErrInvalidNick           = errors.New("nick can not contain `:`, `[`, `]` nor control characters")
 	ErrPasswordRequired      = errors.New("password is required")
+	ErrInvalidPassword       = errors.New("password is incorrect")
+	ErrInvalidResetToken     = errors.New("password reset token is invalid or expired")
 	ErrUserNotFound          = errors.New("user not found")
 	ErrChannelNotFound       = errors.New("channel not found")
 	ErrChannelDoesNotExist   = errors.New("channel does not exist")
@@ -29,4 +45,32 @@ var (
 	ErrToMissing             = errors.New("`to` is required")
 	ErrInvalidRange          = errors.New("invalid range. `from` must be before `to`")
 	ErrMaxReportSizeExceeded = errors.New("max report size exceeded")
+	ErrSessionNotFound       = errors.New("session not found")
+	ErrStreamingUnsupported  = errors.New("streaming is not supported")
+	ErrAPIKeyNameRequired    = errors.New("API key's `name` is required")
+	ErrScopesRequired        = errors.New("at least one scope is required")
+	ErrInvalidScope          = errors.New("unknown scope")
+	ErrAPIKeyNotFound        = errors.New("API key not found")
+	ErrNotServiceAccount     = errors.New("API keys of other users can be managed for service accounts only")
+	ErrInvalidRole           = errors.New("invalid `role` value. Must be one of: admin, user, service")
+	ErrCannotChangeOwnRole   = errors.New("you can not change your own role")
+	ErrTOTPAlreadyEnabled    = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnrolled       = errors.New("two-factor authentication enrollment was not started")
+	ErrTOTPNotEnabled        = errors.New("two-factor authentication is not enabled")
+	ErrIdentityNotFound      = errors.New("external identity is not linked to any user")
+	ErrIdentityAlreadyLinked = errors.New("external identity is already linked to a user")
+	ErrProfileFieldTooLong   = errors.New("profile field is too long")
+	ErrInvalidTimezone       = errors.New("unknown `timezone`. Must be an IANA time zone name, e.g. Europe/Warsaw")
+	ErrTooManyCustomFields   = errors.New("too many custom fields")
+	ErrCustomFieldNameEmpty  = errors.New("custom field's name is required")
+	ErrAvatarTooLarge        = errors.New("avatar is too large")
+	ErrUnsupportedAvatarType = errors.New("avatar must be a PNG, JPEG, GIF or WebP image")
+	ErrBlobNotFound          = errors.New("file not found")
+	ErrCannotBlockSelf       = errors.New("you can not block yourself")
+	ErrPreferenceTooLong     = errors.New("preference value is too long")
+	ErrInvalidNotification   = errors.New("invalid `notification_level` value. Must be one of: all, mentions, none")
+	ErrTooManyChannelPrefs   = errors.New("too many channels in preferences")
+	ErrInvalidCursor         = errors.New("invalid history cursor")
+	ErrConflictingCursors    = errors.New("only one of `before` and `after` can be set")
+	ErrInvalidSort           = errors.New("invalid `sort` value. Must be one of: relevance, time")
 )
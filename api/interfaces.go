@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"time"
 )
 
 // SockchatChannelStore manages chat channels (rooms) and dispatches messages among their members
@@ -13,20 +14,147 @@ type SockchatChannelStore interface {
 	DisconnectUser(user SockchatUserHandler)
 	IsUserPresentIn(user SockchatUserHandler, channel string) bool
 	ChannelExists(name string) bool
+	AddObserver(channel string, observer SockchatChannelObserver) error
+	RemoveObserver(channel string, observer SockchatChannelObserver)
+	// NotifyNickChanged tells members of channels the user is in about their new nick
+	NotifyNickChanged(user SockchatUserHandler, oldNick string)
+	// ChannelsOf returns names of channels the user is member of, sorted
+	ChannelsOf(nick string) []string
+}
+
+// SockchatChannelObserver receives events of a channel without being its member
+type SockchatChannelObserver interface {
+	Write(msg SocketMessage)
 }
 
 // SockchatProfileStore manages DB-stored user profiles
 type SockchatProfileStore interface {
 	Create(ctx context.Context, u *CreateProfileRequest) error
+	CreateServiceAccount(ctx context.Context, req *CreateServiceAccountRequest) error
 	Edit(ctx context.Context, nick string, u *EditProfileRequest) error
 	IsAuthValid(ctx context.Context, nick, password string) bool
 	GetProfile(ctx context.Context, nick string) (*PublicProfile, error)
+	GetRole(ctx context.Context, nick string) (Role, error)
+	ChangePassword(ctx context.Context, nick string, req *ChangePasswordRequest) error
+	GetAccount(ctx context.Context, nick string) (*Account, error)
+	ListAccounts(ctx context.Context, offset, limit int) ([]*Account, error)
+	SetDisabled(ctx context.Context, nick string, disabled bool) error
+	SetRole(ctx context.Context, nick string, role Role) error
+	// SetAvatar replaces avatar of the user with the uploaded image
+	SetAvatar(ctx context.Context, nick string, image []byte) (*PublicProfile, error)
+	// GetAvatar returns image stored under the key from avatar's URL
+	GetAvatar(ctx context.Context, key string) (*Avatar, error)
+	SearchProfiles(ctx context.Context, req *SearchUsersRequest) ([]*PublicProfile, error)
+	// SetHiddenFromDirectory hides the user from search results; their profile is still returned by nick
+	SetHiddenFromDirectory(ctx context.Context, nick string, hidden bool) error
+	ChangeNick(ctx context.Context, nick, newNick string) error
+	GetUserID(ctx context.Context, nick string) (int64, error)
+	ResolveNicks(ctx context.Context, ids []int64) (map[int64]string, error)
+}
+
+// SockchatPasswordResetService lets users who forgot their password set a new one
+type SockchatPasswordResetService interface {
+	RequestReset(ctx context.Context, nick string) error
+	Reset(ctx context.Context, req *ResetPasswordRequest) error
+}
+
+// SockchatNotifier delivers notifications to users outside of the chat (e.g. by e-mail)
+type SockchatNotifier interface {
+	NotifyPasswordReset(ctx context.Context, nick, token string) error
+}
+
+// SockchatSessionStore issues and verifies session tokens
+type SockchatSessionStore interface {
+	Issue(ctx context.Context, nick string) (*SessionTokens, error)
+	Verify(ctx context.Context, token string) (string, error)
+	Refresh(ctx context.Context, refreshToken string) (*SessionTokens, error)
+	Revoke(ctx context.Context, token string) error
+	// RevokeAll invalidates all tokens issued to the user so far
+	RevokeAll(ctx context.Context, nick string) error
+}
+
+// SockchatAPIKeyStore manages API keys, which authenticate integrations with limited scopes
+type SockchatAPIKeyStore interface {
+	Create(ctx context.Context, owner string, req *CreateAPIKeyRequest) (*CreatedAPIKey, error)
+	List(ctx context.Context, owner string) ([]*APIKey, error)
+	Revoke(ctx context.Context, owner string, id int64) error
+	Verify(ctx context.Context, key string) (*Principal, error)
+}
+
+// SockchatTwoFactorStore manages TOTP second factor of users
+type SockchatTwoFactorStore interface {
+	Enroll(ctx context.Context, nick string) (*TOTPEnrollment, error)
+	Confirm(ctx context.Context, nick, code string) (*RecoveryCodes, error)
+	Disable(ctx context.Context, nick, code string) error
+	// Verify succeeds for users without TOTP enabled, otherwise it requires a valid code
+	Verify(ctx context.Context, nick, code string) error
+}
+
+// SockchatOIDCProvider signs users in with an external OpenID Connect identity provider
+type SockchatOIDCProvider interface {
+	// AuthCodeURL returns URL of the provider's authorization endpoint the user should be redirected to
+	AuthCodeURL(ctx context.Context) (string, error)
+	// Authenticate exchanges authorization code for ID token and returns nick of the linked user
+	Authenticate(ctx context.Context, code, state string) (string, error)
+}
+
+// SockchatPersonalDataService erases and exports all data stored about a user
+type SockchatPersonalDataService interface {
+	Delete(ctx context.Context, nick string) error
+	// Export returns a zip archive with the profile and all messages authored by the user
+	Export(ctx context.Context, nick string) ([]byte, error)
+}
+
+// SockchatBlockList manages nicks blocked by users; messages of blocked authors are not delivered to the blocker
+type SockchatBlockList interface {
+	Block(ctx context.Context, nick, blocked string) error
+	Unblock(ctx context.Context, nick, blocked string) error
+	ListBlocked(ctx context.Context, nick string) ([]string, error)
+	IsBlocked(ctx context.Context, recipient, author string) bool
+	RenameUser(nick, newNick string)
+}
+
+// SockchatPreferences stores settings which clients of the user share, e.g. muted channels or notification levels
+type SockchatPreferences interface {
+	GetPreferences(ctx context.Context, nick string) (*Preferences, error)
+	UpdatePreferences(ctx context.Context, nick string, prefs *Preferences) error
+}
+
+// SockchatLoginGuard throttles password logins by nick and client IP
+type SockchatLoginGuard interface {
+	Check(ctx context.Context, nick, ip string) error
+	RecordFailure(ctx context.Context, nick, ip string) error
+	RecordSuccess(ctx context.Context, nick, ip string)
+}
+
+// SockchatAuditLog records security-relevant events
+type SockchatAuditLog interface {
+	Record(ctx context.Context, event *AuditEvent)
 }
 
 // SockchatMessageStore manages messages in ES
 type SockchatMessageStore interface {
 	IndexMessage(msg *MessageEvent) (string, error)
-	FindMessages(ctx context.Context, channel, query string) (ChannelHistory, error)
+	// FindMessages returns a page of messages of the channel matching the query, newest first
+	FindMessages(ctx context.Context, query *HistoryQuery) (*ChannelHistoryPage, error)
+	// SearchMessages searches messages of all requested channels; Channels and Limit of the request must be set
+	SearchMessages(ctx context.Context, req *SearchMessagesRequest) (*SearchMessagesResponse, error)
+	// FindMessagesByAuthor matches messages by id of the author, so messages sent under their previous nicks are included
+	FindMessagesByAuthor(ctx context.Context, author string, authorID int64) (ChannelHistory, error)
+	AnonymizeMessagesByAuthor(ctx context.Context, author string, authorID int64) error
+	// RenameAuthor moves messages sent under the old nick to the new one
+	RenameAuthor(ctx context.Context, author string, authorID int64, newNick string) error
+}
+
+// SockchatMessageIndexer stores messages in the background, so that they can be delivered before they are indexed
+type SockchatMessageIndexer interface {
+	Enqueue(msg *MessageEvent) error
+}
+
+// SockchatRetentionPolicy tells how long messages of channels are kept
+type SockchatRetentionPolicy interface {
+	// RetainedSince returns timestamp of the oldest message of the channel that is kept, 0 if messages are kept forever
+	RetainedSince(channel string, now time.Time) int64
 }
 
 // SockchatUserManager manages user handlers that store connections and send messages to them
@@ -34,6 +162,9 @@ type SockchatUserManager interface {
 	AddConnection(conn SockchatWebsocketConnection, nick string)
 	RemoveConnection(conn SockchatWebsocketConnection)
 	GetHandler(nick string) (SockchatUserHandler, bool)
+	GetConnectionHandler(conn SockchatWebsocketConnection) (SockchatUserHandler, bool)
+	RenameUser(nick, newNick string)
+	DisconnectUser(nick string) int
 }
 
 // SockchatUserHandler manages user actions from multiple connections
@@ -44,6 +175,7 @@ type SockchatUserHandler interface {
 	RemoveConnection(conn SockchatWebsocketConnection)
 	GetActiveConnectionsCount() int
 	GetNick() string
+	SetNick(nick string)
 }
 
 // SockchatWebsocketConnection represents single websocket connection
@@ -51,6 +183,7 @@ type SockchatWebsocketConnection interface {
 	WriteSocketMsg(m SocketMessage)
 	ReadSocketMsg() (*SocketMessage, error)
 	ReadMsg() ([]byte, error)
+	Close() error
 }
 
 // SockchatReportsService generates reports based on user activity
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"encoding/base64"
 	"encoding/json"
 )
 
@@ -28,6 +29,14 @@ func UnmarshalChannelUserChangeEvent(requestBytes json.RawMessage) (*ChannelUser
 	return &channelUserChangeEvent, nil
 }
 
+func UnmarshalNickChangeEvent(requestBytes json.RawMessage) (*NickChangeEvent, error) {
+	nickChangeEvent := NickChangeEvent{}
+	if err := json.Unmarshal(requestBytes, &nickChangeEvent); err != nil {
+		return nil, err
+	}
+	return &nickChangeEvent, nil
+}
+
 func UnmarshalMessageRequest(requestBytes json.RawMessage) (*SendMessageRequest, error) {
 	messageRequest := SendMessageRequest{}
 	if err := json.Unmarshal(requestBytes, &messageRequest); err != nil {
@@ -59,3 +68,152 @@ func UnmarshalEditProfileRequest(requestBytes json.RawMessage) (*EditProfileRequ
 	}
 	return &editProfileRequest, nil
 }
+
+func UnmarshalRefreshSessionRequest(requestBytes json.RawMessage) (*RefreshSessionRequest, error) {
+	refreshSessionRequest := RefreshSessionRequest{}
+	if err := json.Unmarshal(requestBytes, &refreshSessionRequest); err != nil {
+		return nil, err
+	}
+	return &refreshSessionRequest, nil
+}
+
+func UnmarshalLogoutRequest(requestBytes json.RawMessage) (*LogoutRequest, error) {
+	logoutRequest := LogoutRequest{}
+	if err := json.Unmarshal(requestBytes, &logoutRequest); err != nil {
+		return nil, err
+	}
+	return &logoutRequest, nil
+}
+
+func UnmarshalChangePasswordRequest(requestBytes json.RawMessage) (*ChangePasswordRequest, error) {
+	changePasswordRequest := ChangePasswordRequest{}
+	if err := json.Unmarshal(requestBytes, &changePasswordRequest); err != nil {
+		return nil, err
+	}
+	return &changePasswordRequest, nil
+}
+
+func UnmarshalChangeNickRequest(requestBytes json.RawMessage) (*ChangeNickRequest, error) {
+	changeNickRequest := ChangeNickRequest{}
+	if err := json.Unmarshal(requestBytes, &changeNickRequest); err != nil {
+		return nil, err
+	}
+	return &changeNickRequest, nil
+}
+
+func UnmarshalRequestPasswordResetRequest(requestBytes json.RawMessage) (*RequestPasswordResetRequest, error) {
+	requestPasswordResetRequest := RequestPasswordResetRequest{}
+	if err := json.Unmarshal(requestBytes, &requestPasswordResetRequest); err != nil {
+		return nil, err
+	}
+	return &requestPasswordResetRequest, nil
+}
+
+func UnmarshalResetPasswordRequest(requestBytes json.RawMessage) (*ResetPasswordRequest, error) {
+	resetPasswordRequest := ResetPasswordRequest{}
+	if err := json.Unmarshal(requestBytes, &resetPasswordRequest); err != nil {
+		return nil, err
+	}
+	return &resetPasswordRequest, nil
+}
+
+func UnmarshalCreateAPIKeyRequest(requestBytes json.RawMessage) (*CreateAPIKeyRequest, error) {
+	createAPIKeyRequest := CreateAPIKeyRequest{}
+	if err := json.Unmarshal(requestBytes, &createAPIKeyRequest); err != nil {
+		return nil, err
+	}
+	return &createAPIKeyRequest, nil
+}
+
+func UnmarshalRevokeAPIKeyRequest(requestBytes json.RawMessage) (*RevokeAPIKeyRequest, error) {
+	revokeAPIKeyRequest := RevokeAPIKeyRequest{}
+	if err := json.Unmarshal(requestBytes, &revokeAPIKeyRequest); err != nil {
+		return nil, err
+	}
+	return &revokeAPIKeyRequest, nil
+}
+
+func UnmarshalTOTPCodeRequest(requestBytes json.RawMessage) (*TOTPCodeRequest, error) {
+	totpCodeRequest := TOTPCodeRequest{}
+	if err := json.Unmarshal(requestBytes, &totpCodeRequest); err != nil {
+		return nil, err
+	}
+	return &totpCodeRequest, nil
+}
+
+func UnmarshalListUsersRequest(requestBytes json.RawMessage) (*ListUsersRequest, error) {
+	listUsersRequest := ListUsersRequest{}
+	if err := json.Unmarshal(requestBytes, &listUsersRequest); err != nil {
+		return nil, err
+	}
+	return &listUsersRequest, nil
+}
+
+func UnmarshalAccountRequest(requestBytes json.RawMessage) (*AccountRequest, error) {
+	accountRequest := AccountRequest{}
+	if err := json.Unmarshal(requestBytes, &accountRequest); err != nil {
+		return nil, err
+	}
+	return &accountRequest, nil
+}
+
+func UnmarshalSetRoleRequest(requestBytes json.RawMessage) (*SetRoleRequest, error) {
+	setRoleRequest := SetRoleRequest{}
+	if err := json.Unmarshal(requestBytes, &setRoleRequest); err != nil {
+		return nil, err
+	}
+	return &setRoleRequest, nil
+}
+
+func UnmarshalCreateServiceAccountRequest(requestBytes json.RawMessage) (*CreateServiceAccountRequest, error) {
+	createServiceAccountRequest := CreateServiceAccountRequest{}
+	if err := json.Unmarshal(requestBytes, &createServiceAccountRequest); err != nil {
+		return nil, err
+	}
+	return &createServiceAccountRequest, nil
+}
+
+func UnmarshalDirectoryVisibilityRequest(requestBytes json.RawMessage) (*DirectoryVisibilityRequest, error) {
+	directoryVisibilityRequest := DirectoryVisibilityRequest{}
+	if err := json.Unmarshal(requestBytes, &directoryVisibilityRequest); err != nil {
+		return nil, err
+	}
+	return &directoryVisibilityRequest, nil
+}
+
+func UnmarshalBlockRequest(requestBytes json.RawMessage) (*BlockRequest, error) {
+	blockRequest := BlockRequest{}
+	if err := json.Unmarshal(requestBytes, &blockRequest); err != nil {
+		return nil, err
+	}
+	return &blockRequest, nil
+}
+
+func UnmarshalPreferences(requestBytes json.RawMessage) (*Preferences, error) {
+	preferences := Preferences{}
+	if err := json.Unmarshal(requestBytes, &preferences); err != nil {
+		return nil, err
+	}
+	return &preferences, nil
+}
+
+// EncodeCursor returns the cursor in opaque form, which clients pass back as is
+func EncodeCursor(cursor *HistoryCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeCursor(encoded string) (*HistoryCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor struct {
+		Timestamp int64  `json:"ts"`
+		Seq       *int64 `json:"seq"`
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Seq == nil {
+		return nil, ErrInvalidCursor
+	}
+	return &HistoryCursor{Timestamp: cursor.Timestamp, Seq: *cursor.Seq}, nil
+}
@@ -2,6 +2,55 @@ package api
 
 import "time"
 
+const (
+	RoleAdmin   Role = "admin"
+	RoleUser    Role = "user"
+	RoleService Role = "service"
+)
+
+// Roles which may be granted to a user
+var Roles = []Role{RoleAdmin, RoleUser, RoleService}
+
+const (
+	ScopeHistoryRead       Scope = "history:read"
+	ScopeMessagesWrite     Scope = "messages:write"
+	ScopeReportsRead       Scope = "reports:read"
+	ScopeProfileRead       Scope = "profile:read"
+	ScopeChannelsSubscribe Scope = "channels:subscribe"
+)
+
+// Scopes which may be granted to an API key
+var Scopes = []Scope{ScopeHistoryRead, ScopeMessagesWrite, ScopeReportsRead, ScopeProfileRead, ScopeChannelsSubscribe}
+
+const (
+	AuditAccountLocked         = "account_locked"
+	AuditAccountDisabled       = "account_disabled"
+	AuditAccountEnabled        = "account_enabled"
+	AuditUserDisconnected      = "user_disconnected"
+	AuditTOTPEnabled           = "totp_enabled"
+	AuditTOTPDisabled          = "totp_disabled"
+	AuditUserProvisioned       = "user_provisioned"
+	AuditAccountDeleted        = "account_deleted"
+	AuditNickChanged           = "nick_changed"
+	AuditServiceAccountCreated = "service_account_created"
+	AuditRoleChanged           = "role_changed"
+)
+
+const (
+	NotifyAll      NotificationLevel = "all"
+	NotifyMentions NotificationLevel = "mentions"
+	NotifyNone     NotificationLevel = "none"
+)
+
+// NotificationLevels lists levels accepted in preferences
+var NotificationLevels = []NotificationLevel{NotifyAll, NotifyMentions, NotifyNone}
+
+const (
+	MaxAvatarSize = 1 << 20
+	// AvatarsPath is the HTTP path under which avatars are served, followed by their key
+	AvatarsPath = "/avatars/"
+)
+
 const (
 	GroupByDay        GroupBy = "day"
 	GroupByHour       GroupBy = "hour"
@@ -10,29 +59,161 @@ const (
 )
 
 // For messages sent from server
+// MessageEvent is stored with both nick & immutable id of the author, so that history survives nick changes
 type MessageEvent struct {
 	Text      string `json:"text"`
 	Channel   string `json:"channel"`
 	Author    string `json:"author"`
+	AuthorID  int64  `json:"author_id,omitempty" mapstructure:"author_id"`
 	Timestamp int64  `json:"timestamp"`
 }
 
+type Avatar struct {
+	ContentType string
+	Image       []byte
+}
+
 type PublicProfile struct {
-	Nick        string `json:"nick"`
-	Description string `json:"description"`
+	Nick         string            `json:"nick"`
+	Description  string            `json:"description"`
+	DisplayName  string            `json:"display_name,omitempty"`
+	Pronouns     string            `json:"pronouns,omitempty"`
+	Timezone     string            `json:"timezone,omitempty"`
+	AvatarURL    string            `json:"avatar_url,omitempty"`
+	CustomFields map[string]string `json:"custom_fields,omitempty"`
+}
+
+// Account holds administrative details of a user
+type Account struct {
+	Nick     string `json:"nick"`
+	Role     Role   `json:"role"`
+	Disabled bool   `json:"disabled"`
+}
+
+type SessionTokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresAt    int64  `json:"expires_at"`
+}
+
+// AuditEvent records a security-relevant action
+type AuditEvent struct {
+	Type      string    `json:"type"`
+	Nick      string    `json:"nick,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	Details   string    `json:"details,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
 }
 
 type ChannelHistory []*MessageEvent
 
+// HistoryQuery selects one page of messages of the channel
+type HistoryQuery struct {
+	Channel         string
+	Search          string
+	ExcludedAuthors []string
+	// From skips messages older than the timestamp
+	From  int64
+	Limit int
+	// Before and After are cursors taken from NextCursor of a previous page; at most one of them may be set.
+	// Before pages towards older messages, After towards newer ones
+	Before string
+	After  string
+}
+
+// ChannelHistoryPage holds messages newest first; NextCursor is empty when there are no more messages in the paging direction
+type ChannelHistoryPage struct {
+	Messages   ChannelHistory `json:"messages"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// HistoryCursor points at the message a page ended on; Seq, increasing in the order messages were stored,
+// breaks ties between messages sent at the same second
+type HistoryCursor struct {
+	Timestamp int64 `json:"ts"`
+	Seq       int64 `json:"seq"`
+}
+
 type EmptyMessage struct{}
 
 type GroupBy string
 
+// SearchSort orders results of message search; relevance is the default when there is a query, time otherwise
+type SearchSort string
+
+const (
+	SortByRelevance SearchSort = "relevance"
+	SortByTime      SearchSort = "time"
+)
+
+// SearchResult is a message found by search; Highlights are fragments of its text with matches wrapped in <em> tags
+type SearchResult struct {
+	Message    *MessageEvent `json:"message"`
+	Score      float64       `json:"score"`
+	Highlights []string      `json:"highlights,omitempty"`
+}
+
+// NotificationLevel tells clients which messages of a channel the user wants to be notified about
+type NotificationLevel string
+
+// Preferences are settings of a user shared by all of their clients.
+// UpdatePreferences replaces all of them; users who never saved preferences get the defaults.
+type Preferences struct {
+	Theme                string                       `json:"theme"`
+	Language             string                       `json:"language"`
+	NotificationLevel    NotificationLevel            `json:"notification_level"`
+	MutedChannels        []string                     `json:"muted_channels"`
+	ChannelNotifications map[string]NotificationLevel `json:"channel_notifications"`
+}
+
+// Role determines what user is allowed to do apart from chatting
+type Role string
+
+// Scope limits what an API key can be used for
+type Scope string
+
+// Principal is the authenticated caller; APIKeyID is set if the caller used an API key
+type Principal struct {
+	Nick     string
+	APIKeyID int64
+	Scopes   []Scope
+}
+
+// Allows reports whether the principal may access an operation requiring the scope.
+// Users authenticated with password or session token are not limited by scopes.
+func (p *Principal) Allows(scope Scope) bool {
+	if p.APIKeyID == 0 {
+		return true
+	}
+	for _, granted := range p.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey describes a key without its secret value
+type APIKey struct {
+	ID        int64   `json:"id"`
+	Name      string  `json:"name"`
+	Scopes    []Scope `json:"scopes"`
+	CreatedAt int64   `json:"created_at"`
+}
+
+// CreatedAPIKey carries the secret value, which is returned only once
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
 type UserActivityReportOptions struct {
-	Author  string
-	GroupBy GroupBy
-	From    time.Time
-	To      time.Time
+	Author string
+	// AuthorID matches messages of the author sent under any of their nicks; zero matches by Author only
+	AuthorID int64
+	GroupBy  GroupBy
+	From     time.Time
+	To       time.Time
 }
 
 type DistributionEntry struct {
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"encoding/json"
+
 	pb "github.com/kacperf531/sockchat/protobuf"
 )
 
@@ -8,16 +10,205 @@ func CreateProfileRequestFromProto(in *pb.RegisterProfileRequest) *CreateProfile
 	return &CreateProfileRequest{Nick: in.Nick, Password: in.Password}
 }
 
+func LoginRequestFromProto(in *pb.LoginRequest) *LoginRequest {
+	return &LoginRequest{Nick: in.Nick, Password: in.Password, OTP: in.Otp}
+}
+
+func RefreshSessionRequestFromProto(in *pb.RefreshSessionRequest) *RefreshSessionRequest {
+	return &RefreshSessionRequest{RefreshToken: in.RefreshToken}
+}
+
+func LogoutRequestFromProto(in *pb.LogoutRequest) *LogoutRequest {
+	return &LogoutRequest{RefreshToken: in.RefreshToken}
+}
+
+func SessionTokensToProto(in *SessionTokens) *pb.SessionTokens {
+	return &pb.SessionTokens{
+		AccessToken:  in.AccessToken,
+		RefreshToken: in.RefreshToken,
+		ExpiresAt:    in.ExpiresAt,
+	}
+}
+
 func GetProfileRequestFromProto(in *pb.GetProfileRequest) *GetProfileRequest {
 	return &GetProfileRequest{Nick: in.Nick}
 }
 
 func EditProfileRequestFromProto(in *pb.EditProfileRequest) *EditProfileRequest {
-	return &EditProfileRequest{Description: in.Description}
+	return &EditProfileRequest{
+		Description:  in.Description,
+		DisplayName:  in.DisplayName,
+		Pronouns:     in.Pronouns,
+		Timezone:     in.Timezone,
+		CustomFields: in.CustomFields,
+	}
+}
+
+func ChangePasswordRequestFromProto(in *pb.ChangePasswordRequest) *ChangePasswordRequest {
+	return &ChangePasswordRequest{OldPassword: in.OldPassword, NewPassword: in.NewPassword}
+}
+
+func ChangeNickRequestFromProto(in *pb.ChangeNickRequest) *ChangeNickRequest {
+	return &ChangeNickRequest{NewNick: in.NewNick}
+}
+
+func ChangePasswordResponseToProto(in *ChangePasswordResponse) *pb.ChangePasswordResponse {
+	res := &pb.ChangePasswordResponse{}
+	if in.Tokens != nil {
+		res.Tokens = SessionTokensToProto(in.Tokens)
+	}
+	return res
+}
+
+func ChangeNickResponseToProto(in *ChangeNickResponse) *pb.ChangeNickResponse {
+	res := &pb.ChangeNickResponse{Nick: in.Nick}
+	if in.Tokens != nil {
+		res.Tokens = SessionTokensToProto(in.Tokens)
+	}
+	return res
+}
+
+func PreferencesFromProto(in *pb.Preferences) *Preferences {
+	out := &Preferences{Theme: in.Theme, Language: in.Language, NotificationLevel: NotificationLevel(in.NotificationLevel), MutedChannels: in.MutedChannels}
+	if len(in.ChannelNotifications) > 0 {
+		out.ChannelNotifications = make(map[string]NotificationLevel, len(in.ChannelNotifications))
+		for channel, level := range in.ChannelNotifications {
+			out.ChannelNotifications[channel] = NotificationLevel(level)
+		}
+	}
+	return out
+}
+
+func PreferencesToProto(in *Preferences) *pb.Preferences {
+	out := &pb.Preferences{Theme: in.Theme, Language: in.Language, NotificationLevel: string(in.NotificationLevel), MutedChannels: in.MutedChannels}
+	if len(in.ChannelNotifications) > 0 {
+		out.ChannelNotifications = make(map[string]string, len(in.ChannelNotifications))
+		for channel, level := range in.ChannelNotifications {
+			out.ChannelNotifications[channel] = string(level)
+		}
+	}
+	return out
+}
+
+func RequestPasswordResetRequestFromProto(in *pb.RequestPasswordResetRequest) *RequestPasswordResetRequest {
+	return &RequestPasswordResetRequest{Nick: in.Nick}
+}
+
+func ResetPasswordRequestFromProto(in *pb.ResetPasswordRequest) *ResetPasswordRequest {
+	return &ResetPasswordRequest{Token: in.Token, NewPassword: in.NewPassword}
+}
+
+func CreateAPIKeyRequestFromProto(in *pb.CreateAPIKeyRequest) *CreateAPIKeyRequest {
+	scopes := make([]Scope, len(in.Scopes))
+	for i, v := range in.Scopes {
+		scopes[i] = Scope(v)
+	}
+	return &CreateAPIKeyRequest{Name: in.Name, Scopes: scopes, Account: in.Account}
+}
+
+func APIKeyToProto(in *APIKey) *pb.APIKey {
+	scopes := make([]string, len(in.Scopes))
+	for i, v := range in.Scopes {
+		scopes[i] = string(v)
+	}
+	return &pb.APIKey{
+		Id:        in.ID,
+		Name:      in.Name,
+		Scopes:    scopes,
+		CreatedAt: in.CreatedAt,
+	}
+}
+
+func APIKeysToProto(in []*APIKey) []*pb.APIKey {
+	out := make([]*pb.APIKey, len(in))
+	for i, v := range in {
+		out[i] = APIKeyToProto(v)
+	}
+	return out
+}
+
+func TOTPEnrollmentToProto(in *TOTPEnrollment) *pb.TOTPEnrollment {
+	return &pb.TOTPEnrollment{Secret: in.Secret, Uri: in.URI}
+}
+
+func RecoveryCodesToProto(in *RecoveryCodes) *pb.RecoveryCodes {
+	return &pb.RecoveryCodes{RecoveryCodes: in.RecoveryCodes}
+}
+
+func CreatedAPIKeyToProto(in *CreatedAPIKey) *pb.CreatedAPIKey {
+	return &pb.CreatedAPIKey{ApiKey: APIKeyToProto(&in.APIKey), Key: in.Key}
+}
+
+func ListUsersRequestFromProto(in *pb.ListUsersRequest) *ListUsersRequest {
+	return &ListUsersRequest{Offset: int(in.Offset), Limit: int(in.Limit)}
+}
+
+func SearchUsersRequestFromProto(in *pb.SearchUsersRequest) *SearchUsersRequest {
+	return &SearchUsersRequest{Query: in.Query, Fuzzy: in.Fuzzy, Offset: int(in.Offset), Limit: int(in.Limit)}
+}
+
+func ProfilesToProto(in []*PublicProfile) []*pb.Profile {
+	out := make([]*pb.Profile, len(in))
+	for i, v := range in {
+		out[i] = ProfileToProto(v)
+	}
+	return out
+}
+
+func AccountRequestFromProto(in *pb.AccountRequest) *AccountRequest {
+	return &AccountRequest{Nick: in.Nick}
+}
+
+func SetRoleRequestFromProto(in *pb.SetRoleRequest) *SetRoleRequest {
+	return &SetRoleRequest{Nick: in.Nick, Role: Role(in.Role)}
+}
+
+func CreateServiceAccountRequestFromProto(in *pb.CreateServiceAccountRequest) *CreateServiceAccountRequest {
+	return &CreateServiceAccountRequest{Nick: in.Nick, Description: in.Description}
+}
+
+func AccountToProto(in *Account) *pb.Account {
+	return &pb.Account{Nick: in.Nick, Role: string(in.Role), Disabled: in.Disabled}
+}
+
+func AccountsToProto(in []*Account) []*pb.Account {
+	out := make([]*pb.Account, len(in))
+	for i, v := range in {
+		out[i] = AccountToProto(v)
+	}
+	return out
 }
 
 func GetChannelHistoryRequestFromProto(in *pb.GetChannelHistoryRequest) *GetChannelHistoryRequest {
-	return &GetChannelHistoryRequest{Channel: in.Channel, Search: in.Search}
+	return &GetChannelHistoryRequest{
+		Channel:     in.Channel,
+		Search:      in.Search,
+		HideBlocked: in.HideBlocked,
+		Limit:       int(in.Limit),
+		Before:      in.Before,
+		After:       in.After,
+	}
+}
+
+func SearchMessagesRequestFromProto(in *pb.SearchMessagesRequest) *SearchMessagesRequest {
+	return &SearchMessagesRequest{
+		Query:    in.Query,
+		Channels: in.Channels,
+		Authors:  in.Authors,
+		From:     in.From,
+		To:       in.To,
+		Sort:     SearchSort(in.Sort),
+		Offset:   int(in.Offset),
+		Limit:    int(in.Limit),
+	}
+}
+
+func SearchMessagesResponseToProto(in *SearchMessagesResponse) *pb.SearchMessagesResponse {
+	results := make([]*pb.SearchResult, len(in.Results))
+	for i, v := range in.Results {
+		results[i] = &pb.SearchResult{Message: MessageEventToProto(v.Message), Score: v.Score, Highlights: v.Highlights}
+	}
+	return &pb.SearchMessagesResponse{Total: in.Total, Results: results}
 }
 
 func MessageEventToProto(in *MessageEvent) *pb.ChatMessage {
@@ -25,6 +216,7 @@ func MessageEventToProto(in *MessageEvent) *pb.ChatMessage {
 		Channel:   in.Channel,
 		Text:      in.Text,
 		Author:    in.Author,
+		AuthorId:  in.AuthorID,
 		Timestamp: in.Timestamp,
 	}
 }
@@ -39,8 +231,13 @@ func ChannelHistoryToProto(in ChannelHistory) []*pb.ChatMessage {
 
 func ProfileToProto(in *PublicProfile) *pb.Profile {
 	return &pb.Profile{
-		Nick:        in.Nick,
-		Description: in.Description,
+		Nick:         in.Nick,
+		Description:  in.Description,
+		DisplayName:  in.DisplayName,
+		Pronouns:     in.Pronouns,
+		Timezone:     in.Timezone,
+		AvatarUrl:    in.AvatarURL,
+		CustomFields: in.CustomFields,
 	}
 }
 
@@ -71,3 +268,57 @@ func messageCountDistributionToProto(in []DistributionEntry) []*pb.MessageCount
 	}
 	return out
 }
+
+func ChatActionToSocketMessage(in *pb.ChatAction) SocketMessage {
+	switch in.Action {
+	case CreateAction, JoinAction, LeaveAction:
+		return NewSocketMessage(in.Action, ChannelRequest{Name: in.Channel})
+	case SendMessageAction:
+		return NewSocketMessage(in.Action, SendMessageRequest{Channel: in.Channel, Text: in.Text})
+	case BlockAction, UnblockAction:
+		return NewSocketMessage(in.Action, BlockRequest{Nick: in.Nick})
+	case GetPreferencesAction:
+		return NewSocketMessage(in.Action, EmptyMessage{})
+	case UpdatePreferencesAction:
+		if in.Preferences == nil {
+			return NewSocketMessage(in.Action, Preferences{})
+		}
+		return NewSocketMessage(in.Action, PreferencesFromProto(in.Preferences))
+	default:
+		return SocketMessage{Action: in.Action}
+	}
+}
+
+func SocketMessageToChatEvent(in SocketMessage) *pb.ChatEvent {
+	out := &pb.ChatEvent{Event: in.Action}
+	switch in.Action {
+	case NewMessageEvent:
+		if msg, err := UnmarshalMessageEvent(in.Payload); err == nil {
+			out.Details = &pb.ChatEvent_Message{Message: MessageEventToProto(msg)}
+		}
+	case UserJoinedChannelEvent, UserLeftChannelEvent, YouLeftChannelEvent:
+		if change, err := UnmarshalChannelUserChangeEvent(in.Payload); err == nil {
+			out.Details = &pb.ChatEvent_UserChange{UserChange: &pb.ChannelUserChange{Channel: change.Channel, Nick: change.Nick}}
+		}
+	case NickChangedEvent:
+		if change, err := UnmarshalNickChangeEvent(in.Payload); err == nil {
+			out.Details = &pb.ChatEvent_NickChange{NickChange: &pb.NickChange{Channel: change.Channel, OldNick: change.OldNick, NewNick: change.NewNick}}
+		}
+	case UserBlockedEvent, UserUnblockedEvent:
+		if req, err := UnmarshalBlockRequest(in.Payload); err == nil {
+			out.Details = &pb.ChatEvent_BlockChange{BlockChange: &pb.BlockChange{Nick: req.Nick}}
+		}
+	case PreferencesEvent, PreferencesUpdatedEvent:
+		if prefs, err := UnmarshalPreferences(in.Payload); err == nil {
+			out.Details = &pb.ChatEvent_Preferences{Preferences: PreferencesToProto(prefs)}
+		}
+	case ErrInvalidRequest.Error():
+		var details struct {
+			Description string `json:"description"`
+		}
+		if err := json.Unmarshal(in.Payload, &details); err == nil {
+			out.Details = &pb.ChatEvent_ErrorDescription{ErrorDescription: details.Description}
+		}
+	}
+	return out
+}
@@ -6,17 +6,155 @@ type CreateProfileRequest struct {
 	Description string `json:"description"`
 }
 
+// EditProfileRequest replaces all editable fields of the profile
 type EditProfileRequest struct {
+	Description  string            `json:"description"`
+	DisplayName  string            `json:"display_name"`
+	Pronouns     string            `json:"pronouns"`
+	Timezone     string            `json:"timezone"`
+	CustomFields map[string]string `json:"custom_fields"`
+}
+
+type GetAvatarRequest struct {
+	Key string
+}
+
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+// ChangePasswordResponse carries new session tokens, as all sessions of the user are revoked
+type ChangePasswordResponse struct {
+	Tokens *SessionTokens `json:"tokens,omitempty"`
+}
+
+type ChangeNickRequest struct {
+	NewNick string `json:"new_nick"`
+}
+
+// ChangeNickResponse carries new session tokens, as sessions issued for the old nick are revoked
+type ChangeNickResponse struct {
+	Nick   string         `json:"nick"`
+	Tokens *SessionTokens `json:"tokens,omitempty"`
+}
+
+type RequestPasswordResetRequest struct {
+	Nick string `json:"nick"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// Account set by an admin manages keys of that service account instead of the caller's own
+type CreateAPIKeyRequest struct {
+	Name    string  `json:"name"`
+	Scopes  []Scope `json:"scopes"`
+	Account string  `json:"account,omitempty"`
+}
+
+type RevokeAPIKeyRequest struct {
+	ID      int64  `json:"id"`
+	Account string `json:"account,omitempty"`
+}
+
+// TOTPEnrollment holds a new TOTP secret, which must be confirmed with a first code
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// For confirming and disabling TOTP; code can be a TOTP code or a recovery code
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// RecoveryCodes can be used once each instead of a TOTP code
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// SearchUsersRequest looks up users in the directory by prefix of their nick or display name.
+// Fuzzy search matches users whose nick or display name contains characters of the query in the same order.
+type SearchUsersRequest struct {
+	Query  string `json:"query"`
+	Fuzzy  bool   `json:"fuzzy"`
+	Offset int    `json:"offset"`
+	Limit  int    `json:"limit"`
+}
+
+type DirectoryVisibilityRequest struct {
+	Hidden bool `json:"hidden"`
+}
+
+type ListUsersRequest struct {
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+// For admin requests concerning a single account
+type AccountRequest struct {
+	Nick string `json:"nick"`
+}
+
+// CreateServiceAccountRequest registers a user without password, who authenticates with API keys only
+type CreateServiceAccountRequest struct {
+	Nick        string `json:"nick"`
 	Description string `json:"description"`
 }
 
+type SetRoleRequest struct {
+	Nick string `json:"nick"`
+	Role Role   `json:"role"`
+}
+
+type DisconnectUserResponse struct {
+	DisconnectedConnections int `json:"disconnected_connections"`
+}
+
 type GetProfileRequest struct {
 	Nick string `json:"nick"`
 }
 
 type GetChannelHistoryRequest struct {
-	Channel string `json:"channel"`
-	Search  string `json:"search"`
+	Channel     string `json:"channel"`
+	Search      string `json:"search"`
+	HideBlocked bool   `json:"hide_blocked"`
+	Limit       int    `json:"limit"`
+	Before      string `json:"before"`
+	After       string `json:"after"`
+}
+
+// SearchMessagesRequest searches messages of channels the caller is member of; all of them if no channels are given.
+// Query supports phrases in quotes, `+` / `|` / `-` operators and parentheses; From and To are unix timestamps.
+type SearchMessagesRequest struct {
+	Query    string     `json:"query"`
+	Channels []string   `json:"channels"`
+	Authors  []string   `json:"authors"`
+	From     int64      `json:"from"`
+	To       int64      `json:"to"`
+	Sort     SearchSort `json:"sort"`
+	Offset   int        `json:"offset"`
+	Limit    int        `json:"limit"`
+}
+
+type SearchMessagesResponse struct {
+	Total   int64           `json:"total"`
+	Results []*SearchResult `json:"results"`
+}
+
+type BlockListResponse struct {
+	Nicks []string `json:"nicks"`
+}
+
+type RefreshSessionRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
 type ErrorResponse struct {
@@ -6,16 +6,29 @@ import (
 )
 
 const (
-	LoginAction       = "login"
-	JoinAction        = "join"
-	CreateAction      = "create"
-	LeaveAction       = "leave"
-	SendMessageAction = "send_message"
-
-	UserJoinedChannelEvent = "user has joined the channel"
-	UserLeftChannelEvent   = "user has left the channel"
-	YouLeftChannelEvent    = "you have left the channel"
-	NewMessageEvent        = "new message in channel"
+	LoginAction             = "login"
+	JoinAction              = "join"
+	CreateAction            = "create"
+	LeaveAction             = "leave"
+	SendMessageAction       = "send_message"
+	BlockAction             = "block"
+	UnblockAction           = "unblock"
+	GetPreferencesAction    = "get_preferences"
+	UpdatePreferencesAction = "update_preferences"
+
+	UserJoinedChannelEvent  = "user has joined the channel"
+	UserLeftChannelEvent    = "user has left the channel"
+	YouLeftChannelEvent     = "you have left the channel"
+	NewMessageEvent         = "new message in channel"
+	DisconnectedEvent       = "you have been disconnected"
+	UserBlockedEvent        = "you have blocked the user"
+	UserUnblockedEvent      = "you have unblocked the user"
+	NickChangedEvent        = "nick_changed"
+	PreferencesEvent        = "preferences"
+	PreferencesUpdatedEvent = "preferences_updated"
+
+	// author of messages of deleted users
+	DeletedUserNick = "[deleted]"
 )
 
 func NewSocketMessage(action string, payload any) SocketMessage {
@@ -38,9 +51,19 @@ type SocketMessage struct {
 	Payload json.RawMessage `json:"payload"`
 }
 
+// Sent as the first event of an event stream session
+type EventStreamSession struct {
+	SessionID string `json:"session_id"`
+}
+
+// Nick & password, a session token or an API key are required.
+// OTP (a TOTP or recovery code) is required along with password for users with TOTP enabled.
 type LoginRequest struct {
 	Nick     string `json:"nick"`
 	Password string `json:"password"`
+	OTP      string `json:"otp,omitempty"`
+	Token    string `json:"token,omitempty"`
+	APIKey   string `json:"api_key,omitempty"`
 }
 
 // For create, join & leave requests
@@ -53,8 +76,19 @@ type ChannelUserChangeEvent struct {
 	Nick    string `json:"nick"`
 }
 
+type NickChangeEvent struct {
+	Channel string `json:"channel"`
+	OldNick string `json:"old_nick"`
+	NewNick string `json:"new_nick"`
+}
+
 // For messages sent to server
 type SendMessageRequest struct {
 	Channel string `json:"channel"`
 	Text    string `json:"text"`
 }
+
+// For block & unblock requests
+type BlockRequest struct {
+	Nick string `json:"nick"`
+}
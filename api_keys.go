@@ -0,0 +1,110 @@
+package sockchat
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kacperf531/sockchat/api"
+	"github.com/kacperf531/sockchat/storage"
+)
+
+const apiKeyPrefix = "sck_"
+
+// APIKeyService issues API keys and resolves them into principals with limited scopes.
+// Keys are kept in the store as SHA-256 hashes; their secret values are returned only on creation.
+type APIKeyService struct {
+	Store storage.APIKeyStore
+}
+
+func (s *APIKeyService) Create(ctx context.Context, owner string, req *api.CreateAPIKeyRequest) (*api.CreatedAPIKey, error) {
+	if req.Name == "" {
+		return nil, api.ErrAPIKeyNameRequired
+	}
+	if len(req.Scopes) == 0 {
+		return nil, api.ErrScopesRequired
+	}
+	for _, scope := range req.Scopes {
+		if !isKnownScope(scope) {
+			return nil, api.ErrInvalidScope
+		}
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Printf("error generating API key: %v", err)
+		return nil, api.ErrInternal
+	}
+	key := apiKeyPrefix + hex.EncodeToString(secret)
+	storedKey := &storage.APIKey{Owner: owner, Name: req.Name, KeyHash: hashAPIKey(key), Scopes: req.Scopes, CreatedAt: time.Now().Unix()}
+	if err := s.Store.InsertAPIKey(ctx, storedKey); err != nil {
+		log.Printf("error inserting API key into db: %v", err)
+		return nil, api.ErrInternal
+	}
+	return &api.CreatedAPIKey{APIKey: *apiKeyFromStorage(storedKey), Key: key}, nil
+}
+
+func (s *APIKeyService) List(ctx context.Context, owner string) ([]*api.APIKey, error) {
+	storedKeys, err := s.Store.SelectAPIKeysByOwner(ctx, owner)
+	if err != nil {
+		log.Printf("error selecting API keys from db: %v", err)
+		return nil, api.ErrInternal
+	}
+	keys := make([]*api.APIKey, len(storedKeys))
+	for i, storedKey := range storedKeys {
+		keys[i] = apiKeyFromStorage(storedKey)
+	}
+	return keys, nil
+}
+
+func (s *APIKeyService) Revoke(ctx context.Context, owner string, id int64) error {
+	err := s.Store.RevokeAPIKey(ctx, owner, id)
+	if err == api.ErrAPIKeyNotFound {
+		return err
+	}
+	if err != nil {
+		log.Printf("error revoking API key in db: %v", err)
+		return api.ErrInternal
+	}
+	return nil
+}
+
+// Verify returns principal of a valid, non-revoked key
+func (s *APIKeyService) Verify(ctx context.Context, key string) (*api.Principal, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, api.ErrInvalidAPIKey
+	}
+	storedKey, err := s.Store.SelectAPIKeyByHash(ctx, hashAPIKey(key))
+	if err == api.ErrAPIKeyNotFound {
+		return nil, api.ErrInvalidAPIKey
+	}
+	if err != nil {
+		log.Printf("error selecting API key from db: %v", err)
+		return nil, api.ErrInternal
+	}
+	if storedKey.Revoked {
+		return nil, api.ErrInvalidAPIKey
+	}
+	return &api.Principal{Nick: storedKey.Owner, APIKeyID: storedKey.ID, Scopes: storedKey.Scopes}, nil
+}
+
+func hashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+func apiKeyFromStorage(k *storage.APIKey) *api.APIKey {
+	return &api.APIKey{ID: k.ID, Name: k.Name, Scopes: k.Scopes, CreatedAt: k.CreatedAt}
+}
+
+func isKnownScope(scope api.Scope) bool {
+	for _, known := range api.Scopes {
+		if scope == known {
+			return true
+		}
+	}
+	return false
+}
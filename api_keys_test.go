@@ -0,0 +1,55 @@
+package sockchat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kacperf531/sockchat/api"
+	"github.com/kacperf531/sockchat/test_utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyService(t *testing.T) {
+	t.Parallel()
+
+	store := &test_utils.APIKeyStoreDouble{}
+	service := &APIKeyService{Store: store}
+	ctx := context.Background()
+
+	var created *api.CreatedAPIKey
+	t.Run("created key resolves to its owner with granted scopes", func(t *testing.T) {
+		var err error
+		created, err = service.Create(ctx, test_utils.ValidUserNick, &api.CreateAPIKeyRequest{Name: "bot", Scopes: []api.Scope{api.ScopeHistoryRead}})
+		require.NoError(t, err)
+
+		principal, err := service.Verify(ctx, created.Key)
+		require.NoError(t, err)
+		assert.Equal(t, test_utils.ValidUserNick, principal.Nick)
+		assert.True(t, principal.Allows(api.ScopeHistoryRead))
+		assert.False(t, principal.Allows(api.ScopeReportsRead))
+	})
+
+	t.Run("only hash of the key is stored", func(t *testing.T) {
+		stored, err := store.SelectAPIKeysByOwner(ctx, test_utils.ValidUserNick)
+		require.NoError(t, err)
+		require.Len(t, stored, 1)
+		assert.NotEqual(t, created.Key, stored[0].KeyHash)
+	})
+
+	t.Run("returns error for unknown scope", func(t *testing.T) {
+		_, err := service.Create(ctx, test_utils.ValidUserNick, &api.CreateAPIKeyRequest{Name: "bot", Scopes: []api.Scope{"everything"}})
+		assert.EqualError(t, err, api.ErrInvalidScope.Error())
+	})
+
+	t.Run("key can not be revoked by another user", func(t *testing.T) {
+		err := service.Revoke(ctx, test_utils.ValidUser2Nick, created.ID)
+		assert.EqualError(t, err, api.ErrAPIKeyNotFound.Error())
+	})
+
+	t.Run("revoked key is rejected", func(t *testing.T) {
+		require.NoError(t, service.Revoke(ctx, test_utils.ValidUserNick, created.ID))
+		_, err := service.Verify(ctx, created.Key)
+		assert.EqualError(t, err, api.ErrInvalidAPIKey.Error())
+	})
+}
@@ -0,0 +1,21 @@
+package sockchat
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/kacperf531/sockchat/api"
+)
+
+// LogAuditLog writes audit events to the server log as JSON lines
+type LogAuditLog struct{}
+
+func (l *LogAuditLog) Record(ctx context.Context, event *api.AuditEvent) {
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("error marshaling audit event %s: %v", event.Type, err)
+		return
+	}
+	log.Printf("audit: %s", eventBytes)
+}
@@ -0,0 +1,145 @@
+package sockchat
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/kacperf531/sockchat/api"
+	"github.com/kacperf531/sockchat/storage"
+)
+
+// BlockListService stores nicks blocked by users.
+// Block lists are checked for every delivered message, so they are kept in memory once loaded from the DB.
+type BlockListService struct {
+	Store    storage.BlockStore
+	Profiles api.SockchatProfileStore
+	lists    map[string]map[string]bool
+	lock     sync.RWMutex
+}
+
+func (s *BlockListService) Block(ctx context.Context, nick, blocked string) error {
+	if blocked == "" {
+		return api.ErrNickRequired
+	}
+	if blocked == nick {
+		return api.ErrCannotBlockSelf
+	}
+	if _, err := s.Profiles.GetProfile(ctx, blocked); err != nil {
+		return err
+	}
+	list, err := s.getList(ctx, nick)
+	if err != nil {
+		return err
+	}
+	if err := s.Store.InsertBlock(ctx, nick, blocked); err != nil {
+		log.Printf("error blocking %s by %s: %v", blocked, nick, err)
+		return api.ErrInternal
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	list[blocked] = true
+	return nil
+}
+
+func (s *BlockListService) Unblock(ctx context.Context, nick, blocked string) error {
+	if blocked == "" {
+		return api.ErrNickRequired
+	}
+	list, err := s.getList(ctx, nick)
+	if err != nil {
+		return err
+	}
+	if err := s.Store.DeleteBlock(ctx, nick, blocked); err != nil {
+		log.Printf("error unblocking %s by %s: %v", blocked, nick, err)
+		return api.ErrInternal
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(list, blocked)
+	return nil
+}
+
+// ListBlocked returns nicks blocked by the user in alphabetical order
+func (s *BlockListService) ListBlocked(ctx context.Context, nick string) ([]string, error) {
+	nicks, err := s.Store.SelectBlockedNicks(ctx, nick)
+	if err != nil {
+		log.Printf("error getting nicks blocked by %s: %v", nick, err)
+		return nil, api.ErrInternal
+	}
+	return nicks, nil
+}
+
+// IsBlocked reports whether the recipient blocked the author; block list which could not be loaded is treated as empty
+func (s *BlockListService) IsBlocked(ctx context.Context, recipient, author string) bool {
+	if recipient == author {
+		return false
+	}
+	list, err := s.getList(ctx, recipient)
+	if err != nil {
+		return false
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return list[author]
+}
+
+// Forget removes blocks made by the user and blocks of the user, e.g. when the account is deleted
+func (s *BlockListService) Forget(ctx context.Context, nick string) error {
+	if err := s.Store.DeleteBlocksByNick(ctx, nick); err != nil {
+		log.Printf("error deleting blocks of %s: %v", nick, err)
+		return api.ErrInternal
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.lists, nick)
+	for _, list := range s.lists {
+		delete(list, nick)
+	}
+	return nil
+}
+
+// RenameUser moves cached block list entries to the new nick; rows in the DB are renamed together with the user
+func (s *BlockListService) RenameUser(nick, newNick string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if list, ok := s.lists[nick]; ok {
+		delete(s.lists, nick)
+		s.lists[newNick] = list
+	}
+	for _, list := range s.lists {
+		if list[nick] {
+			delete(list, nick)
+			list[newNick] = true
+		}
+	}
+}
+
+func (s *BlockListService) getList(ctx context.Context, nick string) (map[string]bool, error) {
+	s.lock.RLock()
+	list, ok := s.lists[nick]
+	s.lock.RUnlock()
+	if ok {
+		return list, nil
+	}
+
+	nicks, err := s.Store.SelectBlockedNicks(ctx, nick)
+	if err != nil {
+		log.Printf("error loading block list of %s: %v", nick, err)
+		return nil, api.ErrInternal
+	}
+	list = make(map[string]bool, len(nicks))
+	for _, blocked := range nicks {
+		list[blocked] = true
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.lists == nil {
+		s.lists = make(map[string]map[string]bool)
+	}
+	if cached, ok := s.lists[nick]; ok {
+		return cached, nil
+	}
+	s.lists[nick] = list
+	return list, nil
+}
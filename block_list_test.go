@@ -0,0 +1,58 @@
+package sockchat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kacperf531/sockchat/api"
+	"github.com/kacperf531/sockchat/test_utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockListService(t *testing.T) {
+	cache := test_utils.NewTestingRedisClient(t)
+	profiles := &ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: cache}
+	blocks := &test_utils.BlockStoreDouble{}
+	service := &BlockListService{Store: blocks, Profiles: profiles}
+	ctx := context.Background()
+
+	t.Run("blocks messages of the user for the blocker only", func(t *testing.T) {
+		require.NoError(t, service.Block(ctx, test_utils.ValidUserNick, test_utils.ValidUser2Nick))
+		assert.True(t, service.IsBlocked(ctx, test_utils.ValidUserNick, test_utils.ValidUser2Nick))
+		assert.False(t, service.IsBlocked(ctx, test_utils.ValidUser2Nick, test_utils.ValidUserNick))
+		assert.False(t, service.IsBlocked(ctx, test_utils.ValidUser3Nick, test_utils.ValidUser2Nick))
+	})
+
+	t.Run("blocking the same user twice is a no-op", func(t *testing.T) {
+		require.NoError(t, service.Block(ctx, test_utils.ValidUserNick, test_utils.ValidUser2Nick))
+		nicks, err := service.ListBlocked(ctx, test_utils.ValidUserNick)
+		require.NoError(t, err)
+		assert.Equal(t, []string{test_utils.ValidUser2Nick}, nicks)
+	})
+
+	t.Run("block list is loaded from the store", func(t *testing.T) {
+		blocks.InsertBlock(ctx, test_utils.ValidUser3Nick, test_utils.ValidUserNick)
+		fresh := &BlockListService{Store: blocks, Profiles: profiles}
+		assert.True(t, fresh.IsBlocked(ctx, test_utils.ValidUser3Nick, test_utils.ValidUserNick))
+	})
+
+	t.Run("can not block yourself or a nonexistent user", func(t *testing.T) {
+		assert.Equal(t, api.ErrCannotBlockSelf, service.Block(ctx, test_utils.ValidUserNick, test_utils.ValidUserNick))
+		assert.Equal(t, api.ErrUserNotFound, service.Block(ctx, test_utils.ValidUserNick, "not_exists"))
+		assert.Equal(t, api.ErrNickRequired, service.Block(ctx, test_utils.ValidUserNick, ""))
+	})
+
+	t.Run("unblocked user's messages are delivered again", func(t *testing.T) {
+		require.NoError(t, service.Unblock(ctx, test_utils.ValidUserNick, test_utils.ValidUser2Nick))
+		assert.False(t, service.IsBlocked(ctx, test_utils.ValidUserNick, test_utils.ValidUser2Nick))
+	})
+
+	t.Run("forgets blocks made by and of the user", func(t *testing.T) {
+		require.NoError(t, service.Block(ctx, test_utils.ValidUser2Nick, test_utils.ValidUser3Nick))
+		require.NoError(t, service.Block(ctx, test_utils.ValidUser3Nick, test_utils.ValidUser2Nick))
+		require.NoError(t, service.Forget(ctx, test_utils.ValidUser3Nick))
+		assert.False(t, service.IsBlocked(ctx, test_utils.ValidUser2Nick, test_utils.ValidUser3Nick))
+		assert.False(t, service.IsBlocked(ctx, test_utils.ValidUser3Nick, test_utils.ValidUser2Nick))
+	})
+}
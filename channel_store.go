@@ -1,7 +1,9 @@
 package sockchat
 
 import (
+	"context"
 	"log"
+	"sort"
 	"sync"
 
 	"github.com/kacperf531/sockchat/api"
@@ -11,6 +13,10 @@ type ChannelStore struct {
 	Channels     map[string]*Channel
 	lock         sync.RWMutex
 	messageStore api.SockchatMessageStore
+	// BlockList is optional; messages are not delivered to members who blocked their author
+	BlockList api.SockchatBlockList
+	// Indexer is optional; without it messages are indexed before they are delivered
+	Indexer api.SockchatMessageIndexer
 }
 
 func NewChannelStore(messageStore api.SockchatMessageStore) *ChannelStore {
@@ -39,7 +45,9 @@ func (s *ChannelStore) CreateChannel(channelName string) error {
 	if s.Channels[channelName] != nil {
 		return api.ErrChannelAlreadyExists
 	}
-	s.Channels[channelName] = NewChannel()
+	channel := NewChannel()
+	channel.blockList = s.BlockList
+	s.Channels[channelName] = channel
 	return nil
 }
 
@@ -52,7 +60,7 @@ func (s *ChannelStore) AddUserToChannel(channelName string, user api.SockchatUse
 		return api.ErrUserAlreadyInChannel
 	}
 	channel.AddMember(user)
-	channel.MessageMembers(api.NewSocketMessage(api.UserJoinedChannelEvent, api.ChannelUserChangeEvent{Channel: channelName, Nick: user.GetNick()}))
+	channel.MessageMembers(api.NewSocketMessage(api.UserJoinedChannelEvent, api.ChannelUserChangeEvent{Channel: channelName, Nick: user.GetNick()}), "")
 	return nil
 }
 
@@ -65,7 +73,7 @@ func (s *ChannelStore) RemoveUserFromChannel(channelName string, user api.Sockch
 		return api.ErrUserNotInChannel
 	}
 	channel.RemoveMember(user)
-	channel.MessageMembers(api.NewSocketMessage(api.UserLeftChannelEvent, api.ChannelUserChangeEvent{Channel: channelName, Nick: user.GetNick()}))
+	channel.MessageMembers(api.NewSocketMessage(api.UserLeftChannelEvent, api.ChannelUserChangeEvent{Channel: channelName, Nick: user.GetNick()}), "")
 	return nil
 }
 
@@ -78,6 +86,46 @@ func (s *ChannelStore) DisconnectUser(user api.SockchatUserHandler) {
 	}
 }
 
+func (s *ChannelStore) NotifyNickChanged(user api.SockchatUserHandler, oldNick string) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for channelName, channel := range s.Channels {
+		if channel.HasMember(user) {
+			go channel.MessageMembers(api.NewSocketMessage(api.NickChangedEvent, api.NickChangeEvent{Channel: channelName, OldNick: oldNick, NewNick: user.GetNick()}), "")
+		}
+	}
+}
+
+func (s *ChannelStore) ChannelsOf(nick string) []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	channels := []string{}
+	for channelName, channel := range s.Channels {
+		if channel.HasMemberNamed(nick) {
+			channels = append(channels, channelName)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+func (s *ChannelStore) AddObserver(channelName string, observer api.SockchatChannelObserver) error {
+	channel, err := s.getChannel(channelName)
+	if err != nil {
+		return err
+	}
+	channel.AddObserver(observer)
+	return nil
+}
+
+func (s *ChannelStore) RemoveObserver(channelName string, observer api.SockchatChannelObserver) {
+	channel, err := s.getChannel(channelName)
+	if err != nil {
+		return
+	}
+	channel.RemoveObserver(observer)
+}
+
 func (s *ChannelStore) ChannelExists(channelName string) bool {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
@@ -97,13 +145,17 @@ func (s *ChannelStore) MessageChannel(message *api.MessageEvent) error {
 	if err != nil {
 		return err
 	}
-	_, err = s.messageStore.IndexMessage(message)
+	if s.Indexer != nil {
+		err = s.Indexer.Enqueue(message)
+	} else {
+		_, err = s.messageStore.IndexMessage(message)
+	}
 	if err != nil {
 		log.Printf("warning: failed to index message: %v", err)
 		return api.ErrMessageNotSent
 	}
 
-	go channel.MessageMembers(api.NewSocketMessage(api.NewMessageEvent, message))
+	go channel.MessageMembers(api.NewSocketMessage(api.NewMessageEvent, message), message.Author)
 	return nil
 }
 
@@ -115,8 +167,10 @@ func (s *ChannelStore) validateChannelName(channelName string) error {
 }
 
 type Channel struct {
-	members map[api.SockchatUserHandler]bool
-	lock    sync.RWMutex
+	members   map[api.SockchatUserHandler]bool
+	observers map[api.SockchatChannelObserver]bool
+	lock      sync.RWMutex
+	blockList api.SockchatBlockList
 }
 
 func (c *Channel) AddMember(user api.SockchatUserHandler) {
@@ -134,20 +188,54 @@ func (c *Channel) RemoveMember(user api.SockchatUserHandler) {
 	delete(c.members, user)
 }
 
+func (c *Channel) AddObserver(observer api.SockchatChannelObserver) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.observers == nil {
+		c.observers = make(map[api.SockchatChannelObserver]bool)
+	}
+	c.observers[observer] = true
+}
+
+func (c *Channel) RemoveObserver(observer api.SockchatChannelObserver) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.observers, observer)
+}
+
 func (c *Channel) HasMember(user api.SockchatUserHandler) bool {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
 	return c.members[user]
 }
 
-func (c *Channel) MessageMembers(message api.SocketMessage) {
+func (c *Channel) HasMemberNamed(nick string) bool {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
 	for user := range c.members {
+		if user.GetNick() == nick {
+			return true
+		}
+	}
+	return false
+}
+
+// MessageMembers skips members who blocked the author; messages without author (e.g. membership changes) are sent to everyone.
+// Observers receive all messages.
+func (c *Channel) MessageMembers(message api.SocketMessage, author string) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for user := range c.members {
+		if author != "" && c.blockList != nil && c.blockList.IsBlocked(context.Background(), user.GetNick(), author) {
+			continue
+		}
 		go user.Write(message)
 	}
+	for observer := range c.observers {
+		go observer.Write(message)
+	}
 }
 
 func NewChannel() *Channel {
-	return &Channel{members: make(map[api.SockchatUserHandler]bool)}
+	return &Channel{members: make(map[api.SockchatUserHandler]bool), observers: make(map[api.SockchatChannelObserver]bool)}
 }
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/kacperf531/sockchat/api"
+	"github.com/kacperf531/sockchat/storage"
 	"github.com/kacperf531/sockchat/test_utils"
 
 	"github.com/stretchr/testify/assert"
@@ -79,6 +80,84 @@ func TestChannelStore(t *testing.T) {
 		assert.True(t, store.IsUserPresentIn(&dummyUser, "Bar"))
 	})
 
+	t.Run("can list channels of the user", func(t *testing.T) {
+		store := NewChannelStore(messageStore)
+		member := &UserHandler{nick: "Member"}
+		for _, name := range []string{"Second", "First", "Other"} {
+			store.CreateChannel(name)
+		}
+		store.AddUserToChannel("Second", member)
+		store.AddUserToChannel("First", member)
+
+		assert.Equal(t, []string{"First", "Second"}, store.ChannelsOf("Member"))
+		assert.Empty(t, store.ChannelsOf("Stranger"))
+	})
+
+	t.Run("messages are queued for indexing when indexer is set", func(t *testing.T) {
+		spool, err := storage.NewFileSpool(t.TempDir())
+		assert.NoError(t, err)
+		indexing := &IndexingPipeline{Store: &test_utils.BulkIndexerDouble{}, Spool: spool}
+		store := NewChannelStore(messageStore)
+		store.Indexer = indexing
+		store.CreateChannel("Indexed")
+
+		assert.NoError(t, store.MessageChannel(&api.MessageEvent{Channel: "Indexed", Author: "Foo", Text: "Bar"}))
+		assert.Equal(t, 1, indexing.Stats().Queued)
+	})
+
+	t.Run("observer receives channel events without being a member", func(t *testing.T) {
+		store := NewChannelStore(&test_utils.StubMessageStore{})
+		store.CreateChannel("Observed")
+		observer := &spyChannelObserver{received: make(chan api.SocketMessage, 1)}
+		assert.NoError(t, store.AddObserver("Observed", observer))
+
+		store.MessageChannel(&api.MessageEvent{Channel: "Observed", Author: "Foo", Text: "Bar"})
+		select {
+		case msg := <-observer.received:
+			assert.Equal(t, api.NewMessageEvent, msg.Action)
+		case <-time.After(200 * time.Millisecond):
+			t.Error("observer did not receive the message")
+		}
+		assert.False(t, store.IsUserPresentIn(&dummyUser, "Observed"))
+	})
+
+	t.Run("message is not delivered to members who blocked its author", func(t *testing.T) {
+		blocks := &test_utils.BlockStoreDouble{}
+		blocks.InsertBlock(context.Background(), "Blocker", "Author")
+		store := NewChannelStore(&test_utils.StubMessageStore{})
+		store.BlockList = &BlockListService{Store: blocks}
+		store.CreateChannel("Blocking")
+		blocker := NewUserHandler("Blocker", 0, store, nil, nil)
+		blockerConn := &chanConnection{received: make(chan api.SocketMessage, 2)}
+		blocker.AddConnection(blockerConn)
+		other := NewUserHandler("Other", 0, store, nil, nil)
+		otherConn := &chanConnection{received: make(chan api.SocketMessage, 2)}
+		other.AddConnection(otherConn)
+		store.AddUserToChannel("Blocking", blocker)
+		store.AddUserToChannel("Blocking", other)
+		<-blockerConn.received // join events
+		<-blockerConn.received
+		<-otherConn.received
+
+		store.MessageChannel(&api.MessageEvent{Channel: "Blocking", Author: "Author", Text: "Bar"})
+		select {
+		case msg := <-otherConn.received:
+			assert.Equal(t, api.NewMessageEvent, msg.Action)
+		case <-time.After(200 * time.Millisecond):
+			t.Error("member did not receive the message")
+		}
+		select {
+		case msg := <-blockerConn.received:
+			t.Errorf("blocker received message %s", msg.Action)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("can not observe nonexistent channel", func(t *testing.T) {
+		err := store.AddObserver("NotExists", &spyChannelObserver{})
+		assert.ErrorIs(t, err, api.ErrChannelDoesNotExist)
+	})
+
 	t.Run("Channel stores messages from users", func(t *testing.T) {
 		store.CreateChannel("Qux")
 		store.MessageChannel(&api.MessageEvent{Channel: "Qux", Author: "Foo", Text: "Bar", Timestamp: 0})
@@ -87,8 +166,8 @@ func TestChannelStore(t *testing.T) {
 		messageFound := make(chan bool, 1)
 		go func() {
 			for {
-				msgs, _ := messageStore.FindMessages(ctx, "Qux", "")
-				if len(msgs) == 1 {
+				page, _ := messageStore.FindMessages(ctx, &api.HistoryQuery{Channel: "Qux", Limit: 10})
+				if page != nil && len(page.Messages) == 1 {
 					messageFound <- true
 					return
 				}
@@ -103,3 +182,20 @@ func TestChannelStore(t *testing.T) {
 	})
 
 }
+
+type spyChannelObserver struct {
+	received chan api.SocketMessage
+}
+
+func (o *spyChannelObserver) Write(msg api.SocketMessage) {
+	o.received <- msg
+}
+
+type chanConnection struct {
+	spyConnection
+	received chan api.SocketMessage
+}
+
+func (c *chanConnection) WriteSocketMsg(m api.SocketMessage) {
+	c.received <- m
+}
@@ -0,0 +1,333 @@
+package sockchat
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kacperf531/sockchat/api"
+	"github.com/kacperf531/sockchat/storage"
+)
+
+const (
+	DefaultIndexBatchSize     = 500
+	DefaultIndexFlushInterval = time.Second
+	DefaultIndexMaxRetries    = 3
+	DefaultIndexRetryBackoff  = 200 * time.Millisecond
+	// messages queued beyond the limit go straight to the spool
+	DefaultIndexQueueLimit = 10000
+)
+
+// IndexingPipeline indexes messages in batches in the background, so that sending a message doesn't wait for ES.
+// Batches still failing after retries are spooled on disk and indexed once ES is back.
+type IndexingPipeline struct {
+	Store storage.BulkIndexer
+	Spool storage.Spool
+	// zero values stand for the defaults
+	BatchSize     int
+	FlushInterval time.Duration
+	MaxRetries    int
+	RetryBackoff  time.Duration
+	QueueLimit    int
+
+	queue []*storage.BulkMessage
+	lock  sync.Mutex
+	// held while a batch taken off the queue or the spool is being indexed, so that rewrites do not miss it
+	inFlight sync.Mutex
+	once     sync.Once
+	flushes  chan struct{}
+	indexed  atomic.Int64
+	retried  atomic.Int64
+	dropped  atomic.Int64
+}
+
+// IndexingStats are published as metrics of the pipeline
+type IndexingStats struct {
+	Queued  int   `json:"queued"`
+	Spooled int   `json:"spooled_batches"`
+	Indexed int64 `json:"indexed"`
+	Retried int64 `json:"retried"`
+	Dropped int64 `json:"dropped"`
+}
+
+// Enqueue fails only if the message could be neither queued nor spooled
+func (p *IndexingPipeline) Enqueue(msg *api.MessageEvent) error {
+	id, err := randomToken()
+	if err != nil {
+		return err
+	}
+	queued := &storage.BulkMessage{ID: id, Seq: storage.NextSeq(), Message: msg}
+
+	p.lock.Lock()
+	if len(p.queue) >= p.queueLimit() {
+		p.lock.Unlock()
+		return p.spoolQueue(queued)
+	}
+	p.queue = append(p.queue, queued)
+	full := len(p.queue) >= p.batchSize()
+	p.lock.Unlock()
+
+	if full {
+		select {
+		case p.flushSignal() <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// spoolQueue moves the full queue along with the message to the spool as one batch.
+// The spool is written outside of the queue lock, so that other senders are not held up by the disk;
+// inFlight is held instead, so that rewrites do not miss messages on their way to the spool.
+func (p *IndexingPipeline) spoolQueue(queued *storage.BulkMessage) error {
+	p.inFlight.Lock()
+	defer p.inFlight.Unlock()
+	p.lock.Lock()
+	if len(p.queue) < p.queueLimit() {
+		// flushed meanwhile
+		p.queue = append(p.queue, queued)
+		p.lock.Unlock()
+		return nil
+	}
+	batch := append(p.queue, queued)
+	p.queue = nil
+	p.lock.Unlock()
+
+	if err := p.Spool.Push(batch); err != nil {
+		log.Printf("error spooling %d messages: %v", len(batch), err)
+		p.lock.Lock()
+		// queued messages were accepted already, so they are kept; only the new message is refused
+		p.queue = append(batch[:len(batch)-1:len(batch)-1], p.queue...)
+		p.lock.Unlock()
+		return api.ErrInternal
+	}
+	return nil
+}
+
+// Run flushes the queue until the context is done; messages left in the queue are then indexed or spooled
+func (p *IndexingPipeline) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.flushInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// ES gets one attempt, so that shutdown is not delayed by retries
+			p.flush(context.Background(), 0)
+			return
+		case <-p.flushSignal():
+			p.flush(ctx, p.maxRetries())
+		case <-ticker.C:
+			p.flush(ctx, p.maxRetries())
+			p.drainSpool(ctx)
+		}
+	}
+}
+
+func (p *IndexingPipeline) Stats() IndexingStats {
+	p.lock.Lock()
+	queued := len(p.queue)
+	p.lock.Unlock()
+	spooled, err := p.Spool.Len()
+	if err != nil {
+		log.Printf("warning: could not count spooled batches: %v", err)
+	}
+	return IndexingStats{
+		Queued:  queued,
+		Spooled: spooled,
+		Indexed: p.indexed.Load(),
+		Retried: p.retried.Load(),
+		Dropped: p.dropped.Load(),
+	}
+}
+
+// RewriteAuthor applies the rewrite to queued and spooled messages of the author, matched by nick or by id,
+// which changes made by query in the store do not reach. Batches being indexed meanwhile are waited for.
+func (p *IndexingPipeline) RewriteAuthor(author string, authorID int64, rewrite func(msg *api.MessageEvent)) error {
+	matches := func(msg *api.MessageEvent) bool {
+		return msg.Author == author || (authorID != 0 && msg.AuthorID == authorID)
+	}
+	p.inFlight.Lock()
+	defer p.inFlight.Unlock()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, queued := range p.queue {
+		if matches(queued.Message) {
+			// the queued message is shared with the broadcast, so it is replaced rather than changed in place
+			rewritten := *queued.Message
+			rewrite(&rewritten)
+			queued.Message = &rewritten
+		}
+	}
+	return p.Spool.Rewrite(func(spooled *storage.BulkMessage) bool {
+		if !matches(spooled.Message) {
+			return false
+		}
+		rewrite(spooled.Message)
+		return true
+	})
+}
+
+func (p *IndexingPipeline) flush(ctx context.Context, retries int) {
+	for {
+		p.inFlight.Lock()
+		p.lock.Lock()
+		n := len(p.queue)
+		if n == 0 {
+			p.lock.Unlock()
+			p.inFlight.Unlock()
+			return
+		}
+		if n > p.batchSize() {
+			n = p.batchSize()
+		}
+		batch := p.queue[:n:n]
+		p.queue = p.queue[n:]
+		p.lock.Unlock()
+
+		if failed := p.indexBatch(ctx, batch, retries); len(failed) > 0 {
+			p.spool(failed)
+		}
+		p.inFlight.Unlock()
+	}
+}
+
+// indexBatch retries temporary failures with exponential backoff and returns messages which still failed
+func (p *IndexingPipeline) indexBatch(ctx context.Context, batch []*storage.BulkMessage, retries int) []*storage.BulkMessage {
+	for attempt := 0; ; attempt++ {
+		failed, err := p.Store.BulkIndex(ctx, batch)
+		if err == nil {
+			p.indexed.Add(int64(len(batch) - len(failed)))
+			if len(failed) == 0 {
+				return nil
+			}
+			batch = failed
+		} else {
+			log.Printf("warning: failed to index batch of %d messages: %v", len(batch), err)
+		}
+		if attempt >= retries {
+			return batch
+		}
+		p.retried.Add(int64(len(batch)))
+		select {
+		case <-ctx.Done():
+			return batch
+		case <-time.After(p.retryBackoff() << attempt):
+		}
+	}
+}
+
+func (p *IndexingPipeline) spool(batch []*storage.BulkMessage) {
+	if err := p.Spool.Push(batch); err != nil {
+		log.Printf("error spooling %d messages, they are lost: %v", len(batch), err)
+		p.dropped.Add(int64(len(batch)))
+	}
+}
+
+// drainSpool indexes spooled batches oldest first and stops at the first failure, as ES is likely still down
+func (p *IndexingPipeline) drainSpool(ctx context.Context) {
+	p.inFlight.Lock()
+	defer p.inFlight.Unlock()
+	for ctx.Err() == nil {
+		key, batch, err := p.Spool.Peek()
+		if err != nil {
+			log.Printf("error reading spool: %v", err)
+			if key == "" {
+				return
+			}
+			// a corrupted batch would block the spool forever
+			p.Spool.Remove(key)
+			continue
+		}
+		if batch == nil {
+			return
+		}
+		failed, err := p.Store.BulkIndex(ctx, batch)
+		if err != nil {
+			return
+		}
+		p.indexed.Add(int64(len(batch) - len(failed)))
+		if len(failed) > 0 {
+			p.spool(failed)
+		}
+		if err := p.Spool.Remove(key); err != nil {
+			log.Printf("error removing indexed batch from spool: %v", err)
+			return
+		}
+		if len(failed) > 0 {
+			return
+		}
+	}
+}
+
+func (p *IndexingPipeline) flushSignal() chan struct{} {
+	p.once.Do(func() {
+		p.flushes = make(chan struct{}, 1)
+	})
+	return p.flushes
+}
+
+func (p *IndexingPipeline) batchSize() int {
+	if p.BatchSize == 0 {
+		return DefaultIndexBatchSize
+	}
+	return p.BatchSize
+}
+
+func (p *IndexingPipeline) flushInterval() time.Duration {
+	if p.FlushInterval == 0 {
+		return DefaultIndexFlushInterval
+	}
+	return p.FlushInterval
+}
+
+func (p *IndexingPipeline) maxRetries() int {
+	if p.MaxRetries == 0 {
+		return DefaultIndexMaxRetries
+	}
+	return p.MaxRetries
+}
+
+func (p *IndexingPipeline) retryBackoff() time.Duration {
+	if p.RetryBackoff == 0 {
+		return DefaultIndexRetryBackoff
+	}
+	return p.RetryBackoff
+}
+
+func (p *IndexingPipeline) queueLimit() int {
+	if p.QueueLimit == 0 {
+		return DefaultIndexQueueLimit
+	}
+	return p.QueueLimit
+}
+
+// PipelinedMessageStore changes authors of messages waiting in the indexing pipeline before changing them in the store,
+// so that messages indexed after an anonymization or a rename do not bring back the old author
+type PipelinedMessageStore struct {
+	api.SockchatMessageStore
+	Pipeline *IndexingPipeline
+}
+
+func (s *PipelinedMessageStore) AnonymizeMessagesByAuthor(ctx context.Context, author string, authorID int64) error {
+	err := s.Pipeline.RewriteAuthor(author, authorID, func(msg *api.MessageEvent) {
+		msg.Author, msg.AuthorID = api.DeletedUserNick, 0
+	})
+	if err != nil {
+		log.Printf("error anonymizing queued messages of %s: %v", author, err)
+		return api.ErrInternal
+	}
+	return s.SockchatMessageStore.AnonymizeMessagesByAuthor(ctx, author, authorID)
+}
+
+func (s *PipelinedMessageStore) RenameAuthor(ctx context.Context, author string, authorID int64, newNick string) error {
+	err := s.Pipeline.RewriteAuthor(author, authorID, func(msg *api.MessageEvent) {
+		msg.Author, msg.AuthorID = newNick, authorID
+	})
+	if err != nil {
+		log.Printf("error renaming author %s of queued messages: %v", author, err)
+		return api.ErrInternal
+	}
+	return s.SockchatMessageStore.RenameAuthor(ctx, author, authorID, newNick)
+}
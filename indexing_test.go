@@ -0,0 +1,143 @@
+package sockchat
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kacperf531/sockchat/api"
+	"github.com/kacperf531/sockchat/storage"
+	"github.com/kacperf531/sockchat/test_utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexingPipeline(t *testing.T) {
+	t.Parallel()
+
+	newPipeline := func(t *testing.T) (*IndexingPipeline, *test_utils.BulkIndexerDouble) {
+		spool, err := storage.NewFileSpool(t.TempDir())
+		require.NoError(t, err)
+		store := &test_utils.BulkIndexerDouble{}
+		pipeline := &IndexingPipeline{Store: store, Spool: spool, BatchSize: 2, FlushInterval: 20 * time.Millisecond, MaxRetries: 2, RetryBackoff: time.Millisecond}
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		go pipeline.Run(ctx)
+		return pipeline, store
+	}
+	indexedTexts := func(store *test_utils.BulkIndexerDouble) []string {
+		texts := []string{}
+		for _, msg := range store.Indexed() {
+			texts = append(texts, msg.Message.Text)
+		}
+		return texts
+	}
+
+	t.Run("indexes queued messages in batches", func(t *testing.T) {
+		pipeline, store := newPipeline(t)
+		for _, text := range []string{"foo", "bar", "baz"} {
+			require.NoError(t, pipeline.Enqueue(&api.MessageEvent{Channel: "Foo", Text: text}))
+		}
+
+		assert.Eventually(t, func() bool { return len(store.Indexed()) == 3 }, time.Second, 5*time.Millisecond)
+		assert.Equal(t, []string{"foo", "bar", "baz"}, indexedTexts(store))
+		assert.Equal(t, IndexingStats{Indexed: 3}, pipeline.Stats())
+	})
+
+	t.Run("spools messages while ES is down and indexes them once it is back", func(t *testing.T) {
+		pipeline, store := newPipeline(t)
+		store.SetDown(true)
+		require.NoError(t, pipeline.Enqueue(&api.MessageEvent{Channel: "Foo", Text: "foo"}))
+
+		assert.Eventually(t, func() bool { return pipeline.Stats().Spooled == 1 }, time.Second, 5*time.Millisecond)
+		stats := pipeline.Stats()
+		assert.Equal(t, int64(2), stats.Retried)
+		assert.Zero(t, stats.Queued)
+		assert.Empty(t, store.Indexed())
+
+		store.SetDown(false)
+		assert.Eventually(t, func() bool { return pipeline.Stats().Spooled == 0 }, time.Second, 5*time.Millisecond)
+		assert.Equal(t, []string{"foo"}, indexedTexts(store))
+	})
+
+	t.Run("spools the queue along with messages beyond the queue limit", func(t *testing.T) {
+		spool, err := storage.NewFileSpool(t.TempDir())
+		require.NoError(t, err)
+		pipeline := &IndexingPipeline{Store: &test_utils.BulkIndexerDouble{}, Spool: spool, QueueLimit: 1}
+		require.NoError(t, pipeline.Enqueue(&api.MessageEvent{Channel: "Foo", Text: "queued"}))
+		require.NoError(t, pipeline.Enqueue(&api.MessageEvent{Channel: "Foo", Text: "spooled"}))
+		assert.Equal(t, IndexingStats{Queued: 0, Spooled: 1}, pipeline.Stats())
+
+		require.NoError(t, pipeline.Enqueue(&api.MessageEvent{Channel: "Foo", Text: "queued again"}))
+		assert.Equal(t, IndexingStats{Queued: 1, Spooled: 1}, pipeline.Stats())
+		_, batch, err := spool.Peek()
+		require.NoError(t, err)
+		require.Len(t, batch, 2)
+		assert.Equal(t, "queued", batch[0].Message.Text)
+		assert.Equal(t, "spooled", batch[1].Message.Text)
+	})
+
+	t.Run("keeps the queue when it can not be spooled", func(t *testing.T) {
+		dir := t.TempDir()
+		spool, err := storage.NewFileSpool(dir)
+		require.NoError(t, err)
+		require.NoError(t, os.Remove(dir))
+		pipeline := &IndexingPipeline{Store: &test_utils.BulkIndexerDouble{}, Spool: spool, QueueLimit: 1}
+		require.NoError(t, pipeline.Enqueue(&api.MessageEvent{Channel: "Foo", Text: "queued"}))
+		assert.Equal(t, api.ErrInternal, pipeline.Enqueue(&api.MessageEvent{Channel: "Foo", Text: "refused"}))
+		require.Len(t, pipeline.queue, 1)
+		assert.Equal(t, "queued", pipeline.queue[0].Message.Text)
+	})
+
+	t.Run("flushes queued messages when stopped", func(t *testing.T) {
+		spool, err := storage.NewFileSpool(t.TempDir())
+		require.NoError(t, err)
+		store := &test_utils.BulkIndexerDouble{}
+		pipeline := &IndexingPipeline{Store: store, Spool: spool, FlushInterval: time.Hour}
+		require.NoError(t, pipeline.Enqueue(&api.MessageEvent{Channel: "Foo", Text: "foo"}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		pipeline.Run(ctx)
+		assert.Equal(t, []string{"foo"}, indexedTexts(store))
+	})
+
+	t.Run("rewrites authors of queued and spooled messages", func(t *testing.T) {
+		spool, err := storage.NewFileSpool(t.TempDir())
+		require.NoError(t, err)
+		pipeline := &IndexingPipeline{Store: &test_utils.BulkIndexerDouble{}, Spool: spool, QueueLimit: 1}
+		require.NoError(t, pipeline.Enqueue(&api.MessageEvent{Channel: "Foo", Author: "OldFoo", AuthorID: 1, Text: "spooled"}))
+		require.NoError(t, pipeline.Enqueue(&api.MessageEvent{Channel: "Foo", Author: "Bar", AuthorID: 2, Text: "other"}))
+		sent := &api.MessageEvent{Channel: "Foo", Author: "Foo", AuthorID: 1, Text: "queued"}
+		require.NoError(t, pipeline.Enqueue(sent))
+
+		require.NoError(t, pipeline.RewriteAuthor("Foo", 1, func(msg *api.MessageEvent) { msg.Author = "NewFoo" }))
+		assert.Equal(t, "NewFoo", pipeline.queue[0].Message.Author)
+		assert.Equal(t, "Foo", sent.Author, "message already broadcast must not change")
+		_, batch, err := spool.Peek()
+		require.NoError(t, err)
+		require.Len(t, batch, 2)
+		assert.Equal(t, "NewFoo", batch[0].Message.Author)
+		assert.Equal(t, "Bar", batch[1].Message.Author)
+	})
+
+	t.Run("anonymizes messages waiting to be indexed before the store", func(t *testing.T) {
+		spool, err := storage.NewFileSpool(t.TempDir())
+		require.NoError(t, err)
+		indexer := &test_utils.BulkIndexerDouble{}
+		pipeline := &IndexingPipeline{Store: indexer, Spool: spool, FlushInterval: time.Hour}
+		store := &test_utils.StubMessageStore{Messages: api.ChannelHistory{{Author: "Foo", AuthorID: 1, Text: "stored"}}}
+		messages := &PipelinedMessageStore{SockchatMessageStore: store, Pipeline: pipeline}
+		require.NoError(t, pipeline.Enqueue(&api.MessageEvent{Channel: "Foo", Author: "Foo", AuthorID: 1, Text: "queued"}))
+
+		require.NoError(t, messages.AnonymizeMessagesByAuthor(context.Background(), "Foo", 1))
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		pipeline.Run(ctx)
+		require.Len(t, indexer.Indexed(), 1)
+		assert.Equal(t, api.DeletedUserNick, indexer.Indexed()[0].Message.Author)
+		assert.Zero(t, indexer.Indexed()[0].Message.AuthorID)
+		assert.Equal(t, api.DeletedUserNick, store.Messages[0].Author)
+	})
+}
@@ -0,0 +1,147 @@
+package sockchat
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kacperf531/sockchat/api"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	DefaultMaxLoginFailures    = 10
+	DefaultLoginBackoffBase    = 250 * time.Millisecond
+	DefaultLoginLockoutPeriod  = 15 * time.Minute
+	loginFailuresKeyPrefix     = "login_failures:"
+	loginBackoffKeyPrefix      = "login_backoff:"
+	loginLockoutKeyPrefix      = "login_lockout:"
+	loginSubjectNickPrefix     = "nick:"
+	loginSubjectClientIPPrefix = "ip:"
+)
+
+// LoginGuard counts failed logins per nick and per client IP in Redis.
+// Every failure blocks further attempts for an exponentially growing period;
+// once MaxFailures is reached within LockoutPeriod, the subject is locked out for LockoutPeriod.
+type LoginGuard struct {
+	Cache         *redis.Client
+	Audit         api.SockchatAuditLog
+	MaxFailures   int
+	BackoffBase   time.Duration
+	LockoutPeriod time.Duration
+}
+
+// Check returns ErrAccountLocked if either the nick or the IP is not allowed to attempt login
+func (g *LoginGuard) Check(ctx context.Context, nick, ip string) error {
+	var keys []string
+	for _, subject := range loginSubjects(nick, ip) {
+		keys = append(keys, loginBackoffKeyPrefix+subject, loginLockoutKeyPrefix+subject)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	blocked, err := g.Cache.Exists(ctx, keys...).Result()
+	if err != nil {
+		log.Printf("error checking login lockout: %v", err)
+		return api.ErrInternal
+	}
+	if blocked > 0 {
+		return api.ErrAccountLocked
+	}
+	return nil
+}
+
+func (g *LoginGuard) RecordFailure(ctx context.Context, nick, ip string) error {
+	for _, subject := range loginSubjects(nick, ip) {
+		failuresKey := loginFailuresKeyPrefix + subject
+		failures, err := g.Cache.Incr(ctx, failuresKey).Result()
+		if err != nil {
+			log.Printf("error counting failed login: %v", err)
+			return api.ErrInternal
+		}
+		if failures == 1 {
+			g.Cache.Expire(ctx, failuresKey, g.lockoutPeriod())
+		}
+		if failures < int64(g.maxFailures()) {
+			if err := g.Cache.Set(ctx, loginBackoffKeyPrefix+subject, failures, g.backoff(failures)).Err(); err != nil {
+				log.Printf("error storing login backoff: %v", err)
+				return api.ErrInternal
+			}
+			continue
+		}
+		if err := g.Cache.Set(ctx, loginLockoutKeyPrefix+subject, failures, g.lockoutPeriod()).Err(); err != nil {
+			log.Printf("error storing login lockout: %v", err)
+			return api.ErrInternal
+		}
+		g.Cache.Del(ctx, failuresKey)
+		g.recordLockout(ctx, nick, ip, subject, failures)
+	}
+	return nil
+}
+
+// RecordSuccess resets failures of the nick; failures of the IP are kept,
+// so that logging into own account does not let an attacker continue guessing
+func (g *LoginGuard) RecordSuccess(ctx context.Context, nick, ip string) {
+	if nick == "" {
+		return
+	}
+	if err := g.Cache.Del(ctx, loginFailuresKeyPrefix+loginSubjectNickPrefix+strings.ToLower(nick)).Err(); err != nil {
+		log.Printf("error resetting failed logins: %v", err)
+	}
+}
+
+func (g *LoginGuard) recordLockout(ctx context.Context, nick, ip, subject string, failures int64) {
+	if g.Audit == nil {
+		return
+	}
+	g.Audit.Record(ctx, &api.AuditEvent{
+		Type:      api.AuditAccountLocked,
+		Nick:      nick,
+		IP:        ip,
+		Details:   fmt.Sprintf("%s locked for %s after %d failed login attempts", subject, g.lockoutPeriod(), failures),
+		Timestamp: time.Now(),
+	})
+}
+
+func (g *LoginGuard) backoff(failures int64) time.Duration {
+	backoff := g.backoffBase() << (failures - 1)
+	if backoff <= 0 || backoff > g.lockoutPeriod() {
+		return g.lockoutPeriod()
+	}
+	return backoff
+}
+
+func (g *LoginGuard) maxFailures() int {
+	if g.MaxFailures == 0 {
+		return DefaultMaxLoginFailures
+	}
+	return g.MaxFailures
+}
+
+func (g *LoginGuard) backoffBase() time.Duration {
+	if g.BackoffBase == 0 {
+		return DefaultLoginBackoffBase
+	}
+	return g.BackoffBase
+}
+
+func (g *LoginGuard) lockoutPeriod() time.Duration {
+	if g.LockoutPeriod == 0 {
+		return DefaultLoginLockoutPeriod
+	}
+	return g.LockoutPeriod
+}
+
+// loginSubjects key nicks in lower case, as nicks differing in case only log into the same account
+func loginSubjects(nick, ip string) []string {
+	var subjects []string
+	if nick != "" {
+		subjects = append(subjects, loginSubjectNickPrefix+strings.ToLower(nick))
+	}
+	if ip != "" {
+		subjects = append(subjects, loginSubjectClientIPPrefix+ip)
+	}
+	return subjects
+}
@@ -0,0 +1,62 @@
+package sockchat
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kacperf531/sockchat/api"
+	"github.com/kacperf531/sockchat/test_utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type spyAuditLog struct {
+	events []*api.AuditEvent
+}
+
+func (l *spyAuditLog) Record(ctx context.Context, event *api.AuditEvent) {
+	l.events = append(l.events, event)
+}
+
+func TestLoginGuard(t *testing.T) {
+	t.Parallel()
+
+	cache := test_utils.NewTestingRedisClient(t)
+
+	audit := &spyAuditLog{}
+	guard := &LoginGuard{Cache: cache, Audit: audit, MaxFailures: 3, BackoffBase: time.Minute}
+	ctx := context.Background()
+	// unique subjects keep runs against the same Redis independent
+	suffix := fmt.Sprint(time.Now().UnixNano())
+
+	t.Run("login is allowed without previous failures", func(t *testing.T) {
+		assert.NoError(t, guard.Check(ctx, "Fresh"+suffix, "10.0.0.1"+suffix))
+	})
+
+	t.Run("failure blocks further attempts of the nick and the IP", func(t *testing.T) {
+		require.NoError(t, guard.RecordFailure(ctx, "Failed"+suffix, "10.0.0.2"+suffix))
+		assert.EqualError(t, guard.Check(ctx, "Failed"+suffix, "10.0.0.3"+suffix), api.ErrAccountLocked.Error())
+		assert.EqualError(t, guard.Check(ctx, "Other"+suffix, "10.0.0.2"+suffix), api.ErrAccountLocked.Error())
+		assert.Empty(t, audit.events)
+	})
+
+	t.Run("lockout after reaching the threshold is audited", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			require.NoError(t, guard.RecordFailure(ctx, "Locked"+suffix, ""))
+		}
+		assert.EqualError(t, guard.Check(ctx, "Locked"+suffix, ""), api.ErrAccountLocked.Error())
+		require.Len(t, audit.events, 1)
+		assert.Equal(t, api.AuditAccountLocked, audit.events[0].Type)
+		assert.Equal(t, "Locked"+suffix, audit.events[0].Nick)
+	})
+
+	t.Run("nicks differing in case share the failures", func(t *testing.T) {
+		for _, nick := range []string{"Cased", "CASED", "cased"} {
+			require.NoError(t, guard.RecordFailure(ctx, nick+suffix, ""))
+		}
+		assert.EqualError(t, guard.Check(ctx, "cAsEd"+suffix, ""), api.ErrAccountLocked.Error())
+		require.Len(t, audit.events, 2)
+	})
+}
@@ -0,0 +1,388 @@
+package sockchat
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/kacperf531/sockchat/api"
+	"github.com/kacperf531/sockchat/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	DefaultOIDCStateTTL = 10 * time.Minute
+
+	oidcStateKeyPrefix       = "oidc_state:"
+	oidcScopes               = "openid profile email"
+	maxProvisionedNickLength = 64
+	maxProvisioningAttempts  = 5
+)
+
+// OIDCService signs users in with an OpenID Connect provider using authorization code flow with PKCE.
+// ID tokens are verified against keys published by the issuer (JWKS). Users signing in for the first time
+// are provisioned with a nick derived from their claims and their subject is linked to that nick.
+type OIDCService struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Profiles     *ProfileService
+	Identities   storage.IdentityStore
+	Cache        *redis.Client
+	Audit        api.SockchatAuditLog
+	HTTPClient   *http.Client
+	StateTTL     time.Duration
+
+	lock     sync.Mutex
+	provider *oidcProviderMetadata
+	keys     map[string]*rsa.PublicKey
+}
+
+type oidcProviderMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcState is kept in Redis between redirecting the user to the provider and the callback
+type oidcState struct {
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+}
+
+type idTokenClaims struct {
+	Nonce             string `json:"nonce"`
+	PreferredUsername string `json:"preferred_username"`
+	Email             string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+func (s *OIDCService) AuthCodeURL(ctx context.Context) (string, error) {
+	provider, err := s.discover(ctx)
+	if err != nil {
+		log.Printf("error discovering OIDC provider: %v", err)
+		return "", api.ErrInternal
+	}
+	authURL, err := url.Parse(provider.AuthorizationEndpoint)
+	if err != nil {
+		log.Printf("error parsing OIDC authorization endpoint: %v", err)
+		return "", api.ErrInternal
+	}
+	state, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	stored := oidcState{}
+	if stored.Nonce, err = randomToken(); err != nil {
+		return "", err
+	}
+	if stored.CodeVerifier, err = randomToken(); err != nil {
+		return "", err
+	}
+	storedBytes, _ := json.Marshal(stored)
+	if err := s.Cache.Set(ctx, oidcStateKeyPrefix+state, storedBytes, s.stateTTL()).Err(); err != nil {
+		log.Printf("error storing OIDC state: %v", err)
+		return "", api.ErrInternal
+	}
+	challenge := sha256.Sum256([]byte(stored.CodeVerifier))
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", s.ClientID)
+	query.Set("redirect_uri", s.RedirectURL)
+	query.Set("scope", oidcScopes)
+	query.Set("state", state)
+	query.Set("nonce", stored.Nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+	return authURL.String(), nil
+}
+
+func (s *OIDCService) Authenticate(ctx context.Context, code, state string) (string, error) {
+	if code == "" || state == "" {
+		return "", api.ErrInvalidOIDCState
+	}
+	storedBytes, err := s.Cache.GetDel(ctx, oidcStateKeyPrefix+state).Bytes()
+	if err == redis.Nil {
+		return "", api.ErrInvalidOIDCState
+	}
+	if err != nil {
+		log.Printf("error reading OIDC state: %v", err)
+		return "", api.ErrInternal
+	}
+	var stored oidcState
+	if err := json.Unmarshal(storedBytes, &stored); err != nil {
+		log.Printf("error unmarshaling OIDC state: %v", err)
+		return "", api.ErrInternal
+	}
+	idToken, err := s.exchange(ctx, code, stored.CodeVerifier)
+	if err != nil {
+		log.Printf("error exchanging OIDC authorization code: %v", err)
+		return "", api.ErrOIDCLoginFailed
+	}
+	claims, err := s.verifyIDToken(ctx, idToken, stored.Nonce)
+	if err != nil {
+		log.Printf("error verifying OIDC ID token: %v", err)
+		return "", api.ErrOIDCLoginFailed
+	}
+	return s.linkedNick(ctx, claims)
+}
+
+// exchange returns ID token issued for the authorization code
+func (s *OIDCService) exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	provider, err := s.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", s.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(s.ClientID), url.QueryEscape(s.ClientSecret))
+	res, err := s.httpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return "", fmt.Errorf("token endpoint responded with %d: %s", res.StatusCode, body)
+	}
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tokens); err != nil {
+		return "", err
+	}
+	if tokens.IDToken == "" {
+		return "", fmt.Errorf("token endpoint did not return id_token")
+	}
+	return tokens.IDToken, nil
+}
+
+func (s *OIDCService) verifyIDToken(ctx context.Context, idToken, nonce string) (*idTokenClaims, error) {
+	claims := &idTokenClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return s.key(ctx, kid)
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}), jwt.WithIssuer(s.Issuer), jwt.WithAudience(s.ClientID))
+	if err != nil {
+		return nil, err
+	}
+	if claims.ExpiresAt == nil {
+		return nil, fmt.Errorf("ID token has no expiry")
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("ID token has no subject")
+	}
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("ID token nonce does not match")
+	}
+	return claims, nil
+}
+
+// linkedNick returns nick linked to the subject, provisioning a new user on first login
+func (s *OIDCService) linkedNick(ctx context.Context, claims *idTokenClaims) (string, error) {
+	identity, err := s.Identities.SelectIdentity(ctx, s.Issuer, claims.Subject)
+	if err == nil {
+		return identity.Nick, nil
+	}
+	if err != api.ErrIdentityNotFound {
+		log.Printf("error selecting identity from db: %v", err)
+		return "", api.ErrInternal
+	}
+	nick, err := s.provision(ctx, claims)
+	if err != nil {
+		return "", err
+	}
+	if err := s.Identities.InsertIdentity(ctx, &storage.Identity{Issuer: s.Issuer, Subject: claims.Subject, Nick: nick}); err != nil {
+		// the provisioned user is removed, so that no user is left which nobody can log in as
+		if deleteErr := s.Profiles.Store.DeleteUser(ctx, nick); deleteErr != nil {
+			log.Printf("error removing user %s provisioned for unlinked identity: %v", nick, deleteErr)
+		}
+		if err == api.ErrIdentityAlreadyLinked {
+			// concurrent first login of the same subject linked the identity first
+			identity, err := s.Identities.SelectIdentity(ctx, s.Issuer, claims.Subject)
+			if err != nil {
+				log.Printf("error selecting identity from db: %v", err)
+				return "", api.ErrInternal
+			}
+			return identity.Nick, nil
+		}
+		log.Printf("error linking identity to %s: %v", nick, err)
+		return "", api.ErrInternal
+	}
+	if s.Audit != nil {
+		s.Audit.Record(ctx, &api.AuditEvent{Type: api.AuditUserProvisioned, Nick: nick, Details: "issuer: " + s.Issuer, Timestamp: time.Now()})
+	}
+	return nick, nil
+}
+
+// provision creates a user without password; a random suffix is added to the nick if it is already used
+func (s *OIDCService) provision(ctx context.Context, claims *idTokenClaims) (string, error) {
+	base := provisionedNick(claims)
+	nick := base
+	for attempt := 0; attempt < maxProvisioningAttempts; attempt++ {
+		// empty hash never matches any password, so the user can log in only through the provider
+		err := s.Profiles.Store.InsertUser(ctx, &storage.User{Nick: nick, PwHash: ""})
+		if err == nil {
+			return nick, nil
+		}
+		if err != api.ErrNickAlreadyUsed {
+			log.Printf("error provisioning user %s: %v", nick, err)
+			return "", api.ErrInternal
+		}
+		suffix := make([]byte, 2)
+		if _, err := rand.Read(suffix); err != nil {
+			log.Printf("error generating nick suffix: %v", err)
+			return "", api.ErrInternal
+		}
+		nick = base + "-" + hex.EncodeToString(suffix)
+	}
+	log.Printf("could not find unused nick for %s", base)
+	return "", api.ErrNickAlreadyUsed
+}
+
+func provisionedNick(claims *idTokenClaims) string {
+	nick := strings.TrimSpace(claims.PreferredUsername)
+	if nick == "" {
+		nick, _, _ = strings.Cut(strings.TrimSpace(claims.Email), "@")
+	}
+	// the provider's username is not under our control, so characters forbidden in nicks are replaced
+	nick = strings.Map(func(r rune) rune {
+		if isForbiddenNickRune(r) {
+			return '_'
+		}
+		return r
+	}, nick)
+	if nick == "" {
+		hash := sha256.Sum256([]byte(claims.Subject))
+		nick = "user-" + hex.EncodeToString(hash[:4])
+	}
+	// cut by characters, so that a multi-byte character is never split
+	if runes := []rune(nick); len(runes) > maxProvisionedNickLength {
+		nick = string(runes[:maxProvisionedNickLength])
+	}
+	return nick
+}
+
+// discover fetches provider metadata once
+func (s *OIDCService) discover(ctx context.Context) (*oidcProviderMetadata, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.provider != nil {
+		return s.provider, nil
+	}
+	var provider oidcProviderMetadata
+	if err := s.getJSON(ctx, strings.TrimSuffix(s.Issuer, "/")+"/.well-known/openid-configuration", &provider); err != nil {
+		return nil, err
+	}
+	if provider.Issuer != s.Issuer {
+		return nil, fmt.Errorf("provider metadata is issued by %s", provider.Issuer)
+	}
+	s.provider = &provider
+	return s.provider, nil
+}
+
+// key returns signing key of the issuer; keys are fetched again if the key is unknown, which happens on rotation
+func (s *OIDCService) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	provider, err := s.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if key, found := s.keys[kid]; found {
+		return key, nil
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := s.getJSON(ctx, provider.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			log.Printf("skipping malformed key %s of OIDC issuer", k.Kid)
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	s.keys = keys
+	if key, found := s.keys[kid]; found {
+		return key, nil
+	}
+	return nil, fmt.Errorf("signing key %q not found", kid)
+}
+
+func (s *OIDCService) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := s.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with %d", url, res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func (s *OIDCService) httpClient() *http.Client {
+	if s.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return s.HTTPClient
+}
+
+func (s *OIDCService) stateTTL() time.Duration {
+	if s.StateTTL == 0 {
+		return DefaultOIDCStateTTL
+	}
+	return s.StateTTL
+}
+
+func randomToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		log.Printf("error generating random token: %v", err)
+		return "", api.ErrInternal
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
@@ -0,0 +1,153 @@
+package sockchat
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/kacperf531/sockchat/api"
+	"github.com/kacperf531/sockchat/storage"
+	"github.com/kacperf531/sockchat/test_utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOIDCService(t *testing.T) {
+	cache := test_utils.NewTestingRedisClient(t)
+	ctx := context.Background()
+	issuer := test_utils.NewTestOIDCIssuer(t)
+	store := &test_utils.UserStoreDouble{}
+	service := &OIDCService{
+		Issuer:       issuer.URL,
+		ClientID:     test_utils.TestOIDCClientID,
+		ClientSecret: test_utils.TestOIDCClientSecret,
+		RedirectURL:  "http://sockchat.test/oidc/callback",
+		Profiles:     &ProfileService{Store: store, Cache: cache},
+		Identities:   &test_utils.IdentityStoreDouble{},
+		Cache:        cache,
+	}
+	login := func(t *testing.T) (string, error) {
+		authURL, err := service.AuthCodeURL(ctx)
+		require.NoError(t, err)
+		callback := issuer.Authorize(t, authURL)
+		return service.Authenticate(ctx, callback.Get("code"), callback.Get("state"))
+	}
+
+	t.Run("provisions user without password on first login", func(t *testing.T) {
+		issuer.SetUser("subject-1", "jdoe")
+		nick, err := login(t)
+		require.NoError(t, err)
+		assert.Equal(t, "jdoe", nick)
+		require.Len(t, store.CreateCalls, 1)
+		assert.Equal(t, "jdoe", store.CreateCalls[0].Nick)
+		assert.False(t, service.Profiles.IsAuthValid(ctx, "jdoe", "anything"))
+	})
+
+	t.Run("returns linked user on next login", func(t *testing.T) {
+		issuer.SetUser("subject-1", "renamed")
+		nick, err := login(t)
+		require.NoError(t, err)
+		assert.Equal(t, "jdoe", nick)
+		assert.Len(t, store.CreateCalls, 1)
+	})
+
+	t.Run("adds suffix to nick which is already used", func(t *testing.T) {
+		issuer.SetUser("subject-2", "already_exists")
+		nick, err := login(t)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(nick, "already_exists-"), nick)
+	})
+
+	t.Run("replaces characters forbidden in nicks", func(t *testing.T) {
+		issuer.SetUser("subject-3", "login_lockout:nick:jdoe")
+		nick, err := login(t)
+		require.NoError(t, err)
+		assert.Equal(t, "login_lockout_nick_jdoe", nick)
+
+		issuer.SetUser("subject-6", api.DeletedUserNick)
+		nick, err = login(t)
+		require.NoError(t, err)
+		assert.Equal(t, "_deleted_", nick)
+	})
+
+	t.Run("truncates long usernames by characters", func(t *testing.T) {
+		issuer.SetUser("subject-7", strings.Repeat("ż", maxProvisionedNickLength+1))
+		nick, err := login(t)
+		require.NoError(t, err)
+		assert.Equal(t, strings.Repeat("ż", maxProvisionedNickLength), nick)
+		assert.True(t, utf8.ValidString(nick))
+	})
+
+	t.Run("returns user of concurrent first login and removes the duplicate", func(t *testing.T) {
+		service.Identities = &racingIdentityStore{&test_utils.IdentityStoreDouble{}}
+		defer func() { service.Identities = &test_utils.IdentityStoreDouble{} }()
+		issuer.SetUser("subject-4", "racer")
+		nick, err := login(t)
+		require.NoError(t, err)
+		assert.Equal(t, "winner", nick)
+		_, err = store.SelectUser(ctx, "racer")
+		assert.Equal(t, api.ErrUserNotFound, err)
+	})
+
+	t.Run("removes provisioned user when identity can not be linked", func(t *testing.T) {
+		service.Identities = &failingIdentityStore{&test_utils.IdentityStoreDouble{}}
+		defer func() { service.Identities = &test_utils.IdentityStoreDouble{} }()
+		issuer.SetUser("subject-5", "unlinked")
+		_, err := login(t)
+		assert.Equal(t, api.ErrInternal, err)
+		_, err = store.SelectUser(ctx, "unlinked")
+		assert.Equal(t, api.ErrUserNotFound, err)
+	})
+
+	t.Run("rejects unknown or reused state", func(t *testing.T) {
+		issuer.SetUser("subject-1", "jdoe")
+		authURL, err := service.AuthCodeURL(ctx)
+		require.NoError(t, err)
+		callback := issuer.Authorize(t, authURL)
+
+		_, err = service.Authenticate(ctx, callback.Get("code"), "unknown")
+		assert.Equal(t, api.ErrInvalidOIDCState, err)
+		_, err = service.Authenticate(ctx, callback.Get("code"), callback.Get("state"))
+		assert.NoError(t, err)
+		_, err = service.Authenticate(ctx, callback.Get("code"), callback.Get("state"))
+		assert.Equal(t, api.ErrInvalidOIDCState, err)
+	})
+
+	t.Run("rejects ID token issued for another client", func(t *testing.T) {
+		issuer.SetAudience("another-client")
+		defer issuer.SetAudience(test_utils.TestOIDCClientID)
+		_, err := login(t)
+		assert.Equal(t, api.ErrOIDCLoginFailed, err)
+	})
+
+	t.Run("rejects login with invalid client credentials", func(t *testing.T) {
+		service.ClientSecret = "wrong"
+		defer func() { service.ClientSecret = test_utils.TestOIDCClientSecret }()
+		_, err := login(t)
+		assert.Equal(t, api.ErrOIDCLoginFailed, err)
+	})
+}
+
+// racingIdentityStore links the identity to another user right before it is inserted, as a concurrent login would
+type racingIdentityStore struct {
+	*test_utils.IdentityStoreDouble
+}
+
+func (s *racingIdentityStore) InsertIdentity(ctx context.Context, i *storage.Identity) error {
+	winner := *i
+	winner.Nick = "winner"
+	if err := s.IdentityStoreDouble.InsertIdentity(ctx, &winner); err != nil {
+		return err
+	}
+	return s.IdentityStoreDouble.InsertIdentity(ctx, i)
+}
+
+type failingIdentityStore struct {
+	*test_utils.IdentityStoreDouble
+}
+
+func (s *failingIdentityStore) InsertIdentity(ctx context.Context, i *storage.Identity) error {
+	return errors.New("connection lost")
+}
@@ -0,0 +1,104 @@
+package sockchat
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/kacperf531/sockchat/api"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	DefaultPasswordResetTokenTTL = time.Hour
+
+	passwordResetKeyPrefix = "password_reset:"
+)
+
+// PasswordResetService issues single-use password reset tokens and delivers them through Notifier.
+// Only hashes of the tokens are kept in Redis.
+type PasswordResetService struct {
+	Profiles *ProfileService
+	Cache    *redis.Client
+	Notifier api.SockchatNotifier
+	// optional, sessions of the user are revoked on reset when set
+	Sessions api.SockchatSessionStore
+	TokenTTL time.Duration
+}
+
+// RequestReset sends reset token to the user; it does not reveal whether the user exists
+func (s *PasswordResetService) RequestReset(ctx context.Context, nick string) error {
+	if nick == "" {
+		return api.ErrNickRequired
+	}
+	if _, err := s.Profiles.GetProfile(ctx, nick); err != nil {
+		if err != api.ErrUserNotFound {
+			return err
+		}
+		log.Printf("password reset requested for non-existing user %s", nick)
+		return nil
+	}
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		log.Printf("error generating password reset token: %v", err)
+		return api.ErrInternal
+	}
+	token := hex.EncodeToString(tokenBytes)
+	if err := s.Cache.Set(ctx, passwordResetKey(token), nick, s.tokenTTL()).Err(); err != nil {
+		log.Printf("error storing password reset token: %v", err)
+		return api.ErrInternal
+	}
+	if err := s.Notifier.NotifyPasswordReset(ctx, nick, token); err != nil {
+		log.Printf("error delivering password reset token: %v", err)
+		return api.ErrInternal
+	}
+	return nil
+}
+
+// Reset consumes the token, sets the new password of its owner and revokes all their sessions
+func (s *PasswordResetService) Reset(ctx context.Context, req *api.ResetPasswordRequest) error {
+	if req.NewPassword == "" {
+		return api.ErrPasswordRequired
+	}
+	if req.Token == "" {
+		return api.ErrInvalidResetToken
+	}
+	nick, err := s.Cache.GetDel(ctx, passwordResetKey(req.Token)).Result()
+	if err == redis.Nil {
+		return api.ErrInvalidResetToken
+	}
+	if err != nil {
+		log.Printf("error reading password reset token: %v", err)
+		return api.ErrInternal
+	}
+	if err := s.Profiles.setPassword(ctx, nick, req.NewPassword); err != nil {
+		return err
+	}
+	if s.Sessions == nil {
+		return nil
+	}
+	return s.Sessions.RevokeAll(ctx, nick)
+}
+
+func (s *PasswordResetService) tokenTTL() time.Duration {
+	if s.TokenTTL == 0 {
+		return DefaultPasswordResetTokenTTL
+	}
+	return s.TokenTTL
+}
+
+func passwordResetKey(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return passwordResetKeyPrefix + hex.EncodeToString(hash[:])
+}
+
+// LogNotifier writes notifications to the server log; meant for development only
+type LogNotifier struct{}
+
+func (n *LogNotifier) NotifyPasswordReset(ctx context.Context, nick, token string) error {
+	log.Printf("password reset token for %s: %s", nick, token)
+	return nil
+}
@@ -0,0 +1,60 @@
+package sockchat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kacperf531/sockchat/api"
+	"github.com/kacperf531/sockchat/test_utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type spyNotifier struct {
+	tokens map[string]string
+}
+
+func (n *spyNotifier) NotifyPasswordReset(ctx context.Context, nick, token string) error {
+	n.tokens[nick] = token
+	return nil
+}
+
+func TestPasswordResetService(t *testing.T) {
+	t.Parallel()
+
+	cache := test_utils.NewTestingRedisClient(t)
+
+	store := &test_utils.UserStoreDouble{}
+	notifier := &spyNotifier{tokens: map[string]string{}}
+	sessions := &SessionService{Secret: []byte("secret"), Cache: cache}
+	service := &PasswordResetService{Profiles: &ProfileService{Store: store, Cache: cache}, Cache: cache, Notifier: notifier, Sessions: sessions}
+	ctx := context.Background()
+
+	t.Run("reset token is delivered and can be used once", func(t *testing.T) {
+		tokens, err := sessions.Issue(ctx, test_utils.ValidUserNick)
+		require.NoError(t, err)
+		require.NoError(t, service.RequestReset(ctx, test_utils.ValidUserNick))
+		token := notifier.tokens[test_utils.ValidUserNick]
+		require.NotEmpty(t, token)
+
+		require.NoError(t, service.Reset(ctx, &api.ResetPasswordRequest{Token: token, NewPassword: "bar420"}))
+		assert.Equal(t, []string{test_utils.ValidUserNick}, store.PasswordUpdateCalls)
+		_, err = sessions.Verify(ctx, tokens.AccessToken)
+		assert.ErrorIs(t, err, api.ErrInvalidToken, "sessions issued before the reset are revoked")
+		_, err = sessions.Refresh(ctx, tokens.RefreshToken)
+		assert.ErrorIs(t, err, api.ErrInvalidToken)
+
+		err = service.Reset(ctx, &api.ResetPasswordRequest{Token: token, NewPassword: "bar420"})
+		assert.EqualError(t, err, api.ErrInvalidResetToken.Error())
+	})
+
+	t.Run("request for non-existing user does not fail nor notify", func(t *testing.T) {
+		assert.NoError(t, service.RequestReset(ctx, "NonExistingUser"))
+		assert.NotContains(t, notifier.tokens, "NonExistingUser")
+	})
+
+	t.Run("unknown token is rejected", func(t *testing.T) {
+		err := service.Reset(ctx, &api.ResetPasswordRequest{Token: "not_a_token", NewPassword: "bar420"})
+		assert.EqualError(t, err, api.ErrInvalidResetToken.Error())
+	})
+}
@@ -0,0 +1,144 @@
+package sockchat
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/kacperf531/sockchat/api"
+	"github.com/kacperf531/sockchat/storage"
+)
+
+const (
+	exportProfileFile  = "profile.json"
+	exportMessagesFile = "messages.json"
+	exportPrefsFile    = "preferences.json"
+)
+
+// PersonalDataService erases users on request and exports everything stored about them.
+// Messages of deleted users are kept in their channels' history, but their author is replaced with api.DeletedUserNick.
+type PersonalDataService struct {
+	Profiles   *ProfileService
+	Messages   api.SockchatMessageStore
+	Sessions   api.SockchatSessionStore
+	APIKeys    storage.APIKeyStore
+	Identities storage.IdentityStore
+	Blocks     *BlockListService
+	Prefs      *PreferencesService
+	Audit      api.SockchatAuditLog
+}
+
+type exportedProfile struct {
+	*api.PublicProfile
+	Role       api.Role `json:"role"`
+	Disabled   bool     `json:"disabled"`
+	ExportedAt int64    `json:"exported_at"`
+}
+
+type exportFile struct {
+	name    string
+	content any
+}
+
+// Delete revokes all credentials of the user, anonymises their messages and removes the account
+func (s *PersonalDataService) Delete(ctx context.Context, nick string) error {
+	id, err := s.Profiles.GetUserID(ctx, nick)
+	if err != nil {
+		return err
+	}
+	if err := s.Sessions.RevokeAll(ctx, nick); err != nil {
+		return err
+	}
+	if s.APIKeys != nil {
+		if err := s.APIKeys.DeleteAPIKeysByOwner(ctx, nick); err != nil {
+			log.Printf("error deleting API keys of %s: %v", nick, err)
+			return api.ErrInternal
+		}
+	}
+	if s.Identities != nil {
+		if err := s.Identities.DeleteIdentitiesByNick(ctx, nick); err != nil {
+			log.Printf("error deleting identities of %s: %v", nick, err)
+			return api.ErrInternal
+		}
+	}
+	if s.Blocks != nil {
+		if err := s.Blocks.Forget(ctx, nick); err != nil {
+			return err
+		}
+	}
+	if s.Prefs != nil {
+		if err := s.Prefs.Forget(ctx, nick); err != nil {
+			return err
+		}
+	}
+	if err := s.Messages.AnonymizeMessagesByAuthor(ctx, nick, id); err != nil {
+		log.Printf("error anonymizing messages of %s: %v", nick, err)
+		return api.ErrInternal
+	}
+	if err := s.Profiles.Delete(ctx, nick); err != nil {
+		return err
+	}
+	if s.Audit != nil {
+		s.Audit.Record(ctx, &api.AuditEvent{Type: api.AuditAccountDeleted, Nick: nick, Timestamp: time.Now()})
+	}
+	return nil
+}
+
+func (s *PersonalDataService) Export(ctx context.Context, nick string) ([]byte, error) {
+	profile, err := s.Profiles.GetProfile(ctx, nick)
+	if err != nil {
+		return nil, err
+	}
+	account, err := s.Profiles.GetAccount(ctx, nick)
+	if err != nil {
+		return nil, err
+	}
+	id, err := s.Profiles.GetUserID(ctx, nick)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := s.Messages.FindMessagesByAuthor(ctx, nick, id)
+	if err != nil {
+		log.Printf("error finding messages of %s: %v", nick, err)
+		return nil, api.ErrInternal
+	}
+	buf := &bytes.Buffer{}
+	archive := zip.NewWriter(buf)
+	files := []exportFile{
+		{exportProfileFile, exportedProfile{
+			PublicProfile: profile,
+			Role:          account.Role,
+			Disabled:      account.Disabled,
+			ExportedAt:    time.Now().Unix(),
+		}},
+		{exportMessagesFile, messages},
+	}
+	if s.Prefs != nil {
+		prefs, err := s.Prefs.GetPreferences(ctx, nick)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, exportFile{exportPrefsFile, prefs})
+	}
+	for _, file := range files {
+		w, err := archive.Create(file.name)
+		if err != nil {
+			log.Printf("error creating export archive: %v", err)
+			return nil, api.ErrInternal
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.content); err != nil {
+			log.Printf("error writing export archive: %v", err)
+			return nil, api.ErrInternal
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("error closing export archive: %v", err)
+		return nil, api.ErrInternal
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,106 @@
+package sockchat
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/kacperf531/sockchat/api"
+	"github.com/kacperf531/sockchat/storage"
+	"github.com/kacperf531/sockchat/test_utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersonalDataService(t *testing.T) {
+	cache := test_utils.NewTestingRedisClient(t)
+	ctx := context.Background()
+	nick := "PersonalDataTestUser"
+	store := &test_utils.UserStoreDouble{}
+	messages := &test_utils.StubMessageStore{Messages: api.ChannelHistory{
+		{Text: "mine", Channel: "foo", Author: nick, Timestamp: 1},
+		{Text: "not mine", Channel: "foo", Author: test_utils.ValidUserNick, Timestamp: 2},
+	}}
+	apiKeys := &test_utils.APIKeyStoreDouble{}
+	identities := &test_utils.IdentityStoreDouble{}
+	sessions := &SessionService{Secret: []byte("secret"), Cache: cache}
+	audit := &spyAuditLog{}
+	prefs := &test_utils.PreferencesStoreDouble{}
+	service := &PersonalDataService{
+		Profiles:   &ProfileService{Store: store, Cache: cache},
+		Messages:   messages,
+		Sessions:   sessions,
+		APIKeys:    apiKeys,
+		Identities: identities,
+		Prefs:      &PreferencesService{Store: prefs, Cache: cache},
+		Audit:      audit,
+	}
+	require.NoError(t, service.Profiles.Create(ctx, &api.CreateProfileRequest{Nick: nick, Password: "secret", Description: "about me"}))
+	require.NoError(t, apiKeys.InsertAPIKey(ctx, &storage.APIKey{Owner: nick, Name: "bot"}))
+	require.NoError(t, identities.InsertIdentity(ctx, &storage.Identity{Issuer: "https://idp.test", Subject: "1", Nick: nick}))
+	require.NoError(t, service.Prefs.UpdatePreferences(ctx, nick, &api.Preferences{Theme: "dark"}))
+	id, err := service.Profiles.GetUserID(ctx, nick)
+	require.NoError(t, err)
+	messages.Messages = append(messages.Messages, &api.MessageEvent{Text: "under previous nick", Channel: "foo", Author: "PreviousNick", AuthorID: id, Timestamp: 3})
+
+	t.Run("exports profile and authored messages", func(t *testing.T) {
+		archive, err := service.Export(ctx, nick)
+		require.NoError(t, err)
+		reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+		require.NoError(t, err)
+		files := map[string][]byte{}
+		for _, f := range reader.File {
+			r, err := f.Open()
+			require.NoError(t, err)
+			files[f.Name], err = io.ReadAll(r)
+			require.NoError(t, err)
+			r.Close()
+		}
+
+		var profile exportedProfile
+		require.NoError(t, json.Unmarshal(files[exportProfileFile], &profile))
+		assert.Equal(t, nick, profile.Nick)
+		assert.Equal(t, "about me", profile.Description)
+		assert.Equal(t, api.RoleUser, profile.Role)
+		var exported api.ChannelHistory
+		require.NoError(t, json.Unmarshal(files[exportMessagesFile], &exported))
+		require.Len(t, exported, 2)
+		assert.Equal(t, "mine", exported[0].Text)
+		assert.Equal(t, "under previous nick", exported[1].Text)
+		var exportedPrefs api.Preferences
+		require.NoError(t, json.Unmarshal(files[exportPrefsFile], &exportedPrefs))
+		assert.Equal(t, "dark", exportedPrefs.Theme)
+	})
+
+	t.Run("deletes account and anonymises its messages", func(t *testing.T) {
+		tokens, err := sessions.Issue(ctx, nick)
+		require.NoError(t, err)
+
+		require.NoError(t, service.Delete(ctx, nick))
+
+		_, err = store.SelectUser(ctx, nick)
+		assert.ErrorIs(t, err, api.ErrUserNotFound)
+		_, err = sessions.Verify(ctx, tokens.AccessToken)
+		assert.ErrorIs(t, err, api.ErrInvalidToken)
+		keys, err := apiKeys.SelectAPIKeysByOwner(ctx, nick)
+		require.NoError(t, err)
+		assert.Empty(t, keys)
+		_, err = identities.SelectIdentity(ctx, "https://idp.test", "1")
+		assert.ErrorIs(t, err, api.ErrIdentityNotFound)
+		stored, err := prefs.SelectPreferences(ctx, nick)
+		require.NoError(t, err)
+		assert.Empty(t, stored.Theme)
+		assert.Equal(t, api.DeletedUserNick, messages.Messages[0].Author)
+		assert.Equal(t, test_utils.ValidUserNick, messages.Messages[1].Author)
+		assert.Equal(t, api.DeletedUserNick, messages.Messages[2].Author)
+		require.Len(t, audit.events, 1)
+		assert.Equal(t, api.AuditAccountDeleted, audit.events[0].Type)
+	})
+
+	t.Run("deleting unknown user fails", func(t *testing.T) {
+		assert.ErrorIs(t, service.Delete(ctx, "NoSuchUser"), api.ErrUserNotFound)
+	})
+}
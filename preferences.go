@@ -0,0 +1,154 @@
+package sockchat
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kacperf531/sockchat/api"
+	"github.com/kacperf531/sockchat/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	MaxThemeLength             = 32
+	MaxLanguageLength          = 35
+	MaxPreferenceChannels      = 50
+	MaxPreferenceChannelLength = 100
+
+	preferencesCacheKeyPrefix = "preferences:"
+	preferencesCacheTTL       = 10 * time.Second
+)
+
+// PreferencesService stores preferences of users in the DB and caches them in Redis, the same way ProfileService does with profiles
+type PreferencesService struct {
+	Store storage.PreferencesStore
+	Cache *redis.Client
+}
+
+func (s *PreferencesService) GetPreferences(ctx context.Context, nick string) (*api.Preferences, error) {
+	prefs, err := s.getFromCache(ctx, nick)
+	if err == redis.Nil {
+		prefs, err = s.Store.SelectPreferences(ctx, nick)
+		if err != nil {
+			log.Printf("error selecting preferences from db: %v", err)
+			return nil, api.ErrInternal
+		}
+		s.setInCache(ctx, nick, prefs)
+	} else if err != nil {
+		return nil, api.ErrInternal
+	}
+	return withDefaults(prefs), nil
+}
+
+func (s *PreferencesService) UpdatePreferences(ctx context.Context, nick string, prefs *api.Preferences) error {
+	if err := validatePreferences(prefs); err != nil {
+		return err
+	}
+	if err := s.Store.UpsertPreferences(ctx, nick, prefs); err != nil {
+		log.Printf("error updating preferences in db: %v", err)
+		return api.ErrInternal
+	}
+	s.removeFromCache(ctx, nick)
+	return nil
+}
+
+// Forget removes preferences of the user, e.g. when the account is deleted
+func (s *PreferencesService) Forget(ctx context.Context, nick string) error {
+	if err := s.Store.DeletePreferences(ctx, nick); err != nil {
+		log.Printf("error deleting preferences of %s: %v", nick, err)
+		return api.ErrInternal
+	}
+	s.removeFromCache(ctx, nick)
+	return nil
+}
+
+func validatePreferences(prefs *api.Preferences) error {
+	if utf8.RuneCountInString(prefs.Theme) > MaxThemeLength || len(prefs.Language) > MaxLanguageLength {
+		return api.ErrPreferenceTooLong
+	}
+	if !isNotificationLevel(prefs.NotificationLevel) {
+		return api.ErrInvalidNotification
+	}
+	if len(prefs.MutedChannels) > MaxPreferenceChannels || len(prefs.ChannelNotifications) > MaxPreferenceChannels {
+		return api.ErrTooManyChannelPrefs
+	}
+	for _, channel := range prefs.MutedChannels {
+		if err := validatePreferenceChannel(channel); err != nil {
+			return err
+		}
+	}
+	for channel, level := range prefs.ChannelNotifications {
+		if err := validatePreferenceChannel(channel); err != nil {
+			return err
+		}
+		if level == "" || !isNotificationLevel(level) {
+			return api.ErrInvalidNotification
+		}
+	}
+	return nil
+}
+
+func validatePreferenceChannel(channel string) error {
+	if strings.TrimSpace(channel) == "" {
+		return api.ErrEmptyChannelName
+	}
+	if utf8.RuneCountInString(channel) > MaxPreferenceChannelLength {
+		return api.ErrPreferenceTooLong
+	}
+	return nil
+}
+
+// isNotificationLevel accepts empty level, which stands for the default
+func isNotificationLevel(level api.NotificationLevel) bool {
+	if level == "" {
+		return true
+	}
+	for _, known := range api.NotificationLevels {
+		if level == known {
+			return true
+		}
+	}
+	return false
+}
+
+// withDefaults fills in settings the user left empty, so that clients don't need to know the defaults
+func withDefaults(prefs *api.Preferences) *api.Preferences {
+	if prefs.NotificationLevel == "" {
+		prefs.NotificationLevel = api.NotifyAll
+	}
+	if prefs.MutedChannels == nil {
+		prefs.MutedChannels = []string{}
+	}
+	if prefs.ChannelNotifications == nil {
+		prefs.ChannelNotifications = map[string]api.NotificationLevel{}
+	}
+	return prefs
+}
+
+func (s *PreferencesService) setInCache(ctx context.Context, nick string, prefs *api.Preferences) {
+	v, err := json.Marshal(prefs)
+	if err != nil {
+		log.Print("warning: error marshaling preferences for cache")
+	}
+	s.Cache.Set(ctx, preferencesCacheKeyPrefix+nick, v, preferencesCacheTTL)
+}
+
+func (s *PreferencesService) removeFromCache(ctx context.Context, nick string) {
+	s.Cache.Del(ctx, preferencesCacheKeyPrefix+nick)
+}
+
+func (s *PreferencesService) getFromCache(ctx context.Context, nick string) (*api.Preferences, error) {
+	cached, err := s.Cache.Get(ctx, preferencesCacheKeyPrefix+nick).Result()
+	if err != nil {
+		return nil, err
+	}
+	var prefs api.Preferences
+	if err := json.Unmarshal([]byte(cached), &prefs); err != nil {
+		log.Print("warning: error unmarshaling preferences from cache")
+	}
+	return &prefs, nil
+}
@@ -0,0 +1,65 @@
+package sockchat
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/kacperf531/sockchat/api"
+	"github.com/kacperf531/sockchat/test_utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreferencesService(t *testing.T) {
+	t.Parallel()
+
+	cache := test_utils.NewTestingRedisClient(t)
+
+	store := &test_utils.PreferencesStoreDouble{}
+	service := &PreferencesService{Store: store, Cache: cache}
+	ctx := context.Background()
+	nick := "PreferencesTestUser"
+
+	t.Run("returns defaults to user who never saved preferences", func(t *testing.T) {
+		require.NoError(t, service.Forget(ctx, nick))
+		prefs, err := service.GetPreferences(ctx, nick)
+		require.NoError(t, err)
+		assert.Equal(t, &api.Preferences{NotificationLevel: api.NotifyAll, MutedChannels: []string{}, ChannelNotifications: map[string]api.NotificationLevel{}}, prefs)
+	})
+
+	t.Run("returns updated preferences instead of cached ones", func(t *testing.T) {
+		updated := &api.Preferences{
+			Theme:                "dark",
+			Language:             "pl",
+			NotificationLevel:    api.NotifyMentions,
+			MutedChannels:        []string{"random"},
+			ChannelNotifications: map[string]api.NotificationLevel{"general": api.NotifyNone},
+		}
+		require.NoError(t, service.UpdatePreferences(ctx, nick, updated))
+		prefs, err := service.GetPreferences(ctx, nick)
+		require.NoError(t, err)
+		assert.Equal(t, updated, prefs)
+
+		// served from the cache this time
+		prefs, err = service.GetPreferences(ctx, nick)
+		require.NoError(t, err)
+		assert.Equal(t, updated, prefs)
+	})
+
+	t.Run("returns error on invalid preferences", func(t *testing.T) {
+		tooManyChannels := make([]string, MaxPreferenceChannels+1)
+		for i := range tooManyChannels {
+			tooManyChannels[i] = "channel"
+		}
+		invalidPreferences := map[error]*api.Preferences{
+			api.ErrPreferenceTooLong:   {Theme: strings.Repeat("x", MaxThemeLength+1)},
+			api.ErrInvalidNotification: {ChannelNotifications: map[string]api.NotificationLevel{"general": "sometimes"}},
+			api.ErrTooManyChannelPrefs: {MutedChannels: tooManyChannels},
+			api.ErrEmptyChannelName:    {MutedChannels: []string{" "}},
+		}
+		for expected, prefs := range invalidPreferences {
+			assert.Equal(t, expected, service.UpdatePreferences(ctx, nick, prefs))
+		}
+	})
+}
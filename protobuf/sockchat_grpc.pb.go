@@ -24,10 +24,42 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type SockchatClient interface {
 	RegisterProfile(ctx context.Context, in *RegisterProfileRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	Login(ctx context.Context, in *LoginRequest, opts ...grpc.CallOption) (*SessionTokens, error)
+	RefreshSession(ctx context.Context, in *RefreshSessionRequest, opts ...grpc.CallOption) (*SessionTokens, error)
+	Logout(ctx context.Context, in *LogoutRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	GetProfile(ctx context.Context, in *GetProfileRequest, opts ...grpc.CallOption) (*Profile, error)
 	EditProfile(ctx context.Context, in *EditProfileRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	UploadAvatar(ctx context.Context, in *UploadAvatarRequest, opts ...grpc.CallOption) (*Profile, error)
+	SearchUsers(ctx context.Context, in *SearchUsersRequest, opts ...grpc.CallOption) (*SearchUsersResponse, error)
+	SetDirectoryVisibility(ctx context.Context, in *DirectoryVisibilityRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	ChangePassword(ctx context.Context, in *ChangePasswordRequest, opts ...grpc.CallOption) (*ChangePasswordResponse, error)
+	ChangeNick(ctx context.Context, in *ChangeNickRequest, opts ...grpc.CallOption) (*ChangeNickResponse, error)
+	RequestPasswordReset(ctx context.Context, in *RequestPasswordResetRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	ResetPassword(ctx context.Context, in *ResetPasswordRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	CreateAPIKey(ctx context.Context, in *CreateAPIKeyRequest, opts ...grpc.CallOption) (*CreatedAPIKey, error)
+	ListAPIKeys(ctx context.Context, in *ListAPIKeysRequest, opts ...grpc.CallOption) (*ListAPIKeysResponse, error)
+	RevokeAPIKey(ctx context.Context, in *RevokeAPIKeyRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	EnrollTOTP(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, in *TOTPCodeRequest, opts ...grpc.CallOption) (*RecoveryCodes, error)
+	DisableTOTP(ctx context.Context, in *TOTPCodeRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	Block(ctx context.Context, in *BlockRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	Unblock(ctx context.Context, in *BlockRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	ListBlocks(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ListBlocksResponse, error)
+	GetPreferences(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*Preferences, error)
+	UpdatePreferences(ctx context.Context, in *Preferences, opts ...grpc.CallOption) (*Preferences, error)
+	DeleteAccount(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	ExportMyData(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ExportMyDataResponse, error)
+	ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*ListUsersResponse, error)
+	CreateServiceAccount(ctx context.Context, in *CreateServiceAccountRequest, opts ...grpc.CallOption) (*Account, error)
+	SetRole(ctx context.Context, in *SetRoleRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	DisableAccount(ctx context.Context, in *AccountRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	EnableAccount(ctx context.Context, in *AccountRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	DisconnectUser(ctx context.Context, in *AccountRequest, opts ...grpc.CallOption) (*DisconnectUserResponse, error)
 	GetChannelHistory(ctx context.Context, in *GetChannelHistoryRequest, opts ...grpc.CallOption) (*GetChannelHistoryResponse, error)
+	SearchMessages(ctx context.Context, in *SearchMessagesRequest, opts ...grpc.CallOption) (*SearchMessagesResponse, error)
 	GetUserActivityReport(ctx context.Context, in *GetUserActivityReportRequest, opts ...grpc.CallOption) (*GetUserActivityReportResponse, error)
+	Chat(ctx context.Context, opts ...grpc.CallOption) (Sockchat_ChatClient, error)
+	SubscribeChannel(ctx context.Context, in *SubscribeChannelRequest, opts ...grpc.CallOption) (Sockchat_SubscribeChannelClient, error)
 }
 
 type sockchatClient struct {
@@ -47,136 +79,1119 @@ func (c *sockchatClient) RegisterProfile(ctx context.Context, in *RegisterProfil
 	return out, nil
 }
 
+func (c *sockchatClient) Login(ctx context.Context, in *LoginRequest, opts ...grpc.CallOption) (*SessionTokens, error) {
+	out := new(SessionTokens)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/Login", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) RefreshSession(ctx context.Context, in *RefreshSessionRequest, opts ...grpc.CallOption) (*SessionTokens, error) {
+	out := new(SessionTokens)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/RefreshSession", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) Logout(ctx context.Context, in *LogoutRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/Logout", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *sockchatClient) GetProfile(ctx context.Context, in *GetProfileRequest, opts ...grpc.CallOption) (*Profile, error) {
 	out := new(Profile)
 	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/GetProfile", in, out, opts...)
 	if err != nil {
 		return nil, err
 	}
-	return out, nil
+	return out, nil
+}
+
+func (c *sockchatClient) EditProfile(ctx context.Context, in *EditProfileRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/EditProfile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) UploadAvatar(ctx context.Context, in *UploadAvatarRequest, opts ...grpc.CallOption) (*Profile, error) {
+	out := new(Profile)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/UploadAvatar", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) SearchUsers(ctx context.Context, in *SearchUsersRequest, opts ...grpc.CallOption) (*SearchUsersResponse, error) {
+	out := new(SearchUsersResponse)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/SearchUsers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) SetDirectoryVisibility(ctx context.Context, in *DirectoryVisibilityRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/SetDirectoryVisibility", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) ChangePassword(ctx context.Context, in *ChangePasswordRequest, opts ...grpc.CallOption) (*ChangePasswordResponse, error) {
+	out := new(ChangePasswordResponse)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/ChangePassword", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) ChangeNick(ctx context.Context, in *ChangeNickRequest, opts ...grpc.CallOption) (*ChangeNickResponse, error) {
+	out := new(ChangeNickResponse)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/ChangeNick", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) RequestPasswordReset(ctx context.Context, in *RequestPasswordResetRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/RequestPasswordReset", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) ResetPassword(ctx context.Context, in *ResetPasswordRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/ResetPassword", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) CreateAPIKey(ctx context.Context, in *CreateAPIKeyRequest, opts ...grpc.CallOption) (*CreatedAPIKey, error) {
+	out := new(CreatedAPIKey)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/CreateAPIKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) ListAPIKeys(ctx context.Context, in *ListAPIKeysRequest, opts ...grpc.CallOption) (*ListAPIKeysResponse, error) {
+	out := new(ListAPIKeysResponse)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/ListAPIKeys", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) RevokeAPIKey(ctx context.Context, in *RevokeAPIKeyRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/RevokeAPIKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) EnrollTOTP(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*TOTPEnrollment, error) {
+	out := new(TOTPEnrollment)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/EnrollTOTP", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) ConfirmTOTP(ctx context.Context, in *TOTPCodeRequest, opts ...grpc.CallOption) (*RecoveryCodes, error) {
+	out := new(RecoveryCodes)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/ConfirmTOTP", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) DisableTOTP(ctx context.Context, in *TOTPCodeRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/DisableTOTP", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) Block(ctx context.Context, in *BlockRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/Block", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) Unblock(ctx context.Context, in *BlockRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/Unblock", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) ListBlocks(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ListBlocksResponse, error) {
+	out := new(ListBlocksResponse)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/ListBlocks", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) GetPreferences(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*Preferences, error) {
+	out := new(Preferences)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/GetPreferences", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) UpdatePreferences(ctx context.Context, in *Preferences, opts ...grpc.CallOption) (*Preferences, error) {
+	out := new(Preferences)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/UpdatePreferences", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) DeleteAccount(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/DeleteAccount", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) ExportMyData(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ExportMyDataResponse, error) {
+	out := new(ExportMyDataResponse)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/ExportMyData", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*ListUsersResponse, error) {
+	out := new(ListUsersResponse)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/ListUsers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) CreateServiceAccount(ctx context.Context, in *CreateServiceAccountRequest, opts ...grpc.CallOption) (*Account, error) {
+	out := new(Account)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/CreateServiceAccount", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) SetRole(ctx context.Context, in *SetRoleRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/SetRole", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) DisableAccount(ctx context.Context, in *AccountRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/DisableAccount", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) EnableAccount(ctx context.Context, in *AccountRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/EnableAccount", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) DisconnectUser(ctx context.Context, in *AccountRequest, opts ...grpc.CallOption) (*DisconnectUserResponse, error) {
+	out := new(DisconnectUserResponse)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/DisconnectUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) GetChannelHistory(ctx context.Context, in *GetChannelHistoryRequest, opts ...grpc.CallOption) (*GetChannelHistoryResponse, error) {
+	out := new(GetChannelHistoryResponse)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/GetChannelHistory", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) SearchMessages(ctx context.Context, in *SearchMessagesRequest, opts ...grpc.CallOption) (*SearchMessagesResponse, error) {
+	out := new(SearchMessagesResponse)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/SearchMessages", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) GetUserActivityReport(ctx context.Context, in *GetUserActivityReportRequest, opts ...grpc.CallOption) (*GetUserActivityReportResponse, error) {
+	out := new(GetUserActivityReportResponse)
+	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/GetUserActivityReport", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sockchatClient) Chat(ctx context.Context, opts ...grpc.CallOption) (Sockchat_ChatClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Sockchat_ServiceDesc.Streams[0], "/sockchat.Sockchat/Chat", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &sockchatChatClient{stream}
+	return x, nil
+}
+
+type Sockchat_ChatClient interface {
+	Send(*ChatAction) error
+	Recv() (*ChatEvent, error)
+	grpc.ClientStream
+}
+
+type sockchatChatClient struct {
+	grpc.ClientStream
+}
+
+func (x *sockchatChatClient) Send(m *ChatAction) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *sockchatChatClient) Recv() (*ChatEvent, error) {
+	m := new(ChatEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *sockchatClient) SubscribeChannel(ctx context.Context, in *SubscribeChannelRequest, opts ...grpc.CallOption) (Sockchat_SubscribeChannelClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Sockchat_ServiceDesc.Streams[1], "/sockchat.Sockchat/SubscribeChannel", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &sockchatSubscribeChannelClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Sockchat_SubscribeChannelClient interface {
+	Recv() (*ChatEvent, error)
+	grpc.ClientStream
+}
+
+type sockchatSubscribeChannelClient struct {
+	grpc.ClientStream
+}
+
+func (x *sockchatSubscribeChannelClient) Recv() (*ChatEvent, error) {
+	m := new(ChatEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SockchatServer is the server API for Sockchat service.
+// All implementations must embed UnimplementedSockchatServer
+// for forward compatibility
+type SockchatServer interface {
+	RegisterProfile(context.Context, *RegisterProfileRequest) (*emptypb.Empty, error)
+	Login(context.Context, *LoginRequest) (*SessionTokens, error)
+	RefreshSession(context.Context, *RefreshSessionRequest) (*SessionTokens, error)
+	Logout(context.Context, *LogoutRequest) (*emptypb.Empty, error)
+	GetProfile(context.Context, *GetProfileRequest) (*Profile, error)
+	EditProfile(context.Context, *EditProfileRequest) (*emptypb.Empty, error)
+	UploadAvatar(context.Context, *UploadAvatarRequest) (*Profile, error)
+	SearchUsers(context.Context, *SearchUsersRequest) (*SearchUsersResponse, error)
+	SetDirectoryVisibility(context.Context, *DirectoryVisibilityRequest) (*emptypb.Empty, error)
+	ChangePassword(context.Context, *ChangePasswordRequest) (*ChangePasswordResponse, error)
+	ChangeNick(context.Context, *ChangeNickRequest) (*ChangeNickResponse, error)
+	RequestPasswordReset(context.Context, *RequestPasswordResetRequest) (*emptypb.Empty, error)
+	ResetPassword(context.Context, *ResetPasswordRequest) (*emptypb.Empty, error)
+	CreateAPIKey(context.Context, *CreateAPIKeyRequest) (*CreatedAPIKey, error)
+	ListAPIKeys(context.Context, *ListAPIKeysRequest) (*ListAPIKeysResponse, error)
+	RevokeAPIKey(context.Context, *RevokeAPIKeyRequest) (*emptypb.Empty, error)
+	EnrollTOTP(context.Context, *emptypb.Empty) (*TOTPEnrollment, error)
+	ConfirmTOTP(context.Context, *TOTPCodeRequest) (*RecoveryCodes, error)
+	DisableTOTP(context.Context, *TOTPCodeRequest) (*emptypb.Empty, error)
+	Block(context.Context, *BlockRequest) (*emptypb.Empty, error)
+	Unblock(context.Context, *BlockRequest) (*emptypb.Empty, error)
+	ListBlocks(context.Context, *emptypb.Empty) (*ListBlocksResponse, error)
+	GetPreferences(context.Context, *emptypb.Empty) (*Preferences, error)
+	UpdatePreferences(context.Context, *Preferences) (*Preferences, error)
+	DeleteAccount(context.Context, *emptypb.Empty) (*emptypb.Empty, error)
+	ExportMyData(context.Context, *emptypb.Empty) (*ExportMyDataResponse, error)
+	ListUsers(context.Context, *ListUsersRequest) (*ListUsersResponse, error)
+	CreateServiceAccount(context.Context, *CreateServiceAccountRequest) (*Account, error)
+	SetRole(context.Context, *SetRoleRequest) (*emptypb.Empty, error)
+	DisableAccount(context.Context, *AccountRequest) (*emptypb.Empty, error)
+	EnableAccount(context.Context, *AccountRequest) (*emptypb.Empty, error)
+	DisconnectUser(context.Context, *AccountRequest) (*DisconnectUserResponse, error)
+	GetChannelHistory(context.Context, *GetChannelHistoryRequest) (*GetChannelHistoryResponse, error)
+	SearchMessages(context.Context, *SearchMessagesRequest) (*SearchMessagesResponse, error)
+	GetUserActivityReport(context.Context, *GetUserActivityReportRequest) (*GetUserActivityReportResponse, error)
+	Chat(Sockchat_ChatServer) error
+	SubscribeChannel(*SubscribeChannelRequest, Sockchat_SubscribeChannelServer) error
+	mustEmbedUnimplementedSockchatServer()
+}
+
+// UnimplementedSockchatServer must be embedded to have forward compatible implementations.
+type UnimplementedSockchatServer struct {
+}
+
+func (UnimplementedSockchatServer) RegisterProfile(context.Context, *RegisterProfileRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterProfile not implemented")
+}
+func (UnimplementedSockchatServer) Login(context.Context, *LoginRequest) (*SessionTokens, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Login not implemented")
+}
+func (UnimplementedSockchatServer) RefreshSession(context.Context, *RefreshSessionRequest) (*SessionTokens, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RefreshSession not implemented")
+}
+func (UnimplementedSockchatServer) Logout(context.Context, *LogoutRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Logout not implemented")
+}
+func (UnimplementedSockchatServer) GetProfile(context.Context, *GetProfileRequest) (*Profile, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetProfile not implemented")
+}
+func (UnimplementedSockchatServer) EditProfile(context.Context, *EditProfileRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EditProfile not implemented")
+}
+func (UnimplementedSockchatServer) UploadAvatar(context.Context, *UploadAvatarRequest) (*Profile, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UploadAvatar not implemented")
+}
+func (UnimplementedSockchatServer) SearchUsers(context.Context, *SearchUsersRequest) (*SearchUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchUsers not implemented")
+}
+func (UnimplementedSockchatServer) SetDirectoryVisibility(context.Context, *DirectoryVisibilityRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetDirectoryVisibility not implemented")
+}
+func (UnimplementedSockchatServer) ChangePassword(context.Context, *ChangePasswordRequest) (*ChangePasswordResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ChangePassword not implemented")
+}
+func (UnimplementedSockchatServer) ChangeNick(context.Context, *ChangeNickRequest) (*ChangeNickResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ChangeNick not implemented")
+}
+func (UnimplementedSockchatServer) RequestPasswordReset(context.Context, *RequestPasswordResetRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RequestPasswordReset not implemented")
+}
+func (UnimplementedSockchatServer) ResetPassword(context.Context, *ResetPasswordRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResetPassword not implemented")
+}
+func (UnimplementedSockchatServer) CreateAPIKey(context.Context, *CreateAPIKeyRequest) (*CreatedAPIKey, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateAPIKey not implemented")
+}
+func (UnimplementedSockchatServer) ListAPIKeys(context.Context, *ListAPIKeysRequest) (*ListAPIKeysResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAPIKeys not implemented")
+}
+func (UnimplementedSockchatServer) RevokeAPIKey(context.Context, *RevokeAPIKeyRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeAPIKey not implemented")
+}
+func (UnimplementedSockchatServer) EnrollTOTP(context.Context, *emptypb.Empty) (*TOTPEnrollment, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EnrollTOTP not implemented")
+}
+func (UnimplementedSockchatServer) ConfirmTOTP(context.Context, *TOTPCodeRequest) (*RecoveryCodes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ConfirmTOTP not implemented")
+}
+func (UnimplementedSockchatServer) DisableTOTP(context.Context, *TOTPCodeRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DisableTOTP not implemented")
+}
+func (UnimplementedSockchatServer) Block(context.Context, *BlockRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Block not implemented")
+}
+func (UnimplementedSockchatServer) Unblock(context.Context, *BlockRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Unblock not implemented")
+}
+func (UnimplementedSockchatServer) ListBlocks(context.Context, *emptypb.Empty) (*ListBlocksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListBlocks not implemented")
+}
+func (UnimplementedSockchatServer) GetPreferences(context.Context, *emptypb.Empty) (*Preferences, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPreferences not implemented")
+}
+func (UnimplementedSockchatServer) UpdatePreferences(context.Context, *Preferences) (*Preferences, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdatePreferences not implemented")
+}
+func (UnimplementedSockchatServer) DeleteAccount(context.Context, *emptypb.Empty) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteAccount not implemented")
+}
+func (UnimplementedSockchatServer) ExportMyData(context.Context, *emptypb.Empty) (*ExportMyDataResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ExportMyData not implemented")
+}
+func (UnimplementedSockchatServer) ListUsers(context.Context, *ListUsersRequest) (*ListUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListUsers not implemented")
+}
+func (UnimplementedSockchatServer) CreateServiceAccount(context.Context, *CreateServiceAccountRequest) (*Account, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateServiceAccount not implemented")
+}
+func (UnimplementedSockchatServer) SetRole(context.Context, *SetRoleRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetRole not implemented")
+}
+func (UnimplementedSockchatServer) DisableAccount(context.Context, *AccountRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DisableAccount not implemented")
+}
+func (UnimplementedSockchatServer) EnableAccount(context.Context, *AccountRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EnableAccount not implemented")
+}
+func (UnimplementedSockchatServer) DisconnectUser(context.Context, *AccountRequest) (*DisconnectUserResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DisconnectUser not implemented")
+}
+func (UnimplementedSockchatServer) GetChannelHistory(context.Context, *GetChannelHistoryRequest) (*GetChannelHistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetChannelHistory not implemented")
+}
+func (UnimplementedSockchatServer) SearchMessages(context.Context, *SearchMessagesRequest) (*SearchMessagesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchMessages not implemented")
+}
+func (UnimplementedSockchatServer) GetUserActivityReport(context.Context, *GetUserActivityReportRequest) (*GetUserActivityReportResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUserActivityReport not implemented")
+}
+func (UnimplementedSockchatServer) Chat(Sockchat_ChatServer) error {
+	return status.Errorf(codes.Unimplemented, "method Chat not implemented")
+}
+func (UnimplementedSockchatServer) SubscribeChannel(*SubscribeChannelRequest, Sockchat_SubscribeChannelServer) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeChannel not implemented")
+}
+func (UnimplementedSockchatServer) mustEmbedUnimplementedSockchatServer() {}
+
+// UnsafeSockchatServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SockchatServer will
+// result in compilation errors.
+type UnsafeSockchatServer interface {
+	mustEmbedUnimplementedSockchatServer()
+}
+
+func RegisterSockchatServer(s grpc.ServiceRegistrar, srv SockchatServer) {
+	s.RegisterService(&Sockchat_ServiceDesc, srv)
+}
+
+func _Sockchat_RegisterProfile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterProfileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).RegisterProfile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/RegisterProfile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).RegisterProfile(ctx, req.(*RegisterProfileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sockchat_Login_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LoginRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).Login(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/Login",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).Login(ctx, req.(*LoginRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sockchat_RefreshSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RefreshSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).RefreshSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/RefreshSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).RefreshSession(ctx, req.(*RefreshSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sockchat_Logout_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LogoutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).Logout(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/Logout",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).Logout(ctx, req.(*LogoutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sockchat_GetProfile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetProfileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).GetProfile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/GetProfile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).GetProfile(ctx, req.(*GetProfileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sockchat_EditProfile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EditProfileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).EditProfile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/EditProfile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).EditProfile(ctx, req.(*EditProfileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sockchat_UploadAvatar_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UploadAvatarRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).UploadAvatar(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/UploadAvatar",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).UploadAvatar(ctx, req.(*UploadAvatarRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sockchat_SearchUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).SearchUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/SearchUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).SearchUsers(ctx, req.(*SearchUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sockchat_SetDirectoryVisibility_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DirectoryVisibilityRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).SetDirectoryVisibility(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/SetDirectoryVisibility",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).SetDirectoryVisibility(ctx, req.(*DirectoryVisibilityRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sockchat_ChangePassword_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChangePasswordRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).ChangePassword(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/ChangePassword",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).ChangePassword(ctx, req.(*ChangePasswordRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sockchat_ChangeNick_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChangeNickRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).ChangeNick(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/ChangeNick",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).ChangeNick(ctx, req.(*ChangeNickRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sockchat_RequestPasswordReset_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RequestPasswordResetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).RequestPasswordReset(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/RequestPasswordReset",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).RequestPasswordReset(ctx, req.(*RequestPasswordResetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sockchat_ResetPassword_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResetPasswordRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).ResetPassword(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/ResetPassword",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).ResetPassword(ctx, req.(*ResetPasswordRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sockchat_CreateAPIKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateAPIKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).CreateAPIKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/CreateAPIKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).CreateAPIKey(ctx, req.(*CreateAPIKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sockchat_ListAPIKeys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAPIKeysRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).ListAPIKeys(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/ListAPIKeys",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).ListAPIKeys(ctx, req.(*ListAPIKeysRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sockchat_RevokeAPIKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeAPIKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).RevokeAPIKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/RevokeAPIKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).RevokeAPIKey(ctx, req.(*RevokeAPIKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sockchat_EnrollTOTP_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).EnrollTOTP(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/EnrollTOTP",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).EnrollTOTP(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sockchat_ConfirmTOTP_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TOTPCodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).ConfirmTOTP(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/ConfirmTOTP",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).ConfirmTOTP(ctx, req.(*TOTPCodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sockchat_DisableTOTP_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TOTPCodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).DisableTOTP(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/DisableTOTP",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).DisableTOTP(ctx, req.(*TOTPCodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sockchat_Block_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BlockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).Block(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/Block",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).Block(ctx, req.(*BlockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sockchat_Unblock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BlockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).Unblock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/Unblock",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).Unblock(ctx, req.(*BlockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
 }
 
-func (c *sockchatClient) EditProfile(ctx context.Context, in *EditProfileRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
-	out := new(emptypb.Empty)
-	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/EditProfile", in, out, opts...)
-	if err != nil {
+func _Sockchat_ListBlocks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
 		return nil, err
 	}
-	return out, nil
+	if interceptor == nil {
+		return srv.(SockchatServer).ListBlocks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/ListBlocks",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).ListBlocks(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
 }
 
-func (c *sockchatClient) GetChannelHistory(ctx context.Context, in *GetChannelHistoryRequest, opts ...grpc.CallOption) (*GetChannelHistoryResponse, error) {
-	out := new(GetChannelHistoryResponse)
-	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/GetChannelHistory", in, out, opts...)
-	if err != nil {
+func _Sockchat_GetPreferences_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
 		return nil, err
 	}
-	return out, nil
+	if interceptor == nil {
+		return srv.(SockchatServer).GetPreferences(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/GetPreferences",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).GetPreferences(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
 }
 
-func (c *sockchatClient) GetUserActivityReport(ctx context.Context, in *GetUserActivityReportRequest, opts ...grpc.CallOption) (*GetUserActivityReportResponse, error) {
-	out := new(GetUserActivityReportResponse)
-	err := c.cc.Invoke(ctx, "/sockchat.Sockchat/GetUserActivityReport", in, out, opts...)
-	if err != nil {
+func _Sockchat_UpdatePreferences_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Preferences)
+	if err := dec(in); err != nil {
 		return nil, err
 	}
-	return out, nil
+	if interceptor == nil {
+		return srv.(SockchatServer).UpdatePreferences(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/UpdatePreferences",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).UpdatePreferences(ctx, req.(*Preferences))
+	}
+	return interceptor(ctx, in, info, handler)
 }
 
-// SockchatServer is the server API for Sockchat service.
-// All implementations must embed UnimplementedSockchatServer
-// for forward compatibility
-type SockchatServer interface {
-	RegisterProfile(context.Context, *RegisterProfileRequest) (*emptypb.Empty, error)
-	GetProfile(context.Context, *GetProfileRequest) (*Profile, error)
-	EditProfile(context.Context, *EditProfileRequest) (*emptypb.Empty, error)
-	GetChannelHistory(context.Context, *GetChannelHistoryRequest) (*GetChannelHistoryResponse, error)
-	GetUserActivityReport(context.Context, *GetUserActivityReportRequest) (*GetUserActivityReportResponse, error)
-	mustEmbedUnimplementedSockchatServer()
+func _Sockchat_DeleteAccount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).DeleteAccount(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/DeleteAccount",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).DeleteAccount(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
 }
 
-// UnimplementedSockchatServer must be embedded to have forward compatible implementations.
-type UnimplementedSockchatServer struct {
+func _Sockchat_ExportMyData_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).ExportMyData(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/ExportMyData",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).ExportMyData(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
 }
 
-func (UnimplementedSockchatServer) RegisterProfile(context.Context, *RegisterProfileRequest) (*emptypb.Empty, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method RegisterProfile not implemented")
-}
-func (UnimplementedSockchatServer) GetProfile(context.Context, *GetProfileRequest) (*Profile, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method GetProfile not implemented")
-}
-func (UnimplementedSockchatServer) EditProfile(context.Context, *EditProfileRequest) (*emptypb.Empty, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method EditProfile not implemented")
-}
-func (UnimplementedSockchatServer) GetChannelHistory(context.Context, *GetChannelHistoryRequest) (*GetChannelHistoryResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method GetChannelHistory not implemented")
-}
-func (UnimplementedSockchatServer) GetUserActivityReport(context.Context, *GetUserActivityReportRequest) (*GetUserActivityReportResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method GetUserActivityReport not implemented")
+func _Sockchat_ListUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).ListUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/ListUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).ListUsers(ctx, req.(*ListUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
 }
-func (UnimplementedSockchatServer) mustEmbedUnimplementedSockchatServer() {}
 
-// UnsafeSockchatServer may be embedded to opt out of forward compatibility for this service.
-// Use of this interface is not recommended, as added methods to SockchatServer will
-// result in compilation errors.
-type UnsafeSockchatServer interface {
-	mustEmbedUnimplementedSockchatServer()
+func _Sockchat_CreateServiceAccount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateServiceAccountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).CreateServiceAccount(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/CreateServiceAccount",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).CreateServiceAccount(ctx, req.(*CreateServiceAccountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
 }
 
-func RegisterSockchatServer(s grpc.ServiceRegistrar, srv SockchatServer) {
-	s.RegisterService(&Sockchat_ServiceDesc, srv)
+func _Sockchat_SetRole_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetRoleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).SetRole(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/SetRole",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).SetRole(ctx, req.(*SetRoleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
 }
 
-func _Sockchat_RegisterProfile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(RegisterProfileRequest)
+func _Sockchat_DisableAccount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AccountRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(SockchatServer).RegisterProfile(ctx, in)
+		return srv.(SockchatServer).DisableAccount(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/sockchat.Sockchat/RegisterProfile",
+		FullMethod: "/sockchat.Sockchat/DisableAccount",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(SockchatServer).RegisterProfile(ctx, req.(*RegisterProfileRequest))
+		return srv.(SockchatServer).DisableAccount(ctx, req.(*AccountRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _Sockchat_GetProfile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(GetProfileRequest)
+func _Sockchat_EnableAccount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AccountRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(SockchatServer).GetProfile(ctx, in)
+		return srv.(SockchatServer).EnableAccount(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/sockchat.Sockchat/GetProfile",
+		FullMethod: "/sockchat.Sockchat/EnableAccount",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(SockchatServer).GetProfile(ctx, req.(*GetProfileRequest))
+		return srv.(SockchatServer).EnableAccount(ctx, req.(*AccountRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _Sockchat_EditProfile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(EditProfileRequest)
+func _Sockchat_DisconnectUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AccountRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(SockchatServer).EditProfile(ctx, in)
+		return srv.(SockchatServer).DisconnectUser(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/sockchat.Sockchat/EditProfile",
+		FullMethod: "/sockchat.Sockchat/DisconnectUser",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(SockchatServer).EditProfile(ctx, req.(*EditProfileRequest))
+		return srv.(SockchatServer).DisconnectUser(ctx, req.(*AccountRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
@@ -199,6 +1214,24 @@ func _Sockchat_GetChannelHistory_Handler(srv interface{}, ctx context.Context, d
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Sockchat_SearchMessages_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchMessagesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SockchatServer).SearchMessages(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sockchat.Sockchat/SearchMessages",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SockchatServer).SearchMessages(ctx, req.(*SearchMessagesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Sockchat_GetUserActivityReport_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(GetUserActivityReportRequest)
 	if err := dec(in); err != nil {
@@ -217,6 +1250,53 @@ func _Sockchat_GetUserActivityReport_Handler(srv interface{}, ctx context.Contex
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Sockchat_Chat_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(SockchatServer).Chat(&sockchatChatServer{stream})
+}
+
+type Sockchat_ChatServer interface {
+	Send(*ChatEvent) error
+	Recv() (*ChatAction, error)
+	grpc.ServerStream
+}
+
+type sockchatChatServer struct {
+	grpc.ServerStream
+}
+
+func (x *sockchatChatServer) Send(m *ChatEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *sockchatChatServer) Recv() (*ChatAction, error) {
+	m := new(ChatAction)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Sockchat_SubscribeChannel_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeChannelRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SockchatServer).SubscribeChannel(m, &sockchatSubscribeChannelServer{stream})
+}
+
+type Sockchat_SubscribeChannelServer interface {
+	Send(*ChatEvent) error
+	grpc.ServerStream
+}
+
+type sockchatSubscribeChannelServer struct {
+	grpc.ServerStream
+}
+
+func (x *sockchatSubscribeChannelServer) Send(m *ChatEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // Sockchat_ServiceDesc is the grpc.ServiceDesc for Sockchat service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -228,6 +1308,18 @@ var Sockchat_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "RegisterProfile",
 			Handler:    _Sockchat_RegisterProfile_Handler,
 		},
+		{
+			MethodName: "Login",
+			Handler:    _Sockchat_Login_Handler,
+		},
+		{
+			MethodName: "RefreshSession",
+			Handler:    _Sockchat_RefreshSession_Handler,
+		},
+		{
+			MethodName: "Logout",
+			Handler:    _Sockchat_Logout_Handler,
+		},
 		{
 			MethodName: "GetProfile",
 			Handler:    _Sockchat_GetProfile_Handler,
@@ -236,15 +1328,135 @@ var Sockchat_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "EditProfile",
 			Handler:    _Sockchat_EditProfile_Handler,
 		},
+		{
+			MethodName: "UploadAvatar",
+			Handler:    _Sockchat_UploadAvatar_Handler,
+		},
+		{
+			MethodName: "SearchUsers",
+			Handler:    _Sockchat_SearchUsers_Handler,
+		},
+		{
+			MethodName: "SetDirectoryVisibility",
+			Handler:    _Sockchat_SetDirectoryVisibility_Handler,
+		},
+		{
+			MethodName: "ChangePassword",
+			Handler:    _Sockchat_ChangePassword_Handler,
+		},
+		{
+			MethodName: "ChangeNick",
+			Handler:    _Sockchat_ChangeNick_Handler,
+		},
+		{
+			MethodName: "RequestPasswordReset",
+			Handler:    _Sockchat_RequestPasswordReset_Handler,
+		},
+		{
+			MethodName: "ResetPassword",
+			Handler:    _Sockchat_ResetPassword_Handler,
+		},
+		{
+			MethodName: "CreateAPIKey",
+			Handler:    _Sockchat_CreateAPIKey_Handler,
+		},
+		{
+			MethodName: "ListAPIKeys",
+			Handler:    _Sockchat_ListAPIKeys_Handler,
+		},
+		{
+			MethodName: "RevokeAPIKey",
+			Handler:    _Sockchat_RevokeAPIKey_Handler,
+		},
+		{
+			MethodName: "EnrollTOTP",
+			Handler:    _Sockchat_EnrollTOTP_Handler,
+		},
+		{
+			MethodName: "ConfirmTOTP",
+			Handler:    _Sockchat_ConfirmTOTP_Handler,
+		},
+		{
+			MethodName: "DisableTOTP",
+			Handler:    _Sockchat_DisableTOTP_Handler,
+		},
+		{
+			MethodName: "Block",
+			Handler:    _Sockchat_Block_Handler,
+		},
+		{
+			MethodName: "Unblock",
+			Handler:    _Sockchat_Unblock_Handler,
+		},
+		{
+			MethodName: "ListBlocks",
+			Handler:    _Sockchat_ListBlocks_Handler,
+		},
+		{
+			MethodName: "GetPreferences",
+			Handler:    _Sockchat_GetPreferences_Handler,
+		},
+		{
+			MethodName: "UpdatePreferences",
+			Handler:    _Sockchat_UpdatePreferences_Handler,
+		},
+		{
+			MethodName: "DeleteAccount",
+			Handler:    _Sockchat_DeleteAccount_Handler,
+		},
+		{
+			MethodName: "ExportMyData",
+			Handler:    _Sockchat_ExportMyData_Handler,
+		},
+		{
+			MethodName: "ListUsers",
+			Handler:    _Sockchat_ListUsers_Handler,
+		},
+		{
+			MethodName: "CreateServiceAccount",
+			Handler:    _Sockchat_CreateServiceAccount_Handler,
+		},
+		{
+			MethodName: "SetRole",
+			Handler:    _Sockchat_SetRole_Handler,
+		},
+		{
+			MethodName: "DisableAccount",
+			Handler:    _Sockchat_DisableAccount_Handler,
+		},
+		{
+			MethodName: "EnableAccount",
+			Handler:    _Sockchat_EnableAccount_Handler,
+		},
+		{
+			MethodName: "DisconnectUser",
+			Handler:    _Sockchat_DisconnectUser_Handler,
+		},
 		{
 			MethodName: "GetChannelHistory",
 			Handler:    _Sockchat_GetChannelHistory_Handler,
 		},
+		{
+			MethodName: "SearchMessages",
+			Handler:    _Sockchat_SearchMessages_Handler,
+		},
 		{
 			MethodName: "GetUserActivityReport",
 			Handler:    _Sockchat_GetUserActivityReport_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Chat",
+			Handler:       _Sockchat_Chat_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "SubscribeChannel",
+			Handler:       _Sockchat_SubscribeChannel_Handler,
+			ServerStreams: true,
+		},
+	},
 	Metadata: "protobuf/sockchat.proto",
 }
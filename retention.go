@@ -0,0 +1,157 @@
+package sockchat
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kacperf531/sockchat/storage"
+)
+
+const DefaultRetentionInterval = time.Hour
+
+// RetentionPolicy tells how long messages are kept; zero age stands for keeping messages forever
+type RetentionPolicy struct {
+	Default time.Duration
+	// Channels override the default, including with zero
+	Channels map[string]time.Duration
+}
+
+// ParseRetentionPolicy reads the default age and comma separated channel=age overrides, e.g. "general=30d,random=7d"
+func ParseRetentionPolicy(defaultAge, channelAges string) (*RetentionPolicy, error) {
+	policy := &RetentionPolicy{Channels: map[string]time.Duration{}}
+	var err error
+	if policy.Default, err = ParseRetentionAge(defaultAge); err != nil {
+		return nil, err
+	}
+	for _, override := range strings.Split(channelAges, ",") {
+		if strings.TrimSpace(override) == "" {
+			continue
+		}
+		channel, age, ok := strings.Cut(override, "=")
+		if !ok || strings.TrimSpace(channel) == "" {
+			return nil, fmt.Errorf("invalid retention of channel %q, expected channel=age", override)
+		}
+		if policy.Channels[strings.TrimSpace(channel)], err = ParseRetentionAge(age); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// ParseRetentionAge accepts days, e.g. "30d", or Go durations; empty age stands for keeping messages forever
+func ParseRetentionAge(age string) (time.Duration, error) {
+	age = strings.TrimSpace(age)
+	if age == "" {
+		return 0, nil
+	}
+	var d time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(age, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(age)
+	}
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid retention age %q", age)
+	}
+	return d, nil
+}
+
+func (p *RetentionPolicy) MaxAge(channel string) time.Duration {
+	if age, ok := p.Channels[channel]; ok {
+		return age
+	}
+	return p.Default
+}
+
+func (p *RetentionPolicy) RetainedSince(channel string, now time.Time) int64 {
+	age := p.MaxAge(channel)
+	if age == 0 {
+		return 0
+	}
+	return now.Add(-age).Unix()
+}
+
+// RetentionJob purges expired messages in intervals
+type RetentionJob struct {
+	Policy *RetentionPolicy
+	Store  storage.MessagePurger
+	// zero value stands for the default
+	Interval time.Duration
+
+	purged  atomic.Int64
+	lastRun atomic.Int64
+}
+
+// RetentionStats are published as metrics of the job
+type RetentionStats struct {
+	Purged  int64 `json:"purged"`
+	LastRun int64 `json:"last_run"`
+}
+
+// Run purges expired messages right away and then in intervals, until the context is done
+func (j *RetentionJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval())
+	defer ticker.Stop()
+	for {
+		if purged, err := j.Purge(ctx, time.Now()); err != nil {
+			log.Printf("error purging expired messages: %v", err)
+		} else if purged > 0 {
+			log.Printf("purged %d expired messages", purged)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge deletes messages older than the retention of their channels and returns how many were deleted
+func (j *RetentionJob) Purge(ctx context.Context, now time.Time) (int64, error) {
+	var batches []*storage.ExpiredMessages
+	overridden := make([]string, 0, len(j.Policy.Channels))
+	for channel := range j.Policy.Channels {
+		overridden = append(overridden, channel)
+	}
+	sort.Strings(overridden)
+	for _, channel := range overridden {
+		if since := j.Policy.RetainedSince(channel, now); since > 0 {
+			batches = append(batches, &storage.ExpiredMessages{Channels: []string{channel}, Before: since})
+		}
+	}
+	if j.Policy.Default > 0 {
+		batches = append(batches, &storage.ExpiredMessages{ExcludedChannels: overridden, Before: now.Add(-j.Policy.Default).Unix()})
+	}
+
+	var purged int64
+	defer func() { j.purged.Add(purged) }()
+	for _, expired := range batches {
+		deleted, err := j.Store.DeleteMessages(ctx, expired)
+		purged += deleted
+		if err != nil {
+			return purged, err
+		}
+	}
+	j.lastRun.Store(now.Unix())
+	return purged, nil
+}
+
+func (j *RetentionJob) Stats() RetentionStats {
+	return RetentionStats{Purged: j.purged.Load(), LastRun: j.lastRun.Load()}
+}
+
+func (j *RetentionJob) interval() time.Duration {
+	if j.Interval == 0 {
+		return DefaultRetentionInterval
+	}
+	return j.Interval
+}
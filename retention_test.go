@@ -0,0 +1,93 @@
+package sockchat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kacperf531/sockchat/api"
+	"github.com/kacperf531/sockchat/test_utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetentionPolicy(t *testing.T) {
+	t.Parallel()
+
+	t.Run("parses default and channel ages", func(t *testing.T) {
+		policy, err := ParseRetentionPolicy("90d", "general=30d, random=12h,archive=")
+		require.NoError(t, err)
+		assert.Equal(t, 90*24*time.Hour, policy.Default)
+		assert.Equal(t, 30*24*time.Hour, policy.MaxAge("general"))
+		assert.Equal(t, 12*time.Hour, policy.MaxAge("random"))
+		assert.Equal(t, time.Duration(0), policy.MaxAge("archive"))
+		assert.Equal(t, 90*24*time.Hour, policy.MaxAge("other"))
+	})
+
+	t.Run("keeps messages forever when not configured", func(t *testing.T) {
+		policy, err := ParseRetentionPolicy("", "")
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), policy.RetainedSince("general", time.Now()))
+	})
+
+	t.Run("rejects invalid ages", func(t *testing.T) {
+		for _, ages := range [][2]string{{"30 days", ""}, {"-1h", ""}, {"", "general"}, {"", "=30d"}, {"", "general=xd"}} {
+			_, err := ParseRetentionPolicy(ages[0], ages[1])
+			assert.Error(t, err, ages)
+		}
+	})
+
+	t.Run("returns timestamp of the oldest kept message", func(t *testing.T) {
+		policy := &RetentionPolicy{Default: time.Hour, Channels: map[string]time.Duration{"archive": 0}}
+		now := time.Unix(100000, 0)
+		assert.Equal(t, int64(100000-3600), policy.RetainedSince("general", now))
+		assert.Equal(t, int64(0), policy.RetainedSince("archive", now))
+	})
+}
+
+func TestRetentionJob(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(1000000, 0)
+	day := int64(24 * time.Hour / time.Second)
+
+	t.Run("purges messages past retention of their channels", func(t *testing.T) {
+		store := &test_utils.MessagePurgerDouble{Messages: api.ChannelHistory{
+			{Channel: "general", Text: "expired", Timestamp: now.Unix() - 10*day},
+			{Channel: "general", Text: "kept", Timestamp: now.Unix() - 2*day},
+			{Channel: "random", Text: "expired", Timestamp: now.Unix() - 2*day},
+			{Channel: "random", Text: "kept", Timestamp: now.Unix() - 1},
+			{Channel: "archive", Text: "kept", Timestamp: 1},
+		}}
+		job := &RetentionJob{
+			Policy: &RetentionPolicy{Default: 7 * 24 * time.Hour, Channels: map[string]time.Duration{"random": 24 * time.Hour, "archive": 0}},
+			Store:  store,
+		}
+
+		purged, err := job.Purge(context.Background(), now)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), purged)
+		for _, msg := range store.Remaining() {
+			assert.Equal(t, "kept", msg.Text)
+		}
+		assert.Len(t, store.Remaining(), 3)
+		assert.Equal(t, RetentionStats{Purged: 2, LastRun: now.Unix()}, job.Stats())
+	})
+
+	t.Run("purges nothing when messages are kept forever", func(t *testing.T) {
+		store := &test_utils.MessagePurgerDouble{Messages: api.ChannelHistory{{Channel: "general", Timestamp: 1}}}
+		job := &RetentionJob{Policy: &RetentionPolicy{}, Store: store}
+
+		purged, err := job.Purge(context.Background(), now)
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), purged)
+		assert.Len(t, store.Remaining(), 1)
+	})
+
+	t.Run("reports store errors", func(t *testing.T) {
+		job := &RetentionJob{Policy: &RetentionPolicy{Default: time.Hour}, Store: &test_utils.MessagePurgerDouble{Err: api.ErrInternal}}
+
+		_, err := job.Purge(context.Background(), now)
+		require.ErrorIs(t, err, api.ErrInternal)
+		assert.Equal(t, int64(0), job.Stats().LastRun)
+	})
+}
@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"encoding/base64"
+	"net"
 	"net/http"
 	"strings"
 
@@ -11,6 +12,11 @@ import (
 
 type SockchatAuthService struct {
 	UserProfiles api.SockchatProfileStore
+	Sessions     api.SockchatSessionStore
+	LoginGuard   api.SockchatLoginGuard
+	APIKeys      api.SockchatAPIKeyStore
+	TwoFactor    api.SockchatTwoFactorStore
+	OIDC         api.SockchatOIDCProvider
 }
 
 type authWrapper struct {
@@ -18,6 +24,10 @@ type authWrapper struct {
 	Password string
 }
 
+type principalKey struct{}
+
+type clientIPKey struct{}
+
 func (s *SockchatAuthService) AuthenticateFromBasicToken(ctx context.Context, token string) (bool, error) {
 	auth, err := decodeToken(token)
 	if err != nil {
@@ -26,6 +36,248 @@ func (s *SockchatAuthService) AuthenticateFromBasicToken(ctx context.Context, to
 	return s.UserProfiles.IsAuthValid(ctx, auth.Username, auth.Password), nil
 }
 
+// Authenticate verifies Basic, Bearer or ApiKey token and returns the caller
+func (s *SockchatAuthService) Authenticate(ctx context.Context, token string) (*api.Principal, error) {
+	if key, foundAPIKey := strings.CutPrefix(token, "ApiKey "); foundAPIKey {
+		return s.authenticateAPIKey(ctx, key)
+	}
+	if bearer, foundBearer := strings.CutPrefix(token, "Bearer "); foundBearer {
+		if s.Sessions == nil {
+			return nil, api.ErrUnauthorized
+		}
+		nick, err := s.Sessions.Verify(ctx, bearer)
+		if err != nil {
+			return nil, err
+		}
+		return &api.Principal{Nick: nick}, nil
+	}
+	auth, err := decodeToken(token)
+	if err != nil {
+		return nil, err
+	}
+	// Basic auth carries no second factor, so users with TOTP enabled have to use session tokens
+	if err := s.verifyCredentials(ctx, auth.Username, auth.Password, ""); err != nil {
+		return nil, err
+	}
+	return &api.Principal{Nick: auth.Username}, nil
+}
+
+func (s *SockchatAuthService) authenticateAPIKey(ctx context.Context, key string) (*api.Principal, error) {
+	if s.APIKeys == nil {
+		return nil, api.ErrInvalidAPIKey
+	}
+	return s.APIKeys.Verify(ctx, key)
+}
+
+func (s *SockchatAuthService) Login(ctx context.Context, req *api.LoginRequest) (*api.SessionTokens, error) {
+	if req.Nick == "" {
+		return nil, api.ErrNickRequired
+	}
+	if req.Password == "" {
+		return nil, api.ErrPasswordRequired
+	}
+	if err := s.verifyCredentials(ctx, req.Nick, req.Password, req.OTP); err != nil {
+		return nil, err
+	}
+	if s.Sessions == nil {
+		return nil, api.ErrInternal
+	}
+	return s.Sessions.Issue(ctx, req.Nick)
+}
+
+func (s *SockchatAuthService) OIDCAuthCodeURL(ctx context.Context) (string, error) {
+	if s.OIDC == nil {
+		return "", api.ErrOIDCNotConfigured
+	}
+	return s.OIDC.AuthCodeURL(ctx)
+}
+
+// LoginWithOIDC completes sign-in with the identity provider and issues session tokens
+func (s *SockchatAuthService) LoginWithOIDC(ctx context.Context, code, state string) (*api.SessionTokens, error) {
+	if s.OIDC == nil {
+		return nil, api.ErrOIDCNotConfigured
+	}
+	nick, err := s.OIDC.Authenticate(ctx, code, state)
+	if err != nil {
+		return nil, err
+	}
+	account, err := s.UserProfiles.GetAccount(ctx, nick)
+	if err != nil {
+		return nil, err
+	}
+	if account.Disabled {
+		return nil, api.ErrAccountDisabled
+	}
+	if s.Sessions == nil {
+		return nil, api.ErrInternal
+	}
+	return s.Sessions.Issue(ctx, nick)
+}
+
+func (s *SockchatAuthService) RefreshSession(ctx context.Context, req *api.RefreshSessionRequest) (*api.SessionTokens, error) {
+	if req.RefreshToken == "" {
+		return nil, api.ErrRefreshTokenRequired
+	}
+	if s.Sessions == nil {
+		return nil, api.ErrInternal
+	}
+	return s.Sessions.Refresh(ctx, req.RefreshToken)
+}
+
+// Logout revokes session token used for authorization (if any) and refresh token from the request
+func (s *SockchatAuthService) Logout(ctx context.Context, token string, req *api.LogoutRequest) error {
+	if s.Sessions == nil {
+		return api.ErrInternal
+	}
+	if bearer, foundBearer := strings.CutPrefix(token, "Bearer "); foundBearer {
+		if err := s.Sessions.Revoke(ctx, bearer); err != nil {
+			return err
+		}
+	}
+	if req.RefreshToken != "" {
+		return s.Sessions.Revoke(ctx, req.RefreshToken)
+	}
+	return nil
+}
+
+// verifyCredentials checks nick, password and second factor (if enabled for the user),
+// throttled by LoginGuard (if set) using client IP stored in the context
+func (s *SockchatAuthService) verifyCredentials(ctx context.Context, nick, password, otp string) error {
+	ip := clientIP(ctx)
+	if s.LoginGuard != nil {
+		if err := s.LoginGuard.Check(ctx, nick, ip); err != nil {
+			return err
+		}
+	}
+	if !s.UserProfiles.IsAuthValid(ctx, nick, password) {
+		return s.recordLoginFailure(ctx, nick, ip, api.ErrUnauthorized)
+	}
+	if s.TwoFactor != nil {
+		if err := s.TwoFactor.Verify(ctx, nick, otp); err == api.ErrInvalidOTP {
+			return s.recordLoginFailure(ctx, nick, ip, err)
+		} else if err != nil {
+			return err
+		}
+	}
+	if s.LoginGuard != nil {
+		s.LoginGuard.RecordSuccess(ctx, nick, ip)
+	}
+	return nil
+}
+
+// recordLoginFailure returns err unless recording the failure fails
+func (s *SockchatAuthService) recordLoginFailure(ctx context.Context, nick, ip string, err error) error {
+	if s.LoginGuard != nil {
+		if guardErr := s.LoginGuard.RecordFailure(ctx, nick, ip); guardErr != nil {
+			return guardErr
+		}
+	}
+	return err
+}
+
+func (s *SockchatAuthService) EnrollTOTP(ctx context.Context, nick string) (*api.TOTPEnrollment, error) {
+	if s.TwoFactor == nil {
+		return nil, api.ErrInternal
+	}
+	return s.TwoFactor.Enroll(ctx, nick)
+}
+
+func (s *SockchatAuthService) ConfirmTOTP(ctx context.Context, nick string, req *api.TOTPCodeRequest) (*api.RecoveryCodes, error) {
+	if s.TwoFactor == nil {
+		return nil, api.ErrInternal
+	}
+	var codes *api.RecoveryCodes
+	err := s.throttleTOTPAttempt(ctx, nick, func() (err error) {
+		codes, err = s.TwoFactor.Confirm(ctx, nick, req.Code)
+		return err
+	})
+	return codes, err
+}
+
+func (s *SockchatAuthService) DisableTOTP(ctx context.Context, nick string, req *api.TOTPCodeRequest) error {
+	if s.TwoFactor == nil {
+		return api.ErrInternal
+	}
+	return s.throttleTOTPAttempt(ctx, nick, func() error {
+		return s.TwoFactor.Disable(ctx, nick, req.Code)
+	})
+}
+
+// throttleTOTPAttempt runs attempt through LoginGuard (if set), counting invalid codes as failed logins
+// so that a stolen session cannot be used to guess the second factor
+func (s *SockchatAuthService) throttleTOTPAttempt(ctx context.Context, nick string, attempt func() error) error {
+	ip := clientIP(ctx)
+	if s.LoginGuard != nil {
+		if err := s.LoginGuard.Check(ctx, nick, ip); err != nil {
+			return err
+		}
+	}
+	if err := attempt(); err == api.ErrInvalidOTP {
+		return s.recordLoginFailure(ctx, nick, ip, err)
+	} else if err != nil {
+		return err
+	}
+	if s.LoginGuard != nil {
+		s.LoginGuard.RecordSuccess(ctx, nick, ip)
+	}
+	return nil
+}
+
+func (s *SockchatAuthService) CreateAPIKey(ctx context.Context, caller string, req *api.CreateAPIKeyRequest) (*api.CreatedAPIKey, error) {
+	if s.APIKeys == nil {
+		return nil, api.ErrInternal
+	}
+	owner, err := s.apiKeyOwner(ctx, caller, req.Account)
+	if err != nil {
+		return nil, err
+	}
+	return s.APIKeys.Create(ctx, owner, req)
+}
+
+func (s *SockchatAuthService) ListAPIKeys(ctx context.Context, caller, account string) ([]*api.APIKey, error) {
+	if s.APIKeys == nil {
+		return nil, api.ErrInternal
+	}
+	owner, err := s.apiKeyOwner(ctx, caller, account)
+	if err != nil {
+		return nil, err
+	}
+	return s.APIKeys.List(ctx, owner)
+}
+
+func (s *SockchatAuthService) RevokeAPIKey(ctx context.Context, caller string, req *api.RevokeAPIKeyRequest) error {
+	if s.APIKeys == nil {
+		return api.ErrInternal
+	}
+	owner, err := s.apiKeyOwner(ctx, caller, req.Account)
+	if err != nil {
+		return err
+	}
+	return s.APIKeys.Revoke(ctx, owner, req.ID)
+}
+
+// apiKeyOwner returns whose keys the caller manages; keys of service accounts are managed by admins
+func (s *SockchatAuthService) apiKeyOwner(ctx context.Context, caller, account string) (string, error) {
+	if account == "" || account == caller {
+		return caller, nil
+	}
+	isAdmin, err := s.HasRole(ctx, caller, api.RoleAdmin)
+	if err != nil {
+		return "", err
+	}
+	if !isAdmin {
+		return "", api.ErrForbidden
+	}
+	isService, err := s.HasRole(ctx, account, api.RoleService)
+	if err != nil {
+		return "", err
+	}
+	if !isService {
+		return "", api.ErrNotServiceAccount
+	}
+	return account, nil
+}
+
 func decodeToken(token string) (*authWrapper, error) {
 	encoded, foundBasic := strings.CutPrefix(token, "Basic ")
 	if !foundBasic {
@@ -42,25 +294,27 @@ func decodeToken(token string) (*authWrapper, error) {
 	return &authWrapper{credentials[0], credentials[1]}, nil
 }
 
-func newAuthMiddleware(s *SockchatAuthService) func(next http.HandlerFunc) http.HandlerFunc {
-	return func(next http.HandlerFunc) http.HandlerFunc {
+// newAuthMiddleware lets through requests of callers granted the permission
+func newAuthMiddleware(s *SockchatAuthService) func(permission Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(permission Permission, next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			token, err := tokenFromHeader(r.Header)
 			if err != nil {
 				writeJsonHttpResponse(w, http.StatusUnauthorized, api.ErrorResponse{ErrorDescription: err.Error()})
+				return
 			}
-			ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+			ctx, cancel := context.WithTimeout(withClientIP(r.Context(), remoteIP(r.RemoteAddr)), ResponseDeadline)
 			defer cancel()
-			authenticationOK, err := s.AuthenticateFromBasicToken(ctx, token)
+			principal, err := s.Authenticate(ctx, token)
 			if err != nil {
-				writeJsonHttpResponse(w, http.StatusUnauthorized, api.ErrorResponse{ErrorDescription: err.Error()})
+				writeJsonHttpResponse(w, authErrorStatus(err), api.ErrorResponse{ErrorDescription: err.Error()})
 				return
 			}
-			if !authenticationOK {
-				writeJsonHttpResponse(w, http.StatusUnauthorized, api.ErrorResponse{ErrorDescription: "unauthorized"})
+			if err := s.Authorize(ctx, principal, permission); err != nil {
+				writeJsonHttpResponse(w, authErrorStatus(err), api.ErrorResponse{ErrorDescription: err.Error()})
 				return
 			}
-			next(w, r)
+			next(w, r.WithContext(withPrincipal(withClientIP(r.Context(), remoteIP(r.RemoteAddr)), principal)))
 		}
 	}
 }
@@ -72,3 +326,51 @@ func tokenFromHeader(header http.Header) (string, error) {
 	}
 	return token, nil
 }
+
+func withPrincipal(ctx context.Context, principal *api.Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// authenticatedPrincipal returns caller stored in the context by authentication middleware or interceptor
+func authenticatedPrincipal(ctx context.Context) (*api.Principal, error) {
+	principal, ok := ctx.Value(principalKey{}).(*api.Principal)
+	if !ok || principal.Nick == "" {
+		return nil, api.ErrUnauthorized
+	}
+	return principal, nil
+}
+
+// authenticatedNick returns nick of the caller stored in the context by authentication middleware or interceptor
+func authenticatedNick(ctx context.Context) (string, error) {
+	principal, err := authenticatedPrincipal(ctx)
+	if err != nil {
+		return "", err
+	}
+	return principal.Nick, nil
+}
+
+func withClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+func clientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+// remoteIP strips port from the remote address of a connection
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// authErrorStatus returns HTTP status for failed authentication; 401 unless the error is mapped otherwise
+func authErrorStatus(err error) int {
+	if status, found := HTTPStatuses[err]; found {
+		return status
+	}
+	return http.StatusUnauthorized
+}
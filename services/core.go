@@ -2,15 +2,36 @@ package services
 
 import (
 	"context"
+	"fmt"
+	"log"
+	"time"
 
 	"github.com/kacperf531/sockchat/api"
 )
 
+const (
+	DefaultHistoryLimit = 50
+	MaxHistoryLimit     = 500
+
+	DefaultSearchMessagesLimit = 20
+	MaxSearchMessagesLimit     = 100
+	// results past Elasticsearch's default index.max_result_window can not be paged to
+	MaxSearchMessagesWindow = 10000
+)
+
 type SockchatCoreService struct {
 	UserProfiles   api.SockchatProfileStore
 	Messages       api.SockchatMessageStore
 	ChatChannels   api.SockchatChannelStore
 	ConnectedUsers api.SockchatUserManager
+	PasswordResets api.SockchatPasswordResetService
+	PersonalData   api.SockchatPersonalDataService
+	BlockList      api.SockchatBlockList
+	Preferences    api.SockchatPreferences
+	Sessions       api.SockchatSessionStore
+	Audit          api.SockchatAuditLog
+	// optional, messages are kept forever without it
+	Retention api.SockchatRetentionPolicy
 }
 
 type EditProfileWrapper struct {
@@ -18,6 +39,66 @@ type EditProfileWrapper struct {
 	Request *api.EditProfileRequest
 }
 
+// AdminRequestWrapper carries nick of the admin making the request
+type AdminRequestWrapper struct {
+	Nick    string
+	Request *api.AccountRequest
+}
+
+// SetRoleWrapper carries nick of the admin granting the role
+type SetRoleWrapper struct {
+	Nick    string
+	Request *api.SetRoleRequest
+}
+
+// CreateServiceAccountWrapper carries nick of the admin creating the account
+type CreateServiceAccountWrapper struct {
+	Nick    string
+	Request *api.CreateServiceAccountRequest
+}
+
+type SetAvatarWrapper struct {
+	Nick  string
+	Image []byte
+}
+
+type DirectoryVisibilityWrapper struct {
+	Nick    string
+	Request *api.DirectoryVisibilityRequest
+}
+
+type BlockWrapper struct {
+	Nick    string
+	Request *api.BlockRequest
+}
+
+type PreferencesWrapper struct {
+	Nick    string
+	Request *api.Preferences
+}
+
+// SearchMessagesWrapper carries nick of the user whose channels are searched
+type SearchMessagesWrapper struct {
+	Nick    string
+	Request *api.SearchMessagesRequest
+}
+
+// ChannelHistoryWrapper carries nick of the user whose block list is applied to the history
+type ChannelHistoryWrapper struct {
+	Nick    string
+	Request *api.GetChannelHistoryRequest
+}
+
+type ChangeNickWrapper struct {
+	Nick    string
+	Request *api.ChangeNickRequest
+}
+
+type ChangePasswordWrapper struct {
+	Nick    string
+	Request *api.ChangePasswordRequest
+}
+
 func (s *SockchatCoreService) RegisterProfile(req *api.CreateProfileRequest, ctx context.Context) (*api.EmptyMessage, error) {
 	if req.Nick == "" {
 		return nil, api.ErrNickRequired
@@ -50,9 +131,396 @@ func (s *SockchatCoreService) EditProfile(req *EditProfileWrapper, ctx context.C
 	return &api.EmptyMessage{}, nil
 }
 
-func (s *SockchatCoreService) GetChannelHistory(req *api.GetChannelHistoryRequest, ctx context.Context) (api.ChannelHistory, error) {
-	if !s.ChatChannels.ChannelExists(req.Channel) {
+func (s *SockchatCoreService) SetAvatar(req *SetAvatarWrapper, ctx context.Context) (*api.PublicProfile, error) {
+	return s.UserProfiles.SetAvatar(ctx, req.Nick, req.Image)
+}
+
+func (s *SockchatCoreService) GetAvatar(req *api.GetAvatarRequest, ctx context.Context) (*api.Avatar, error) {
+	return s.UserProfiles.GetAvatar(ctx, req.Key)
+}
+
+func (s *SockchatCoreService) SearchUsers(req *api.SearchUsersRequest, ctx context.Context) ([]*api.PublicProfile, error) {
+	return s.UserProfiles.SearchProfiles(ctx, req)
+}
+
+func (s *SockchatCoreService) SetDirectoryVisibility(req *DirectoryVisibilityWrapper, ctx context.Context) (*api.EmptyMessage, error) {
+	if err := s.UserProfiles.SetHiddenFromDirectory(ctx, req.Nick, req.Request.Hidden); err != nil {
+		return nil, err
+	}
+	return &api.EmptyMessage{}, nil
+}
+
+// ChangePassword revokes all sessions of the user, so that whoever knew the old password loses access;
+// new tokens are returned for the caller.
+func (s *SockchatCoreService) ChangePassword(req *ChangePasswordWrapper, ctx context.Context) (*api.ChangePasswordResponse, error) {
+	err := s.UserProfiles.ChangePassword(ctx, req.Nick, req.Request)
+	if err != nil {
+		return nil, err
+	}
+	res := &api.ChangePasswordResponse{}
+	if s.Sessions == nil {
+		return res, nil
+	}
+	if err := s.Sessions.RevokeAll(ctx, req.Nick); err != nil {
+		return nil, err
+	}
+	if res.Tokens, err = s.Sessions.Issue(ctx, req.Nick); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ChangeNick renames the user keeping their id, so that their message history and reports follow them.
+// Sessions of the old nick are revoked and new tokens are returned; live connections stay open under the new nick.
+func (s *SockchatCoreService) ChangeNick(req *ChangeNickWrapper, ctx context.Context) (*api.ChangeNickResponse, error) {
+	newNick := req.Request.NewNick
+	id, err := s.UserProfiles.GetUserID(ctx, req.Nick)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.UserProfiles.ChangeNick(ctx, req.Nick, newNick); err != nil {
+		return nil, err
+	}
+	if err := s.Messages.RenameAuthor(ctx, req.Nick, id, newNick); err != nil {
+		log.Printf("warning: messages of %s were not renamed to %s: %v", req.Nick, newNick, err)
+	}
+	if s.BlockList != nil {
+		s.BlockList.RenameUser(req.Nick, newNick)
+	}
+	if s.ConnectedUsers != nil {
+		s.ConnectedUsers.RenameUser(req.Nick, newNick)
+	}
+	if s.Audit != nil {
+		s.Audit.Record(ctx, &api.AuditEvent{Type: api.AuditNickChanged, Nick: newNick, Details: fmt.Sprintf("renamed from %s", req.Nick), Timestamp: time.Now()})
+	}
+
+	res := &api.ChangeNickResponse{Nick: newNick}
+	if s.Sessions == nil {
+		return res, nil
+	}
+	if err := s.Sessions.RevokeAll(ctx, req.Nick); err != nil {
+		log.Printf("warning: sessions of %s were not revoked: %v", req.Nick, err)
+	}
+	if res.Tokens, err = s.Sessions.Issue(ctx, newNick); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *SockchatCoreService) RequestPasswordReset(req *api.RequestPasswordResetRequest, ctx context.Context) (*api.EmptyMessage, error) {
+	if s.PasswordResets == nil {
+		return nil, api.ErrInternal
+	}
+	err := s.PasswordResets.RequestReset(ctx, req.Nick)
+	if err != nil {
+		return nil, err
+	}
+	return &api.EmptyMessage{}, nil
+}
+
+func (s *SockchatCoreService) ResetPassword(req *api.ResetPasswordRequest, ctx context.Context) (*api.EmptyMessage, error) {
+	if s.PasswordResets == nil {
+		return nil, api.ErrInternal
+	}
+	err := s.PasswordResets.Reset(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &api.EmptyMessage{}, nil
+}
+
+// DeleteAccount erases the user and ends their live connections
+func (s *SockchatCoreService) DeleteAccount(req *api.AccountRequest, ctx context.Context) (*api.EmptyMessage, error) {
+	if req.Nick == "" {
+		return nil, api.ErrNickRequired
+	}
+	if s.PersonalData == nil {
+		return nil, api.ErrInternal
+	}
+	if err := s.PersonalData.Delete(ctx, req.Nick); err != nil {
+		return nil, err
+	}
+	s.ConnectedUsers.DisconnectUser(req.Nick)
+	return &api.EmptyMessage{}, nil
+}
+
+// ExportMyData returns zip archive with all data stored about the user
+func (s *SockchatCoreService) ExportMyData(req *api.AccountRequest, ctx context.Context) ([]byte, error) {
+	if req.Nick == "" {
+		return nil, api.ErrNickRequired
+	}
+	if s.PersonalData == nil {
+		return nil, api.ErrInternal
+	}
+	return s.PersonalData.Export(ctx, req.Nick)
+}
+
+func (s *SockchatCoreService) ListUsers(req *api.ListUsersRequest, ctx context.Context) ([]*api.Account, error) {
+	return s.UserProfiles.ListAccounts(ctx, req.Offset, req.Limit)
+}
+
+func (s *SockchatCoreService) CreateServiceAccount(req *CreateServiceAccountWrapper, ctx context.Context) (*api.Account, error) {
+	if err := s.UserProfiles.CreateServiceAccount(ctx, req.Request); err != nil {
+		return nil, err
+	}
+	s.recordAdminAction(ctx, api.AuditServiceAccountCreated, &AdminRequestWrapper{Nick: req.Nick, Request: &api.AccountRequest{Nick: req.Request.Nick}}, fmt.Sprintf("created by %s", req.Nick))
+	return s.UserProfiles.GetAccount(ctx, req.Request.Nick)
+}
+
+// SetRole grants the global role to the user; admins can not change their own role, so that at least one admin remains
+func (s *SockchatCoreService) SetRole(req *SetRoleWrapper, ctx context.Context) (*api.EmptyMessage, error) {
+	if req.Request.Nick == "" {
+		return nil, api.ErrNickRequired
+	}
+	if req.Request.Nick == req.Nick {
+		return nil, api.ErrCannotChangeOwnRole
+	}
+	if err := s.UserProfiles.SetRole(ctx, req.Request.Nick, req.Request.Role); err != nil {
+		return nil, err
+	}
+	s.recordAdminAction(ctx, api.AuditRoleChanged, &AdminRequestWrapper{Nick: req.Nick, Request: &api.AccountRequest{Nick: req.Request.Nick}}, fmt.Sprintf("%s granted by %s", req.Request.Role, req.Nick))
+	return &api.EmptyMessage{}, nil
+}
+
+// DisableAccount blocks the user from logging in and ends their live connections
+func (s *SockchatCoreService) DisableAccount(req *AdminRequestWrapper, ctx context.Context) (*api.EmptyMessage, error) {
+	if req.Request.Nick == "" {
+		return nil, api.ErrNickRequired
+	}
+	if err := s.UserProfiles.SetDisabled(ctx, req.Request.Nick, true); err != nil {
+		return nil, err
+	}
+	disconnected := s.ConnectedUsers.DisconnectUser(req.Request.Nick)
+	s.recordAdminAction(ctx, api.AuditAccountDisabled, req, fmt.Sprintf("disabled by %s, %d connections closed", req.Nick, disconnected))
+	return &api.EmptyMessage{}, nil
+}
+
+func (s *SockchatCoreService) EnableAccount(req *AdminRequestWrapper, ctx context.Context) (*api.EmptyMessage, error) {
+	if req.Request.Nick == "" {
+		return nil, api.ErrNickRequired
+	}
+	if err := s.UserProfiles.SetDisabled(ctx, req.Request.Nick, false); err != nil {
+		return nil, err
+	}
+	s.recordAdminAction(ctx, api.AuditAccountEnabled, req, fmt.Sprintf("enabled by %s", req.Nick))
+	return &api.EmptyMessage{}, nil
+}
+
+func (s *SockchatCoreService) DisconnectUser(req *AdminRequestWrapper, ctx context.Context) (*api.DisconnectUserResponse, error) {
+	if req.Request.Nick == "" {
+		return nil, api.ErrNickRequired
+	}
+	disconnected := s.ConnectedUsers.DisconnectUser(req.Request.Nick)
+	s.recordAdminAction(ctx, api.AuditUserDisconnected, req, fmt.Sprintf("%d connections closed by %s", disconnected, req.Nick))
+	return &api.DisconnectUserResponse{DisconnectedConnections: disconnected}, nil
+}
+
+func (s *SockchatCoreService) recordAdminAction(ctx context.Context, eventType string, req *AdminRequestWrapper, details string) {
+	if s.Audit == nil {
+		return
+	}
+	s.Audit.Record(ctx, &api.AuditEvent{Type: eventType, Nick: req.Request.Nick, Details: details, Timestamp: time.Now()})
+}
+
+func (s *SockchatCoreService) Block(req *BlockWrapper, ctx context.Context) (*api.EmptyMessage, error) {
+	if s.BlockList == nil {
+		return nil, api.ErrInternal
+	}
+	if err := s.BlockList.Block(ctx, req.Nick, req.Request.Nick); err != nil {
+		return nil, err
+	}
+	s.notifyConnectedUser(req.Nick, api.NewSocketMessage(api.UserBlockedEvent, req.Request))
+	return &api.EmptyMessage{}, nil
+}
+
+func (s *SockchatCoreService) Unblock(req *BlockWrapper, ctx context.Context) (*api.EmptyMessage, error) {
+	if s.BlockList == nil {
+		return nil, api.ErrInternal
+	}
+	if err := s.BlockList.Unblock(ctx, req.Nick, req.Request.Nick); err != nil {
+		return nil, err
+	}
+	s.notifyConnectedUser(req.Nick, api.NewSocketMessage(api.UserUnblockedEvent, req.Request))
+	return &api.EmptyMessage{}, nil
+}
+
+func (s *SockchatCoreService) ListBlocks(req *api.AccountRequest, ctx context.Context) (*api.BlockListResponse, error) {
+	if s.BlockList == nil {
+		return nil, api.ErrInternal
+	}
+	nicks, err := s.BlockList.ListBlocked(ctx, req.Nick)
+	if err != nil {
+		return nil, err
+	}
+	return &api.BlockListResponse{Nicks: nicks}, nil
+}
+
+func (s *SockchatCoreService) GetPreferences(req *api.AccountRequest, ctx context.Context) (*api.Preferences, error) {
+	if s.Preferences == nil {
+		return nil, api.ErrInternal
+	}
+	return s.Preferences.GetPreferences(ctx, req.Nick)
+}
+
+// UpdatePreferences replaces preferences of the user and returns them with defaults filled in
+func (s *SockchatCoreService) UpdatePreferences(req *PreferencesWrapper, ctx context.Context) (*api.Preferences, error) {
+	if s.Preferences == nil {
+		return nil, api.ErrInternal
+	}
+	if err := s.Preferences.UpdatePreferences(ctx, req.Nick, req.Request); err != nil {
+		return nil, err
+	}
+	prefs, err := s.Preferences.GetPreferences(ctx, req.Nick)
+	if err != nil {
+		return nil, err
+	}
+	s.notifyConnectedUser(req.Nick, api.NewSocketMessage(api.PreferencesUpdatedEvent, prefs))
+	return prefs, nil
+}
+
+// notifyConnectedUser keeps websocket connections of the user in sync with changes made over other APIs
+func (s *SockchatCoreService) notifyConnectedUser(nick string, msg api.SocketMessage) {
+	if s.ConnectedUsers == nil {
+		return
+	}
+	if handler, ok := s.ConnectedUsers.GetHandler(nick); ok {
+		go handler.Write(msg)
+	}
+}
+
+func (s *SockchatCoreService) GetChannelHistory(req *ChannelHistoryWrapper, ctx context.Context) (*api.ChannelHistoryPage, error) {
+	if !s.ChatChannels.ChannelExists(req.Request.Channel) {
 		return nil, api.ErrChannelNotFound
 	}
-	return s.Messages.FindMessages(ctx, req.Channel, req.Search)
+	if req.Request.Limit < 0 {
+		return nil, api.ErrInvalidRequest
+	}
+	if req.Request.Before != "" && req.Request.After != "" {
+		return nil, api.ErrConflictingCursors
+	}
+	query := &api.HistoryQuery{
+		Channel: req.Request.Channel,
+		Search:  req.Request.Search,
+		From:    s.RetainedSince(req.Request.Channel, 0),
+		Limit:   req.Request.Limit,
+		Before:  req.Request.Before,
+		After:   req.Request.After,
+	}
+	if query.Limit == 0 {
+		query.Limit = DefaultHistoryLimit
+	}
+	if query.Limit > MaxHistoryLimit {
+		query.Limit = MaxHistoryLimit
+	}
+	if req.Request.HideBlocked && s.BlockList != nil {
+		blocked, err := s.BlockList.ListBlocked(ctx, req.Nick)
+		if err != nil {
+			return nil, err
+		}
+		query.ExcludedAuthors = blocked
+	}
+	page, err := s.Messages.FindMessages(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	s.resolveAuthors(ctx, page.Messages)
+	return page, nil
+}
+
+// RetainedSince moves the start of channel history past messages which expired, even if they are not purged yet
+func (s *SockchatCoreService) RetainedSince(channel string, from int64) int64 {
+	if s.Retention == nil {
+		return from
+	}
+	if since := s.Retention.RetainedSince(channel, time.Now()); since > from {
+		return since
+	}
+	return from
+}
+
+func (s *SockchatCoreService) SearchMessages(req *SearchMessagesWrapper, ctx context.Context) (*api.SearchMessagesResponse, error) {
+	search := *req.Request
+	if search.Offset < 0 || search.Limit < 0 {
+		return nil, api.ErrInvalidRequest
+	}
+	if search.To > 0 && search.From > search.To {
+		return nil, api.ErrInvalidRange
+	}
+	if search.Sort == "" {
+		search.Sort = api.SortByTime
+		if search.Query != "" {
+			search.Sort = api.SortByRelevance
+		}
+	}
+	if search.Sort != api.SortByRelevance && search.Sort != api.SortByTime {
+		return nil, api.ErrInvalidSort
+	}
+	if search.Limit == 0 {
+		search.Limit = DefaultSearchMessagesLimit
+	}
+	if search.Limit > MaxSearchMessagesLimit {
+		search.Limit = MaxSearchMessagesLimit
+	}
+	if search.Offset+search.Limit > MaxSearchMessagesWindow {
+		return nil, api.ErrInvalidRequest
+	}
+
+	joined := s.ChatChannels.ChannelsOf(req.Nick)
+	if len(search.Channels) == 0 {
+		search.Channels = joined
+	}
+	for _, channel := range search.Channels {
+		if !s.ChatChannels.ChannelExists(channel) {
+			return nil, api.ErrChannelNotFound
+		}
+		if !contains(joined, channel) {
+			return nil, api.ErrUserNotInChannel
+		}
+	}
+	if len(search.Channels) == 0 {
+		return &api.SearchMessagesResponse{Results: []*api.SearchResult{}}, nil
+	}
+
+	res, err := s.Messages.SearchMessages(ctx, &search)
+	if err != nil {
+		return nil, err
+	}
+	found := make(api.ChannelHistory, len(res.Results))
+	for i, result := range res.Results {
+		found[i] = result.Message
+	}
+	s.resolveAuthors(ctx, found)
+	return res, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveAuthors sets current nicks of authors known by id, in case they were renamed after the message was sent
+func (s *SockchatCoreService) resolveAuthors(ctx context.Context, history api.ChannelHistory) {
+	ids := make([]int64, 0, len(history))
+	for _, msg := range history {
+		if msg.AuthorID != 0 {
+			ids = append(ids, msg.AuthorID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	nicks, err := s.UserProfiles.ResolveNicks(ctx, ids)
+	if err != nil {
+		log.Printf("warning: authors of channel history were not resolved: %v", err)
+		return
+	}
+	for _, msg := range history {
+		if nick, ok := nicks[msg.AuthorID]; ok {
+			msg.Author = nick
+		}
+	}
 }
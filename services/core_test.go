@@ -3,6 +3,7 @@ package services_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/kacperf531/sockchat"
 	"github.com/kacperf531/sockchat/api"
@@ -13,15 +14,17 @@ import (
 )
 
 func TestSockChatCoreService(t *testing.T) {
+	cache := test_utils.NewTestingRedisClient(t)
 
 	sampleMessage := api.MessageEvent{Text: "foo", Channel: "bar", Author: "baz"}
 	messageStore := &test_utils.StubMessageStore{Messages: api.ChannelHistory{&sampleMessage}}
-	userProfiles := &sockchat.ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: test_utils.TestingRedisClient}
+	userProfiles := &sockchat.ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: cache}
 
 	oriDescription := test_utils.ValidUserDescription
 	updatedDescription := "D3scription"
 
-	core := &services.SockchatCoreService{UserProfiles: userProfiles, ChatChannels: &test_utils.StubChannelStore{}, Messages: messageStore}
+	blockList := &sockchat.BlockListService{Store: &test_utils.BlockStoreDouble{}, Profiles: userProfiles}
+	core := &services.SockchatCoreService{UserProfiles: userProfiles, ChatChannels: &test_utils.StubChannelStore{}, Messages: messageStore, BlockList: blockList}
 	ctx := context.Background()
 
 	t.Run("can register a new profile", func(t *testing.T) {
@@ -48,15 +51,165 @@ func TestSockChatCoreService(t *testing.T) {
 	})
 
 	t.Run("can get messages history of a channel", func(t *testing.T) {
-		history, err := core.GetChannelHistory(&api.GetChannelHistoryRequest{Channel: test_utils.ChannelWithUser}, ctx)
+		history, err := core.GetChannelHistory(&services.ChannelHistoryWrapper{Nick: test_utils.ValidUserNick, Request: &api.GetChannelHistoryRequest{Channel: test_utils.ChannelWithUser}}, ctx)
 		require.NoError(t, err)
-		assert.Equal(t, api.ChannelHistory{&sampleMessage}, history)
+		assert.Equal(t, api.ChannelHistory{&sampleMessage}, history.Messages)
+		assert.Empty(t, history.NextCursor)
 	})
 
 	t.Run("can filter messages history of a channel", func(t *testing.T) {
-		history, err := core.GetChannelHistory(&api.GetChannelHistoryRequest{Channel: test_utils.ChannelWithUser, Search: "qux"}, ctx)
+		history, err := core.GetChannelHistory(&services.ChannelHistoryWrapper{Nick: test_utils.ValidUserNick, Request: &api.GetChannelHistoryRequest{Channel: test_utils.ChannelWithUser, Search: "qux"}}, ctx)
 		require.NoError(t, err)
-		assert.NotEqual(t, api.ChannelHistory{&sampleMessage}, history)
+		assert.NotEqual(t, api.ChannelHistory{&sampleMessage}, history.Messages)
+	})
+
+	t.Run("can page through messages history with cursors", func(t *testing.T) {
+		newest := api.MessageEvent{Text: "newest", Channel: "bar", Author: "Foo", Timestamp: 3}
+		middle := api.MessageEvent{Text: "middle", Channel: "bar", Author: "Foo", Timestamp: 2}
+		oldest := api.MessageEvent{Text: "oldest", Channel: "bar", Author: "Foo", Timestamp: 1}
+		messageStore := &test_utils.StubMessageStore{Messages: api.ChannelHistory{&newest, &middle, &oldest}}
+		core := &services.SockchatCoreService{ChatChannels: &test_utils.StubChannelStore{}, Messages: messageStore}
+		getPage := func(req *api.GetChannelHistoryRequest) *api.ChannelHistoryPage {
+			req.Channel = test_utils.ChannelWithUser
+			page, err := core.GetChannelHistory(&services.ChannelHistoryWrapper{Nick: test_utils.ValidUserNick, Request: req}, ctx)
+			require.NoError(t, err)
+			return page
+		}
+
+		first := getPage(&api.GetChannelHistoryRequest{Limit: 2})
+		assert.Equal(t, api.ChannelHistory{&newest, &middle}, first.Messages)
+		require.NotEmpty(t, first.NextCursor)
+
+		second := getPage(&api.GetChannelHistoryRequest{Limit: 2, Before: first.NextCursor})
+		assert.Equal(t, api.ChannelHistory{&oldest}, second.Messages)
+		assert.Empty(t, second.NextCursor)
+
+		newer := getPage(&api.GetChannelHistoryRequest{Limit: 2, After: first.NextCursor})
+		assert.Equal(t, api.ChannelHistory{&newest}, newer.Messages)
+	})
+
+	t.Run("can not get messages history with invalid paging", func(t *testing.T) {
+		invalid := map[*api.GetChannelHistoryRequest]error{
+			{Channel: test_utils.ChannelWithUser, Limit: -1}:                   api.ErrInvalidRequest,
+			{Channel: test_utils.ChannelWithUser, Before: "foo"}:               api.ErrInvalidCursor,
+			{Channel: test_utils.ChannelWithUser, Before: "foo", After: "bar"}: api.ErrConflictingCursors,
+		}
+		for req, expected := range invalid {
+			_, err := core.GetChannelHistory(&services.ChannelHistoryWrapper{Nick: test_utils.ValidUserNick, Request: req}, ctx)
+			assert.Equal(t, expected, err)
+		}
+	})
+
+	t.Run("can search messages of joined channels", func(t *testing.T) {
+		hello := api.MessageEvent{Text: "hello world", Channel: test_utils.ChannelWithUser, Author: "Foo", Timestamp: 10}
+		bye := api.MessageEvent{Text: "bye world", Channel: test_utils.ChannelWithUser, Author: "Bar", Timestamp: 20}
+		elsewhere := api.MessageEvent{Text: "hello there", Channel: "elsewhere", Author: "Foo", Timestamp: 30}
+		messageStore := &test_utils.StubMessageStore{Messages: api.ChannelHistory{&hello, &bye, &elsewhere}}
+		core := &services.SockchatCoreService{UserProfiles: userProfiles, ChatChannels: &test_utils.StubChannelStore{}, Messages: messageStore}
+		search := func(req *api.SearchMessagesRequest) (*api.SearchMessagesResponse, error) {
+			return core.SearchMessages(&services.SearchMessagesWrapper{Nick: test_utils.ValidUserNick, Request: req}, ctx)
+		}
+
+		res, err := search(&api.SearchMessagesRequest{Query: "hello"})
+		require.NoError(t, err)
+		require.Equal(t, int64(1), res.Total)
+		assert.Equal(t, &hello, res.Results[0].Message)
+		assert.Equal(t, []string{hello.Text}, res.Results[0].Highlights)
+
+		res, err = search(&api.SearchMessagesRequest{Query: "world", Authors: []string{"Bar"}, Channels: []string{test_utils.ChannelWithUser}})
+		require.NoError(t, err)
+		require.Len(t, res.Results, 1)
+		assert.Equal(t, &bye, res.Results[0].Message)
+
+		res, err = search(&api.SearchMessagesRequest{From: 15, To: 25})
+		require.NoError(t, err)
+		require.Len(t, res.Results, 1)
+		assert.Equal(t, &bye, res.Results[0].Message)
+	})
+
+	t.Run("can not search messages with invalid request", func(t *testing.T) {
+		invalid := map[*api.SearchMessagesRequest]error{
+			{Channels: []string{"elsewhere"}}:  api.ErrUserNotInChannel,
+			{Channels: []string{"not_exists"}}: api.ErrChannelNotFound,
+			{Sort: "alphabetical"}:             api.ErrInvalidSort,
+			{From: 20, To: 10}:                 api.ErrInvalidRange,
+			{Limit: -1}:                        api.ErrInvalidRequest,
+			{Offset: 9990, Limit: 20}:          api.ErrInvalidRequest,
+		}
+		for req, expected := range invalid {
+			_, err := core.SearchMessages(&services.SearchMessagesWrapper{Nick: test_utils.ValidUserNick, Request: req}, ctx)
+			assert.Equal(t, expected, err)
+		}
+	})
+
+	t.Run("can hide messages of blocked authors from history", func(t *testing.T) {
+		_, err := core.Block(&services.BlockWrapper{Nick: test_utils.ValidUser2Nick, Request: &api.BlockRequest{Nick: test_utils.ValidUserNick}}, ctx)
+		require.NoError(t, err)
+		blocked := api.MessageEvent{Text: "blocked", Channel: "bar", Author: test_utils.ValidUserNick}
+		messageStore := &test_utils.StubMessageStore{Messages: api.ChannelHistory{&sampleMessage, &blocked}}
+		core := &services.SockchatCoreService{ChatChannels: &test_utils.StubChannelStore{}, Messages: messageStore, BlockList: blockList}
+
+		history, err := core.GetChannelHistory(&services.ChannelHistoryWrapper{Nick: test_utils.ValidUser2Nick, Request: &api.GetChannelHistoryRequest{Channel: test_utils.ChannelWithUser, HideBlocked: true}}, ctx)
+		require.NoError(t, err)
+		assert.Equal(t, api.ChannelHistory{&sampleMessage}, history.Messages)
+
+		history, err = core.GetChannelHistory(&services.ChannelHistoryWrapper{Nick: test_utils.ValidUser2Nick, Request: &api.GetChannelHistoryRequest{Channel: test_utils.ChannelWithUser}}, ctx)
+		require.NoError(t, err)
+		assert.Len(t, history.Messages, 2)
+	})
+
+	t.Run("hides expired messages from history before they are purged", func(t *testing.T) {
+		expired := api.MessageEvent{Text: "expired", Channel: "bar", Author: "baz", Timestamp: time.Now().Add(-48 * time.Hour).Unix()}
+		recent := api.MessageEvent{Text: "recent", Channel: "bar", Author: "baz", Timestamp: time.Now().Unix()}
+		messageStore := &test_utils.StubMessageStore{Messages: api.ChannelHistory{&recent, &expired}}
+		retention := &sockchat.RetentionPolicy{Channels: map[string]time.Duration{test_utils.ChannelWithUser: 24 * time.Hour}}
+		core := &services.SockchatCoreService{ChatChannels: &test_utils.StubChannelStore{}, Messages: messageStore, Retention: retention}
+
+		history, err := core.GetChannelHistory(&services.ChannelHistoryWrapper{Nick: test_utils.ValidUserNick, Request: &api.GetChannelHistoryRequest{Channel: test_utils.ChannelWithUser}}, ctx)
+		require.NoError(t, err)
+		assert.Equal(t, api.ChannelHistory{&recent}, history.Messages)
+
+		core.Retention = &sockchat.RetentionPolicy{}
+		history, err = core.GetChannelHistory(&services.ChannelHistoryWrapper{Nick: test_utils.ValidUserNick, Request: &api.GetChannelHistoryRequest{Channel: test_utils.ChannelWithUser}}, ctx)
+		require.NoError(t, err)
+		assert.Len(t, history.Messages, 2)
+	})
+
+	t.Run("can list and unblock blocked users", func(t *testing.T) {
+		res, err := core.ListBlocks(&api.AccountRequest{Nick: test_utils.ValidUser2Nick}, ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{test_utils.ValidUserNick}, res.Nicks)
+
+		_, err = core.Unblock(&services.BlockWrapper{Nick: test_utils.ValidUser2Nick, Request: &api.BlockRequest{Nick: test_utils.ValidUserNick}}, ctx)
+		require.NoError(t, err)
+		res, err = core.ListBlocks(&api.AccountRequest{Nick: test_utils.ValidUser2Nick}, ctx)
+		require.NoError(t, err)
+		assert.Empty(t, res.Nicks)
+	})
+
+	t.Run("can change nick and history follows the author", func(t *testing.T) {
+		id, err := userProfiles.GetUserID(ctx, "Foo")
+		require.NoError(t, err)
+		sent := api.MessageEvent{Text: "before rename", Channel: "bar", Author: "Foo", AuthorID: id}
+		// indexed with the old nick while the user was being renamed
+		late := api.MessageEvent{Text: "during rename", Channel: "bar", Author: "Foo", AuthorID: id}
+		messageStore := &test_utils.StubMessageStore{Messages: api.ChannelHistory{&sent}}
+		core := &services.SockchatCoreService{UserProfiles: userProfiles, ChatChannels: &test_utils.StubChannelStore{}, Messages: messageStore}
+
+		res, err := core.ChangeNick(&services.ChangeNickWrapper{Nick: "Foo", Request: &api.ChangeNickRequest{NewNick: "FooRenamed"}}, ctx)
+		require.NoError(t, err)
+		assert.Equal(t, &api.ChangeNickResponse{Nick: "FooRenamed"}, res)
+		messageStore.Messages = append(messageStore.Messages, &late)
+
+		history, err := core.GetChannelHistory(&services.ChannelHistoryWrapper{Nick: "FooRenamed", Request: &api.GetChannelHistoryRequest{Channel: test_utils.ChannelWithUser}}, ctx)
+		require.NoError(t, err)
+		require.Len(t, history.Messages, 2)
+		for _, msg := range history.Messages {
+			assert.Equal(t, "FooRenamed", msg.Author)
+		}
+
+		_, err = core.ChangeNick(&services.ChangeNickWrapper{Nick: "FooRenamed", Request: &api.ChangeNickRequest{NewNick: test_utils.ValidUserNick}}, ctx)
+		assert.Equal(t, api.ErrNickAlreadyUsed, err)
 	})
 
 	t.Run("can not register a new user with missing required data", func(t *testing.T) {
@@ -79,7 +232,7 @@ func TestSockChatCoreService(t *testing.T) {
 	})
 
 	t.Run("can not get history of non existing channel", func(t *testing.T) {
-		_, err := core.GetChannelHistory(&api.GetChannelHistoryRequest{Channel: "not_exists"}, ctx)
+		_, err := core.GetChannelHistory(&services.ChannelHistoryWrapper{Nick: test_utils.ValidUserNick, Request: &api.GetChannelHistoryRequest{Channel: "not_exists"}}, ctx)
 		assert.Error(t, err)
 	})
 }
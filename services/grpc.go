@@ -13,6 +13,7 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 	emptypb "google.golang.org/protobuf/types/known/emptypb"
 )
@@ -20,20 +21,62 @@ import (
 var GRPCCodes = map[error]codes.Code{
 	api.ErrNickAlreadyUsed:       codes.AlreadyExists,
 	api.ErrNickRequired:          codes.InvalidArgument,
+	api.ErrInvalidNick:           codes.InvalidArgument,
 	api.ErrPasswordRequired:      codes.InvalidArgument,
+	api.ErrInvalidPassword:       codes.PermissionDenied,
+	api.ErrInvalidResetToken:     codes.InvalidArgument,
 	api.ErrInvalidRequest:        codes.InvalidArgument,
 	api.ErrMetadataNotProvided:   codes.InvalidArgument,
 	api.ErrChannelNotFound:       codes.NotFound,
 	api.ErrInternal:              codes.Internal,
 	api.ErrUnauthorized:          codes.Unauthenticated,
+	api.ErrForbidden:             codes.PermissionDenied,
+	api.ErrUserNotFound:          codes.NotFound,
+	api.ErrEmptyChannelName:      codes.InvalidArgument,
 	api.ErrAuthorizationRequired: codes.Unauthenticated,
 	api.ErrBasicTokenRequired:    codes.Unauthenticated,
 	api.ErrCouldNotDecodeToken:   codes.Unauthenticated,
+	api.ErrInvalidToken:          codes.Unauthenticated,
+	api.ErrRefreshTokenRequired:  codes.InvalidArgument,
+	api.ErrAccountLocked:         codes.ResourceExhausted,
+	api.ErrInvalidAPIKey:         codes.Unauthenticated,
+	api.ErrInsufficientScope:     codes.PermissionDenied,
+	api.ErrAccountDisabled:       codes.PermissionDenied,
+	api.ErrAPIKeyNameRequired:    codes.InvalidArgument,
+	api.ErrScopesRequired:        codes.InvalidArgument,
+	api.ErrInvalidScope:          codes.InvalidArgument,
+	api.ErrAPIKeyNotFound:        codes.NotFound,
+	api.ErrNotServiceAccount:     codes.FailedPrecondition,
+	api.ErrInvalidRole:           codes.InvalidArgument,
+	api.ErrCannotChangeOwnRole:   codes.FailedPrecondition,
+	api.ErrOTPRequired:           codes.Unauthenticated,
+	api.ErrInvalidOTP:            codes.Unauthenticated,
+	api.ErrTOTPAlreadyEnabled:    codes.FailedPrecondition,
+	api.ErrTOTPNotEnrolled:       codes.FailedPrecondition,
+	api.ErrTOTPNotEnabled:        codes.FailedPrecondition,
+	api.ErrIdentityNotFound:      codes.NotFound,
+	api.ErrIdentityAlreadyLinked: codes.AlreadyExists,
 	api.ErrInvalidGroupBy:        codes.InvalidArgument,
 	api.ErrInvalidDateFormat:     codes.InvalidArgument,
 	api.ErrFromMissing:           codes.InvalidArgument,
 	api.ErrToMissing:             codes.InvalidArgument,
 	api.ErrMaxReportSizeExceeded: codes.OutOfRange,
+	api.ErrProfileFieldTooLong:   codes.InvalidArgument,
+	api.ErrInvalidTimezone:       codes.InvalidArgument,
+	api.ErrTooManyCustomFields:   codes.InvalidArgument,
+	api.ErrCustomFieldNameEmpty:  codes.InvalidArgument,
+	api.ErrAvatarTooLarge:        codes.InvalidArgument,
+	api.ErrUnsupportedAvatarType: codes.InvalidArgument,
+	api.ErrBlobNotFound:          codes.NotFound,
+	api.ErrCannotBlockSelf:       codes.InvalidArgument,
+	api.ErrPreferenceTooLong:     codes.InvalidArgument,
+	api.ErrInvalidNotification:   codes.InvalidArgument,
+	api.ErrTooManyChannelPrefs:   codes.InvalidArgument,
+	api.ErrInvalidCursor:         codes.InvalidArgument,
+	api.ErrConflictingCursors:    codes.InvalidArgument,
+	api.ErrInvalidSort:           codes.InvalidArgument,
+	api.ErrInvalidRange:          codes.InvalidArgument,
+	api.ErrUserNotInChannel:      codes.PermissionDenied,
 }
 
 func NewGRPCError(err error) error {
@@ -54,44 +97,115 @@ type GrpcAPI struct {
 
 func NewSockchatGRPCServer(core *SockchatCoreService, authService *SockchatAuthService, reportsService api.SockchatReportsService) *grpc.Server {
 	grpcApi := &GrpcAPI{core: core, authService: authService, userReports: reportsService}
-	server := grpc.NewServer(grpc.UnaryInterceptor(grpcApi.AuthInterceptor))
+	server := grpc.NewServer(grpc.UnaryInterceptor(grpcApi.AuthInterceptor), grpc.StreamInterceptor(grpcApi.StreamAuthInterceptor))
 	pb.RegisterSockchatServer(server, grpcApi)
 	return server
 }
 
-var methodAuthorizationRequired = map[string]bool{
-	"RegisterProfile":       false,
-	"GetProfile":            true,
-	"EditProfile":           true,
-	"GetChannelHistory":     true,
-	"GetUserActivityReport": true,
+var methodPermissions = map[string]Permission{
+	"RegisterProfile":        public,
+	"Login":                  public,
+	"RefreshSession":         public,
+	"Logout":                 authenticated,
+	"GetProfile":             {Scope: api.ScopeProfileRead},
+	"EditProfile":            authenticated,
+	"UploadAvatar":           authenticated,
+	"SearchUsers":            {Scope: api.ScopeProfileRead},
+	"SetDirectoryVisibility": authenticated,
+	"ChangePassword":         authenticated,
+	"ChangeNick":             authenticated,
+	"GetPreferences":         authenticated,
+	"UpdatePreferences":      authenticated,
+	"RequestPasswordReset":   public,
+	"ResetPassword":          public,
+	"CreateAPIKey":           authenticated,
+	"ListAPIKeys":            authenticated,
+	"RevokeAPIKey":           authenticated,
+	"EnrollTOTP":             authenticated,
+	"ConfirmTOTP":            authenticated,
+	"DisableTOTP":            authenticated,
+	"Block":                  authenticated,
+	"Unblock":                authenticated,
+	"ListBlocks":             authenticated,
+	"DeleteAccount":          authenticated,
+	"ExportMyData":           authenticated,
+	"ListUsers":              adminOnly,
+	"CreateServiceAccount":   adminOnly,
+	"SetRole":                adminOnly,
+	"DisableAccount":         adminOnly,
+	"EnableAccount":          adminOnly,
+	"DisconnectUser":         adminOnly,
+	"GetChannelHistory":      {Scope: api.ScopeHistoryRead},
+	"SearchMessages":         {Scope: api.ScopeHistoryRead},
+	"GetUserActivityReport":  {Scope: api.ScopeReportsRead},
+	"Chat":                   {Scope: api.ScopeMessagesWrite},
+	"SubscribeChannel":       {Scope: api.ScopeChannelsSubscribe, Roles: []api.Role{api.RoleService, api.RoleAdmin}},
 }
 
-func isProtected(fullMethodName string) bool {
+func methodPermission(fullMethodName string) Permission {
 	methodName := strings.TrimPrefix(fullMethodName, "/sockchat.Sockchat/")
-	requires_authorization, exists := methodAuthorizationRequired[methodName]
+	permission, exists := methodPermissions[methodName]
 	if !exists {
-		log.Fatalf("gRPC method %s not defined in methodAuthorizationRequired map", methodName)
+		log.Fatalf("gRPC method %s not defined in methodPermissions map", methodName)
 	}
-	return requires_authorization
+	return permission
 }
 
 func (s *GrpcAPI) AuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	if isProtected(info.FullMethod) {
-		token, err := tokenFromCtx(ctx)
+	permission := methodPermission(info.FullMethod)
+	if !permission.Public {
+		principal, err := s.authorize(ctx, permission)
 		if err != nil {
-			return nil, NewGRPCError(err)
+			return nil, err
 		}
-		authenticationOK, err := s.authService.AuthenticateFromBasicToken(ctx, token)
+		ctx = withPrincipal(ctx, principal)
+	}
+	return handler(ctx, req)
+}
+
+func (s *GrpcAPI) StreamAuthInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	permission := methodPermission(info.FullMethod)
+	if !permission.Public {
+		principal, err := s.authorize(ss.Context(), permission)
 		if err != nil {
-			return nil, NewGRPCError(err)
-		}
-		if !authenticationOK {
-			err := api.ErrUnauthorized
-			return nil, NewGRPCError(err)
+			return err
 		}
+		ss = &authenticatedServerStream{ServerStream: ss, ctx: withPrincipal(ss.Context(), principal)}
 	}
-	return handler(ctx, req)
+	return handler(srv, ss)
+}
+
+func (s *GrpcAPI) authorize(ctx context.Context, permission Permission) (*api.Principal, error) {
+	token, err := tokenFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	principal, err := s.authService.Authenticate(withClientIP(ctx, peerIP(ctx)), token)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	if err := s.authService.Authorize(ctx, principal, permission); err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return principal, nil
+}
+
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	return remoteIP(p.Addr.String())
+}
+
+// authenticatedServerStream carries nick of the authenticated user in its context
+type authenticatedServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedServerStream) Context() context.Context {
+	return s.ctx
 }
 
 func (s *GrpcAPI) RegisterProfile(ctx context.Context, in *pb.RegisterProfileRequest) (*emptypb.Empty, error) {
@@ -102,6 +216,195 @@ func (s *GrpcAPI) RegisterProfile(ctx context.Context, in *pb.RegisterProfileReq
 	return &emptypb.Empty{}, nil
 }
 
+func (s *GrpcAPI) Login(ctx context.Context, in *pb.LoginRequest) (*pb.SessionTokens, error) {
+	res, err := s.authService.Login(withClientIP(ctx, peerIP(ctx)), api.LoginRequestFromProto(in))
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return api.SessionTokensToProto(res), nil
+}
+
+func (s *GrpcAPI) RefreshSession(ctx context.Context, in *pb.RefreshSessionRequest) (*pb.SessionTokens, error) {
+	res, err := s.authService.RefreshSession(ctx, api.RefreshSessionRequestFromProto(in))
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return api.SessionTokensToProto(res), nil
+}
+
+func (s *GrpcAPI) Logout(ctx context.Context, in *pb.LogoutRequest) (*emptypb.Empty, error) {
+	token, err := tokenFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	if err := s.authService.Logout(ctx, token, api.LogoutRequestFromProto(in)); err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *GrpcAPI) CreateAPIKey(ctx context.Context, in *pb.CreateAPIKeyRequest) (*pb.CreatedAPIKey, error) {
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	res, err := s.authService.CreateAPIKey(ctx, nick, api.CreateAPIKeyRequestFromProto(in))
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return api.CreatedAPIKeyToProto(res), nil
+}
+
+func (s *GrpcAPI) ListAPIKeys(ctx context.Context, in *pb.ListAPIKeysRequest) (*pb.ListAPIKeysResponse, error) {
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	res, err := s.authService.ListAPIKeys(ctx, nick, in.Account)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return &pb.ListAPIKeysResponse{ApiKeys: api.APIKeysToProto(res)}, nil
+}
+
+func (s *GrpcAPI) RevokeAPIKey(ctx context.Context, in *pb.RevokeAPIKeyRequest) (*emptypb.Empty, error) {
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	if err := s.authService.RevokeAPIKey(ctx, nick, &api.RevokeAPIKeyRequest{ID: in.Id, Account: in.Account}); err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *GrpcAPI) EnrollTOTP(ctx context.Context, in *emptypb.Empty) (*pb.TOTPEnrollment, error) {
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	res, err := s.authService.EnrollTOTP(ctx, nick)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return api.TOTPEnrollmentToProto(res), nil
+}
+
+func (s *GrpcAPI) ConfirmTOTP(ctx context.Context, in *pb.TOTPCodeRequest) (*pb.RecoveryCodes, error) {
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	res, err := s.authService.ConfirmTOTP(withClientIP(ctx, peerIP(ctx)), nick, &api.TOTPCodeRequest{Code: in.Code})
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return api.RecoveryCodesToProto(res), nil
+}
+
+func (s *GrpcAPI) DisableTOTP(ctx context.Context, in *pb.TOTPCodeRequest) (*emptypb.Empty, error) {
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	if err := s.authService.DisableTOTP(withClientIP(ctx, peerIP(ctx)), nick, &api.TOTPCodeRequest{Code: in.Code}); err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *GrpcAPI) ListUsers(ctx context.Context, in *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	res, err := s.core.ListUsers(api.ListUsersRequestFromProto(in), ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return &pb.ListUsersResponse{Accounts: api.AccountsToProto(res)}, nil
+}
+
+func (s *GrpcAPI) DeleteAccount(ctx context.Context, in *emptypb.Empty) (*emptypb.Empty, error) {
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	_, err = s.core.DeleteAccount(&api.AccountRequest{Nick: nick}, ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *GrpcAPI) ExportMyData(ctx context.Context, in *emptypb.Empty) (*pb.ExportMyDataResponse, error) {
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	archive, err := s.core.ExportMyData(&api.AccountRequest{Nick: nick}, ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return &pb.ExportMyDataResponse{Archive: archive}, nil
+}
+
+func (s *GrpcAPI) CreateServiceAccount(ctx context.Context, in *pb.CreateServiceAccountRequest) (*pb.Account, error) {
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	res, err := s.core.CreateServiceAccount(&CreateServiceAccountWrapper{Nick: nick, Request: api.CreateServiceAccountRequestFromProto(in)}, ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return api.AccountToProto(res), nil
+}
+
+func (s *GrpcAPI) SetRole(ctx context.Context, in *pb.SetRoleRequest) (*emptypb.Empty, error) {
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	_, err = s.core.SetRole(&SetRoleWrapper{Nick: nick, Request: api.SetRoleRequestFromProto(in)}, ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *GrpcAPI) DisableAccount(ctx context.Context, in *pb.AccountRequest) (*emptypb.Empty, error) {
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	_, err = s.core.DisableAccount(&AdminRequestWrapper{Nick: nick, Request: api.AccountRequestFromProto(in)}, ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *GrpcAPI) EnableAccount(ctx context.Context, in *pb.AccountRequest) (*emptypb.Empty, error) {
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	_, err = s.core.EnableAccount(&AdminRequestWrapper{Nick: nick, Request: api.AccountRequestFromProto(in)}, ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *GrpcAPI) DisconnectUser(ctx context.Context, in *pb.AccountRequest) (*pb.DisconnectUserResponse, error) {
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	res, err := s.core.DisconnectUser(&AdminRequestWrapper{Nick: nick, Request: api.AccountRequestFromProto(in)}, ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return &pb.DisconnectUserResponse{DisconnectedConnections: int32(res.DisconnectedConnections)}, nil
+}
+
 func (s *GrpcAPI) GetProfile(ctx context.Context, in *pb.GetProfileRequest) (*pb.Profile, error) {
 	res, err := s.core.GetProfile(api.GetProfileRequestFromProto(in), ctx)
 	if err != nil {
@@ -111,28 +414,173 @@ func (s *GrpcAPI) GetProfile(ctx context.Context, in *pb.GetProfileRequest) (*pb
 }
 
 func (s *GrpcAPI) EditProfile(ctx context.Context, in *pb.EditProfileRequest) (*emptypb.Empty, error) {
-	token, err := tokenFromCtx(ctx)
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	_, err = s.core.EditProfile(&EditProfileWrapper{Nick: nick, Request: api.EditProfileRequestFromProto(in)}, ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *GrpcAPI) UploadAvatar(ctx context.Context, in *pb.UploadAvatarRequest) (*pb.Profile, error) {
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	res, err := s.core.SetAvatar(&SetAvatarWrapper{Nick: nick, Image: in.Image}, ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return api.ProfileToProto(res), nil
+}
+
+func (s *GrpcAPI) SearchUsers(ctx context.Context, in *pb.SearchUsersRequest) (*pb.SearchUsersResponse, error) {
+	res, err := s.core.SearchUsers(api.SearchUsersRequestFromProto(in), ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return &pb.SearchUsersResponse{Users: api.ProfilesToProto(res)}, nil
+}
+
+func (s *GrpcAPI) SetDirectoryVisibility(ctx context.Context, in *pb.DirectoryVisibilityRequest) (*emptypb.Empty, error) {
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	_, err = s.core.SetDirectoryVisibility(&DirectoryVisibilityWrapper{Nick: nick, Request: &api.DirectoryVisibilityRequest{Hidden: in.Hidden}}, ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *GrpcAPI) Block(ctx context.Context, in *pb.BlockRequest) (*emptypb.Empty, error) {
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	_, err = s.core.Block(&BlockWrapper{Nick: nick, Request: &api.BlockRequest{Nick: in.Nick}}, ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *GrpcAPI) Unblock(ctx context.Context, in *pb.BlockRequest) (*emptypb.Empty, error) {
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	_, err = s.core.Unblock(&BlockWrapper{Nick: nick, Request: &api.BlockRequest{Nick: in.Nick}}, ctx)
 	if err != nil {
 		return nil, NewGRPCError(err)
 	}
-	authData, err := decodeToken(token)
+	return &emptypb.Empty{}, nil
+}
+
+func (s *GrpcAPI) ListBlocks(ctx context.Context, in *emptypb.Empty) (*pb.ListBlocksResponse, error) {
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	res, err := s.core.ListBlocks(&api.AccountRequest{Nick: nick}, ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return &pb.ListBlocksResponse{Nicks: res.Nicks}, nil
+}
+
+func (s *GrpcAPI) GetPreferences(ctx context.Context, in *emptypb.Empty) (*pb.Preferences, error) {
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	res, err := s.core.GetPreferences(&api.AccountRequest{Nick: nick}, ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return api.PreferencesToProto(res), nil
+}
+
+func (s *GrpcAPI) UpdatePreferences(ctx context.Context, in *pb.Preferences) (*pb.Preferences, error) {
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	res, err := s.core.UpdatePreferences(&PreferencesWrapper{Nick: nick, Request: api.PreferencesFromProto(in)}, ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return api.PreferencesToProto(res), nil
+}
+
+func (s *GrpcAPI) ChangePassword(ctx context.Context, in *pb.ChangePasswordRequest) (*pb.ChangePasswordResponse, error) {
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	res, err := s.core.ChangePassword(&ChangePasswordWrapper{Nick: nick, Request: api.ChangePasswordRequestFromProto(in)}, ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return api.ChangePasswordResponseToProto(res), nil
+}
+
+func (s *GrpcAPI) ChangeNick(ctx context.Context, in *pb.ChangeNickRequest) (*pb.ChangeNickResponse, error) {
+	nick, err := nickFromCtx(ctx)
 	if err != nil {
 		return nil, NewGRPCError(err)
 	}
-	_, err = s.core.EditProfile(&EditProfileWrapper{Nick: authData.Username, Request: api.EditProfileRequestFromProto(in)}, ctx)
+	res, err := s.core.ChangeNick(&ChangeNickWrapper{Nick: nick, Request: api.ChangeNickRequestFromProto(in)}, ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return api.ChangeNickResponseToProto(res), nil
+}
+
+func (s *GrpcAPI) RequestPasswordReset(ctx context.Context, in *pb.RequestPasswordResetRequest) (*emptypb.Empty, error) {
+	_, err := s.core.RequestPasswordReset(api.RequestPasswordResetRequestFromProto(in), ctx)
 	if err != nil {
 		return nil, NewGRPCError(err)
 	}
 	return &emptypb.Empty{}, nil
 }
 
+func (s *GrpcAPI) ResetPassword(ctx context.Context, in *pb.ResetPasswordRequest) (*emptypb.Empty, error) {
+	_, err := s.core.ResetPassword(api.ResetPasswordRequestFromProto(in), ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *GrpcAPI) SearchMessages(ctx context.Context, in *pb.SearchMessagesRequest) (*pb.SearchMessagesResponse, error) {
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	res, err := s.core.SearchMessages(&SearchMessagesWrapper{Nick: nick, Request: api.SearchMessagesRequestFromProto(in)}, ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	return api.SearchMessagesResponseToProto(res), nil
+}
+
 func (s *GrpcAPI) GetChannelHistory(ctx context.Context, in *pb.GetChannelHistoryRequest) (*pb.GetChannelHistoryResponse, error) {
-	res, err := s.core.GetChannelHistory(api.GetChannelHistoryRequestFromProto(in), ctx)
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	res, err := s.core.GetChannelHistory(&ChannelHistoryWrapper{Nick: nick, Request: api.GetChannelHistoryRequestFromProto(in)}, ctx)
 	if err != nil {
 		return nil, NewGRPCError(err)
 	}
 	return &pb.GetChannelHistoryResponse{
-		Messages: api.ChannelHistoryToProto(res),
+		Messages:   api.ChannelHistoryToProto(res.Messages),
+		NextCursor: res.NextCursor,
 	}, nil
 }
 
@@ -141,6 +589,20 @@ func (s *GrpcAPI) GetUserActivityReport(ctx context.Context, in *pb.GetUserActiv
 	if err != nil {
 		return nil, NewGRPCError(err)
 	}
+	// reports of other users are available to admins only
+	nick, err := nickFromCtx(ctx)
+	if err != nil {
+		return nil, NewGRPCError(err)
+	}
+	if in.Author != nick {
+		isAdmin, err := s.authService.HasRole(ctx, nick, api.RoleAdmin)
+		if err != nil {
+			return nil, NewGRPCError(err)
+		}
+		if !isAdmin {
+			return nil, NewGRPCError(api.ErrForbidden)
+		}
+	}
 	parsedIn, err := parseReportsDate(in.From)
 	if err != nil {
 		return nil, NewGRPCError(err)
@@ -155,6 +617,10 @@ func (s *GrpcAPI) GetUserActivityReport(ctx context.Context, in *pb.GetUserActiv
 		From:    *parsedIn,
 		To:      *parsedOut,
 	}
+	// messages are matched by author id too, so that they are counted after the author changes nick
+	if id, err := s.core.UserProfiles.GetUserID(ctx, in.Author); err == nil {
+		opts.AuthorID = id
+	}
 	res, err := s.userReports.GetUserActivityReport(opts)
 	if err != nil {
 		return nil, err
@@ -173,6 +639,11 @@ func tokenFromCtx(ctx context.Context) (string, error) {
 	return meta["authorization"][0], nil
 }
 
+// nickFromCtx returns nick of the user who authenticated the call
+func nickFromCtx(ctx context.Context) (string, error) {
+	return authenticatedNick(ctx)
+}
+
 func ServeGRPC(server *grpc.Server, grpcPort int) {
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", grpcPort))
 	if err != nil {
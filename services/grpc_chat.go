@@ -0,0 +1,98 @@
+package services
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/kacperf531/sockchat/api"
+	pb "github.com/kacperf531/sockchat/protobuf"
+)
+
+// Chat lets gRPC clients take part in channels the same way websocket clients do
+func (s *GrpcAPI) Chat(stream pb.Sockchat_ChatServer) error {
+	nick, err := nickFromCtx(stream.Context())
+	if err != nil {
+		return NewGRPCError(err)
+	}
+	conn := &GrpcChatConnection{stream: stream, done: make(chan struct{})}
+	s.core.ConnectedUsers.AddConnection(conn, nick)
+	defer s.core.ConnectedUsers.RemoveConnection(conn)
+
+	conn.WriteSocketMsg(api.NewSocketMessage("logged_in:"+nick, "{}"))
+	served := make(chan error, 1)
+	go func() {
+		served <- s.serveChatStream(conn)
+	}()
+	select {
+	case err := <-served:
+		return err
+	case <-conn.done:
+		return nil
+	}
+}
+
+func (s *GrpcAPI) serveChatStream(conn *GrpcChatConnection) error {
+	for {
+		receivedMsg, err := conn.ReadSocketMsg()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = serveConnectionRequest(s.core.ConnectedUsers, conn, *receivedMsg)
+		if err != nil {
+			log.Printf("error serving gRPC chat stream: %v", err)
+			return NewGRPCError(api.ErrInternal)
+		}
+	}
+}
+
+// GrpcChatConnection adapts bidirectional gRPC chat stream to a websocket-like connection
+type GrpcChatConnection struct {
+	stream    pb.Sockchat_ChatServer
+	writeLock sync.Mutex
+	done      chan struct{}
+	closed    bool
+}
+
+func (c *GrpcChatConnection) ReadMsg() ([]byte, error) {
+	in, err := c.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(api.ChatActionToSocketMessage(in))
+}
+
+func (c *GrpcChatConnection) ReadSocketMsg() (*api.SocketMessage, error) {
+	in, err := c.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	msg := api.ChatActionToSocketMessage(in)
+	return &msg, nil
+}
+
+func (c *GrpcChatConnection) WriteSocketMsg(m api.SocketMessage) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if c.closed {
+		return
+	}
+	if err := c.stream.Send(api.SocketMessageToChatEvent(m)); err != nil {
+		log.Printf("Error writing message %s with payload %s to gRPC stream: %v", m.Action, string(m.Payload), err)
+	}
+}
+
+// Close ends the stream; nothing is sent over it afterwards
+func (c *GrpcChatConnection) Close() error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.done)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"sort"
+
+	"github.com/kacperf531/sockchat/api"
+	pb "github.com/kacperf531/sockchat/protobuf"
+)
+
+const subscriptionBufferSize = 256
+
+// SubscribeChannel streams messages and membership changes of given channels to service accounts.
+// Subscriber is not listed as a member of the channels.
+func (s *GrpcAPI) SubscribeChannel(in *pb.SubscribeChannelRequest, stream pb.Sockchat_SubscribeChannelServer) error {
+	ctx := stream.Context()
+	if _, err := nickFromCtx(ctx); err != nil {
+		return NewGRPCError(err)
+	}
+	if len(in.Channels) == 0 {
+		return NewGRPCError(api.ErrEmptyChannelName)
+	}
+	for _, channel := range in.Channels {
+		if !s.core.ChatChannels.ChannelExists(channel) {
+			return NewGRPCError(api.ErrChannelNotFound)
+		}
+	}
+
+	subscription := &channelSubscription{events: make(chan api.SocketMessage, subscriptionBufferSize), done: ctx.Done()}
+	for _, channel := range in.Channels {
+		if err := s.core.ChatChannels.AddObserver(channel, subscription); err != nil {
+			return NewGRPCError(api.ErrChannelNotFound)
+		}
+		defer s.core.ChatChannels.RemoveObserver(channel, subscription)
+	}
+
+	if in.ReplayFrom > 0 {
+		if err := s.replayChannels(in, stream); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-subscription.events:
+			if err := stream.Send(api.SocketMessageToChatEvent(msg)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// replayChannels sends stored messages not older than requested timestamp, oldest first
+func (s *GrpcAPI) replayChannels(in *pb.SubscribeChannelRequest, stream pb.Sockchat_SubscribeChannelServer) error {
+	var replayed api.ChannelHistory
+	for _, channel := range in.Channels {
+		query := &api.HistoryQuery{Channel: channel, From: s.core.RetainedSince(channel, in.ReplayFrom), Limit: MaxHistoryLimit}
+		for {
+			page, err := s.core.Messages.FindMessages(stream.Context(), query)
+			if err != nil {
+				return NewGRPCError(err)
+			}
+			replayed = append(replayed, page.Messages...)
+			if page.NextCursor == "" {
+				break
+			}
+			query.Before = page.NextCursor
+		}
+	}
+	sort.SliceStable(replayed, func(i, j int) bool { return replayed[i].Timestamp < replayed[j].Timestamp })
+	for _, msg := range replayed {
+		if err := stream.Send(api.SocketMessageToChatEvent(api.NewSocketMessage(api.NewMessageEvent, msg))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// channelSubscription buffers channel events until they are sent to the subscriber
+type channelSubscription struct {
+	events chan api.SocketMessage
+	done   <-chan struct{}
+}
+
+func (o *channelSubscription) Write(msg api.SocketMessage) {
+	select {
+	case o.events <- msg:
+	case <-o.done:
+	}
+}
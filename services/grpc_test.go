@@ -14,6 +14,7 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/kacperf531/sockchat"
 	"github.com/kacperf531/sockchat/api"
@@ -32,9 +33,11 @@ var (
 func TestSockChatGRPC(t *testing.T) {
 	t.Parallel()
 
+	cache := test_utils.NewTestingRedisClient(t)
+
 	validToken := "Basic " + base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", test_utils.ValidUserNick, test_utils.ValidUserPassword)))
 	invalidToken := "Basic rhweufdsf420"
-	sampleMessage := api.MessageEvent{Text: "foo", Channel: "bar", Author: "baz"}
+	sampleMessage := api.MessageEvent{Text: "foo", Channel: "bar", Author: "baz", Timestamp: 100}
 	messageStore := &test_utils.StubMessageStore{Messages: api.ChannelHistory{&sampleMessage}}
 
 	// Set up test grpc server
@@ -42,10 +45,19 @@ func TestSockChatGRPC(t *testing.T) {
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
-	userProfiles := &sockchat.ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: test_utils.TestingRedisClient}
-	core := &services.SockchatCoreService{UserProfiles: userProfiles, ChatChannels: &test_utils.StubChannelStore{}, Messages: messageStore}
+	userProfiles := &sockchat.ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: cache}
+	channelStore := &test_utils.StubChannelStore{}
+	sessions := &sockchat.SessionService{Secret: []byte("secret"), Cache: cache}
+	personalData := &sockchat.PersonalDataService{Profiles: userProfiles, Messages: messageStore, Sessions: sessions}
+	blockList := &sockchat.BlockListService{Store: &test_utils.BlockStoreDouble{}, Profiles: userProfiles}
+	connectedUsers := sockchat.NewConnectedUsersPool(channelStore)
+	connectedUsers.BlockList = blockList
+	preferences := &sockchat.PreferencesService{Store: &test_utils.PreferencesStoreDouble{}, Cache: cache}
+	connectedUsers.Preferences = preferences
+	core := &services.SockchatCoreService{UserProfiles: userProfiles, ChatChannels: channelStore, Messages: messageStore, ConnectedUsers: connectedUsers, PersonalData: personalData, BlockList: blockList, Sessions: sessions, Preferences: preferences}
 	stubReports := &test_utils.StubReportsService{}
-	server := services.NewSockchatGRPCServer(core, &services.SockchatAuthService{UserProfiles: userProfiles}, stubReports)
+	apiKeys := &sockchat.APIKeyService{Store: &test_utils.APIKeyStoreDouble{}}
+	server := services.NewSockchatGRPCServer(core, &services.SockchatAuthService{UserProfiles: userProfiles, Sessions: sessions, APIKeys: apiKeys}, stubReports)
 	go func() {
 		if err := server.Serve(lis); err != nil {
 			log.Fatalf("failed to serve: %v", err)
@@ -66,6 +78,112 @@ func TestSockChatGRPC(t *testing.T) {
 		require.NoError(t, err)
 	})
 
+	t.Run("can log in and use session token over grpc", func(t *testing.T) {
+		tokens, err := client.Login(context.Background(), &pb.LoginRequest{Nick: test_utils.ValidUserNick, Password: test_utils.ValidUserPassword})
+		require.NoError(t, err)
+
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+tokens.AccessToken))
+		_, err = client.GetChannelHistory(ctx, &pb.GetChannelHistoryRequest{Channel: test_utils.ChannelWithUser})
+		require.NoError(t, err)
+
+		_, err = client.Logout(ctx, &pb.LogoutRequest{RefreshToken: tokens.RefreshToken})
+		require.NoError(t, err)
+		_, err = client.GetChannelHistory(ctx, &pb.GetChannelHistoryRequest{Channel: test_utils.ChannelWithUser})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		_, err = client.RefreshSession(context.Background(), &pb.RefreshSessionRequest{RefreshToken: tokens.RefreshToken})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("API key can only call methods allowed by its scopes", func(t *testing.T) {
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", validToken))
+		created, err := client.CreateAPIKey(ctx, &pb.CreateAPIKeyRequest{Name: "bot", Scopes: []string{string(api.ScopeHistoryRead)}})
+		require.NoError(t, err)
+
+		keyCtx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "ApiKey "+created.Key))
+		_, err = client.GetChannelHistory(keyCtx, &pb.GetChannelHistoryRequest{Channel: test_utils.ChannelWithUser})
+		require.NoError(t, err)
+		_, err = client.GetUserActivityReport(keyCtx, &pb.GetUserActivityReportRequest{Author: test_utils.ValidUserNick, From: "2018-01-01 00:00", To: "2018-01-02 00:00"})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		_, err = client.ListAPIKeys(keyCtx, &pb.ListAPIKeysRequest{})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+		_, err = client.RevokeAPIKey(ctx, &pb.RevokeAPIKeyRequest{Id: created.ApiKey.Id})
+		require.NoError(t, err)
+		_, err = client.GetChannelHistory(keyCtx, &pb.GetChannelHistoryRequest{Channel: test_utils.ChannelWithUser})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("can export data and delete own account", func(t *testing.T) {
+		nick := "GRPCDeletedTestUser"
+		_, err := client.RegisterProfile(context.Background(), &pb.RegisterProfileRequest{Nick: nick, Password: test_utils.ValidUserPassword})
+		require.NoError(t, err)
+		tokens, err := client.Login(context.Background(), &pb.LoginRequest{Nick: nick, Password: test_utils.ValidUserPassword})
+		require.NoError(t, err)
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+tokens.AccessToken))
+
+		export, err := client.ExportMyData(ctx, &emptypb.Empty{})
+		require.NoError(t, err)
+		assert.NotEmpty(t, export.Archive)
+
+		_, err = client.DeleteAccount(ctx, &emptypb.Empty{})
+		require.NoError(t, err)
+		_, err = client.ExportMyData(ctx, &emptypb.Empty{})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("can change nick and use new session", func(t *testing.T) {
+		nick := "GRPCRenamedTestUser"
+		_, err := client.RegisterProfile(context.Background(), &pb.RegisterProfileRequest{Nick: nick, Password: test_utils.ValidUserPassword})
+		require.NoError(t, err)
+		tokens, err := client.Login(context.Background(), &pb.LoginRequest{Nick: nick, Password: test_utils.ValidUserPassword})
+		require.NoError(t, err)
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+tokens.AccessToken))
+
+		res, err := client.ChangeNick(ctx, &pb.ChangeNickRequest{NewNick: nick + "New"})
+		require.NoError(t, err)
+		assert.Equal(t, nick+"New", res.Nick)
+		require.NotNil(t, res.Tokens)
+
+		newCtx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+res.Tokens.AccessToken))
+		_, err = client.ListBlocks(newCtx, &emptypb.Empty{})
+		assert.NoError(t, err)
+		_, err = client.ListBlocks(ctx, &emptypb.Empty{})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("can update and get preferences", func(t *testing.T) {
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", validToken))
+		updated, err := client.UpdatePreferences(ctx, &pb.Preferences{Language: "de", ChannelNotifications: map[string]string{"general": "none"}})
+		require.NoError(t, err)
+		assert.Equal(t, "all", updated.NotificationLevel)
+
+		prefs, err := client.GetPreferences(ctx, &emptypb.Empty{})
+		require.NoError(t, err)
+		assert.Equal(t, "de", prefs.Language)
+		assert.Equal(t, map[string]string{"general": "none"}, prefs.ChannelNotifications)
+
+		_, err = client.UpdatePreferences(ctx, &pb.Preferences{NotificationLevel: "sometimes"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("can block, list and unblock users", func(t *testing.T) {
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", validToken))
+		_, err := client.Block(ctx, &pb.BlockRequest{Nick: test_utils.ValidUser3Nick})
+		require.NoError(t, err)
+		blocks, err := client.ListBlocks(ctx, &emptypb.Empty{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{test_utils.ValidUser3Nick}, blocks.Nicks)
+
+		_, err = client.Unblock(ctx, &pb.BlockRequest{Nick: test_utils.ValidUser3Nick})
+		require.NoError(t, err)
+		blocks, err = client.ListBlocks(ctx, &emptypb.Empty{})
+		require.NoError(t, err)
+		assert.Empty(t, blocks.Nicks)
+
+		_, err = client.Block(ctx, &pb.BlockRequest{Nick: test_utils.ValidUserNick})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
 	t.Run("returns error for unauthorized request to channel history", func(t *testing.T) {
 		_, err := client.GetChannelHistory(ctx, &pb.GetChannelHistoryRequest{Channel: test_utils.ChannelWithUser})
 		require.ErrorContains(t, err, api.ErrBasicTokenRequired.Error())
@@ -78,6 +196,20 @@ func TestSockChatGRPC(t *testing.T) {
 		require.NoError(t, err)
 		require.Len(t, resp.Messages, 1)
 		require.Equal(t, resp.Messages[0].Text, sampleMessage.Text)
+		require.Empty(t, resp.NextCursor)
+
+		_, err = client.GetChannelHistory(ctx, &pb.GetChannelHistoryRequest{Channel: test_utils.ChannelWithUser, Before: "foo"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("can search messages of joined channels", func(t *testing.T) {
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", validToken))
+		resp, err := client.SearchMessages(ctx, &pb.SearchMessagesRequest{Query: "foo", Channels: []string{test_utils.ChannelWithUser}})
+		require.NoError(t, err)
+		assert.Zero(t, resp.Total)
+
+		_, err = client.SearchMessages(ctx, &pb.SearchMessagesRequest{Channels: []string{sampleMessage.Channel}})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
 	})
 
 	t.Run("returns error for unauthorized request to edit profile", func(t *testing.T) {
@@ -86,6 +218,22 @@ func TestSockChatGRPC(t *testing.T) {
 		assert.Equal(t, codes.Unauthenticated, status.Code(err))
 	})
 
+	t.Run("returns invalid argument for invalid profile and avatar", func(t *testing.T) {
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", validToken))
+		_, err := client.EditProfile(ctx, &pb.EditProfileRequest{Timezone: "Nowhere/Special"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		_, err = client.UploadAvatar(ctx, &pb.UploadAvatarRequest{Image: []byte("not an image")})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("searches users in directory", func(t *testing.T) {
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", validToken))
+		res, err := client.SearchUsers(ctx, &pb.SearchUsersRequest{Query: "veryspecial"})
+		require.NoError(t, err)
+		require.Len(t, res.Users, 1)
+		assert.Equal(t, test_utils.ValidUser2Nick, res.Users[0].Nick)
+	})
+
 	t.Run("returns error for unauthorized request to get profile", func(t *testing.T) {
 		_, err := client.GetProfile(ctx, &pb.GetProfileRequest{Nick: test_utils.ValidUserNick})
 		require.ErrorContains(t, err, api.ErrBasicTokenRequired.Error())
@@ -107,6 +255,17 @@ func TestSockChatGRPC(t *testing.T) {
 		assert.Nil(t, resp.Channels["foo"].MessageCountDistribution)
 	})
 
+	t.Run("returns error for request to user activity report of another user", func(t *testing.T) {
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", validToken))
+		_, err := client.GetUserActivityReport(ctx, &pb.GetUserActivityReportRequest{Author: test_utils.ValidUser2Nick, From: "2018-01-01 00:00", To: "2018-01-02 00:00"})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+		adminToken := "Basic " + base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", test_utils.ValidAdminNick, test_utils.ValidUserPassword)))
+		ctx = metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", adminToken))
+		_, err = client.GetUserActivityReport(ctx, &pb.GetUserActivityReportRequest{Author: test_utils.ValidUser2Nick, From: "2018-01-01 00:00", To: "2018-01-02 00:00"})
+		assert.NoError(t, err)
+	})
+
 	t.Run("returns user activity grouped by days", func(t *testing.T) {
 		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", validToken))
 		from := "2018-01-01 00:00"
@@ -136,9 +295,160 @@ func TestSockChatGRPC(t *testing.T) {
 		require.ErrorContains(t, err, api.ErrFromMissing.Error())
 	})
 
+	t.Run("can chat over bidirectional stream", func(t *testing.T) {
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", validToken))
+		stream, err := client.Chat(ctx)
+		require.NoError(t, err)
+		defer stream.CloseSend()
+
+		received, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, "logged_in:"+test_utils.ValidUserNick, received.Event)
+
+		require.NoError(t, stream.Send(&pb.ChatAction{Action: api.JoinAction, Channel: test_utils.ChannelWithoutUser}))
+		received, err = stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, api.UserJoinedChannelEvent, received.Event)
+		assert.Equal(t, test_utils.ChannelWithoutUser, received.GetUserChange().Channel)
+		assert.Equal(t, test_utils.ValidUserNick, received.GetUserChange().Nick)
+
+		require.NoError(t, stream.Send(&pb.ChatAction{Action: api.JoinAction, Channel: test_utils.ChannelWithUser}))
+		received, err = stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, api.ErrInvalidRequest.Error(), received.Event)
+		assert.Equal(t, api.ErrUserAlreadyInChannel.Error(), received.GetErrorDescription())
+	})
+
+	t.Run("can block and unblock users over chat stream", func(t *testing.T) {
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", validToken))
+		stream, err := client.Chat(ctx)
+		require.NoError(t, err)
+		defer stream.CloseSend()
+		_, err = stream.Recv()
+		require.NoError(t, err)
+
+		require.NoError(t, stream.Send(&pb.ChatAction{Action: api.BlockAction, Nick: test_utils.ValidAdminNick}))
+		received, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, api.UserBlockedEvent, received.Event)
+		assert.Equal(t, test_utils.ValidAdminNick, received.GetBlockChange().Nick)
+
+		require.NoError(t, stream.Send(&pb.ChatAction{Action: api.UnblockAction, Nick: test_utils.ValidAdminNick}))
+		received, err = stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, api.UserUnblockedEvent, received.Event)
+		assert.Equal(t, test_utils.ValidAdminNick, received.GetBlockChange().Nick)
+	})
+
+	t.Run("can update preferences over chat stream", func(t *testing.T) {
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", validToken))
+		stream, err := client.Chat(ctx)
+		require.NoError(t, err)
+		defer stream.CloseSend()
+		_, err = stream.Recv()
+		require.NoError(t, err)
+
+		require.NoError(t, stream.Send(&pb.ChatAction{Action: api.UpdatePreferencesAction, Preferences: &pb.Preferences{Theme: "dark"}}))
+		received, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, api.PreferencesUpdatedEvent, received.Event)
+		assert.Equal(t, "dark", received.GetPreferences().Theme)
+	})
+
+	t.Run("returns error for unauthorized chat stream", func(t *testing.T) {
+		stream, err := client.Chat(ctx)
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		require.ErrorContains(t, err, api.ErrBasicTokenRequired.Error())
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("service account can subscribe to a channel with replay", func(t *testing.T) {
+		serviceToken := "Basic " + base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", test_utils.ValidServiceNick, test_utils.ValidUserPassword)))
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", serviceToken))
+		stream, err := client.SubscribeChannel(ctx, &pb.SubscribeChannelRequest{Channels: []string{"bar"}, ReplayFrom: 50})
+		require.NoError(t, err)
+
+		replayed, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, api.NewMessageEvent, replayed.Event)
+		assert.Equal(t, sampleMessage.Text, replayed.GetMessage().Text)
+
+		observed, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, api.NewMessageEvent, observed.Event)
+		assert.Equal(t, "observed", observed.GetMessage().Text)
+	})
+
+	t.Run("admin can create a service account and issue API keys to it", func(t *testing.T) {
+		adminToken := "Basic " + base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", test_utils.ValidAdminNick, test_utils.ValidUserPassword)))
+		adminCtx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", adminToken))
+		account, err := client.CreateServiceAccount(adminCtx, &pb.CreateServiceAccountRequest{Nick: "GRPCServiceAccount"})
+		require.NoError(t, err)
+		assert.Equal(t, string(api.RoleService), account.Role)
+
+		created, err := client.CreateAPIKey(adminCtx, &pb.CreateAPIKeyRequest{Name: "feed", Scopes: []string{string(api.ScopeChannelsSubscribe)}, Account: account.Nick})
+		require.NoError(t, err)
+		keys, err := client.ListAPIKeys(adminCtx, &pb.ListAPIKeysRequest{Account: account.Nick})
+		require.NoError(t, err)
+		require.Len(t, keys.ApiKeys, 1)
+
+		keyCtx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "ApiKey "+created.Key))
+		stream, err := client.SubscribeChannel(keyCtx, &pb.SubscribeChannelRequest{Channels: []string{"bar"}, ReplayFrom: 50})
+		require.NoError(t, err)
+		replayed, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, api.NewMessageEvent, replayed.Event)
+	})
+
+	t.Run("API keys of other users can be managed by admins for service accounts only", func(t *testing.T) {
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", validToken))
+		_, err := client.CreateAPIKey(ctx, &pb.CreateAPIKeyRequest{Name: "feed", Scopes: []string{string(api.ScopeChannelsSubscribe)}, Account: test_utils.ValidServiceNick})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		_, err = client.CreateServiceAccount(ctx, &pb.CreateServiceAccountRequest{Nick: "GRPCForbiddenServiceAccount"})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+		adminToken := "Basic " + base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", test_utils.ValidAdminNick, test_utils.ValidUserPassword)))
+		adminCtx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", adminToken))
+		_, err = client.CreateAPIKey(adminCtx, &pb.CreateAPIKeyRequest{Name: "feed", Scopes: []string{string(api.ScopeHistoryRead)}, Account: test_utils.ValidUser2Nick})
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+		_, err = client.ListAPIKeys(adminCtx, &pb.ListAPIKeysRequest{Account: test_utils.ValidUser2Nick})
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+
+	t.Run("regular user can not subscribe to a channel", func(t *testing.T) {
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", validToken))
+		stream, err := client.SubscribeChannel(ctx, &pb.SubscribeChannelRequest{Channels: []string{"bar"}})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+
 	t.Run("returns error for missing `to` parameter", func(t *testing.T) {
 		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", validToken))
 		_, err := client.GetUserActivityReport(ctx, &pb.GetUserActivityReportRequest{Author: test_utils.ValidUserNick, From: "2018-01-01 00:00"})
 		require.ErrorContains(t, err, api.ErrToMissing.Error())
 	})
+
+	t.Run("chat stream is notified about nick change", func(t *testing.T) {
+		token := "Basic " + base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", test_utils.ValidUser2Nick, test_utils.ValidUserPassword)))
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", token))
+		stream, err := client.Chat(ctx)
+		require.NoError(t, err)
+		defer stream.CloseSend()
+		_, err = stream.Recv()
+		require.NoError(t, err)
+
+		res, err := client.ChangeNick(ctx, &pb.ChangeNickRequest{NewNick: "RenamedOverGRPC"})
+		require.NoError(t, err)
+		received, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, api.NickChangedEvent, received.Event)
+		assert.Equal(t, test_utils.ValidUser2Nick, received.GetNickChange().OldNick)
+		assert.Equal(t, "RenamedOverGRPC", received.GetNickChange().NewNick)
+
+		ctx = metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+res.Tokens.AccessToken))
+		_, err = client.ChangeNick(ctx, &pb.ChangeNickRequest{NewNick: test_utils.ValidUser2Nick})
+		require.NoError(t, err)
+	})
 }
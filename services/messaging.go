@@ -1,13 +1,16 @@
 package services
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -15,8 +18,9 @@ import (
 )
 
 var wsUpgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
+	ReadBufferSize:    1024,
+	WriteBufferSize:   1024,
+	EnableCompression: true,
 }
 
 type MessagingAPI struct {
@@ -24,17 +28,51 @@ type MessagingAPI struct {
 	TimeoutUnauthorized time.Duration
 	ConnectedUsers      api.SockchatUserManager
 	UserProfiles        api.SockchatProfileStore
+	Sessions            api.SockchatSessionStore
+	LoginGuard          api.SockchatLoginGuard
+	APIKeys             api.SockchatAPIKeyStore
+	TwoFactor           api.SockchatTwoFactorStore
+	Compression         CompressionOptions
+	sseSessions         map[string]*SockChatSSE
+	sseLock             sync.RWMutex
+	// bytes of messages sent over closed connections, before and after compression
+	bytesBefore atomic.Int64
+	bytesAfter  atomic.Int64
+}
+
+// CompressionOptions configure permessage-deflate for connections which negotiated it
+type CompressionOptions struct {
+	// Level is a flate compression level; zero means the library default
+	Level int
+	// Threshold is the minimum payload size (in bytes) of a compressed frame
+	Threshold int
+}
+
+// CompressionStats holds bytes of messages written to connections before and after compression
+type CompressionStats struct {
+	BytesBefore int64 `json:"bytes_before"`
+	BytesAfter  int64 `json:"bytes_after"`
+}
+
+// CompressionStats are published as metrics; they count connections once they are closed
+func (s *MessagingAPI) CompressionStats() CompressionStats {
+	return CompressionStats{BytesBefore: s.bytesBefore.Load(), BytesAfter: s.bytesAfter.Load()}
 }
 
 func (s *MessagingAPI) HandleRequests(router *http.ServeMux) {
 	router.Handle("/ws", http.HandlerFunc(s.ServeSession))
+
+	s.sseSessions = make(map[string]*SockChatSSE)
+	authorize := newAuthMiddleware(s.authService())
+	router.Handle("/sse", authorize(Permission{Scope: api.ScopeMessagesWrite}, s.ServeEventStream))
+	router.Handle("/sse/send", authorize(Permission{Scope: api.ScopeMessagesWrite}, s.receiveEventStreamAction))
 }
 
 func (s *MessagingAPI) ServeSession(w http.ResponseWriter, r *http.Request) {
-	conn := newSockChatWS(w, r)
+	conn := newSockChatWS(w, r, s.Compression)
 	defer s.shutConnection(conn)
+	ip := remoteIP(r.RemoteAddr)
 	conn.SetReadDeadline(time.Now().Add(s.TimeoutUnauthorized))
-	var nick string
 	for {
 		receivedMsg, err := conn.ReadSocketMsg()
 		if err != nil {
@@ -42,14 +80,15 @@ func (s *MessagingAPI) ServeSession(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if conn.authorized {
-			err = s.serveAuthorizedConnection(conn, nick, *receivedMsg)
+			conn.SetReadDeadline(time.Now().Add(s.TimeoutAuthorized))
+			err = s.serveAuthorizedConnection(conn, *receivedMsg)
 			if err != nil {
 				log.Printf("error serving authorized connection: %v", err)
 				break
 			}
 			continue
 		}
-		nick, err = s.authorizeConnection(*receivedMsg, conn)
+		_, err = s.authorizeConnection(*receivedMsg, conn, ip)
 		if err != nil {
 			conn.WriteSocketMsg(api.NewSocketError(err.Error()))
 		}
@@ -57,44 +96,77 @@ func (s *MessagingAPI) ServeSession(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *MessagingAPI) authorizeConnection(request api.SocketMessage, conn *SockChatWS) (string, error) {
+func (s *MessagingAPI) authorizeConnection(request api.SocketMessage, conn *SockChatWS, ip string) (string, error) {
 	if request.Action == api.LoginAction {
 		req, err := api.UnmarshalLoginRequest(request.Payload)
 		if err != nil {
 			return "", api.ErrInvalidRequest
 		}
-		u, err := s.loginUser(req)
+		u, err := s.loginUser(req, ip)
 		if err == nil {
 			conn.authorized = true
 			s.ConnectedUsers.AddConnection(conn, u.Nick)
 			conn.WriteSocketMsg(api.NewSocketMessage("logged_in:"+u.Nick, "{}"))
 			conn.SetReadDeadline(time.Now().Add(s.TimeoutAuthorized))
-			return req.Nick, nil
+			return u.Nick, nil
 		}
 		return "", err
 	}
 	return "", fmt.Errorf("you must log in first using " + api.LoginAction + " action")
 }
 
-func (s *MessagingAPI) loginUser(req *api.LoginRequest) (*api.PublicProfile, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), ResponseDeadline)
+func (s *MessagingAPI) loginUser(req *api.LoginRequest, ip string) (*api.PublicProfile, error) {
+	ctx, cancel := context.WithTimeout(withClientIP(context.Background(), ip), ResponseDeadline)
 	defer cancel()
-	if s.UserProfiles.IsAuthValid(ctx, req.Nick, req.Password) {
-		return &api.PublicProfile{Nick: req.Nick}, nil
+	principal, err := s.authenticateLogin(ctx, req)
+	if err == api.ErrUnauthorized {
+		return nil, fmt.Errorf("login rejected: invalid credentials")
+	}
+	if err == nil {
+		err = s.authService().Authorize(ctx, principal, Permission{Scope: api.ScopeMessagesWrite})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("login rejected: %v", err)
 	}
-	return nil, fmt.Errorf("login rejected: invalid credentials")
+	return &api.PublicProfile{Nick: principal.Nick}, nil
 }
 
-func (s *MessagingAPI) serveAuthorizedConnection(conn *SockChatWS, nick string, receivedMsg api.SocketMessage) error {
-	conn.SetReadDeadline(time.Now().Add(s.TimeoutAuthorized))
+func (s *MessagingAPI) authenticateLogin(ctx context.Context, req *api.LoginRequest) (*api.Principal, error) {
+	if req.APIKey != "" {
+		return s.authService().authenticateAPIKey(ctx, req.APIKey)
+	}
+	if req.Token != "" && s.Sessions != nil {
+		nick, err := s.Sessions.Verify(ctx, req.Token)
+		if err != nil {
+			return nil, err
+		}
+		return &api.Principal{Nick: nick}, nil
+	}
+	if err := s.authService().verifyCredentials(ctx, req.Nick, req.Password, req.OTP); err != nil {
+		return nil, err
+	}
+	return &api.Principal{Nick: req.Nick}, nil
+}
+
+func (s *MessagingAPI) authService() *SockchatAuthService {
+	return &SockchatAuthService{UserProfiles: s.UserProfiles, Sessions: s.Sessions, LoginGuard: s.LoginGuard, APIKeys: s.APIKeys, TwoFactor: s.TwoFactor}
+}
+
+func (s *MessagingAPI) serveAuthorizedConnection(conn api.SockchatWebsocketConnection, receivedMsg api.SocketMessage) error {
+	return serveConnectionRequest(s.ConnectedUsers, conn, receivedMsg)
+}
+
+// serveConnectionRequest passes request received from an authorized connection to the user's handler.
+// The handler is looked up by connection, as the user may change their nick while connected.
+func serveConnectionRequest(connectedUsers api.SockchatUserManager, conn api.SockchatWebsocketConnection, receivedMsg api.SocketMessage) error {
 	req, err := parseWebsocketMessage(receivedMsg)
 	if err != nil {
 		conn.WriteSocketMsg(api.NewSocketError(err.Error()))
 		return nil
 	}
-	handler, ok := s.ConnectedUsers.GetHandler(nick)
+	handler, ok := connectedUsers.GetConnectionHandler(conn)
 	if !ok {
-		return fmt.Errorf("handler not found for user `%s`", nick)
+		return fmt.Errorf("handler not found for the connection")
 	}
 	err = handler.MakeRequest(receivedMsg.Action, req)
 	if err != nil {
@@ -107,6 +179,9 @@ func (s *MessagingAPI) shutConnection(conn *SockChatWS) {
 	if conn.authorized {
 		s.ConnectedUsers.RemoveConnection(conn)
 	}
+	stats := conn.CompressionStats()
+	s.bytesBefore.Add(stats.BytesBefore)
+	s.bytesAfter.Add(stats.BytesAfter)
 	conn.Close()
 }
 
@@ -116,6 +191,12 @@ func parseWebsocketMessage(msg api.SocketMessage) (interface{}, error) {
 		return api.UnmarshalChannelRequest(msg.Payload)
 	case api.SendMessageAction:
 		return api.UnmarshalMessageRequest(msg.Payload)
+	case api.BlockAction, api.UnblockAction:
+		return api.UnmarshalBlockRequest(msg.Payload)
+	case api.GetPreferencesAction:
+		return &api.EmptyMessage{}, nil
+	case api.UpdatePreferencesAction:
+		return api.UnmarshalPreferences(msg.Payload)
 	default:
 		return nil, fmt.Errorf(api.ErrInvalidRequest.Error())
 	}
@@ -123,19 +204,35 @@ func parseWebsocketMessage(msg api.SocketMessage) (interface{}, error) {
 
 type SockChatWS struct {
 	*websocket.Conn
-	writeLock  sync.Mutex
-	readLock   sync.Mutex
-	authorized bool
+	writeLock   sync.Mutex
+	readLock    sync.Mutex
+	authorized  bool
+	compression CompressionOptions
+	netConn     *countingConn
+	bytesBefore atomic.Int64
+	bytesAfter  atomic.Int64
 }
 
-func newSockChatWS(w http.ResponseWriter, r *http.Request) *SockChatWS {
-	conn, err := wsUpgrader.Upgrade(w, r, nil)
+func newSockChatWS(w http.ResponseWriter, r *http.Request, compression CompressionOptions) *SockChatWS {
+	hijacker := &countingHijacker{ResponseWriter: w}
+	conn, err := wsUpgrader.Upgrade(hijacker, r, nil)
 
 	if err != nil {
 		log.Printf("problem upgrading connection to WebSockets %v\n", err)
+		return &SockChatWS{Conn: conn}
+	}
+	if compression.Level != 0 {
+		if err := conn.SetCompressionLevel(compression.Level); err != nil {
+			log.Printf("warning: invalid compression level %d: %v", compression.Level, err)
+		}
 	}
 
-	return &SockChatWS{Conn: conn}
+	return &SockChatWS{Conn: conn, compression: compression, netConn: hijacker.conn}
+}
+
+// CompressionStats returns the number of bytes of messages written so far, before and after compression
+func (w *SockChatWS) CompressionStats() CompressionStats {
+	return CompressionStats{BytesBefore: w.bytesBefore.Load(), BytesAfter: w.bytesAfter.Load()}
 }
 
 func (w *SockChatWS) ReadMsg() ([]byte, error) {
@@ -162,10 +259,55 @@ func (w *SockChatWS) ReadSocketMsg() (*api.SocketMessage, error) {
 }
 
 func (w *SockChatWS) WriteSocketMsg(m api.SocketMessage) {
+	msgBytes, err := json.Marshal(m)
+	if err != nil {
+		log.Printf("Error marshaling message %s with payload %s: %v", m.Action, string(m.Payload), err)
+		return
+	}
 	w.writeLock.Lock()
 	defer w.writeLock.Unlock()
-	err := w.WriteJSON(m)
+	w.EnableWriteCompression(len(msgBytes) >= w.compression.Threshold)
+	var written int64
+	if w.netConn != nil {
+		written = w.netConn.written.Load()
+	}
+	err = w.WriteMessage(websocket.TextMessage, msgBytes)
 	if err != nil {
 		log.Printf("Error writing message %s with payload %s to websocket: %v", m.Action, string(m.Payload), err)
+		return
+	}
+	if w.netConn != nil {
+		w.bytesBefore.Add(int64(len(msgBytes)))
+		w.bytesAfter.Add(w.netConn.written.Load() - written)
 	}
 }
+
+// countingHijacker wraps the hijacked connection so that bytes written to the wire can be counted
+type countingHijacker struct {
+	http.ResponseWriter
+	conn *countingConn
+}
+
+func (h *countingHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := h.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response does not implement http.Hijacker")
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	h.conn = &countingConn{Conn: conn}
+	return h.conn, brw, nil
+}
+
+type countingConn struct {
+	net.Conn
+	written atomic.Int64
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written.Add(int64(n))
+	return n, err
+}
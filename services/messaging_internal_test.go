@@ -0,0 +1,142 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kacperf531/sockchat"
+	"github.com/kacperf531/sockchat/api"
+	"github.com/kacperf531/sockchat/test_utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingConn keeps the raw bytes read by the client, so that frame headers can be inspected
+type recordingConn struct {
+	net.Conn
+	lock sync.Mutex
+	read bytes.Buffer
+}
+
+func (c *recordingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.lock.Lock()
+	c.read.Write(b[:n])
+	c.lock.Unlock()
+	return n, err
+}
+
+// takeFrame returns the bytes read since the previous call
+func (c *recordingConn) takeFrame() []byte {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	frame := append([]byte(nil), c.read.Bytes()...)
+	c.read.Reset()
+	return frame
+}
+
+// frameCompressed reports whether the RSV1 bit, which marks a permessage-deflate frame, is set
+func frameCompressed(frame []byte) bool {
+	return len(frame) > 0 && frame[0]&0x40 != 0
+}
+
+func TestSockChatWSCompression(t *testing.T) {
+	cache := test_utils.NewTestingRedisClient(t)
+	const threshold = 256
+	userProfiles := &sockchat.ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: cache}
+	messagingAPI := &MessagingAPI{
+		TimeoutAuthorized:   time.Second,
+		TimeoutUnauthorized: time.Second,
+		ConnectedUsers:      sockchat.NewConnectedUsersPool(&test_utils.StubChannelStore{}),
+		UserProfiles:        userProfiles,
+		Compression:         CompressionOptions{Level: 9, Threshold: threshold}}
+
+	router := http.NewServeMux()
+	messagingAPI.HandleRequests(router)
+	serverConns := make(chan *SockChatWS, 1)
+	router.HandleFunc("/raw", func(w http.ResponseWriter, r *http.Request) {
+		serverConns <- newSockChatWS(w, r, messagingAPI.Compression)
+	})
+	testServer := httptest.NewServer(router)
+	defer testServer.Close()
+
+	var clientConn *recordingConn
+	dialer := websocket.Dialer{EnableCompression: true, NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		clientConn = &recordingConn{Conn: conn}
+		return clientConn, nil
+	}}
+
+	t.Run("negotiates permessage-deflate", func(t *testing.T) {
+		conn, res, err := dialer.Dial(test_utils.GetWsURL(testServer.URL), nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		assert.Contains(t, res.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")
+
+		t.Run("messages are readable by the client", func(t *testing.T) {
+			require.NoError(t, conn.WriteJSON(api.NewSocketMessage(api.LoginAction, api.LoginRequest{Nick: test_utils.ValidUserNick, Password: test_utils.ValidUserPassword})))
+			received := api.SocketMessage{}
+			require.NoError(t, conn.ReadJSON(&received))
+			assert.Equal(t, "logged_in:"+test_utils.ValidUserNick, received.Action)
+		})
+	})
+
+	conn, _, err := dialer.Dial(strings.Replace(test_utils.GetWsURL(testServer.URL), "/ws", "/raw", 1), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	serverConn := <-serverConns
+	defer serverConn.Close()
+	clientConn.takeFrame()
+
+	t.Run("messages under the threshold are sent uncompressed and counted", func(t *testing.T) {
+		msg := api.NewSocketMessage(api.NewMessageEvent, api.MessageEvent{Text: "short"})
+		serverConn.WriteSocketMsg(msg)
+		received := api.SocketMessage{}
+		require.NoError(t, conn.ReadJSON(&received))
+		assert.Equal(t, msg.Payload, received.Payload)
+		frame := clientConn.takeFrame()
+		assert.False(t, frameCompressed(frame))
+
+		stats := serverConn.CompressionStats()
+		require.Less(t, stats.BytesBefore, int64(threshold))
+		assert.Equal(t, int64(len(frame)), stats.BytesAfter)
+		// an unmasked server frame of this size has a two byte header
+		assert.Equal(t, stats.BytesBefore+2, stats.BytesAfter)
+	})
+
+	t.Run("messages over the threshold are compressed and counted", func(t *testing.T) {
+		before := serverConn.CompressionStats()
+		msg := api.NewSocketMessage(api.NewMessageEvent, api.MessageEvent{Text: strings.Repeat("lorem ipsum ", 100)})
+		serverConn.WriteSocketMsg(msg)
+		received := api.SocketMessage{}
+		require.NoError(t, conn.ReadJSON(&received))
+		assert.Equal(t, msg.Payload, received.Payload)
+		frame := clientConn.takeFrame()
+		assert.True(t, frameCompressed(frame))
+
+		after := serverConn.CompressionStats()
+		sentBefore, sentAfter := after.BytesBefore-before.BytesBefore, after.BytesAfter-before.BytesAfter
+		assert.GreaterOrEqual(t, sentBefore, int64(threshold))
+		assert.Equal(t, int64(len(frame)), sentAfter)
+		assert.Less(t, sentAfter, sentBefore)
+	})
+
+	t.Run("bytes of closed connections are added to the published stats", func(t *testing.T) {
+		sent, before := serverConn.CompressionStats(), messagingAPI.CompressionStats()
+		messagingAPI.shutConnection(serverConn)
+		after := messagingAPI.CompressionStats()
+		assert.Equal(t, sent.BytesBefore, after.BytesBefore-before.BytesBefore)
+		assert.Equal(t, sent.BytesAfter, after.BytesAfter-before.BytesAfter)
+	})
+}
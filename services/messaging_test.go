@@ -1,6 +1,7 @@
 package services_test
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -16,13 +17,19 @@ import (
 )
 
 func TestSockChatWS(t *testing.T) {
+	cache := test_utils.NewTestingRedisClient(t)
 	testTimeoutUnauthorized := 200 * time.Millisecond
 	testTimeoutAuthorized := 20 * testTimeoutUnauthorized
-	userProfiles := &sockchat.ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: test_utils.TestingRedisClient}
+	userProfiles := &sockchat.ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: cache}
 
 	router := http.NewServeMux()
 	channelStore := &test_utils.StubChannelStore{}
-	messagingAPI := &services.MessagingAPI{TimeoutAuthorized: testTimeoutAuthorized, TimeoutUnauthorized: testTimeoutUnauthorized, ConnectedUsers: sockchat.NewConnectedUsersPool(channelStore), UserProfiles: userProfiles}
+	sessions := &sockchat.SessionService{Secret: []byte("secret"), Cache: cache}
+	twoFactor := &sockchat.TwoFactorService{Profiles: userProfiles, Cache: cache, EncryptionKey: []byte("0123456789abcdef")}
+	preferences := &sockchat.PreferencesService{Store: &test_utils.PreferencesStoreDouble{}, Cache: cache}
+	connectedUsers := sockchat.NewConnectedUsersPool(channelStore)
+	connectedUsers.Preferences = preferences
+	messagingAPI := &services.MessagingAPI{TimeoutAuthorized: testTimeoutAuthorized, TimeoutUnauthorized: testTimeoutUnauthorized, ConnectedUsers: connectedUsers, UserProfiles: userProfiles, Sessions: sessions, TwoFactor: twoFactor}
 
 	messagingAPI.HandleRequests(router)
 	testServer := httptest.NewServer(router)
@@ -108,6 +115,49 @@ func TestSockChatWS(t *testing.T) {
 
 	})
 
+	t.Run("can log in with a session token", func(t *testing.T) {
+		tokens, err := sessions.Issue(context.Background(), test_utils.ValidUser2Nick)
+		require.NoError(t, err)
+		new_ws := test_utils.NewTestWS(t, wsURL)
+		new_ws.Write(t, api.NewSocketMessage(api.LoginAction, api.LoginRequest{Token: tokens.AccessToken}))
+		new_ws.AssertEventReceivedWithin(t, "logged_in:"+test_utils.ValidUser2Nick, time.Second)
+	})
+
+	t.Run("preferences updated over other APIs are synced and can be read", func(t *testing.T) {
+		core := &services.SockchatCoreService{ConnectedUsers: connectedUsers, Preferences: preferences}
+		_, err := core.UpdatePreferences(&services.PreferencesWrapper{Nick: test_utils.ValidUserNick, Request: &api.Preferences{Language: "en"}}, context.Background())
+		require.NoError(t, err)
+		received := <-ws.MessageStash
+		require.Equal(t, api.PreferencesUpdatedEvent, received.Action)
+
+		ws.Write(t, api.NewSocketMessage(api.GetPreferencesAction, api.EmptyMessage{}))
+		received = <-ws.MessageStash
+		require.Equal(t, api.PreferencesEvent, received.Action)
+		prefs, err := api.UnmarshalPreferences(received.Payload)
+		require.NoError(t, err)
+		assert.Equal(t, "en", prefs.Language)
+		assert.Equal(t, api.NotifyAll, prefs.NotificationLevel)
+	})
+
+	t.Run("login of user with TOTP enabled requires the code", func(t *testing.T) {
+		ctx := context.Background()
+		enrollment, err := twoFactor.Enroll(ctx, test_utils.ValidAdminNick)
+		require.NoError(t, err)
+		code, err := sockchat.GenerateTOTPCode(enrollment.Secret, time.Now())
+		require.NoError(t, err)
+		_, err = twoFactor.Confirm(ctx, test_utils.ValidAdminNick, code)
+		require.NoError(t, err)
+
+		new_ws := test_utils.NewTestWS(t, wsURL)
+		new_ws.Write(t, api.NewSocketMessage(api.LoginAction, api.LoginRequest{Nick: test_utils.ValidAdminNick, Password: test_utils.ValidUserPassword}))
+		new_ws.AssertEventReceivedWithin(t, api.ErrInvalidRequest.Error(), time.Second)
+
+		nextCode, err := sockchat.GenerateTOTPCode(enrollment.Secret, time.Now().Add(sockchat.TOTPPeriod))
+		require.NoError(t, err)
+		new_ws.Write(t, api.NewSocketMessage(api.LoginAction, api.LoginRequest{Nick: test_utils.ValidAdminNick, Password: test_utils.ValidUserPassword, OTP: nextCode}))
+		new_ws.AssertEventReceivedWithin(t, "logged_in:"+test_utils.ValidAdminNick, time.Second)
+	})
+
 	t.Run("unauthorized connection times out", func(t *testing.T) {
 		new_ws := test_utils.NewTestWS(t, wsURL)
 		new_ws.AssertEventReceivedWithin(t, "connection_timed_out", testTimeoutUnauthorized+20*time.Millisecond)
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+
+	"github.com/kacperf531/sockchat/api"
+)
+
+// Permission describes who is allowed to call an endpoint
+type Permission struct {
+	// Public endpoints do not require authentication
+	Public bool
+	// Scope is required from API keys; API keys are not accepted if it is empty
+	Scope api.Scope
+	// Roles limit access to users with one of them; any role is accepted if empty
+	Roles []api.Role
+}
+
+var (
+	public        = Permission{Public: true}
+	authenticated = Permission{}
+	adminOnly     = Permission{Roles: []api.Role{api.RoleAdmin}}
+)
+
+// Authorize checks whether the authenticated caller is granted the permission
+func (s *SockchatAuthService) Authorize(ctx context.Context, principal *api.Principal, permission Permission) error {
+	if permission.Public {
+		return nil
+	}
+	if !principal.Allows(permission.Scope) {
+		return api.ErrInsufficientScope
+	}
+	account, err := s.UserProfiles.GetAccount(ctx, principal.Nick)
+	if err == api.ErrUserNotFound {
+		return api.ErrUnauthorized
+	}
+	if err != nil {
+		return err
+	}
+	if account.Disabled {
+		return api.ErrAccountDisabled
+	}
+	if len(permission.Roles) == 0 || hasRole(account, permission.Roles...) {
+		return nil
+	}
+	return api.ErrForbidden
+}
+
+// HasRole reports whether the user has one of the roles
+func (s *SockchatAuthService) HasRole(ctx context.Context, nick string, roles ...api.Role) (bool, error) {
+	account, err := s.UserProfiles.GetAccount(ctx, nick)
+	if err != nil {
+		return false, err
+	}
+	return hasRole(account, roles...), nil
+}
+
+func hasRole(account *api.Account, roles ...api.Role) bool {
+	for _, role := range roles {
+		if account.Role == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kacperf531/sockchat/api"
+)
+
+const (
+	sseOutboxSize        = 64
+	sseKeepAliveInterval = 15 * time.Second
+)
+
+// ServeEventStream streams events of an authenticated user as Server-Sent Events.
+// Actions for the session are sent as POST requests to /sse/send?session=<session_id>
+func (s *MessagingAPI) ServeEventStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJsonHttpResponse(w, http.StatusInternalServerError, &api.ErrorResponse{ErrorDescription: api.ErrStreamingUnsupported.Error()})
+		return
+	}
+	nick, _ := authenticatedNick(r.Context())
+	conn, err := newSockChatSSE(nick, s.TimeoutAuthorized)
+	if err != nil {
+		log.Printf("could not open event stream session: %v", err)
+		writeJsonHttpResponse(w, http.StatusInternalServerError, &api.ErrorResponse{ErrorDescription: api.ErrInternal.Error()})
+		return
+	}
+	s.addEventStreamSession(conn)
+	s.ConnectedUsers.AddConnection(conn, nick)
+	defer s.shutEventStream(conn)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	go s.serveEventStreamActions(conn)
+	conn.WriteSocketMsg(api.NewSocketMessage("logged_in:"+nick, api.EventStreamSession{SessionID: conn.id}))
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-conn.done:
+			flushPendingEvents(w, conn)
+			flusher.Flush()
+			return
+		case msg := <-conn.outbox:
+			if err := writeServerSentEvent(w, msg); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func (s *MessagingAPI) serveEventStreamActions(conn *SockChatSSE) {
+	defer conn.Close()
+	for {
+		receivedMsg, err := conn.ReadSocketMsg()
+		if err != nil {
+			return
+		}
+		err = s.serveAuthorizedConnection(conn, *receivedMsg)
+		if err != nil {
+			log.Printf("error serving event stream session: %v", err)
+			return
+		}
+	}
+}
+
+func (s *MessagingAPI) receiveEventStreamAction(w http.ResponseWriter, r *http.Request) {
+	// actions have side effects, so they must not be triggered by e.g. following a link
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJsonHttpResponse(w, HTTPStatuses[api.ErrMethodNotAllowed], &api.ErrorResponse{ErrorDescription: api.ErrMethodNotAllowed.Error()})
+		return
+	}
+	nick, _ := authenticatedNick(r.Context())
+	conn, ok := s.getEventStreamSession(r.URL.Query().Get("session"))
+	if !ok {
+		writeJsonHttpResponse(w, HTTPStatuses[api.ErrSessionNotFound], &api.ErrorResponse{ErrorDescription: api.ErrSessionNotFound.Error()})
+		return
+	}
+	if handler, ok := s.ConnectedUsers.GetConnectionHandler(conn); !ok || handler.GetNick() != nick {
+		writeJsonHttpResponse(w, HTTPStatuses[api.ErrUnauthorized], &api.ErrorResponse{ErrorDescription: api.ErrUnauthorized.Error()})
+		return
+	}
+	msgBytes, err := io.ReadAll(r.Body)
+	if err != nil || !json.Valid(msgBytes) {
+		writeJsonHttpResponse(w, HTTPStatuses[api.ErrInvalidRequest], &api.ErrorResponse{ErrorDescription: api.ErrInvalidRequest.Error()})
+		return
+	}
+	if err := conn.push(msgBytes); err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[api.ErrSessionNotFound], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusAccepted, &api.EmptyMessage{})
+}
+
+func (s *MessagingAPI) addEventStreamSession(conn *SockChatSSE) {
+	s.sseLock.Lock()
+	defer s.sseLock.Unlock()
+	s.sseSessions[conn.id] = conn
+}
+
+func (s *MessagingAPI) getEventStreamSession(id string) (*SockChatSSE, bool) {
+	s.sseLock.RLock()
+	defer s.sseLock.RUnlock()
+	conn, ok := s.sseSessions[id]
+	return conn, ok
+}
+
+func (s *MessagingAPI) shutEventStream(conn *SockChatSSE) {
+	s.sseLock.Lock()
+	delete(s.sseSessions, conn.id)
+	s.sseLock.Unlock()
+	s.ConnectedUsers.RemoveConnection(conn)
+	conn.Close()
+}
+
+func flushPendingEvents(w io.Writer, conn *SockChatSSE) {
+	for {
+		select {
+		case msg := <-conn.outbox:
+			if err := writeServerSentEvent(w, msg); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func writeServerSentEvent(w io.Writer, msg api.SocketMessage) error {
+	msgBytes, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error marshaling message %s with payload %s: %v", msg.Action, string(msg.Payload), err)
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "data: %s\n\n", msgBytes)
+	return err
+}
+
+// SockChatSSE is a connection made of a Server-Sent Events stream (server to client)
+// and POST requests (client to server)
+type SockChatSSE struct {
+	id          string
+	nick        string
+	readTimeout time.Duration
+	outbox      chan api.SocketMessage
+	inbox       chan []byte
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+func newSockChatSSE(nick string, readTimeout time.Duration) (*SockChatSSE, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, err
+	}
+	return &SockChatSSE{
+		id:          hex.EncodeToString(idBytes),
+		nick:        nick,
+		readTimeout: readTimeout,
+		outbox:      make(chan api.SocketMessage, sseOutboxSize),
+		inbox:       make(chan []byte),
+		done:        make(chan struct{}),
+	}, nil
+}
+
+func (c *SockChatSSE) push(msgBytes []byte) error {
+	select {
+	case c.inbox <- msgBytes:
+		return nil
+	case <-c.done:
+		return api.ErrSessionNotFound
+	}
+}
+
+func (c *SockChatSSE) ReadMsg() ([]byte, error) {
+	var timeout <-chan time.Time
+	if c.readTimeout > 0 {
+		timer := time.NewTimer(c.readTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case msgBytes := <-c.inbox:
+		return msgBytes, nil
+	case <-timeout:
+		c.WriteSocketMsg(api.NewSocketMessage("connection_timed_out", "{}"))
+		return nil, fmt.Errorf("event stream session %s timed out", c.id)
+	case <-c.done:
+		return nil, io.EOF
+	}
+}
+
+func (c *SockChatSSE) ReadSocketMsg() (*api.SocketMessage, error) {
+	msgBytes, err := c.ReadMsg()
+	if err != nil {
+		return nil, err
+	}
+	msg := &api.SocketMessage{}
+	json.Unmarshal(msgBytes, &msg)
+	return msg, nil
+}
+
+func (c *SockChatSSE) WriteSocketMsg(m api.SocketMessage) {
+	select {
+	case c.outbox <- m:
+	case <-c.done:
+	}
+}
+
+// Close ends the session; the event stream is closed once pending events are flushed
+func (c *SockChatSSE) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return nil
+}
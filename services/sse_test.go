@@ -0,0 +1,129 @@
+package services_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kacperf531/sockchat"
+	"github.com/kacperf531/sockchat/api"
+	"github.com/kacperf531/sockchat/services"
+	"github.com/kacperf531/sockchat/test_utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSockChatSSE(t *testing.T) {
+	cache := test_utils.NewTestingRedisClient(t)
+	userProfiles := &sockchat.ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: cache}
+	messagingAPI := &services.MessagingAPI{TimeoutAuthorized: 10 * time.Second, TimeoutUnauthorized: time.Second, ConnectedUsers: sockchat.NewConnectedUsersPool(&test_utils.StubChannelStore{}), UserProfiles: userProfiles}
+
+	router := http.NewServeMux()
+	messagingAPI.HandleRequests(router)
+	testServer := httptest.NewServer(router)
+	defer testServer.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, testServer.URL+"/sse", nil)
+	req.SetBasicAuth(test_utils.ValidUserNick, test_utils.ValidUserPassword)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	events := newEventStreamReader(res)
+
+	var sessionID string
+	t.Run("first event carries the session id", func(t *testing.T) {
+		received := events.next(t)
+		require.Equal(t, "logged_in:"+test_utils.ValidUserNick, received.Action)
+		var session api.EventStreamSession
+		require.NoError(t, json.Unmarshal(received.Payload, &session))
+		require.NotEmpty(t, session.SessionID)
+		sessionID = session.SessionID
+	})
+
+	t.Run("actions sent over POST are answered on the stream", func(t *testing.T) {
+		res := postEventStreamAction(t, testServer.URL, sessionID, test_utils.ValidUserNick, api.NewSocketMessage(api.JoinAction, api.ChannelRequest{Name: test_utils.ChannelWithoutUser}))
+		require.Equal(t, http.StatusAccepted, res.StatusCode)
+		assert.Equal(t, api.UserJoinedChannelEvent, events.next(t).Action)
+	})
+
+	t.Run("errors are delivered on the stream", func(t *testing.T) {
+		res := postEventStreamAction(t, testServer.URL, sessionID, test_utils.ValidUserNick, api.NewSocketMessage(api.JoinAction, api.ChannelRequest{Name: test_utils.ChannelWithUser}))
+		require.Equal(t, http.StatusAccepted, res.StatusCode)
+		assert.Equal(t, api.ErrInvalidRequest.Error(), events.next(t).Action)
+	})
+
+	t.Run("can not send actions to a session of another user", func(t *testing.T) {
+		res := postEventStreamAction(t, testServer.URL, sessionID, test_utils.ValidUser2Nick, api.NewSocketMessage(api.JoinAction, api.ChannelRequest{Name: "foo"}))
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	})
+
+	t.Run("returns error for unknown session", func(t *testing.T) {
+		res := postEventStreamAction(t, testServer.URL, "not_exists", test_utils.ValidUserNick, api.NewSocketMessage(api.JoinAction, api.ChannelRequest{Name: "foo"}))
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	})
+
+	t.Run("actions can not be sent with a GET request", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, testServer.URL+"/sse/send?session="+sessionID, nil)
+		req.SetBasicAuth(test_utils.ValidUserNick, test_utils.ValidUserPassword)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+		assert.Equal(t, http.MethodPost, res.Header.Get("Allow"))
+	})
+
+	t.Run("returns error for unauthorized request to event stream", func(t *testing.T) {
+		res, err := http.Get(testServer.URL + "/sse")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	})
+}
+
+type eventStreamReader struct {
+	events chan api.SocketMessage
+}
+
+func newEventStreamReader(res *http.Response) *eventStreamReader {
+	r := &eventStreamReader{events: make(chan api.SocketMessage)}
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			data, found := strings.CutPrefix(scanner.Text(), "data: ")
+			if !found {
+				continue
+			}
+			msg := api.SocketMessage{}
+			json.Unmarshal([]byte(data), &msg)
+			r.events <- msg
+		}
+	}()
+	return r
+}
+
+func (r *eventStreamReader) next(t *testing.T) api.SocketMessage {
+	t.Helper()
+	select {
+	case msg := <-r.events:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for server-sent event")
+	}
+	return api.SocketMessage{}
+}
+
+func postEventStreamAction(t *testing.T, serverURL, sessionID, nick string, msg api.SocketMessage) *http.Response {
+	t.Helper()
+	msgBytes, _ := json.Marshal(msg)
+	req, _ := http.NewRequest(http.MethodPost, serverURL+"/sse/send?session="+sessionID, bytes.NewReader(msgBytes))
+	req.SetBasicAuth(nick, test_utils.ValidUserPassword)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	return res
+}
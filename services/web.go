@@ -3,9 +3,15 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/kacperf531/sockchat/api"
@@ -14,12 +20,60 @@ import (
 const ResponseDeadline = 5 * time.Second
 
 var HTTPStatuses = map[error]int{
-	api.ErrNickAlreadyUsed:  http.StatusConflict,
-	api.ErrNickRequired:     http.StatusUnprocessableEntity,
-	api.ErrPasswordRequired: http.StatusUnprocessableEntity,
-	api.ErrInvalidRequest:   http.StatusBadRequest,
-	api.ErrChannelNotFound:  http.StatusNotFound,
-	api.ErrInternal:         http.StatusInternalServerError,
+	api.ErrNickAlreadyUsed:       http.StatusConflict,
+	api.ErrNickRequired:          http.StatusUnprocessableEntity,
+	api.ErrInvalidNick:           http.StatusUnprocessableEntity,
+	api.ErrPasswordRequired:      http.StatusUnprocessableEntity,
+	api.ErrInvalidPassword:       http.StatusForbidden,
+	api.ErrInvalidResetToken:     http.StatusBadRequest,
+	api.ErrInvalidRequest:        http.StatusBadRequest,
+	api.ErrChannelNotFound:       http.StatusNotFound,
+	api.ErrSessionNotFound:       http.StatusNotFound,
+	api.ErrUnauthorized:          http.StatusUnauthorized,
+	api.ErrInvalidToken:          http.StatusUnauthorized,
+	api.ErrRefreshTokenRequired:  http.StatusUnprocessableEntity,
+	api.ErrAccountLocked:         http.StatusTooManyRequests,
+	api.ErrInvalidAPIKey:         http.StatusUnauthorized,
+	api.ErrInsufficientScope:     http.StatusForbidden,
+	api.ErrAccountDisabled:       http.StatusForbidden,
+	api.ErrUserNotFound:          http.StatusNotFound,
+	api.ErrAPIKeyNameRequired:    http.StatusUnprocessableEntity,
+	api.ErrScopesRequired:        http.StatusUnprocessableEntity,
+	api.ErrInvalidScope:          http.StatusUnprocessableEntity,
+	api.ErrAPIKeyNotFound:        http.StatusNotFound,
+	api.ErrNotServiceAccount:     http.StatusUnprocessableEntity,
+	api.ErrInvalidRole:           http.StatusUnprocessableEntity,
+	api.ErrCannotChangeOwnRole:   http.StatusUnprocessableEntity,
+	api.ErrOTPRequired:           http.StatusUnauthorized,
+	api.ErrInvalidOTP:            http.StatusUnauthorized,
+	api.ErrTOTPAlreadyEnabled:    http.StatusConflict,
+	api.ErrTOTPNotEnrolled:       http.StatusConflict,
+	api.ErrTOTPNotEnabled:        http.StatusConflict,
+	api.ErrOIDCNotConfigured:     http.StatusNotFound,
+	api.ErrInvalidOIDCState:      http.StatusBadRequest,
+	api.ErrOIDCLoginFailed:       http.StatusUnauthorized,
+	api.ErrIdentityNotFound:      http.StatusNotFound,
+	api.ErrIdentityAlreadyLinked: http.StatusConflict,
+	api.ErrProfileFieldTooLong:   http.StatusUnprocessableEntity,
+	api.ErrInvalidTimezone:       http.StatusUnprocessableEntity,
+	api.ErrTooManyCustomFields:   http.StatusUnprocessableEntity,
+	api.ErrCustomFieldNameEmpty:  http.StatusUnprocessableEntity,
+	api.ErrAvatarTooLarge:        http.StatusRequestEntityTooLarge,
+	api.ErrUnsupportedAvatarType: http.StatusUnsupportedMediaType,
+	api.ErrBlobNotFound:          http.StatusNotFound,
+	api.ErrCannotBlockSelf:       http.StatusUnprocessableEntity,
+	api.ErrPreferenceTooLong:     http.StatusUnprocessableEntity,
+	api.ErrInvalidNotification:   http.StatusUnprocessableEntity,
+	api.ErrTooManyChannelPrefs:   http.StatusUnprocessableEntity,
+	api.ErrInvalidCursor:         http.StatusBadRequest,
+	api.ErrConflictingCursors:    http.StatusBadRequest,
+	api.ErrInvalidSort:           http.StatusBadRequest,
+	api.ErrInvalidRange:          http.StatusBadRequest,
+	api.ErrUserNotInChannel:      http.StatusForbidden,
+	api.ErrEmptyChannelName:      http.StatusUnprocessableEntity,
+	api.ErrForbidden:             http.StatusForbidden,
+	api.ErrMethodNotAllowed:      http.StatusMethodNotAllowed,
+	api.ErrInternal:              http.StatusInternalServerError,
 }
 
 type WebAPI struct {
@@ -33,11 +87,48 @@ func NewWebAPI(core *SockchatCoreService, authService *SockchatAuthService) *Web
 
 func (s *WebAPI) HandleRequests(router *http.ServeMux) {
 	router.Handle("/register", http.HandlerFunc(s.registerProfile))
+	router.Handle("/login", http.HandlerFunc(s.login))
+	router.Handle("/refresh_session", http.HandlerFunc(s.refreshSession))
+	router.Handle("/request_password_reset", http.HandlerFunc(s.requestPasswordReset))
+	router.Handle("/reset_password", http.HandlerFunc(s.resetPassword))
+	router.Handle("/oidc/login", http.HandlerFunc(s.oidcLogin))
+	router.Handle("/oidc/callback", http.HandlerFunc(s.oidcCallback))
+	// avatars are public, so that they can be embedded in pages
+	router.Handle(api.AvatarsPath, http.HandlerFunc(s.getAvatar))
 
-	authenticate := newAuthMiddleware(s.AuthService)
-	router.Handle("/edit_profile", authenticate(s.editProfile))
-	router.Handle("/history", authenticate(s.getChannelHistory))
-	router.Handle("/profile", authenticate(s.getProfile))
+	authorize := newAuthMiddleware(s.AuthService)
+	router.Handle("/logout", authorize(authenticated, s.logout))
+	router.Handle("/edit_profile", authorize(authenticated, s.editProfile))
+	router.Handle("/change_password", authorize(authenticated, s.changePassword))
+	router.Handle("/change_nick", authorize(authenticated, s.changeNick))
+	router.Handle("/avatar", authorize(authenticated, s.uploadAvatar))
+	router.Handle("/directory_visibility", authorize(authenticated, s.setDirectoryVisibility))
+	router.Handle("/api_keys", authorize(authenticated, s.listAPIKeys))
+	router.Handle("/create_api_key", authorize(authenticated, s.createAPIKey))
+	router.Handle("/revoke_api_key", authorize(authenticated, s.revokeAPIKey))
+	router.Handle("/totp/enroll", authorize(authenticated, s.enrollTOTP))
+	router.Handle("/totp/confirm", authorize(authenticated, s.confirmTOTP))
+	router.Handle("/totp/disable", authorize(authenticated, s.disableTOTP))
+	router.Handle("/block", authorize(authenticated, s.block))
+	router.Handle("/unblock", authorize(authenticated, s.unblock))
+	router.Handle("/blocks", authorize(authenticated, s.listBlocks))
+	router.Handle("/preferences", authorize(authenticated, s.getPreferences))
+	router.Handle("/update_preferences", authorize(authenticated, s.updatePreferences))
+	router.Handle("/delete_account", authorize(authenticated, s.deleteAccount))
+	router.Handle("/export_my_data", authorize(authenticated, s.exportMyData))
+	router.Handle("/history", authorize(Permission{Scope: api.ScopeHistoryRead}, s.getChannelHistory))
+	router.Handle("/search", authorize(Permission{Scope: api.ScopeHistoryRead}, s.searchMessages))
+	router.Handle("/profile", authorize(Permission{Scope: api.ScopeProfileRead}, s.getProfile))
+	router.Handle("/users", authorize(Permission{Scope: api.ScopeProfileRead}, s.searchUsers))
+
+	router.Handle("/admin/users", authorize(adminOnly, s.listUsers))
+	router.Handle("/admin/create_service_account", authorize(adminOnly, s.createServiceAccount))
+	router.Handle("/admin/set_role", authorize(adminOnly, s.setRole))
+	router.Handle("/admin/disable_account", authorize(adminOnly, s.disableAccount))
+	router.Handle("/admin/enable_account", authorize(adminOnly, s.enableAccount))
+	router.Handle("/admin/disconnect_user", authorize(adminOnly, s.disconnectUser))
+	// published metrics reveal server internals, so they are not public
+	router.Handle("/debug/vars", authorize(adminOnly, expvar.Handler().ServeHTTP))
 }
 
 func (s *WebAPI) registerProfile(w http.ResponseWriter, r *http.Request) {
@@ -52,6 +143,265 @@ func (s *WebAPI) registerProfile(w http.ResponseWriter, r *http.Request) {
 	writeJsonHttpResponse(w, http.StatusCreated, res)
 }
 
+func (s *WebAPI) login(w http.ResponseWriter, r *http.Request) {
+	req := readLoginRequest(w, r)
+	if req == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(withClientIP(r.Context(), remoteIP(r.RemoteAddr)), ResponseDeadline)
+	defer cancel()
+	res, err := s.AuthService.Login(ctx, req)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, res)
+}
+
+// oidcLogin redirects the user to the identity provider, which redirects back to oidcCallback
+func (s *WebAPI) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	authURL, err := s.AuthService.OIDCAuthCodeURL(ctx)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (s *WebAPI) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		log.Printf("identity provider returned error: %s %s", providerErr, query.Get("error_description"))
+		writeJsonHttpResponse(w, HTTPStatuses[api.ErrOIDCLoginFailed], &api.ErrorResponse{ErrorDescription: api.ErrOIDCLoginFailed.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	res, err := s.AuthService.LoginWithOIDC(ctx, query.Get("code"), query.Get("state"))
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, res)
+}
+
+func (s *WebAPI) refreshSession(w http.ResponseWriter, r *http.Request) {
+	req := readRefreshSessionRequest(w, r)
+	if req == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	res, err := s.AuthService.RefreshSession(ctx, req)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, res)
+}
+
+func (s *WebAPI) logout(w http.ResponseWriter, r *http.Request) {
+	req := readLogoutRequest(w, r)
+	if req == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	err := s.AuthService.Logout(ctx, r.Header.Get("Authorization"), req)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, &api.EmptyMessage{})
+}
+
+func (s *WebAPI) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	req := readCreateAPIKeyRequest(w, r)
+	if req == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	username, _ := authenticatedNick(r.Context())
+	res, err := s.AuthService.CreateAPIKey(ctx, username, req)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusCreated, res)
+}
+
+func (s *WebAPI) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	username, _ := authenticatedNick(r.Context())
+	res, err := s.AuthService.ListAPIKeys(ctx, username, r.URL.Query().Get("account"))
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, res)
+}
+
+func (s *WebAPI) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	req := readRevokeAPIKeyRequest(w, r)
+	if req == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	username, _ := authenticatedNick(r.Context())
+	err := s.AuthService.RevokeAPIKey(ctx, username, req)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, &api.EmptyMessage{})
+}
+
+func (s *WebAPI) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	username, _ := authenticatedNick(r.Context())
+	res, err := s.AuthService.EnrollTOTP(ctx, username)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, res)
+}
+
+func (s *WebAPI) confirmTOTP(w http.ResponseWriter, r *http.Request) {
+	req := readTOTPCodeRequest(w, r)
+	if req == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	username, _ := authenticatedNick(r.Context())
+	res, err := s.AuthService.ConfirmTOTP(ctx, username, req)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, res)
+}
+
+func (s *WebAPI) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	req := readTOTPCodeRequest(w, r)
+	if req == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	username, _ := authenticatedNick(r.Context())
+	err := s.AuthService.DisableTOTP(ctx, username, req)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, &api.EmptyMessage{})
+}
+
+func (s *WebAPI) listUsers(w http.ResponseWriter, r *http.Request) {
+	offset, errOffset := queryInt(r, "offset")
+	limit, errLimit := queryInt(r, "limit")
+	if errOffset != nil || errLimit != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[api.ErrInvalidRequest], &api.ErrorResponse{ErrorDescription: api.ErrInvalidRequest.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	res, err := s.CoreService.ListUsers(&api.ListUsersRequest{Offset: offset, Limit: limit}, ctx)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, res)
+}
+
+func (s *WebAPI) createServiceAccount(w http.ResponseWriter, r *http.Request) {
+	req := readCreateServiceAccountRequest(w, r)
+	if req == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	username, _ := authenticatedNick(r.Context())
+	res, err := s.CoreService.CreateServiceAccount(&CreateServiceAccountWrapper{Nick: username, Request: req}, ctx)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusCreated, res)
+}
+
+func (s *WebAPI) setRole(w http.ResponseWriter, r *http.Request) {
+	req := readSetRoleRequest(w, r)
+	if req == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	username, _ := authenticatedNick(r.Context())
+	res, err := s.CoreService.SetRole(&SetRoleWrapper{Nick: username, Request: req}, ctx)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, res)
+}
+
+func (s *WebAPI) disableAccount(w http.ResponseWriter, r *http.Request) {
+	req := readAccountRequest(w, r)
+	if req == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	username, _ := authenticatedNick(r.Context())
+	res, err := s.CoreService.DisableAccount(&AdminRequestWrapper{Nick: username, Request: req}, ctx)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, res)
+}
+
+func (s *WebAPI) enableAccount(w http.ResponseWriter, r *http.Request) {
+	req := readAccountRequest(w, r)
+	if req == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	username, _ := authenticatedNick(r.Context())
+	res, err := s.CoreService.EnableAccount(&AdminRequestWrapper{Nick: username, Request: req}, ctx)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, res)
+}
+
+func (s *WebAPI) disconnectUser(w http.ResponseWriter, r *http.Request) {
+	req := readAccountRequest(w, r)
+	if req == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	username, _ := authenticatedNick(r.Context())
+	res, err := s.CoreService.DisconnectUser(&AdminRequestWrapper{Nick: username, Request: req}, ctx)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, res)
+}
+
 func (s *WebAPI) getProfile(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
 	defer cancel()
@@ -63,11 +413,48 @@ func (s *WebAPI) getProfile(w http.ResponseWriter, r *http.Request) {
 	writeJsonHttpResponse(w, http.StatusOK, res)
 }
 
+func (s *WebAPI) searchUsers(w http.ResponseWriter, r *http.Request) {
+	offset, errOffset := queryInt(r, "offset")
+	limit, errLimit := queryInt(r, "limit")
+	fuzzy, errFuzzy := queryBool(r, "fuzzy")
+	if errOffset != nil || errLimit != nil || errFuzzy != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[api.ErrInvalidRequest], &api.ErrorResponse{ErrorDescription: api.ErrInvalidRequest.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	res, err := s.CoreService.SearchUsers(&api.SearchUsersRequest{Query: r.URL.Query().Get("query"), Fuzzy: fuzzy, Offset: offset, Limit: limit}, ctx)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, res)
+}
+
+func (s *WebAPI) setDirectoryVisibility(w http.ResponseWriter, r *http.Request) {
+	req := readDirectoryVisibilityRequest(w, r)
+	if req == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	username, _ := authenticatedNick(r.Context())
+	res, err := s.CoreService.SetDirectoryVisibility(&DirectoryVisibilityWrapper{Nick: username, Request: req}, ctx)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, res)
+}
+
 func (s *WebAPI) editProfile(w http.ResponseWriter, r *http.Request) {
 	userData := readEditProfileRequest(w, r)
+	if userData == nil {
+		return
+	}
 	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
 	defer cancel()
-	username, _, _ := r.BasicAuth()
+	username, _ := authenticatedNick(r.Context())
 	res, err := s.CoreService.EditProfile(&EditProfileWrapper{Nick: username, Request: userData}, ctx)
 	if err != nil {
 		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
@@ -76,12 +463,260 @@ func (s *WebAPI) editProfile(w http.ResponseWriter, r *http.Request) {
 	writeJsonHttpResponse(w, http.StatusOK, res)
 }
 
+// uploadAvatar expects the image as raw request body
+func (s *WebAPI) uploadAvatar(w http.ResponseWriter, r *http.Request) {
+	image, err := io.ReadAll(http.MaxBytesReader(w, r.Body, api.MaxAvatarSize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			err = api.ErrAvatarTooLarge
+		} else {
+			err = api.ErrInvalidRequest
+		}
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	username, _ := authenticatedNick(r.Context())
+	res, err := s.CoreService.SetAvatar(&SetAvatarWrapper{Nick: username, Image: image}, ctx)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, res)
+}
+
+func (s *WebAPI) getAvatar(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	res, err := s.CoreService.GetAvatar(&api.GetAvatarRequest{Key: strings.TrimPrefix(r.URL.Path, api.AvatarsPath)}, ctx)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", res.ContentType)
+	// every upload gets a new key, so avatars never change
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res.Image)
+}
+
+func (s *WebAPI) changePassword(w http.ResponseWriter, r *http.Request) {
+	req := readChangePasswordRequest(w, r)
+	if req == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	username, _ := authenticatedNick(r.Context())
+	res, err := s.CoreService.ChangePassword(&ChangePasswordWrapper{Nick: username, Request: req}, ctx)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, res)
+}
+
+func (s *WebAPI) changeNick(w http.ResponseWriter, r *http.Request) {
+	req := readChangeNickRequest(w, r)
+	if req == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	username, _ := authenticatedNick(r.Context())
+	res, err := s.CoreService.ChangeNick(&ChangeNickWrapper{Nick: username, Request: req}, ctx)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, res)
+}
+
+func (s *WebAPI) block(w http.ResponseWriter, r *http.Request) {
+	req := readBlockRequest(w, r)
+	if req == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	username, _ := authenticatedNick(r.Context())
+	res, err := s.CoreService.Block(&BlockWrapper{Nick: username, Request: req}, ctx)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, res)
+}
+
+func (s *WebAPI) unblock(w http.ResponseWriter, r *http.Request) {
+	req := readBlockRequest(w, r)
+	if req == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	username, _ := authenticatedNick(r.Context())
+	res, err := s.CoreService.Unblock(&BlockWrapper{Nick: username, Request: req}, ctx)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, res)
+}
+
+func (s *WebAPI) listBlocks(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	username, _ := authenticatedNick(r.Context())
+	res, err := s.CoreService.ListBlocks(&api.AccountRequest{Nick: username}, ctx)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, res)
+}
+
+func (s *WebAPI) getPreferences(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	username, _ := authenticatedNick(r.Context())
+	res, err := s.CoreService.GetPreferences(&api.AccountRequest{Nick: username}, ctx)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, res)
+}
+
+func (s *WebAPI) updatePreferences(w http.ResponseWriter, r *http.Request) {
+	req := readPreferences(w, r)
+	if req == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	username, _ := authenticatedNick(r.Context())
+	res, err := s.CoreService.UpdatePreferences(&PreferencesWrapper{Nick: username, Request: req}, ctx)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, res)
+}
+
+func (s *WebAPI) deleteAccount(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	username, _ := authenticatedNick(r.Context())
+	res, err := s.CoreService.DeleteAccount(&api.AccountRequest{Nick: username}, ctx)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, res)
+}
+
+func (s *WebAPI) exportMyData(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	username, _ := authenticatedNick(r.Context())
+	archive, err := s.CoreService.ExportMyData(&api.AccountRequest{Nick: username}, ctx)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=sockchat-%s.zip", url.PathEscape(username)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(archive)
+}
+
+func (s *WebAPI) requestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	req := readRequestPasswordResetRequest(w, r)
+	if req == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	res, err := s.CoreService.RequestPasswordReset(req, ctx)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusAccepted, res)
+}
+
+func (s *WebAPI) resetPassword(w http.ResponseWriter, r *http.Request) {
+	req := readResetPasswordRequest(w, r)
+	if req == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	res, err := s.CoreService.ResetPassword(req, ctx)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, res)
+}
+
 func (s *WebAPI) getChannelHistory(w http.ResponseWriter, r *http.Request) {
 	channelName := r.URL.Query().Get("channel")
 	soughtPhrase := r.URL.Query().Get("search")
+	hideBlocked, errHideBlocked := queryBool(r, "hide_blocked")
+	limit, errLimit := queryInt(r, "limit")
+	if errHideBlocked != nil || errLimit != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[api.ErrInvalidRequest], &api.ErrorResponse{ErrorDescription: api.ErrInvalidRequest.Error()})
+		return
+	}
 	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
 	defer cancel()
-	res, err := s.CoreService.GetChannelHistory(&api.GetChannelHistoryRequest{Channel: channelName, Search: soughtPhrase}, ctx)
+	username, _ := authenticatedNick(r.Context())
+	req := &api.GetChannelHistoryRequest{
+		Channel:     channelName,
+		Search:      soughtPhrase,
+		HideBlocked: hideBlocked,
+		Limit:       limit,
+		Before:      r.URL.Query().Get("before"),
+		After:       r.URL.Query().Get("after"),
+	}
+	res, err := s.CoreService.GetChannelHistory(&ChannelHistoryWrapper{Nick: username, Request: req}, ctx)
+	if err != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
+		return
+	}
+	writeJsonHttpResponse(w, http.StatusOK, res)
+}
+
+// searchMessages accepts `channel` and `author` parameters multiple times
+func (s *WebAPI) searchMessages(w http.ResponseWriter, r *http.Request) {
+	offset, errOffset := queryInt(r, "offset")
+	limit, errLimit := queryInt(r, "limit")
+	from, errFrom := queryInt64(r, "from")
+	to, errTo := queryInt64(r, "to")
+	if errOffset != nil || errLimit != nil || errFrom != nil || errTo != nil {
+		writeJsonHttpResponse(w, HTTPStatuses[api.ErrInvalidRequest], &api.ErrorResponse{ErrorDescription: api.ErrInvalidRequest.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ResponseDeadline)
+	defer cancel()
+	username, _ := authenticatedNick(r.Context())
+	query := r.URL.Query()
+	req := &api.SearchMessagesRequest{
+		Query:    query.Get("query"),
+		Channels: query["channel"],
+		Authors:  query["author"],
+		From:     from,
+		To:       to,
+		Sort:     api.SearchSort(query.Get("sort")),
+		Offset:   offset,
+		Limit:    limit,
+	}
+	res, err := s.CoreService.SearchMessages(&SearchMessagesWrapper{Nick: username, Request: req}, ctx)
 	if err != nil {
 		writeJsonHttpResponse(w, HTTPStatuses[err], &api.ErrorResponse{ErrorDescription: err.Error()})
 		return
@@ -124,6 +759,179 @@ func readEditProfileRequest(w http.ResponseWriter, r *http.Request) *api.EditPro
 	return req.(*api.EditProfileRequest)
 }
 
+func readLoginRequest(w http.ResponseWriter, r *http.Request) *api.LoginRequest {
+	req, err := ParseRequest(r, "login")
+	if err != nil {
+		writeJsonHttpResponse(w, http.StatusBadRequest, api.NewSocketError(api.ErrInvalidRequest.Error()))
+		return nil
+	}
+	return req.(*api.LoginRequest)
+}
+
+func readRefreshSessionRequest(w http.ResponseWriter, r *http.Request) *api.RefreshSessionRequest {
+	req, err := ParseRequest(r, "refresh_session")
+	if err != nil {
+		writeJsonHttpResponse(w, http.StatusBadRequest, api.NewSocketError(api.ErrInvalidRequest.Error()))
+		return nil
+	}
+	return req.(*api.RefreshSessionRequest)
+}
+
+func readChangePasswordRequest(w http.ResponseWriter, r *http.Request) *api.ChangePasswordRequest {
+	req, err := ParseRequest(r, "change_password")
+	if err != nil {
+		writeJsonHttpResponse(w, http.StatusBadRequest, api.NewSocketError(api.ErrInvalidRequest.Error()))
+		return nil
+	}
+	return req.(*api.ChangePasswordRequest)
+}
+
+func readChangeNickRequest(w http.ResponseWriter, r *http.Request) *api.ChangeNickRequest {
+	req, err := ParseRequest(r, "change_nick")
+	if err != nil {
+		writeJsonHttpResponse(w, http.StatusBadRequest, api.NewSocketError(api.ErrInvalidRequest.Error()))
+		return nil
+	}
+	return req.(*api.ChangeNickRequest)
+}
+
+func readPreferences(w http.ResponseWriter, r *http.Request) *api.Preferences {
+	req, err := ParseRequest(r, "update_preferences")
+	if err != nil {
+		writeJsonHttpResponse(w, http.StatusBadRequest, api.NewSocketError(api.ErrInvalidRequest.Error()))
+		return nil
+	}
+	return req.(*api.Preferences)
+}
+
+func readRequestPasswordResetRequest(w http.ResponseWriter, r *http.Request) *api.RequestPasswordResetRequest {
+	req, err := ParseRequest(r, "request_password_reset")
+	if err != nil {
+		writeJsonHttpResponse(w, http.StatusBadRequest, api.NewSocketError(api.ErrInvalidRequest.Error()))
+		return nil
+	}
+	return req.(*api.RequestPasswordResetRequest)
+}
+
+func readResetPasswordRequest(w http.ResponseWriter, r *http.Request) *api.ResetPasswordRequest {
+	req, err := ParseRequest(r, "reset_password")
+	if err != nil {
+		writeJsonHttpResponse(w, http.StatusBadRequest, api.NewSocketError(api.ErrInvalidRequest.Error()))
+		return nil
+	}
+	return req.(*api.ResetPasswordRequest)
+}
+
+func readCreateAPIKeyRequest(w http.ResponseWriter, r *http.Request) *api.CreateAPIKeyRequest {
+	req, err := ParseRequest(r, "create_api_key")
+	if err != nil {
+		writeJsonHttpResponse(w, http.StatusBadRequest, api.NewSocketError(api.ErrInvalidRequest.Error()))
+		return nil
+	}
+	return req.(*api.CreateAPIKeyRequest)
+}
+
+func readRevokeAPIKeyRequest(w http.ResponseWriter, r *http.Request) *api.RevokeAPIKeyRequest {
+	req, err := ParseRequest(r, "revoke_api_key")
+	if err != nil {
+		writeJsonHttpResponse(w, http.StatusBadRequest, api.NewSocketError(api.ErrInvalidRequest.Error()))
+		return nil
+	}
+	return req.(*api.RevokeAPIKeyRequest)
+}
+
+func readTOTPCodeRequest(w http.ResponseWriter, r *http.Request) *api.TOTPCodeRequest {
+	req, err := ParseRequest(r, "totp_code")
+	if err != nil {
+		writeJsonHttpResponse(w, http.StatusBadRequest, api.NewSocketError(api.ErrInvalidRequest.Error()))
+		return nil
+	}
+	return req.(*api.TOTPCodeRequest)
+}
+
+func readDirectoryVisibilityRequest(w http.ResponseWriter, r *http.Request) *api.DirectoryVisibilityRequest {
+	req, err := ParseRequest(r, "directory_visibility")
+	if err != nil {
+		writeJsonHttpResponse(w, http.StatusBadRequest, api.NewSocketError(api.ErrInvalidRequest.Error()))
+		return nil
+	}
+	return req.(*api.DirectoryVisibilityRequest)
+}
+
+func readBlockRequest(w http.ResponseWriter, r *http.Request) *api.BlockRequest {
+	req, err := ParseRequest(r, "block")
+	if err != nil {
+		writeJsonHttpResponse(w, http.StatusBadRequest, api.NewSocketError(api.ErrInvalidRequest.Error()))
+		return nil
+	}
+	return req.(*api.BlockRequest)
+}
+
+func readAccountRequest(w http.ResponseWriter, r *http.Request) *api.AccountRequest {
+	req, err := ParseRequest(r, "account")
+	if err != nil {
+		writeJsonHttpResponse(w, http.StatusBadRequest, api.NewSocketError(api.ErrInvalidRequest.Error()))
+		return nil
+	}
+	return req.(*api.AccountRequest)
+}
+
+func readSetRoleRequest(w http.ResponseWriter, r *http.Request) *api.SetRoleRequest {
+	req, err := ParseRequest(r, "set_role")
+	if err != nil {
+		writeJsonHttpResponse(w, http.StatusBadRequest, api.NewSocketError(api.ErrInvalidRequest.Error()))
+		return nil
+	}
+	return req.(*api.SetRoleRequest)
+}
+
+func readCreateServiceAccountRequest(w http.ResponseWriter, r *http.Request) *api.CreateServiceAccountRequest {
+	req, err := ParseRequest(r, "create_service_account")
+	if err != nil {
+		writeJsonHttpResponse(w, http.StatusBadRequest, api.NewSocketError(api.ErrInvalidRequest.Error()))
+		return nil
+	}
+	return req.(*api.CreateServiceAccountRequest)
+}
+
+// queryInt returns integer query parameter or zero if it is missing
+func queryInt(r *http.Request, key string) (int, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func queryInt64(r *http.Request, key string) (int64, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+func queryBool(r *http.Request, key string) (bool, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// logout request body is optional
+func readLogoutRequest(w http.ResponseWriter, r *http.Request) *api.LogoutRequest {
+	if r.ContentLength == 0 {
+		return &api.LogoutRequest{}
+	}
+	req, err := ParseRequest(r, "logout")
+	if err != nil {
+		writeJsonHttpResponse(w, http.StatusBadRequest, api.NewSocketError(api.ErrInvalidRequest.Error()))
+		return nil
+	}
+	return req.(*api.LogoutRequest)
+}
+
 func ParseRequest(r *http.Request, action string) (any, error) {
 	bodyBytes, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -134,6 +942,38 @@ func ParseRequest(r *http.Request, action string) (any, error) {
 		return api.UnmarshalCreateProfileRequest(bodyBytes)
 	case "edit_profile":
 		return api.UnmarshalEditProfileRequest(bodyBytes)
+	case "login":
+		return api.UnmarshalLoginRequest(bodyBytes)
+	case "refresh_session":
+		return api.UnmarshalRefreshSessionRequest(bodyBytes)
+	case "logout":
+		return api.UnmarshalLogoutRequest(bodyBytes)
+	case "change_password":
+		return api.UnmarshalChangePasswordRequest(bodyBytes)
+	case "change_nick":
+		return api.UnmarshalChangeNickRequest(bodyBytes)
+	case "update_preferences":
+		return api.UnmarshalPreferences(bodyBytes)
+	case "request_password_reset":
+		return api.UnmarshalRequestPasswordResetRequest(bodyBytes)
+	case "reset_password":
+		return api.UnmarshalResetPasswordRequest(bodyBytes)
+	case "create_api_key":
+		return api.UnmarshalCreateAPIKeyRequest(bodyBytes)
+	case "revoke_api_key":
+		return api.UnmarshalRevokeAPIKeyRequest(bodyBytes)
+	case "totp_code":
+		return api.UnmarshalTOTPCodeRequest(bodyBytes)
+	case "account":
+		return api.UnmarshalAccountRequest(bodyBytes)
+	case "create_service_account":
+		return api.UnmarshalCreateServiceAccountRequest(bodyBytes)
+	case "set_role":
+		return api.UnmarshalSetRoleRequest(bodyBytes)
+	case "directory_visibility":
+		return api.UnmarshalDirectoryVisibilityRequest(bodyBytes)
+	case "block":
+		return api.UnmarshalBlockRequest(bodyBytes)
 	}
 	return nil, api.ErrInvalidRequest
 }
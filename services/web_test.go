@@ -1,17 +1,21 @@
 package services_test
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/kacperf531/sockchat"
 	"github.com/kacperf531/sockchat/api"
 	"github.com/kacperf531/sockchat/services"
+	"github.com/kacperf531/sockchat/storage"
 	"github.com/kacperf531/sockchat/test_utils"
 	"github.com/stretchr/testify/require"
 )
@@ -19,15 +23,19 @@ import (
 func TestSockChatWebAPI(t *testing.T) {
 	t.Parallel()
 
+	cache := test_utils.NewTestingRedisClient(t)
+
 	sampleMessage := api.MessageEvent{Text: "foo", Channel: "bar", Author: "baz"}
 	messageStore := &test_utils.StubMessageStore{Messages: api.ChannelHistory{&sampleMessage}}
-	userProfiles := &sockchat.ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: test_utils.TestingRedisClient}
+	userProfiles := &sockchat.ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: cache}
 	validToken := "Basic " + base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", test_utils.ValidUserNick, test_utils.ValidUserPassword)))
 
 	router := http.NewServeMux()
 
 	core := &services.SockchatCoreService{UserProfiles: userProfiles, ChatChannels: &test_utils.StubChannelStore{}, Messages: messageStore}
-	webAPI := services.NewWebAPI(core, &services.SockchatAuthService{UserProfiles: userProfiles})
+	sessions := &sockchat.SessionService{Secret: []byte("secret"), Cache: cache}
+	apiKeys := &sockchat.APIKeyService{Store: &test_utils.APIKeyStoreDouble{}}
+	webAPI := services.NewWebAPI(core, &services.SockchatAuthService{UserProfiles: userProfiles, Sessions: sessions, APIKeys: apiKeys})
 	webAPI.HandleRequests(router)
 
 	t.Run("can register over HTTP", func(t *testing.T) {
@@ -54,7 +62,109 @@ func TestSockChatWebAPI(t *testing.T) {
 
 		router.ServeHTTP(res, req)
 		require.Equal(t, http.StatusOK, res.Code)
-		require.Equal(t, api.ChannelHistory{&sampleMessage}, decodeChannelHistoryResponse(res.Body))
+		require.Equal(t, &api.ChannelHistoryPage{Messages: api.ChannelHistory{&sampleMessage}}, decodeChannelHistoryResponse(res.Body))
+	})
+
+	var tokens api.SessionTokens
+	t.Run("can log in over HTTP", func(t *testing.T) {
+		req := newLoginRequest(api.LoginRequest{Nick: test_utils.ValidUserNick, Password: test_utils.ValidUserPassword})
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&tokens))
+		require.NotEmpty(t, tokens.AccessToken)
+	})
+
+	t.Run("returns error for login with invalid password", func(t *testing.T) {
+		req := newLoginRequest(api.LoginRequest{Nick: test_utils.ValidUserNick, Password: "invalid"})
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+
+	t.Run("returns history for request authorized with session token", func(t *testing.T) {
+		req := newChannelHistoryRequest(test_utils.ChannelWithUser)
+		res := httptest.NewRecorder()
+		req.Header.Set("authorization", "Bearer "+tokens.AccessToken)
+
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+	})
+
+	t.Run("session token is rejected after logout", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, "/logout", nil)
+		req.Header.Set("authorization", "Bearer "+tokens.AccessToken)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+
+		req = newChannelHistoryRequest(test_utils.ChannelWithUser)
+		req.Header.Set("authorization", "Bearer "+tokens.AccessToken)
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusUnauthorized, res.Code)
+		require.Equal(t, api.ErrorResponse{ErrorDescription: api.ErrInvalidToken.Error()}, decodeErrorResponse(res.Body))
+	})
+
+	var apiKey api.CreatedAPIKey
+	t.Run("can create API key over HTTP", func(t *testing.T) {
+		req := newCreateAPIKeyRequest(api.CreateAPIKeyRequest{Name: "bot", Scopes: []api.Scope{api.ScopeHistoryRead}})
+		req.Header.Set("authorization", validToken)
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusCreated, res.Code)
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&apiKey))
+		require.NotEmpty(t, apiKey.Key)
+	})
+
+	t.Run("returns history for request authorized with API key", func(t *testing.T) {
+		req := newChannelHistoryRequest(test_utils.ChannelWithUser)
+		req.Header.Set("authorization", "ApiKey "+apiKey.Key)
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+	})
+
+	t.Run("returns error for API key without required scope", func(t *testing.T) {
+		req := newGetProfileRequest(test_utils.ValidUserNick)
+		req.Header.Set("authorization", "ApiKey "+apiKey.Key)
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusForbidden, res.Code)
+		require.Equal(t, api.ErrorResponse{ErrorDescription: api.ErrInsufficientScope.Error()}, decodeErrorResponse(res.Body))
+	})
+
+	t.Run("lists API keys of the user without their secrets", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/api_keys", nil)
+		req.Header.Set("authorization", validToken)
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+		var keys []*api.APIKey
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&keys))
+		require.Equal(t, []*api.APIKey{&apiKey.APIKey}, keys)
+	})
+
+	t.Run("revoked API key is rejected", func(t *testing.T) {
+		requestBytes, _ := json.Marshal(api.RevokeAPIKeyRequest{ID: apiKey.ID})
+		req, _ := http.NewRequest(http.MethodPost, "/revoke_api_key", bytes.NewBuffer(requestBytes))
+		req.Header.Set("authorization", validToken)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+
+		req = newChannelHistoryRequest(test_utils.ChannelWithUser)
+		req.Header.Set("authorization", "ApiKey "+apiKey.Key)
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusUnauthorized, res.Code)
+		require.Equal(t, api.ErrorResponse{ErrorDescription: api.ErrInvalidAPIKey.Error()}, decodeErrorResponse(res.Body))
 	})
 
 	t.Run("returns error for unauthorized request to edit profile", func(t *testing.T) {
@@ -65,6 +175,25 @@ func TestSockChatWebAPI(t *testing.T) {
 		require.Equal(t, http.StatusUnauthorized, res.Code)
 	})
 
+	t.Run("can change password over HTTP", func(t *testing.T) {
+		req := newChangePasswordRequest(api.ChangePasswordRequest{OldPassword: test_utils.ValidUserPassword, NewPassword: "bar420"})
+		req.Header.Set("authorization", validToken)
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+	})
+
+	t.Run("returns error for password change with invalid old password", func(t *testing.T) {
+		req := newChangePasswordRequest(api.ChangePasswordRequest{OldPassword: "invalid", NewPassword: "bar420"})
+		req.Header.Set("authorization", validToken)
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusForbidden, res.Code)
+		require.Equal(t, api.ErrorResponse{ErrorDescription: api.ErrInvalidPassword.Error()}, decodeErrorResponse(res.Body))
+	})
+
 	t.Run("returns error for unauthorized request to get profile", func(t *testing.T) {
 		req := newGetProfileRequest(test_utils.ValidUserNick)
 		res := httptest.NewRecorder()
@@ -75,12 +204,913 @@ func TestSockChatWebAPI(t *testing.T) {
 
 }
 
+func TestLoginLockout(t *testing.T) {
+	t.Parallel()
+
+	cache := test_utils.NewTestingRedisClient(t)
+
+	userProfiles := &sockchat.ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: cache}
+	sessions := &sockchat.SessionService{Secret: []byte("secret"), Cache: cache}
+	guard := &sockchat.LoginGuard{Cache: cache, MaxFailures: 2}
+	router := http.NewServeMux()
+	webAPI := services.NewWebAPI(&services.SockchatCoreService{UserProfiles: userProfiles}, &services.SockchatAuthService{UserProfiles: userProfiles, Sessions: sessions, LoginGuard: guard})
+	webAPI.HandleRequests(router)
+	// nick unique per run, so that lockouts from previous runs do not interfere
+	nick := fmt.Sprintf("LockoutTest%d", time.Now().UnixNano())
+
+	t.Run("returns error for invalid password", func(t *testing.T) {
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, newLoginRequest(api.LoginRequest{Nick: nick, Password: "invalid"}))
+		require.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+
+	t.Run("returns error for attempts made too early", func(t *testing.T) {
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, newLoginRequest(api.LoginRequest{Nick: nick, Password: "invalid"}))
+		require.Equal(t, http.StatusTooManyRequests, res.Code)
+		require.Equal(t, api.ErrorResponse{ErrorDescription: api.ErrAccountLocked.Error()}, decodeErrorResponse(res.Body))
+	})
+
+	t.Run("basic authorization is throttled as well", func(t *testing.T) {
+		req := newChannelHistoryRequest(test_utils.ChannelWithUser)
+		req.SetBasicAuth(nick, "invalid")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusTooManyRequests, res.Code)
+	})
+}
+
+func TestAdminWebAPI(t *testing.T) {
+	t.Parallel()
+
+	cache := test_utils.NewTestingRedisClient(t)
+
+	userProfiles := &sockchat.ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: cache}
+	sessions := &sockchat.SessionService{Secret: []byte("secret"), Cache: cache}
+	channelStore := &test_utils.StubChannelStore{}
+	core := &services.SockchatCoreService{UserProfiles: userProfiles, ChatChannels: channelStore, ConnectedUsers: sockchat.NewConnectedUsersPool(channelStore)}
+	authService := &services.SockchatAuthService{UserProfiles: userProfiles, Sessions: sessions}
+	router := http.NewServeMux()
+	services.NewWebAPI(core, authService).HandleRequests(router)
+
+	t.Run("regular user can not list users", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/admin/users", nil)
+		req.SetBasicAuth(test_utils.ValidUserNick, test_utils.ValidUserPassword)
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusForbidden, res.Code)
+	})
+
+	t.Run("admin can list users", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/admin/users?limit=1", nil)
+		req.SetBasicAuth(test_utils.ValidAdminNick, test_utils.ValidUserPassword)
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+		var accounts []*api.Account
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&accounts))
+		require.Equal(t, []*api.Account{{Nick: test_utils.ValidUserNick, Role: api.RoleUser}}, accounts)
+	})
+
+	t.Run("only admin can read published metrics", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/debug/vars", nil)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusUnauthorized, res.Code)
+
+		req.SetBasicAuth(test_utils.ValidUserNick, test_utils.ValidUserPassword)
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusForbidden, res.Code)
+
+		req.SetBasicAuth(test_utils.ValidAdminNick, test_utils.ValidUserPassword)
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Contains(t, res.Body.String(), `"memstats"`)
+	})
+
+	t.Run("disabled user can not use the API until enabled again", func(t *testing.T) {
+		tokens, err := sessions.Issue(context.Background(), test_utils.ValidUser3Nick)
+		require.NoError(t, err)
+
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, newAdminRequest("/admin/disable_account", test_utils.ValidUser3Nick))
+		require.Equal(t, http.StatusOK, res.Code)
+
+		req := newGetProfileRequest(test_utils.ValidUserNick)
+		req.Header.Set("authorization", "Bearer "+tokens.AccessToken)
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusForbidden, res.Code)
+		require.Equal(t, api.ErrorResponse{ErrorDescription: api.ErrAccountDisabled.Error()}, decodeErrorResponse(res.Body))
+
+		req = newGetProfileRequest(test_utils.ValidUserNick)
+		req.SetBasicAuth(test_utils.ValidUser3Nick, test_utils.ValidUserPassword)
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusUnauthorized, res.Code)
+
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, newAdminRequest("/admin/enable_account", test_utils.ValidUser3Nick))
+		require.Equal(t, http.StatusOK, res.Code)
+
+		req = newGetProfileRequest(test_utils.ValidUserNick)
+		req.Header.Set("authorization", "Bearer "+tokens.AccessToken)
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+	})
+
+	t.Run("returns error for disabling non-existing user", func(t *testing.T) {
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, newAdminRequest("/admin/disable_account", "NonExistingUser"))
+		require.Equal(t, http.StatusNotFound, res.Code)
+	})
+
+	t.Run("admin can disconnect user", func(t *testing.T) {
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, newAdminRequest("/admin/disconnect_user", test_utils.ValidUserNick))
+		require.Equal(t, http.StatusOK, res.Code)
+		var disconnected api.DisconnectUserResponse
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&disconnected))
+		require.Zero(t, disconnected.DisconnectedConnections)
+	})
+
+	t.Run("admin can grant admin role to another user", func(t *testing.T) {
+		nick := "WebPromotedTestUser"
+		require.NoError(t, userProfiles.Create(context.Background(), &api.CreateProfileRequest{Nick: nick, Password: test_utils.ValidUserPassword}))
+		listUsers := func() int {
+			req, _ := http.NewRequest(http.MethodGet, "/admin/users", nil)
+			req.SetBasicAuth(nick, test_utils.ValidUserPassword)
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, req)
+			return res.Code
+		}
+		require.Equal(t, http.StatusForbidden, listUsers())
+
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, newSetRoleRequest(api.SetRoleRequest{Nick: nick, Role: api.RoleAdmin}))
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Equal(t, http.StatusOK, listUsers())
+	})
+
+	t.Run("returns error for invalid role or change of own role", func(t *testing.T) {
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, newSetRoleRequest(api.SetRoleRequest{Nick: test_utils.ValidUser2Nick, Role: "superuser"}))
+		require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+		require.Equal(t, api.ErrorResponse{ErrorDescription: api.ErrInvalidRole.Error()}, decodeErrorResponse(res.Body))
+
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, newSetRoleRequest(api.SetRoleRequest{Nick: test_utils.ValidAdminNick, Role: api.RoleUser}))
+		require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+		require.Equal(t, api.ErrorResponse{ErrorDescription: api.ErrCannotChangeOwnRole.Error()}, decodeErrorResponse(res.Body))
+	})
+}
+
+func TestTwoFactorWebAPI(t *testing.T) {
+	t.Parallel()
+
+	cache := test_utils.NewTestingRedisClient(t)
+
+	userProfiles := &sockchat.ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: cache}
+	sessions := &sockchat.SessionService{Secret: []byte("secret"), Cache: cache}
+	twoFactor := &sockchat.TwoFactorService{Profiles: userProfiles, Cache: cache, EncryptionKey: []byte("0123456789abcdef")}
+	core := &services.SockchatCoreService{UserProfiles: userProfiles, ChatChannels: &test_utils.StubChannelStore{}}
+	authService := &services.SockchatAuthService{UserProfiles: userProfiles, Sessions: sessions, TwoFactor: twoFactor}
+	router := http.NewServeMux()
+	services.NewWebAPI(core, authService).HandleRequests(router)
+
+	req, _ := http.NewRequest(http.MethodPost, "/totp/enroll", nil)
+	req.SetBasicAuth(test_utils.ValidUserNick, test_utils.ValidUserPassword)
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	var enrollment api.TOTPEnrollment
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&enrollment))
+
+	code, err := sockchat.GenerateTOTPCode(enrollment.Secret, time.Now())
+	require.NoError(t, err)
+	req = newTOTPCodeRequest("/totp/confirm", code)
+	req.SetBasicAuth(test_utils.ValidUserNick, test_utils.ValidUserPassword)
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	var recoveryCodes api.RecoveryCodes
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&recoveryCodes))
+
+	t.Run("login requires TOTP code", func(t *testing.T) {
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, newLoginRequest(api.LoginRequest{Nick: test_utils.ValidUserNick, Password: test_utils.ValidUserPassword}))
+		require.Equal(t, http.StatusUnauthorized, res.Code)
+		require.Equal(t, api.ErrorResponse{ErrorDescription: api.ErrOTPRequired.Error()}, decodeErrorResponse(res.Body))
+
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, newLoginRequest(api.LoginRequest{Nick: test_utils.ValidUserNick, Password: test_utils.ValidUserPassword, OTP: "abcdef"}))
+		require.Equal(t, http.StatusUnauthorized, res.Code)
+		require.Equal(t, api.ErrorResponse{ErrorDescription: api.ErrInvalidOTP.Error()}, decodeErrorResponse(res.Body))
+	})
+
+	t.Run("basic auth is rejected", func(t *testing.T) {
+		req := newGetProfileRequest(test_utils.ValidUserNick)
+		req.SetBasicAuth(test_utils.ValidUserNick, test_utils.ValidUserPassword)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusUnauthorized, res.Code)
+		require.Equal(t, api.ErrorResponse{ErrorDescription: api.ErrOTPRequired.Error()}, decodeErrorResponse(res.Body))
+	})
+
+	t.Run("logs in with TOTP code and disables TOTP with recovery code", func(t *testing.T) {
+		nextCode, err := sockchat.GenerateTOTPCode(enrollment.Secret, time.Now().Add(sockchat.TOTPPeriod))
+		require.NoError(t, err)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, newLoginRequest(api.LoginRequest{Nick: test_utils.ValidUserNick, Password: test_utils.ValidUserPassword, OTP: nextCode}))
+		require.Equal(t, http.StatusOK, res.Code)
+		var tokens api.SessionTokens
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&tokens))
+
+		req := newTOTPCodeRequest("/totp/disable", recoveryCodes.RecoveryCodes[0])
+		req.Header.Set("authorization", "Bearer "+tokens.AccessToken)
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, newLoginRequest(api.LoginRequest{Nick: test_utils.ValidUserNick, Password: test_utils.ValidUserPassword}))
+		require.Equal(t, http.StatusOK, res.Code)
+	})
+}
+
+func TestTwoFactorAttemptsThrottled(t *testing.T) {
+	t.Parallel()
+
+	cache := test_utils.NewTestingRedisClient(t)
+
+	userProfiles := &sockchat.ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: cache}
+	sessions := &sockchat.SessionService{Secret: []byte("secret"), Cache: cache}
+	twoFactor := &sockchat.TwoFactorService{Profiles: userProfiles, Cache: cache, EncryptionKey: []byte("0123456789abcdef")}
+	guard := &sockchat.LoginGuard{Cache: cache, MaxFailures: 2}
+	authService := &services.SockchatAuthService{UserProfiles: userProfiles, Sessions: sessions, TwoFactor: twoFactor, LoginGuard: guard}
+	router := http.NewServeMux()
+	services.NewWebAPI(&services.SockchatCoreService{UserProfiles: userProfiles}, authService).HandleRequests(router)
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, newLoginRequest(api.LoginRequest{Nick: test_utils.ValidUserNick, Password: test_utils.ValidUserPassword}))
+	require.Equal(t, http.StatusOK, res.Code)
+	var tokens api.SessionTokens
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&tokens))
+
+	req, _ := http.NewRequest(http.MethodPost, "/totp/enroll", nil)
+	req.Header.Set("authorization", "Bearer "+tokens.AccessToken)
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	t.Run("returns error for invalid code", func(t *testing.T) {
+		req := newTOTPCodeRequest("/totp/confirm", "abcdef")
+		req.Header.Set("authorization", "Bearer "+tokens.AccessToken)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusUnauthorized, res.Code)
+		require.Equal(t, api.ErrorResponse{ErrorDescription: api.ErrInvalidOTP.Error()}, decodeErrorResponse(res.Body))
+	})
+
+	t.Run("returns error for codes tried too early", func(t *testing.T) {
+		req := newTOTPCodeRequest("/totp/confirm", "abcdef")
+		req.Header.Set("authorization", "Bearer "+tokens.AccessToken)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusTooManyRequests, res.Code)
+		require.Equal(t, api.ErrorResponse{ErrorDescription: api.ErrAccountLocked.Error()}, decodeErrorResponse(res.Body))
+	})
+}
+
+func TestOIDCWebAPI(t *testing.T) {
+	t.Parallel()
+
+	cache := test_utils.NewTestingRedisClient(t)
+
+	issuer := test_utils.NewTestOIDCIssuer(t)
+	userProfiles := &sockchat.ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: cache}
+	sessions := &sockchat.SessionService{Secret: []byte("secret"), Cache: cache}
+	oidc := &sockchat.OIDCService{
+		Issuer:       issuer.URL,
+		ClientID:     test_utils.TestOIDCClientID,
+		ClientSecret: test_utils.TestOIDCClientSecret,
+		RedirectURL:  "http://sockchat.test/oidc/callback",
+		Profiles:     userProfiles,
+		Identities:   &test_utils.IdentityStoreDouble{},
+		Cache:        cache,
+	}
+	core := &services.SockchatCoreService{UserProfiles: userProfiles, ChatChannels: &test_utils.StubChannelStore{}}
+	authService := &services.SockchatAuthService{UserProfiles: userProfiles, Sessions: sessions, OIDC: oidc}
+	router := http.NewServeMux()
+	services.NewWebAPI(core, authService).HandleRequests(router)
+
+	t.Run("signs in user of the identity provider", func(t *testing.T) {
+		issuer.SetUser("subject-1", "SSOTestUser")
+		req, _ := http.NewRequest(http.MethodGet, "/oidc/login", nil)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusFound, res.Code)
+		callback := issuer.Authorize(t, res.Header().Get("Location"))
+
+		req, _ = http.NewRequest(http.MethodGet, "/oidc/callback?"+callback.Encode(), nil)
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+		var tokens api.SessionTokens
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&tokens))
+		nick, err := sessions.Verify(context.Background(), tokens.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, "SSOTestUser", nick)
+	})
+
+	t.Run("returns error for callback with invalid state", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/oidc/callback?code=foo&state=bar", nil)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("returns error for callback with provider's error", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/oidc/callback?error=access_denied", nil)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+
+	t.Run("returns error if single sign-on is not configured", func(t *testing.T) {
+		router := http.NewServeMux()
+		services.NewWebAPI(core, &services.SockchatAuthService{UserProfiles: userProfiles, Sessions: sessions}).HandleRequests(router)
+		req, _ := http.NewRequest(http.MethodGet, "/oidc/login", nil)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusNotFound, res.Code)
+	})
+}
+
+func TestPersonalDataWebAPI(t *testing.T) {
+	t.Parallel()
+
+	cache := test_utils.NewTestingRedisClient(t)
+
+	nick, password := "WebDeletedTestUser", "password"
+	userProfiles := &sockchat.ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: cache}
+	sessions := &sockchat.SessionService{Secret: []byte("secret"), Cache: cache}
+	messages := &test_utils.StubMessageStore{Messages: api.ChannelHistory{{Text: "bye", Channel: "foo", Author: nick}}}
+	channelStore := &test_utils.StubChannelStore{}
+	core := &services.SockchatCoreService{
+		UserProfiles:   userProfiles,
+		Messages:       messages,
+		ChatChannels:   channelStore,
+		ConnectedUsers: sockchat.NewConnectedUsersPool(channelStore),
+		PersonalData:   &sockchat.PersonalDataService{Profiles: userProfiles, Messages: messages, Sessions: sessions},
+	}
+	authService := &services.SockchatAuthService{UserProfiles: userProfiles, Sessions: sessions}
+	router := http.NewServeMux()
+	services.NewWebAPI(core, authService).HandleRequests(router)
+	require.NoError(t, userProfiles.Create(context.Background(), &api.CreateProfileRequest{Nick: nick, Password: password}))
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, newLoginRequest(api.LoginRequest{Nick: nick, Password: password}))
+	require.Equal(t, http.StatusOK, res.Code)
+	var tokens api.SessionTokens
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&tokens))
+
+	t.Run("exports data as zip archive", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "/export_my_data", nil)
+		req.Header.Set("authorization", "Bearer "+tokens.AccessToken)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Equal(t, "application/zip", res.Header().Get("Content-Type"))
+		require.Equal(t, "attachment; filename=sockchat-"+nick+".zip", res.Header().Get("Content-Disposition"))
+		archive, err := zip.NewReader(bytes.NewReader(res.Body.Bytes()), int64(res.Body.Len()))
+		require.NoError(t, err)
+		require.Len(t, archive.File, 2)
+	})
+
+	t.Run("deleted account can not be used anymore", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, "/delete_account", nil)
+		req.Header.Set("authorization", "Bearer "+tokens.AccessToken)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Equal(t, api.DeletedUserNick, messages.Messages[0].Author)
+
+		req = newGetProfileRequest(nick)
+		req.Header.Set("authorization", "Bearer "+tokens.AccessToken)
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+}
+
+func TestChangeNickWebAPI(t *testing.T) {
+	t.Parallel()
+
+	cache := test_utils.NewTestingRedisClient(t)
+
+	nick, newNick, password := "WebRenamedTestUser", "WebRenamedTestUserNew", "password"
+	userProfiles := &sockchat.ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: cache}
+	sessions := &sockchat.SessionService{Secret: []byte("secret"), Cache: cache}
+	messages := &test_utils.StubMessageStore{Messages: api.ChannelHistory{{Text: "hi", Channel: test_utils.ChannelWithUser, Author: nick}}}
+	channelStore := &test_utils.StubChannelStore{}
+	core := &services.SockchatCoreService{
+		UserProfiles:   userProfiles,
+		Messages:       messages,
+		ChatChannels:   channelStore,
+		ConnectedUsers: sockchat.NewConnectedUsersPool(channelStore),
+		Sessions:       sessions,
+	}
+	authService := &services.SockchatAuthService{UserProfiles: userProfiles, Sessions: sessions}
+	router := http.NewServeMux()
+	services.NewWebAPI(core, authService).HandleRequests(router)
+	require.NoError(t, userProfiles.Create(context.Background(), &api.CreateProfileRequest{Nick: nick, Password: password}))
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, newLoginRequest(api.LoginRequest{Nick: nick, Password: password}))
+	require.Equal(t, http.StatusOK, res.Code)
+	var tokens api.SessionTokens
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&tokens))
+
+	t.Run("returns error for taken nick", func(t *testing.T) {
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, newChangeNickRequest(tokens.AccessToken, test_utils.ValidUserNick))
+		require.Equal(t, http.StatusConflict, res.Code)
+		require.Equal(t, api.ErrorResponse{ErrorDescription: api.ErrNickAlreadyUsed.Error()}, decodeErrorResponse(res.Body))
+	})
+
+	t.Run("renames the user and issues new session", func(t *testing.T) {
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, newChangeNickRequest(tokens.AccessToken, newNick))
+		require.Equal(t, http.StatusOK, res.Code)
+		var changed api.ChangeNickResponse
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&changed))
+		require.Equal(t, newNick, changed.Nick)
+		require.NotNil(t, changed.Tokens)
+		require.Equal(t, newNick, messages.Messages[0].Author)
+
+		req := newGetProfileRequest(newNick)
+		req.Header.Set("authorization", "Bearer "+changed.Tokens.AccessToken)
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+
+		req = newGetProfileRequest(newNick)
+		req.Header.Set("authorization", "Bearer "+tokens.AccessToken)
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+}
+
+func TestChangePasswordWebAPI(t *testing.T) {
+	t.Parallel()
+
+	cache := test_utils.NewTestingRedisClient(t)
+
+	nick, password := "WebPasswordChangeTestUser", "password"
+	userProfiles := &sockchat.ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: cache}
+	sessions := &sockchat.SessionService{Secret: []byte("secret"), Cache: cache}
+	core := &services.SockchatCoreService{UserProfiles: userProfiles, ChatChannels: &test_utils.StubChannelStore{}, Sessions: sessions}
+	router := http.NewServeMux()
+	services.NewWebAPI(core, &services.SockchatAuthService{UserProfiles: userProfiles, Sessions: sessions}).HandleRequests(router)
+	require.NoError(t, userProfiles.Create(context.Background(), &api.CreateProfileRequest{Nick: nick, Password: password}))
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, newLoginRequest(api.LoginRequest{Nick: nick, Password: password}))
+	require.Equal(t, http.StatusOK, res.Code)
+	var tokens api.SessionTokens
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&tokens))
+
+	t.Run("revokes all sessions and issues new one", func(t *testing.T) {
+		req := newChangePasswordRequest(api.ChangePasswordRequest{OldPassword: password, NewPassword: "bar420"})
+		req.Header.Set("authorization", "Bearer "+tokens.AccessToken)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+		var changed api.ChangePasswordResponse
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&changed))
+		require.NotNil(t, changed.Tokens)
+
+		req = newGetProfileRequest(nick)
+		req.Header.Set("authorization", "Bearer "+changed.Tokens.AccessToken)
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+
+		req = newGetProfileRequest(nick)
+		req.Header.Set("authorization", "Bearer "+tokens.AccessToken)
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusUnauthorized, res.Code)
+
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, newRefreshSessionRequest(tokens.RefreshToken))
+		require.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+}
+
+func TestProfileWebAPI(t *testing.T) {
+	t.Parallel()
+
+	cache := test_utils.NewTestingRedisClient(t)
+
+	nick, password := "WebProfileTestUser", "password"
+	avatars, err := storage.NewLocalBlobStore(t.TempDir())
+	require.NoError(t, err)
+	userProfiles := &sockchat.ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: cache, Avatars: avatars}
+	sessions := &sockchat.SessionService{Secret: []byte("secret"), Cache: cache}
+	core := &services.SockchatCoreService{UserProfiles: userProfiles, ChatChannels: &test_utils.StubChannelStore{}}
+	router := http.NewServeMux()
+	services.NewWebAPI(core, &services.SockchatAuthService{UserProfiles: userProfiles, Sessions: sessions}).HandleRequests(router)
+	require.NoError(t, userProfiles.Create(context.Background(), &api.CreateProfileRequest{Nick: nick, Password: password, Description: "foo"}))
+
+	t.Run("edits and returns profile fields", func(t *testing.T) {
+		req := newEditProfileRequest(api.EditProfileRequest{
+			Description:  "foo",
+			DisplayName:  "Web Profile",
+			Pronouns:     "they/them",
+			Timezone:     "Europe/Warsaw",
+			CustomFields: map[string]string{"website": "https://example.com"},
+		})
+		req.SetBasicAuth(nick, password)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+
+		req = newGetProfileRequest(nick)
+		req.SetBasicAuth(nick, password)
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+		var profile api.PublicProfile
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&profile))
+		require.Equal(t, api.PublicProfile{
+			Nick:         nick,
+			Description:  "foo",
+			DisplayName:  "Web Profile",
+			Pronouns:     "they/them",
+			Timezone:     "Europe/Warsaw",
+			CustomFields: map[string]string{"website": "https://example.com"},
+		}, profile)
+	})
+
+	t.Run("returns error for invalid timezone", func(t *testing.T) {
+		req := newEditProfileRequest(api.EditProfileRequest{Timezone: "Nowhere/Special"})
+		req.SetBasicAuth(nick, password)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+		require.Equal(t, api.ErrorResponse{ErrorDescription: api.ErrInvalidTimezone.Error()}, decodeErrorResponse(res.Body))
+	})
+
+	t.Run("uploads avatar and serves it publicly", func(t *testing.T) {
+		gif := []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;")
+		req, _ := http.NewRequest(http.MethodPost, "/avatar", bytes.NewBuffer(gif))
+		req.SetBasicAuth(nick, password)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+		var profile api.PublicProfile
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&profile))
+		require.NotEmpty(t, profile.AvatarURL)
+
+		req, _ = http.NewRequest(http.MethodGet, profile.AvatarURL, nil)
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Equal(t, "image/gif", res.Header().Get("Content-Type"))
+		require.Equal(t, gif, res.Body.Bytes())
+	})
+
+	t.Run("rejects avatar which is not an image", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, "/avatar", bytes.NewBufferString("<svg onload=alert(1)>"))
+		req.SetBasicAuth(nick, password)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusUnsupportedMediaType, res.Code)
+	})
+
+	t.Run("rejects avatar which is too large", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, "/avatar", bytes.NewBuffer(make([]byte, api.MaxAvatarSize+1)))
+		req.SetBasicAuth(nick, password)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusRequestEntityTooLarge, res.Code)
+	})
+
+	t.Run("searches users in directory unless they are hidden", func(t *testing.T) {
+		search := func(query string) []api.PublicProfile {
+			req, _ := http.NewRequest(http.MethodGet, "/users?"+query, nil)
+			req.SetBasicAuth(nick, password)
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, req)
+			require.Equal(t, http.StatusOK, res.Code)
+			var profiles []api.PublicProfile
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&profiles))
+			return profiles
+		}
+		profiles := search("query=web+prof")
+		require.Len(t, profiles, 1)
+		require.Equal(t, nick, profiles[0].Nick)
+		require.Len(t, search("query=sptu&fuzzy=true&limit=2"), 2)
+
+		req, _ := http.NewRequest(http.MethodPost, "/directory_visibility", bytes.NewBufferString(`{"hidden": true}`))
+		req.SetBasicAuth(nick, password)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Empty(t, search("query=web+prof"))
+	})
+
+	t.Run("returns not found for unknown avatar", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, api.AvatarsPath+"unknown.png", nil)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusNotFound, res.Code)
+	})
+}
+
+func TestBlockListWebAPI(t *testing.T) {
+	t.Parallel()
+
+	cache := test_utils.NewTestingRedisClient(t)
+
+	userProfiles := &sockchat.ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: cache}
+	messageStore := &test_utils.StubMessageStore{Messages: api.ChannelHistory{
+		{Channel: test_utils.ChannelWithUser, Author: test_utils.ValidUser2Nick, Text: "foo"},
+		{Channel: test_utils.ChannelWithUser, Author: test_utils.ValidUser3Nick, Text: "bar"},
+	}}
+	blockList := &sockchat.BlockListService{Store: &test_utils.BlockStoreDouble{}, Profiles: userProfiles}
+	core := &services.SockchatCoreService{UserProfiles: userProfiles, ChatChannels: &test_utils.StubChannelStore{}, Messages: messageStore, BlockList: blockList}
+	router := http.NewServeMux()
+	services.NewWebAPI(core, &services.SockchatAuthService{UserProfiles: userProfiles}).HandleRequests(router)
+	getHistory := func(query string) api.ChannelHistory {
+		req, _ := http.NewRequest(http.MethodGet, "/history?channel="+test_utils.ChannelWithUser+query, nil)
+		req.SetBasicAuth(test_utils.ValidUserNick, test_utils.ValidUserPassword)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+		var history api.ChannelHistoryPage
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&history))
+		return history.Messages
+	}
+
+	t.Run("blocked users are listed and hidden from history on request", func(t *testing.T) {
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, newBlockRequest("/block", test_utils.ValidUser2Nick))
+		require.Equal(t, http.StatusOK, res.Code)
+
+		req, _ := http.NewRequest(http.MethodGet, "/blocks", nil)
+		req.SetBasicAuth(test_utils.ValidUserNick, test_utils.ValidUserPassword)
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+		var blocks api.BlockListResponse
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&blocks))
+		require.Equal(t, []string{test_utils.ValidUser2Nick}, blocks.Nicks)
+
+		require.Len(t, getHistory(""), 2)
+		history := getHistory("&hide_blocked=true")
+		require.Len(t, history, 1)
+		require.Equal(t, test_utils.ValidUser3Nick, history[0].Author)
+	})
+
+	t.Run("unblocked users are shown in history again", func(t *testing.T) {
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, newBlockRequest("/unblock", test_utils.ValidUser2Nick))
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Len(t, getHistory("&hide_blocked=true"), 2)
+	})
+
+	t.Run("returns error for blocking yourself or nonexistent user", func(t *testing.T) {
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, newBlockRequest("/block", test_utils.ValidUserNick))
+		require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+		require.Equal(t, api.ErrorResponse{ErrorDescription: api.ErrCannotBlockSelf.Error()}, decodeErrorResponse(res.Body))
+
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, newBlockRequest("/block", "not_exists"))
+		require.Equal(t, http.StatusNotFound, res.Code)
+	})
+}
+
+func TestChannelHistoryPagingWebAPI(t *testing.T) {
+	t.Parallel()
+
+	cache := test_utils.NewTestingRedisClient(t)
+
+	userProfiles := &sockchat.ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: cache}
+	messageStore := &test_utils.StubMessageStore{Messages: api.ChannelHistory{
+		{Channel: test_utils.ChannelWithUser, Author: test_utils.ValidUser2Nick, Text: "newest", Timestamp: 3},
+		{Channel: test_utils.ChannelWithUser, Author: test_utils.ValidUser2Nick, Text: "middle", Timestamp: 2},
+		{Channel: test_utils.ChannelWithUser, Author: test_utils.ValidUser2Nick, Text: "oldest", Timestamp: 1},
+	}}
+	core := &services.SockchatCoreService{UserProfiles: userProfiles, ChatChannels: &test_utils.StubChannelStore{}, Messages: messageStore}
+	router := http.NewServeMux()
+	services.NewWebAPI(core, &services.SockchatAuthService{UserProfiles: userProfiles}).HandleRequests(router)
+	getHistory := func(query string) *httptest.ResponseRecorder {
+		req := newChannelHistoryRequest(test_utils.ChannelWithUser + query)
+		req.SetBasicAuth(test_utils.ValidUserNick, test_utils.ValidUserPassword)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		return res
+	}
+
+	t.Run("can page through history with limit and cursors", func(t *testing.T) {
+		res := getHistory("&limit=2")
+		require.Equal(t, http.StatusOK, res.Code)
+		first := decodeChannelHistoryResponse(res.Body)
+		require.Len(t, first.Messages, 2)
+		require.Equal(t, "newest", first.Messages[0].Text)
+		require.NotEmpty(t, first.NextCursor)
+
+		res = getHistory("&limit=2&before=" + first.NextCursor)
+		require.Equal(t, http.StatusOK, res.Code)
+		second := decodeChannelHistoryResponse(res.Body)
+		require.Len(t, second.Messages, 1)
+		require.Equal(t, "oldest", second.Messages[0].Text)
+		require.Empty(t, second.NextCursor)
+	})
+
+	t.Run("returns error for invalid paging parameters", func(t *testing.T) {
+		invalid := map[string]error{
+			"&limit=foo":            api.ErrInvalidRequest,
+			"&before=foo":           api.ErrInvalidCursor,
+			"&before=foo&after=bar": api.ErrConflictingCursors,
+		}
+		for query, expected := range invalid {
+			res := getHistory(query)
+			require.Equal(t, http.StatusBadRequest, res.Code)
+			require.Equal(t, api.ErrorResponse{ErrorDescription: expected.Error()}, decodeErrorResponse(res.Body))
+		}
+	})
+}
+
+func TestSearchMessagesWebAPI(t *testing.T) {
+	t.Parallel()
+
+	cache := test_utils.NewTestingRedisClient(t)
+
+	userProfiles := &sockchat.ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: cache}
+	messageStore := &test_utils.StubMessageStore{Messages: api.ChannelHistory{
+		{Channel: test_utils.ChannelWithUser, Author: test_utils.ValidUser2Nick, Text: "hello world", Timestamp: 1},
+		{Channel: test_utils.ChannelWithUser, Author: test_utils.ValidUser3Nick, Text: "hello again", Timestamp: 2},
+	}}
+	core := &services.SockchatCoreService{UserProfiles: userProfiles, ChatChannels: &test_utils.StubChannelStore{}, Messages: messageStore}
+	router := http.NewServeMux()
+	services.NewWebAPI(core, &services.SockchatAuthService{UserProfiles: userProfiles}).HandleRequests(router)
+	search := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, "/search?"+query, nil)
+		req.SetBasicAuth(test_utils.ValidUserNick, test_utils.ValidUserPassword)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		return res
+	}
+
+	t.Run("can search messages with filters", func(t *testing.T) {
+		res := search("query=hello&channel=" + test_utils.ChannelWithUser + "&author=" + test_utils.ValidUser3Nick + "&sort=time")
+		require.Equal(t, http.StatusOK, res.Code)
+		var found api.SearchMessagesResponse
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&found))
+		require.Equal(t, int64(1), found.Total)
+		require.Equal(t, "hello again", found.Results[0].Message.Text)
+		require.NotEmpty(t, found.Results[0].Highlights)
+	})
+
+	t.Run("returns error for invalid search", func(t *testing.T) {
+		invalid := map[string]struct {
+			status int
+			err    error
+		}{
+			"from=yesterday":    {http.StatusBadRequest, api.ErrInvalidRequest},
+			"sort=alphabetical": {http.StatusBadRequest, api.ErrInvalidSort},
+			"channel=elsewhere": {http.StatusForbidden, api.ErrUserNotInChannel},
+		}
+		for query, expected := range invalid {
+			res := search(query)
+			require.Equal(t, expected.status, res.Code)
+			require.Equal(t, api.ErrorResponse{ErrorDescription: expected.err.Error()}, decodeErrorResponse(res.Body))
+		}
+	})
+}
+
+func TestPreferencesWebAPI(t *testing.T) {
+	t.Parallel()
+
+	cache := test_utils.NewTestingRedisClient(t)
+
+	userProfiles := &sockchat.ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: cache}
+	preferences := &sockchat.PreferencesService{Store: &test_utils.PreferencesStoreDouble{}, Cache: cache}
+	core := &services.SockchatCoreService{UserProfiles: userProfiles, Preferences: preferences}
+	router := http.NewServeMux()
+	services.NewWebAPI(core, &services.SockchatAuthService{UserProfiles: userProfiles}).HandleRequests(router)
+
+	t.Run("updated preferences are returned", func(t *testing.T) {
+		updated := api.Preferences{
+			Theme:                "dark",
+			NotificationLevel:    api.NotifyMentions,
+			MutedChannels:        []string{"random"},
+			ChannelNotifications: map[string]api.NotificationLevel{"general": api.NotifyAll},
+		}
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, newUpdatePreferencesRequest(updated))
+		require.Equal(t, http.StatusOK, res.Code)
+
+		req, _ := http.NewRequest(http.MethodGet, "/preferences", nil)
+		req.SetBasicAuth(test_utils.ValidUser3Nick, test_utils.ValidUserPassword)
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+		var prefs api.Preferences
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&prefs))
+		require.Equal(t, updated, prefs)
+	})
+
+	t.Run("returns error for invalid notification level", func(t *testing.T) {
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, newUpdatePreferencesRequest(api.Preferences{NotificationLevel: "sometimes"}))
+		require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+		require.Equal(t, api.ErrorResponse{ErrorDescription: api.ErrInvalidNotification.Error()}, decodeErrorResponse(res.Body))
+	})
+}
+
+func newUpdatePreferencesRequest(prefs api.Preferences) *http.Request {
+	requestBytes, _ := json.Marshal(prefs)
+	req, _ := http.NewRequest(http.MethodPost, "/update_preferences", bytes.NewBuffer(requestBytes))
+	req.SetBasicAuth(test_utils.ValidUser3Nick, test_utils.ValidUserPassword)
+	return req
+}
+
+func newBlockRequest(path, nick string) *http.Request {
+	requestBytes, _ := json.Marshal(api.BlockRequest{Nick: nick})
+	req, _ := http.NewRequest(http.MethodPost, path, bytes.NewBuffer(requestBytes))
+	req.SetBasicAuth(test_utils.ValidUserNick, test_utils.ValidUserPassword)
+	return req
+}
+
+func newChangeNickRequest(accessToken, newNick string) *http.Request {
+	requestBytes, _ := json.Marshal(api.ChangeNickRequest{NewNick: newNick})
+	req, _ := http.NewRequest(http.MethodPost, "/change_nick", bytes.NewBuffer(requestBytes))
+	req.Header.Set("authorization", "Bearer "+accessToken)
+	return req
+}
+
+func newTOTPCodeRequest(path, code string) *http.Request {
+	requestBytes, _ := json.Marshal(api.TOTPCodeRequest{Code: code})
+	req, _ := http.NewRequest(http.MethodPost, path, bytes.NewBuffer(requestBytes))
+	return req
+}
+
+func newAdminRequest(path, nick string) *http.Request {
+	requestBytes, _ := json.Marshal(api.AccountRequest{Nick: nick})
+	req, _ := http.NewRequest(http.MethodPost, path, bytes.NewBuffer(requestBytes))
+	req.SetBasicAuth(test_utils.ValidAdminNick, test_utils.ValidUserPassword)
+	return req
+}
+
+func newSetRoleRequest(b api.SetRoleRequest) *http.Request {
+	requestBytes, _ := json.Marshal(b)
+	req, _ := http.NewRequest(http.MethodPost, "/admin/set_role", bytes.NewBuffer(requestBytes))
+	req.SetBasicAuth(test_utils.ValidAdminNick, test_utils.ValidUserPassword)
+	return req
+}
+
 func newRegisterRequest(b api.CreateProfileRequest) *http.Request {
 	requestBytes, _ := json.Marshal(b)
 	req, _ := http.NewRequest(http.MethodPost, "/register", bytes.NewBuffer(requestBytes))
 	return req
 }
 
+func newChangePasswordRequest(b api.ChangePasswordRequest) *http.Request {
+	requestBytes, _ := json.Marshal(b)
+	req, _ := http.NewRequest(http.MethodPost, "/change_password", bytes.NewBuffer(requestBytes))
+	return req
+}
+
+func newCreateAPIKeyRequest(b api.CreateAPIKeyRequest) *http.Request {
+	requestBytes, _ := json.Marshal(b)
+	req, _ := http.NewRequest(http.MethodPost, "/create_api_key", bytes.NewBuffer(requestBytes))
+	return req
+}
+
+func newLoginRequest(b api.LoginRequest) *http.Request {
+	requestBytes, _ := json.Marshal(b)
+	req, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(requestBytes))
+	return req
+}
+
+func newRefreshSessionRequest(refreshToken string) *http.Request {
+	requestBytes, _ := json.Marshal(api.RefreshSessionRequest{RefreshToken: refreshToken})
+	req, _ := http.NewRequest(http.MethodPost, "/refresh_session", bytes.NewBuffer(requestBytes))
+	return req
+}
+
 func newGetProfileRequest(nick string) *http.Request {
 	req, _ := http.NewRequest(http.MethodGet, "/profile?nick="+nick, nil)
 	return req
@@ -103,8 +1133,8 @@ func decodeErrorResponse(b *bytes.Buffer) api.ErrorResponse {
 	return errResponse
 }
 
-func decodeChannelHistoryResponse(b *bytes.Buffer) api.ChannelHistory {
-	var history api.ChannelHistory
+func decodeChannelHistoryResponse(b *bytes.Buffer) *api.ChannelHistoryPage {
+	var history api.ChannelHistoryPage
 	json.NewDecoder(b).Decode(&history)
-	return history
+	return &history
 }
@@ -0,0 +1,215 @@
+package sockchat
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/kacperf531/sockchat/api"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	DefaultAccessTokenTTL  = 15 * time.Minute
+	DefaultRefreshTokenTTL = 7 * 24 * time.Hour
+
+	accessTokenType  = "access"
+	refreshTokenType = "refresh"
+
+	revokedTokenKeyPrefix  = "revoked_token:"
+	sessionsEpochKeyPrefix = "sessions_epoch:"
+)
+
+// SessionService issues signed session tokens and keeps track of revoked ones in Redis
+type SessionService struct {
+	Secret          []byte
+	Cache           *redis.Client
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+}
+
+type sessionClaims struct {
+	TokenType string `json:"typ"`
+	// Epoch is the user's sessions epoch at the time of issuing, RevokeAll starts a new one
+	Epoch string `json:"sep,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func (s *SessionService) Issue(ctx context.Context, nick string) (*api.SessionTokens, error) {
+	if nick == "" {
+		return nil, api.ErrNickRequired
+	}
+	epoch, err := s.currentEpoch(ctx, nick)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	accessExpiry := now.Add(s.accessTokenTTL())
+	accessToken, err := s.sign(nick, accessTokenType, epoch, now, accessExpiry)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := s.sign(nick, refreshTokenType, epoch, now, now.Add(s.refreshTokenTTL()))
+	if err != nil {
+		return nil, err
+	}
+	return &api.SessionTokens{AccessToken: accessToken, RefreshToken: refreshToken, ExpiresAt: accessExpiry.Unix()}, nil
+}
+
+// Verify returns nick of the owner of a valid access token
+func (s *SessionService) Verify(ctx context.Context, token string) (string, error) {
+	claims, err := s.parse(ctx, token, accessTokenType)
+	if err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
+
+// Refresh issues new tokens in exchange for a valid refresh token, which gets revoked.
+// The token is claimed atomically, so that it can not be exchanged twice by concurrent requests.
+func (s *SessionService) Refresh(ctx context.Context, refreshToken string) (*api.SessionTokens, error) {
+	claims, err := s.parse(ctx, refreshToken, refreshTokenType)
+	if err != nil {
+		return nil, err
+	}
+	claimed, err := s.Cache.SetNX(ctx, revokedTokenKeyPrefix+claims.ID, claims.Subject, time.Until(claims.ExpiresAt.Time)).Result()
+	if err != nil {
+		log.Printf("error revoking session token: %v", err)
+		return nil, api.ErrInternal
+	}
+	if !claimed {
+		return nil, api.ErrInvalidToken
+	}
+	return s.Issue(ctx, claims.Subject)
+}
+
+// Revoke invalidates access or refresh token before its expiry
+func (s *SessionService) Revoke(ctx context.Context, token string) error {
+	claims, err := s.parse(ctx, token, "")
+	if err != nil {
+		return err
+	}
+	return s.revokeClaims(ctx, claims)
+}
+
+// RevokeAll invalidates all tokens of the user issued up to now by starting a new sessions epoch;
+// tokens issued afterwards are valid, even within the same second.
+// The epoch outlives all tokens issued before it, so it can expire together with the longest living of them.
+func (s *SessionService) RevokeAll(ctx context.Context, nick string) error {
+	if nick == "" {
+		return api.ErrNickRequired
+	}
+	epoch, err := randomTokenID()
+	if err != nil {
+		return err
+	}
+	if err := s.Cache.Set(ctx, sessionsEpochKeyPrefix+nick, epoch, s.refreshTokenTTL()).Err(); err != nil {
+		log.Printf("error revoking sessions of %s: %v", nick, err)
+		return api.ErrInternal
+	}
+	return nil
+}
+
+// currentEpoch returns the sessions epoch of the user, empty if their sessions were never revoked
+func (s *SessionService) currentEpoch(ctx context.Context, nick string) (string, error) {
+	epoch, err := s.Cache.Get(ctx, sessionsEpochKeyPrefix+nick).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		log.Printf("error checking session revocation of %s: %v", nick, err)
+		return "", api.ErrInternal
+	}
+	return epoch, nil
+}
+
+func randomTokenID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		log.Printf("error generating session token id: %v", err)
+		return "", api.ErrInternal
+	}
+	return hex.EncodeToString(id), nil
+}
+
+func (s *SessionService) sign(nick, tokenType, epoch string, issuedAt, expiresAt time.Time) (string, error) {
+	id, err := randomTokenID()
+	if err != nil {
+		return "", err
+	}
+	claims := sessionClaims{
+		TokenType: tokenType,
+		Epoch:     epoch,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			Subject:   nick,
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.Secret)
+	if err != nil {
+		log.Printf("error signing session token: %v", err)
+		return "", api.ErrInternal
+	}
+	return token, nil
+}
+
+// parse verifies signature, expiry, type (unless empty) and revocation of the token
+func (s *SessionService) parse(ctx context.Context, token, tokenType string) (*sessionClaims, error) {
+	claims := &sessionClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return s.Secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, api.ErrInvalidToken
+	}
+	if tokenType != "" && claims.TokenType != tokenType {
+		return nil, api.ErrInvalidToken
+	}
+	revoked, err := s.Cache.Exists(ctx, revokedTokenKeyPrefix+claims.ID).Result()
+	if err != nil {
+		log.Printf("error checking session token revocation: %v", err)
+		return nil, api.ErrInternal
+	}
+	if revoked > 0 {
+		return nil, api.ErrInvalidToken
+	}
+	epoch, err := s.currentEpoch(ctx, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if epoch != "" && claims.Epoch != epoch {
+		return nil, api.ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func (s *SessionService) revokeClaims(ctx context.Context, claims *sessionClaims) error {
+	ttl := time.Until(claims.ExpiresAt.Time)
+	if ttl <= 0 {
+		return nil
+	}
+	if err := s.Cache.Set(ctx, revokedTokenKeyPrefix+claims.ID, claims.Subject, ttl).Err(); err != nil {
+		log.Printf("error revoking session token: %v", err)
+		return api.ErrInternal
+	}
+	return nil
+}
+
+func (s *SessionService) accessTokenTTL() time.Duration {
+	if s.AccessTokenTTL == 0 {
+		return DefaultAccessTokenTTL
+	}
+	return s.AccessTokenTTL
+}
+
+func (s *SessionService) refreshTokenTTL() time.Duration {
+	if s.RefreshTokenTTL == 0 {
+		return DefaultRefreshTokenTTL
+	}
+	return s.RefreshTokenTTL
+}
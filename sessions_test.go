@@ -0,0 +1,121 @@
+package sockchat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kacperf531/sockchat/api"
+	"github.com/kacperf531/sockchat/test_utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionService(t *testing.T) {
+	t.Parallel()
+
+	cache := test_utils.NewTestingRedisClient(t)
+
+	service := &SessionService{Secret: []byte("secret"), Cache: cache}
+	ctx := context.Background()
+
+	t.Run("issued access token resolves to its owner", func(t *testing.T) {
+		tokens, err := service.Issue(ctx, "Foo")
+		require.NoError(t, err)
+		nick, err := service.Verify(ctx, tokens.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, "Foo", nick)
+		assert.Greater(t, tokens.ExpiresAt, time.Now().Unix())
+	})
+
+	t.Run("refresh token can not be used as access token", func(t *testing.T) {
+		tokens, err := service.Issue(ctx, "Foo")
+		require.NoError(t, err)
+		_, err = service.Verify(ctx, tokens.RefreshToken)
+		assert.ErrorIs(t, err, api.ErrInvalidToken)
+	})
+
+	t.Run("token signed with another secret is rejected", func(t *testing.T) {
+		other := &SessionService{Secret: []byte("other"), Cache: cache}
+		tokens, err := other.Issue(ctx, "Foo")
+		require.NoError(t, err)
+		_, err = service.Verify(ctx, tokens.AccessToken)
+		assert.ErrorIs(t, err, api.ErrInvalidToken)
+	})
+
+	t.Run("expired token is rejected", func(t *testing.T) {
+		shortLived := &SessionService{Secret: []byte("secret"), Cache: cache, AccessTokenTTL: -time.Second}
+		tokens, err := shortLived.Issue(ctx, "Foo")
+		require.NoError(t, err)
+		_, err = service.Verify(ctx, tokens.AccessToken)
+		assert.ErrorIs(t, err, api.ErrInvalidToken)
+	})
+
+	t.Run("refresh issues new tokens and revokes the used refresh token", func(t *testing.T) {
+		tokens, err := service.Issue(ctx, "Foo")
+		require.NoError(t, err)
+		refreshed, err := service.Refresh(ctx, tokens.RefreshToken)
+		require.NoError(t, err)
+		nick, err := service.Verify(ctx, refreshed.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, "Foo", nick)
+
+		_, err = service.Refresh(ctx, tokens.RefreshToken)
+		assert.ErrorIs(t, err, api.ErrInvalidToken)
+	})
+
+	t.Run("concurrent refreshes with the same token issue a single pair of tokens", func(t *testing.T) {
+		tokens, err := service.Issue(ctx, "Foo")
+		require.NoError(t, err)
+		const attempts = 10
+		results := make(chan error, attempts)
+		for i := 0; i < attempts; i++ {
+			go func() {
+				_, err := service.Refresh(ctx, tokens.RefreshToken)
+				results <- err
+			}()
+		}
+		succeeded := 0
+		for i := 0; i < attempts; i++ {
+			if err := <-results; err == nil {
+				succeeded++
+			} else {
+				assert.ErrorIs(t, err, api.ErrInvalidToken)
+			}
+		}
+		assert.Equal(t, 1, succeeded)
+	})
+
+	t.Run("revoked token is rejected", func(t *testing.T) {
+		tokens, err := service.Issue(ctx, "Foo")
+		require.NoError(t, err)
+		require.NoError(t, service.Revoke(ctx, tokens.AccessToken))
+		_, err = service.Verify(ctx, tokens.AccessToken)
+		assert.ErrorIs(t, err, api.ErrInvalidToken)
+	})
+
+	t.Run("revoking all sessions rejects tokens of the user only", func(t *testing.T) {
+		tokens, err := service.Issue(ctx, "RevokedEverywhere")
+		require.NoError(t, err)
+		otherTokens, err := service.Issue(ctx, "Foo")
+		require.NoError(t, err)
+		require.NoError(t, service.RevokeAll(ctx, "RevokedEverywhere"))
+
+		_, err = service.Verify(ctx, tokens.AccessToken)
+		assert.ErrorIs(t, err, api.ErrInvalidToken)
+		_, err = service.Refresh(ctx, tokens.RefreshToken)
+		assert.ErrorIs(t, err, api.ErrInvalidToken)
+		_, err = service.Verify(ctx, otherTokens.AccessToken)
+		assert.NoError(t, err)
+	})
+
+	t.Run("tokens issued right after revoking all sessions are valid", func(t *testing.T) {
+		require.NoError(t, service.RevokeAll(ctx, "RevokedAndReissued"))
+		tokens, err := service.Issue(ctx, "RevokedAndReissued")
+		require.NoError(t, err)
+		_, err = service.Verify(ctx, tokens.AccessToken)
+		assert.NoError(t, err)
+		_, err = service.Refresh(ctx, tokens.RefreshToken)
+		assert.NoError(t, err)
+	})
+}
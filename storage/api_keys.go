@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kacperf531/sockchat/api"
+)
+
+type APIKeyStore interface {
+	InsertAPIKey(context.Context, *APIKey) error
+	SelectAPIKeysByOwner(ctx context.Context, owner string) ([]*APIKey, error)
+	SelectAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error)
+	RevokeAPIKey(ctx context.Context, owner string, id int64) error
+	DeleteAPIKeysByOwner(ctx context.Context, owner string) error
+}
+
+func NewAPIKeyStore(db *sql.DB) APIKeyStore {
+	return &apiKeyStore{
+		db: db,
+	}
+}
+
+type apiKeyStore struct {
+	db *sql.DB
+}
+
+// APIKey is stored with a hash of its secret value only
+type APIKey struct {
+	ID        int64
+	Owner     string
+	Name      string
+	KeyHash   string
+	Scopes    []api.Scope
+	CreatedAt int64
+	Revoked   bool
+}
+
+func (s *apiKeyStore) InsertAPIKey(ctx context.Context, k *APIKey) error {
+	const stmt = "INSERT INTO api_keys(owner, name, key_hash, scopes, created_at) VALUES (?, ?, ?, ?, ?);  "
+
+	res, err := s.db.ExecContext(ctx, stmt, k.Owner, k.Name, k.KeyHash, joinScopes(k.Scopes), k.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("could not insert row: %w", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("could not get inserted id: %w", err)
+	}
+	k.ID = id
+
+	return nil
+}
+
+func (s *apiKeyStore) SelectAPIKeysByOwner(ctx context.Context, owner string) ([]*APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, owner, name, key_hash, scopes, created_at, revoked FROM api_keys WHERE owner = ? AND NOT revoked ORDER BY id;", owner)
+	if err != nil {
+		return nil, fmt.Errorf("could not get rows: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not get rows: %w", err)
+	}
+
+	return keys, nil
+}
+
+func (s *apiKeyStore) SelectAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT id, owner, name, key_hash, scopes, created_at, revoked FROM api_keys WHERE key_hash = ?;", keyHash)
+	key, err := scanAPIKey(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, api.ErrAPIKeyNotFound
+	}
+	return key, err
+}
+
+func (s *apiKeyStore) RevokeAPIKey(ctx context.Context, owner string, id int64) error {
+	const stmt = "UPDATE api_keys SET revoked = TRUE WHERE id = ? AND owner = ? AND NOT revoked;  "
+
+	res, err := s.db.ExecContext(ctx, stmt, id, owner)
+	if err != nil {
+		return fmt.Errorf("could not update row: %w", err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return api.ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+func (s *apiKeyStore) DeleteAPIKeysByOwner(ctx context.Context, owner string) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM api_keys WHERE owner = ?;  ", owner); err != nil {
+		return fmt.Errorf("could not delete rows: %w", err)
+	}
+
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var key APIKey
+	var scopes string
+	if err := row.Scan(&key.ID, &key.Owner, &key.Name, &key.KeyHash, &scopes, &key.CreatedAt, &key.Revoked); err != nil {
+		return nil, fmt.Errorf("could not get row: %w", err)
+	}
+	key.Scopes = splitScopes(scopes)
+	return &key, nil
+}
+
+func joinScopes(scopes []api.Scope) string {
+	values := make([]string, len(scopes))
+	for i, scope := range scopes {
+		values[i] = string(scope)
+	}
+	return strings.Join(values, ",")
+}
+
+func splitScopes(scopes string) []api.Scope {
+	if scopes == "" {
+		return nil
+	}
+	values := strings.Split(scopes, ",")
+	out := make([]api.Scope, len(values))
+	for i, value := range values {
+		out[i] = api.Scope(value)
+	}
+	return out
+}
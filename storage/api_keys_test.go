@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/joho/godotenv"
+	"github.com/kacperf531/sockchat/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyStore(t *testing.T) {
+	godotenv.Load("../.env")
+
+	db := mustSetUpTestDB(t)
+	defer db.Close()
+
+	store := NewAPIKeyStore(db)
+	key := &APIKey{Owner: "Foo", Name: "bot", KeyHash: "Bar", Scopes: []api.Scope{api.ScopeHistoryRead, api.ScopeMessagesWrite}, CreatedAt: 100}
+
+	t.Run("inserts new API key into DB", func(t *testing.T) {
+		require.NoError(t, store.InsertAPIKey(context.TODO(), key))
+		assert.NotZero(t, key.ID)
+	})
+
+	t.Run("returns API key by its hash", func(t *testing.T) {
+		found, err := store.SelectAPIKeyByHash(context.TODO(), "Bar")
+		require.NoError(t, err)
+		assert.Equal(t, key, found)
+	})
+
+	t.Run("lists API keys of the owner", func(t *testing.T) {
+		keys, err := store.SelectAPIKeysByOwner(context.TODO(), "Foo")
+		require.NoError(t, err)
+		assert.Equal(t, []*APIKey{key}, keys)
+	})
+
+	t.Run("can not revoke API key of another owner", func(t *testing.T) {
+		err := store.RevokeAPIKey(context.TODO(), "Baz", key.ID)
+		assert.EqualError(t, err, api.ErrAPIKeyNotFound.Error())
+	})
+
+	t.Run("revoked API key is marked and not listed", func(t *testing.T) {
+		require.NoError(t, store.RevokeAPIKey(context.TODO(), "Foo", key.ID))
+		found, err := store.SelectAPIKeyByHash(context.TODO(), "Bar")
+		require.NoError(t, err)
+		assert.True(t, found.Revoked)
+		keys, err := store.SelectAPIKeysByOwner(context.TODO(), "Foo")
+		require.NoError(t, err)
+		assert.Empty(t, keys)
+	})
+
+	t.Run("deletes API keys of the owner", func(t *testing.T) {
+		require.NoError(t, store.DeleteAPIKeysByOwner(context.TODO(), "Foo"))
+		_, err := store.SelectAPIKeyByHash(context.TODO(), "Bar")
+		assert.Equal(t, api.ErrAPIKeyNotFound, err)
+	})
+}
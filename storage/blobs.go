@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/kacperf531/sockchat/api"
+)
+
+// BlobStore keeps binary files, such as avatars, under keys chosen by the caller
+type BlobStore interface {
+	PutBlob(ctx context.Context, key string, data []byte) error
+	GetBlob(ctx context.Context, key string) ([]byte, error)
+	DeleteBlob(ctx context.Context, key string) error
+}
+
+// keys are used as file names, so they must not contain path separators
+var blobKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// NewLocalBlobStore stores blobs as files in the directory, which is created if it does not exist
+func NewLocalBlobStore(dir string) (BlobStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create blob directory: %w", err)
+	}
+	return &localBlobStore{dir: dir}, nil
+}
+
+type localBlobStore struct {
+	dir string
+}
+
+func (s *localBlobStore) PutBlob(ctx context.Context, key string, data []byte) error {
+	if !blobKeyPattern.MatchString(key) {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	// write to a temporary file first, so that readers never see partially written blobs
+	tmp, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return fmt.Errorf("could not create blob file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write blob file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not write blob file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, key)); err != nil {
+		return fmt.Errorf("could not store blob file: %w", err)
+	}
+	return nil
+}
+
+func (s *localBlobStore) GetBlob(ctx context.Context, key string) ([]byte, error) {
+	if !blobKeyPattern.MatchString(key) {
+		return nil, api.ErrBlobNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, key))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, api.ErrBlobNotFound
+		}
+		return nil, fmt.Errorf("could not read blob file: %w", err)
+	}
+	return data, nil
+}
+
+// DeleteBlob succeeds if there is no blob with the key
+func (s *localBlobStore) DeleteBlob(ctx context.Context, key string) error {
+	if !blobKeyPattern.MatchString(key) {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(s.dir, key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not delete blob file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kacperf531/sockchat/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalBlobStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalBlobStore(t.TempDir() + "/blobs")
+	require.NoError(t, err)
+
+	t.Run("stores, returns and deletes blob", func(t *testing.T) {
+		require.NoError(t, store.PutBlob(ctx, "foo.png", []byte("bar")))
+		data, err := store.GetBlob(ctx, "foo.png")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("bar"), data)
+
+		require.NoError(t, store.DeleteBlob(ctx, "foo.png"))
+		_, err = store.GetBlob(ctx, "foo.png")
+		assert.ErrorIs(t, err, api.ErrBlobNotFound)
+		assert.NoError(t, store.DeleteBlob(ctx, "foo.png"))
+	})
+
+	t.Run("rejects keys pointing outside of the directory", func(t *testing.T) {
+		assert.Error(t, store.PutBlob(ctx, "../foo", []byte("bar")))
+		_, err := store.GetBlob(ctx, "../blobs/foo.png")
+		assert.ErrorIs(t, err, api.ErrBlobNotFound)
+	})
+}
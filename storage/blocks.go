@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+type BlockStore interface {
+	// InsertBlock is a no-op if the user is already blocked
+	InsertBlock(ctx context.Context, blocker, blocked string) error
+	DeleteBlock(ctx context.Context, blocker, blocked string) error
+	SelectBlockedNicks(ctx context.Context, blocker string) ([]string, error)
+	// DeleteBlocksByNick removes blocks made by the user as well as blocks of the user
+	DeleteBlocksByNick(ctx context.Context, nick string) error
+}
+
+func NewBlockStore(db *sql.DB) BlockStore {
+	return &blockStore{
+		db: db,
+	}
+}
+
+type blockStore struct {
+	db *sql.DB
+}
+
+func (s *blockStore) InsertBlock(ctx context.Context, blocker, blocked string) error {
+	const stmt = "INSERT IGNORE INTO blocks(blocker, blocked) VALUES (?, ?);  "
+
+	if _, err := s.db.ExecContext(ctx, stmt, blocker, blocked); err != nil {
+		return fmt.Errorf("could not insert row: %w", err)
+	}
+
+	return nil
+}
+
+func (s *blockStore) DeleteBlock(ctx context.Context, blocker, blocked string) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM blocks WHERE blocker = ? AND blocked = ?;  ", blocker, blocked); err != nil {
+		return fmt.Errorf("could not delete row: %w", err)
+	}
+
+	return nil
+}
+
+func (s *blockStore) SelectBlockedNicks(ctx context.Context, blocker string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT blocked FROM blocks WHERE blocker = ? ORDER BY blocked;", blocker)
+	if err != nil {
+		return nil, fmt.Errorf("could not get rows: %w", err)
+	}
+	defer rows.Close()
+
+	nicks := []string{}
+	for rows.Next() {
+		var nick string
+		if err := rows.Scan(&nick); err != nil {
+			return nil, fmt.Errorf("could not scan row: %w", err)
+		}
+		nicks = append(nicks, nick)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not get rows: %w", err)
+	}
+
+	return nicks, nil
+}
+
+func (s *blockStore) DeleteBlocksByNick(ctx context.Context, nick string) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM blocks WHERE blocker = ? OR blocked = ?;  ", nick, nick); err != nil {
+		return fmt.Errorf("could not delete rows: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockStore(t *testing.T) {
+	godotenv.Load("../.env")
+
+	db := mustSetUpTestDB(t)
+	defer db.Close()
+
+	store := NewBlockStore(db)
+
+	t.Run("inserts blocks into DB", func(t *testing.T) {
+		require.NoError(t, store.InsertBlock(context.TODO(), "Foo", "Baz"))
+		require.NoError(t, store.InsertBlock(context.TODO(), "Foo", "Bar"))
+		require.NoError(t, store.InsertBlock(context.TODO(), "Bar", "Foo"))
+	})
+
+	t.Run("blocking the same user twice is a no-op", func(t *testing.T) {
+		require.NoError(t, store.InsertBlock(context.TODO(), "Foo", "Bar"))
+	})
+
+	t.Run("returns nicks blocked by the user in order", func(t *testing.T) {
+		nicks, err := store.SelectBlockedNicks(context.TODO(), "Foo")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Bar", "Baz"}, nicks)
+	})
+
+	t.Run("deletes a block", func(t *testing.T) {
+		require.NoError(t, store.DeleteBlock(context.TODO(), "Foo", "Baz"))
+		nicks, err := store.SelectBlockedNicks(context.TODO(), "Foo")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Bar"}, nicks)
+	})
+
+	t.Run("deletes blocks made by and of the nick", func(t *testing.T) {
+		require.NoError(t, store.DeleteBlocksByNick(context.TODO(), "Foo"))
+		nicks, err := store.SelectBlockedNicks(context.TODO(), "Foo")
+		require.NoError(t, err)
+		assert.Empty(t, nicks)
+		nicks, err = store.SelectBlockedNicks(context.TODO(), "Bar")
+		require.NoError(t, err)
+		assert.Empty(t, nicks)
+	})
+}
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS messages (
+		seq      BIGSERIAL PRIMARY KEY,
+		id      VARCHAR(64) COLLATE "C" NOT NULL UNIQUE,
+		channel      VARCHAR(255) NOT NULL,
+		author      VARCHAR(255) NOT NULL,
+		author_id      BIGINT NOT NULL DEFAULT 0,
+		text      TEXT NOT NULL,
+		timestamp      BIGINT NOT NULL,
+		text_search      TSVECTOR GENERATED ALWAYS AS (to_tsvector('simple', text)) STORED
+	  );
+DROP INDEX IF EXISTS messages_channel_timestamp;
+CREATE INDEX IF NOT EXISTS messages_channel_timestamp_seq ON messages (channel, timestamp, seq);
+CREATE INDEX IF NOT EXISTS messages_author ON messages (author);
+CREATE INDEX IF NOT EXISTS messages_author_id ON messages (author_id);
+CREATE INDEX IF NOT EXISTS messages_text_search ON messages USING GIN (text_search);
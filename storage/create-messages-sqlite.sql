@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS messages (
+		seq      INTEGER PRIMARY KEY,
+		id      TEXT NOT NULL UNIQUE,
+		channel      TEXT NOT NULL,
+		author      TEXT NOT NULL,
+		author_id      INTEGER NOT NULL DEFAULT 0,
+		text      TEXT NOT NULL,
+		timestamp      INTEGER NOT NULL
+	  );
+DROP INDEX IF EXISTS messages_channel_timestamp;
+CREATE INDEX IF NOT EXISTS messages_channel_timestamp_seq ON messages (channel, timestamp, seq);
+CREATE INDEX IF NOT EXISTS messages_author ON messages (author);
+CREATE INDEX IF NOT EXISTS messages_author_id ON messages (author_id);
+CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts4 (content="messages", text, tokenize=unicode61 "remove_diacritics=1");
+CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+		INSERT INTO messages_fts (docid, text) VALUES (new.seq, new.text);
+	  END;
+CREATE TRIGGER IF NOT EXISTS messages_fts_delete BEFORE DELETE ON messages BEGIN
+		DELETE FROM messages_fts WHERE docid = old.seq;
+	  END;
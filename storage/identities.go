@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/VividCortex/mysqlerr"
+	"github.com/go-sql-driver/mysql"
+	"github.com/kacperf531/sockchat/api"
+)
+
+type IdentityStore interface {
+	InsertIdentity(context.Context, *Identity) error
+	SelectIdentity(ctx context.Context, issuer, subject string) (*Identity, error)
+	DeleteIdentitiesByNick(ctx context.Context, nick string) error
+}
+
+func NewIdentityStore(db *sql.DB) IdentityStore {
+	return &identityStore{
+		db: db,
+	}
+}
+
+type identityStore struct {
+	db *sql.DB
+}
+
+// Identity links subject of an external identity provider to a user
+type Identity struct {
+	Issuer  string
+	Subject string
+	Nick    string
+}
+
+func (s *identityStore) InsertIdentity(ctx context.Context, i *Identity) error {
+	const stmt = "INSERT INTO identities(issuer, subject, nick) VALUES (?, ?, ?);  "
+
+	if _, err := s.db.ExecContext(ctx, stmt, i.Issuer, i.Subject, i.Nick); err != nil {
+		if driverErr, ok := err.(*mysql.MySQLError); ok {
+			if driverErr.Number == mysqlerr.ER_DUP_ENTRY {
+				return api.ErrIdentityAlreadyLinked
+			}
+		}
+		return fmt.Errorf("could not insert row: %w", err)
+	}
+
+	return nil
+}
+
+func (s *identityStore) SelectIdentity(ctx context.Context, issuer, subject string) (*Identity, error) {
+	var identity Identity
+	err := s.db.QueryRowContext(ctx, "SELECT issuer, subject, nick FROM identities WHERE issuer = ? AND subject = ?;", issuer, subject).Scan(&identity.Issuer, &identity.Subject, &identity.Nick)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, api.ErrIdentityNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get row: %w", err)
+	}
+
+	return &identity, nil
+}
+
+func (s *identityStore) DeleteIdentitiesByNick(ctx context.Context, nick string) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM identities WHERE nick = ?;  ", nick); err != nil {
+		return fmt.Errorf("could not delete rows: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/joho/godotenv"
+	"github.com/kacperf531/sockchat/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentityStore(t *testing.T) {
+	godotenv.Load("../.env")
+
+	db := mustSetUpTestDB(t)
+	defer db.Close()
+
+	store := NewIdentityStore(db)
+	identity := &Identity{Issuer: "https://issuer.example.com", Subject: "Bar", Nick: "Foo"}
+
+	t.Run("inserts new identity into DB", func(t *testing.T) {
+		require.NoError(t, store.InsertIdentity(context.TODO(), identity))
+	})
+
+	t.Run("can not link the same subject twice", func(t *testing.T) {
+		err := store.InsertIdentity(context.TODO(), &Identity{Issuer: identity.Issuer, Subject: identity.Subject, Nick: "Baz"})
+		assert.Equal(t, api.ErrIdentityAlreadyLinked, err)
+	})
+
+	t.Run("returns identity by issuer and subject", func(t *testing.T) {
+		found, err := store.SelectIdentity(context.TODO(), identity.Issuer, identity.Subject)
+		require.NoError(t, err)
+		assert.Equal(t, identity, found)
+	})
+
+	t.Run("returns error for identity of another issuer", func(t *testing.T) {
+		_, err := store.SelectIdentity(context.TODO(), "https://another.example.com", identity.Subject)
+		assert.Equal(t, api.ErrIdentityNotFound, err)
+	})
+
+	t.Run("deletes identities linked to the nick", func(t *testing.T) {
+		require.NoError(t, store.DeleteIdentitiesByNick(context.TODO(), identity.Nick))
+		_, err := store.SelectIdentity(context.TODO(), identity.Issuer, identity.Subject)
+		assert.Equal(t, api.ErrIdentityNotFound, err)
+	})
+}
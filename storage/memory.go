@@ -0,0 +1,447 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/kacperf531/sockchat/api"
+)
+
+// MemoryStore keeps users and the data linked to them in memory, for development without MySQL.
+// It implements UserStore, APIKeyStore, IdentityStore, BlockStore and PreferencesStore,
+// so that renaming a user updates the other data the way it does in the DB.
+type MemoryStore struct {
+	users       []*User
+	twoFactor   map[int64]*TwoFactor
+	apiKeys     []*APIKey
+	identities  []*Identity
+	blocks      map[string]map[string]bool
+	preferences map[string]*api.Preferences
+	lastUserID  int64
+	lastKeyID   int64
+	lock        sync.RWMutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		twoFactor:   make(map[int64]*TwoFactor),
+		blocks:      make(map[string]map[string]bool),
+		preferences: make(map[string]*api.Preferences),
+	}
+}
+
+// user must be called with the lock held
+func (s *MemoryStore) user(nick string) *User {
+	for _, u := range s.users {
+		if u.Nick == nick {
+			return u
+		}
+	}
+	return nil
+}
+
+// copyUser protects stored users from changes made by callers
+func copyUser(u *User) *User {
+	c := *u
+	if u.CustomFields != nil {
+		c.CustomFields = make(map[string]string, len(u.CustomFields))
+		for k, v := range u.CustomFields {
+			c.CustomFields[k] = v
+		}
+	}
+	return &c
+}
+
+func (s *MemoryStore) InsertUser(ctx context.Context, u *User) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.user(u.Nick) != nil {
+		return api.ErrNickAlreadyUsed
+	}
+	role := u.Role
+	if role == "" {
+		role = api.RoleUser
+	}
+	s.lastUserID++
+	u.ID = s.lastUserID
+	s.users = append(s.users, &User{ID: u.ID, Nick: u.Nick, PwHash: u.PwHash, Description: u.Description, Role: role})
+	return nil
+}
+
+func (s *MemoryStore) update(nick string, apply func(*User)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if u := s.user(nick); u != nil {
+		apply(u)
+	}
+	return nil
+}
+
+// UpdatePublicProfile updates all editable fields of the profile; avatar is updated with UpdateAvatar
+func (s *MemoryStore) UpdatePublicProfile(ctx context.Context, p *api.PublicProfile) error {
+	customFields := make(map[string]string, len(p.CustomFields))
+	for k, v := range p.CustomFields {
+		customFields[k] = v
+	}
+	return s.update(p.Nick, func(u *User) {
+		u.Description, u.DisplayName, u.Pronouns, u.Timezone, u.CustomFields = p.Description, p.DisplayName, p.Pronouns, p.Timezone, customFields
+	})
+}
+
+func (s *MemoryStore) UpdateAvatar(ctx context.Context, nick, avatar string) error {
+	return s.update(nick, func(u *User) { u.Avatar = avatar })
+}
+
+func (s *MemoryStore) UpdatePasswordHash(ctx context.Context, nick, pwHash string) error {
+	return s.update(nick, func(u *User) { u.PwHash = pwHash })
+}
+
+func (s *MemoryStore) UpdateDisabled(ctx context.Context, nick string, disabled bool) error {
+	return s.update(nick, func(u *User) { u.Disabled = disabled })
+}
+
+func (s *MemoryStore) UpdateRole(ctx context.Context, nick string, role api.Role) error {
+	return s.update(nick, func(u *User) { u.Role = role })
+}
+
+func (s *MemoryStore) UpdateHiddenFromDirectory(ctx context.Context, nick string, hidden bool) error {
+	return s.update(nick, func(u *User) { u.HiddenFromDirectory = hidden })
+}
+
+func (s *MemoryStore) UpdateNick(ctx context.Context, nick, newNick string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	u := s.user(nick)
+	if u == nil {
+		return api.ErrUserNotFound
+	}
+	if s.user(newNick) != nil {
+		return api.ErrNickAlreadyUsed
+	}
+	u.Nick = newNick
+	for _, key := range s.apiKeys {
+		if key.Owner == nick {
+			key.Owner = newNick
+		}
+	}
+	for _, identity := range s.identities {
+		if identity.Nick == nick {
+			identity.Nick = newNick
+		}
+	}
+	if blocked, ok := s.blocks[nick]; ok {
+		delete(s.blocks, nick)
+		s.blocks[newNick] = blocked
+	}
+	for _, blocked := range s.blocks {
+		if blocked[nick] {
+			delete(blocked, nick)
+			blocked[newNick] = true
+		}
+	}
+	if prefs, ok := s.preferences[nick]; ok {
+		delete(s.preferences, nick)
+		s.preferences[newNick] = prefs
+	}
+	return nil
+}
+
+func (s *MemoryStore) SelectUser(ctx context.Context, nick string) (*User, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	u := s.user(nick)
+	if u == nil {
+		return nil, api.ErrUserNotFound
+	}
+	return copyUser(u), nil
+}
+
+// SelectNicksByIDs returns current nicks of the users; unknown ids are skipped
+func (s *MemoryStore) SelectNicksByIDs(ctx context.Context, ids []int64) (map[int64]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	wanted := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	nicks := make(map[int64]string, len(ids))
+	for _, u := range s.users {
+		if wanted[u.ID] {
+			nicks[u.ID] = u.Nick
+		}
+	}
+	return nicks, nil
+}
+
+func (s *MemoryStore) SelectUsers(ctx context.Context, offset, limit int) ([]*User, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	users := []*User{}
+	for i := offset; i < len(s.users) && len(users) < limit; i++ {
+		users = append(users, copyUser(s.users[i]))
+	}
+	return users, nil
+}
+
+// SearchUsers returns enabled users listed in the directory, those matching by prefix first
+func (s *MemoryStore) SearchUsers(ctx context.Context, query *UserQuery) ([]*User, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	phrase := strings.ToLower(query.Phrase)
+	var byPrefix, fuzzy []*User
+	for _, u := range s.users {
+		if u.HiddenFromDirectory || u.Disabled {
+			continue
+		}
+		nick, displayName := strings.ToLower(u.Nick), strings.ToLower(u.DisplayName)
+		switch {
+		case strings.HasPrefix(nick, phrase) || strings.HasPrefix(displayName, phrase):
+			byPrefix = append(byPrefix, u)
+		case query.Fuzzy && (containsInOrder(nick, phrase) || containsInOrder(displayName, phrase)):
+			fuzzy = append(fuzzy, u)
+		}
+	}
+	byNick := func(users []*User) func(i, j int) bool {
+		return func(i, j int) bool { return users[i].Nick < users[j].Nick }
+	}
+	sort.Slice(byPrefix, byNick(byPrefix))
+	sort.Slice(fuzzy, byNick(fuzzy))
+
+	users := []*User{}
+	for i, u := range append(byPrefix, fuzzy...) {
+		if i >= query.Offset && len(users) < query.Limit {
+			users = append(users, copyUser(u))
+		}
+	}
+	return users, nil
+}
+
+// containsInOrder tells if characters of the phrase appear in the text in the same order, like fuzzyLikePattern
+func containsInOrder(text, phrase string) bool {
+	for _, r := range phrase {
+		i := strings.IndexRune(text, r)
+		if i < 0 {
+			return false
+		}
+		text = text[i+utf8.RuneLen(r):]
+	}
+	return true
+}
+
+func (s *MemoryStore) SelectTwoFactor(ctx context.Context, nick string) (*TwoFactor, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	u := s.user(nick)
+	if u == nil {
+		return nil, api.ErrUserNotFound
+	}
+	tf := TwoFactor{}
+	if stored, ok := s.twoFactor[u.ID]; ok {
+		tf = *stored
+		tf.RecoveryCodeHashes = append([]string{}, stored.RecoveryCodeHashes...)
+	}
+	return &tf, nil
+}
+
+func (s *MemoryStore) UpdateTwoFactor(ctx context.Context, nick string, tf *TwoFactor) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if u := s.user(nick); u != nil {
+		stored := *tf
+		stored.RecoveryCodeHashes = append([]string{}, tf.RecoveryCodeHashes...)
+		s.twoFactor[u.ID] = &stored
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteUser(ctx context.Context, nick string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, u := range s.users {
+		if u.Nick == nick {
+			s.users = append(s.users[:i], s.users[i+1:]...)
+			delete(s.twoFactor, u.ID)
+			return nil
+		}
+	}
+	return api.ErrUserNotFound
+}
+
+func (s *MemoryStore) InsertAPIKey(ctx context.Context, k *APIKey) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lastKeyID++
+	k.ID = s.lastKeyID
+	stored := *k
+	s.apiKeys = append(s.apiKeys, &stored)
+	return nil
+}
+
+func (s *MemoryStore) SelectAPIKeysByOwner(ctx context.Context, owner string) ([]*APIKey, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	keys := []*APIKey{}
+	for _, key := range s.apiKeys {
+		if key.Owner == owner && !key.Revoked {
+			k := *key
+			keys = append(keys, &k)
+		}
+	}
+	return keys, nil
+}
+
+func (s *MemoryStore) SelectAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, key := range s.apiKeys {
+		if key.KeyHash == keyHash {
+			k := *key
+			return &k, nil
+		}
+	}
+	return nil, api.ErrAPIKeyNotFound
+}
+
+func (s *MemoryStore) RevokeAPIKey(ctx context.Context, owner string, id int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, key := range s.apiKeys {
+		if key.ID == id && key.Owner == owner && !key.Revoked {
+			key.Revoked = true
+			return nil
+		}
+	}
+	return api.ErrAPIKeyNotFound
+}
+
+func (s *MemoryStore) DeleteAPIKeysByOwner(ctx context.Context, owner string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	kept := s.apiKeys[:0]
+	for _, key := range s.apiKeys {
+		if key.Owner != owner {
+			kept = append(kept, key)
+		}
+	}
+	s.apiKeys = kept
+	return nil
+}
+
+func (s *MemoryStore) InsertIdentity(ctx context.Context, i *Identity) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, identity := range s.identities {
+		if identity.Issuer == i.Issuer && identity.Subject == i.Subject {
+			return api.ErrIdentityAlreadyLinked
+		}
+	}
+	stored := *i
+	s.identities = append(s.identities, &stored)
+	return nil
+}
+
+func (s *MemoryStore) SelectIdentity(ctx context.Context, issuer, subject string) (*Identity, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, identity := range s.identities {
+		if identity.Issuer == issuer && identity.Subject == subject {
+			i := *identity
+			return &i, nil
+		}
+	}
+	return nil, api.ErrIdentityNotFound
+}
+
+func (s *MemoryStore) DeleteIdentitiesByNick(ctx context.Context, nick string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	kept := s.identities[:0]
+	for _, identity := range s.identities {
+		if identity.Nick != nick {
+			kept = append(kept, identity)
+		}
+	}
+	s.identities = kept
+	return nil
+}
+
+// InsertBlock is a no-op if the user is already blocked
+func (s *MemoryStore) InsertBlock(ctx context.Context, blocker, blocked string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.blocks[blocker] == nil {
+		s.blocks[blocker] = make(map[string]bool)
+	}
+	s.blocks[blocker][blocked] = true
+	return nil
+}
+
+func (s *MemoryStore) DeleteBlock(ctx context.Context, blocker, blocked string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.blocks[blocker], blocked)
+	return nil
+}
+
+func (s *MemoryStore) SelectBlockedNicks(ctx context.Context, blocker string) ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	nicks := []string{}
+	for nick := range s.blocks[blocker] {
+		nicks = append(nicks, nick)
+	}
+	sort.Strings(nicks)
+	return nicks, nil
+}
+
+// DeleteBlocksByNick removes blocks made by the user as well as blocks of the user
+func (s *MemoryStore) DeleteBlocksByNick(ctx context.Context, nick string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.blocks, nick)
+	for _, blocked := range s.blocks {
+		delete(blocked, nick)
+	}
+	return nil
+}
+
+// SelectPreferences returns zero value preferences if the user never saved any
+func (s *MemoryStore) SelectPreferences(ctx context.Context, nick string) (*api.Preferences, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	prefs := api.Preferences{}
+	if stored, ok := s.preferences[nick]; ok {
+		prefs = *stored
+		prefs.MutedChannels = append([]string(nil), stored.MutedChannels...)
+		if stored.ChannelNotifications != nil {
+			prefs.ChannelNotifications = make(map[string]api.NotificationLevel, len(stored.ChannelNotifications))
+			for channel, level := range stored.ChannelNotifications {
+				prefs.ChannelNotifications[channel] = level
+			}
+		}
+	}
+	return &prefs, nil
+}
+
+func (s *MemoryStore) UpsertPreferences(ctx context.Context, nick string, prefs *api.Preferences) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	stored := *prefs
+	stored.MutedChannels = append([]string(nil), prefs.MutedChannels...)
+	stored.ChannelNotifications = make(map[string]api.NotificationLevel, len(prefs.ChannelNotifications))
+	for channel, level := range prefs.ChannelNotifications {
+		stored.ChannelNotifications[channel] = level
+	}
+	s.preferences[nick] = &stored
+	return nil
+}
+
+func (s *MemoryStore) DeletePreferences(ctx context.Context, nick string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.preferences, nick)
+	return nil
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kacperf531/sockchat/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	t.Run("inserts users with unique nicks", func(t *testing.T) {
+		foo := &User{Nick: "Foo", PwHash: "Bar"}
+		require.NoError(t, store.InsertUser(ctx, foo))
+		assert.NotZero(t, foo.ID)
+		require.ErrorIs(t, store.InsertUser(ctx, &User{Nick: "Foo"}), api.ErrNickAlreadyUsed)
+		require.NoError(t, store.InsertUser(ctx, &User{Nick: "Fizz"}))
+
+		user, err := store.SelectUser(ctx, "Foo")
+		require.NoError(t, err)
+		assert.Equal(t, "Bar", user.PwHash)
+		assert.Equal(t, api.RoleUser, user.Role)
+		_, err = store.SelectUser(ctx, "Missing")
+		require.ErrorIs(t, err, api.ErrUserNotFound)
+	})
+
+	t.Run("returned users are not affected by later updates", func(t *testing.T) {
+		user, err := store.SelectUser(ctx, "Foo")
+		require.NoError(t, err)
+		require.NoError(t, store.UpdatePublicProfile(ctx, &api.PublicProfile{Nick: "Foo", DisplayName: "Mr. Foo"}))
+		assert.Empty(t, user.DisplayName)
+		user, err = store.SelectUser(ctx, "Foo")
+		require.NoError(t, err)
+		assert.Equal(t, "Mr. Foo", user.DisplayName)
+	})
+
+	t.Run("searches users listed in the directory", func(t *testing.T) {
+		require.NoError(t, store.InsertUser(ctx, &User{Nick: "Hidden"}))
+		require.NoError(t, store.UpdateHiddenFromDirectory(ctx, "Hidden", true))
+
+		users, err := store.SearchUsers(ctx, &UserQuery{Phrase: "f", Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, users, 2)
+		assert.Equal(t, "Fizz", users[0].Nick)
+
+		users, err = store.SearchUsers(ctx, &UserQuery{Phrase: "mfo", Fuzzy: true, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		assert.Equal(t, "Foo", users[0].Nick)
+
+		users, err = store.SearchUsers(ctx, &UserQuery{Phrase: "hid", Limit: 10})
+		require.NoError(t, err)
+		assert.Empty(t, users)
+	})
+
+	t.Run("renaming a user renames their keys, identities, blocks and preferences", func(t *testing.T) {
+		require.NoError(t, store.InsertAPIKey(ctx, &APIKey{Owner: "Foo", KeyHash: "hash"}))
+		require.NoError(t, store.InsertIdentity(ctx, &Identity{Issuer: "idp", Subject: "1", Nick: "Foo"}))
+		require.NoError(t, store.InsertBlock(ctx, "Foo", "Fizz"))
+		require.NoError(t, store.InsertBlock(ctx, "Fizz", "Foo"))
+		require.NoError(t, store.UpsertPreferences(ctx, "Foo", &api.Preferences{Theme: "dark"}))
+
+		require.ErrorIs(t, store.UpdateNick(ctx, "Foo", "Fizz"), api.ErrNickAlreadyUsed)
+		require.ErrorIs(t, store.UpdateNick(ctx, "Missing", "Buzz"), api.ErrUserNotFound)
+		require.NoError(t, store.UpdateNick(ctx, "Foo", "Buzz"))
+
+		key, err := store.SelectAPIKeyByHash(ctx, "hash")
+		require.NoError(t, err)
+		assert.Equal(t, "Buzz", key.Owner)
+		identity, err := store.SelectIdentity(ctx, "idp", "1")
+		require.NoError(t, err)
+		assert.Equal(t, "Buzz", identity.Nick)
+		blocked, err := store.SelectBlockedNicks(ctx, "Buzz")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Fizz"}, blocked)
+		blocked, err = store.SelectBlockedNicks(ctx, "Fizz")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Buzz"}, blocked)
+		prefs, err := store.SelectPreferences(ctx, "Buzz")
+		require.NoError(t, err)
+		assert.Equal(t, "dark", prefs.Theme)
+	})
+
+	t.Run("revoked api keys are not listed and can't be revoked again", func(t *testing.T) {
+		keys, err := store.SelectAPIKeysByOwner(ctx, "Buzz")
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+		require.NoError(t, store.RevokeAPIKey(ctx, "Buzz", keys[0].ID))
+		require.ErrorIs(t, store.RevokeAPIKey(ctx, "Buzz", keys[0].ID), api.ErrAPIKeyNotFound)
+		keys, err = store.SelectAPIKeysByOwner(ctx, "Buzz")
+		require.NoError(t, err)
+		assert.Empty(t, keys)
+	})
+
+	t.Run("deletes users", func(t *testing.T) {
+		require.NoError(t, store.DeleteUser(ctx, "Buzz"))
+		require.ErrorIs(t, store.DeleteUser(ctx, "Buzz"), api.ErrUserNotFound)
+		users, err := store.SelectUsers(ctx, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, users, 2)
+		assert.Equal(t, "Fizz", users[0].Nick)
+	})
+
+	t.Run("inserts users with the given role", func(t *testing.T) {
+		require.NoError(t, store.InsertUser(ctx, &User{Nick: "Bot", Role: api.RoleService}))
+		user, err := store.SelectUser(ctx, "Bot")
+		require.NoError(t, err)
+		assert.Equal(t, api.RoleService, user.Role)
+	})
+}
@@ -7,6 +7,9 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
 
 	"github.com/elastic/go-elasticsearch/v7"
 	"github.com/elastic/go-elasticsearch/v7/esapi"
@@ -14,6 +17,16 @@ import (
 	"github.com/mitchellh/mapstructure"
 )
 
+const (
+	scrollPageSize  = 1000
+	scrollKeepAlive = time.Minute
+	// documents changed concurrently are skipped by update by query, so it is repeated until none are left
+	maxUpdateByQueryAttempts = 5
+	// Elasticsearch refuses searches paging past index.max_result_window, which defaults to 10000
+	maxResultWindow = 10000
+)
+
+// MessageStore reads and writes messages through the alias set up by MessagesIndex
 type MessageStore struct {
 	es        *elasticsearch.Client
 	indexName string
@@ -27,38 +40,87 @@ type searchQuery struct {
 	Query struct {
 		Bool boolQueryFilter `json:"bool"`
 	} `json:"query"`
-	Sort []timestampOrder `json:"sort,omitempty"`
-	Aggs interface{}      `json:"aggs,omitempty"`
+	Sort           []sortOrder   `json:"sort,omitempty"`
+	SearchAfter    []interface{} `json:"search_after,omitempty"`
+	Aggs           interface{}   `json:"aggs,omitempty"`
+	Highlight      *highlight    `json:"highlight,omitempty"`
+	TrackScores    bool          `json:"track_scores,omitempty"`
+	TrackTotalHits bool          `json:"track_total_hits,omitempty"`
+	From           int           `json:"from,omitempty"`
+	Size           int           `json:"size,omitempty"`
+}
+
+type updateByQuery struct {
+	Query struct {
+		Bool boolQueryFilter `json:"bool"`
+	} `json:"query"`
+	Script struct {
+		Source string         `json:"source"`
+		Lang   string         `json:"lang"`
+		Params map[string]any `json:"params"`
+	} `json:"script"`
+}
+
+type deleteByQuery struct {
+	Query struct {
+		Bool boolQueryFilter `json:"bool"`
+	} `json:"query"`
 }
 
 type boolQueryFilter struct {
-	Filter []filters `json:"filter"`
-	Must   *must     `json:"must,omitempty"`
+	Filter             []filters `json:"filter,omitempty"`
+	Must               *must     `json:"must,omitempty"`
+	MustNot            []filters `json:"must_not,omitempty"`
+	Should             []filters `json:"should,omitempty"`
+	MinimumShouldMatch int       `json:"minimum_should_match,omitempty"`
 }
 
 type must struct {
-	Match struct {
-		Text struct {
-			Query     string `json:"query"`
-			Fuzziness string `json:"fuzziness"`
-		} `json:"text"`
-	} `json:"match"`
+	Match             *match             `json:"match,omitempty"`
+	SimpleQueryString *simpleQueryString `json:"simple_query_string,omitempty"`
+}
+
+type match struct {
+	Text struct {
+		Query     string `json:"query"`
+		Fuzziness string `json:"fuzziness"`
+	} `json:"text"`
+}
+
+// simpleQueryString accepts phrases in quotes and +, |, - operators, never failing on syntax errors
+type simpleQueryString struct {
+	Query           string   `json:"query"`
+	Fields          []string `json:"fields"`
+	DefaultOperator string   `json:"default_operator"`
+}
+
+type highlight struct {
+	Fields map[string]struct{} `json:"fields"`
 }
 
 type filters struct {
-	Term  *term   `json:"term,omitempty"`
-	Range *range_ `json:"range,omitempty"`
+	Term  *term            `json:"term,omitempty"`
+	Terms *terms           `json:"terms,omitempty"`
+	Range *range_          `json:"range,omitempty"`
+	Bool  *boolQueryFilter `json:"bool,omitempty"`
 }
 
 type term struct {
-	Channel *termFilterValue `json:"channel.keyword,omitempty"`
-	Author  *termFilterValue `json:"author.keyword,omitempty"`
+	Channel  *termFilterValue `json:"channel.keyword,omitempty"`
+	Author   *termFilterValue `json:"author.keyword,omitempty"`
+	AuthorID *termIDValue     `json:"author_id,omitempty"`
+}
+
+type terms struct {
+	Channel []string `json:"channel.keyword,omitempty"`
+	Author  []string `json:"author.keyword,omitempty"`
 }
 
 type range_ struct {
 	Timestamp struct {
 		Gte int64 `json:"gte"`
-		Lte int64 `json:"lte"`
+		Lte int64 `json:"lte,omitempty"`
+		Lt  int64 `json:"lt,omitempty"`
 	} `json:"timestamp"`
 }
 
@@ -66,30 +128,92 @@ type termFilterValue struct {
 	Value string `json:"value"`
 }
 
-type timestampOrder struct {
-	Timestamp struct {
-		Order string `json:"order"`
-	} `json:"timestamp"`
+type termIDValue struct {
+	Value int64 `json:"value"`
+}
+
+// authorFilter matches messages by id of the author; messages indexed before ids were stored are matched by nick
+func authorFilter(author string, authorID int64) filters {
+	byNick := filters{Term: &term{Author: &termFilterValue{Value: author}}}
+	if authorID == 0 {
+		return byNick
+	}
+	byID := filters{Term: &term{AuthorID: &termIDValue{Value: authorID}}}
+	return filters{Bool: &boolQueryFilter{Should: []filters{byID, byNick}, MinimumShouldMatch: 1}}
 }
 
-func (s *MessageStore) buildSearchQuery(channel, soughtPhrase string) (*bytes.Reader, error) {
+type fieldOrder struct {
+	Order string `json:"order"`
+	// Missing and UnmappedType order documents indexed before the field was stored
+	Missing      any    `json:"missing,omitempty"`
+	UnmappedType string `json:"unmapped_type,omitempty"`
+}
+
+type sortOrder map[string]fieldOrder
+
+func orderBy(field, order string) sortOrder {
+	return sortOrder{field: {Order: order}}
+}
+
+// searchHit is a message together with its seq, which orders messages sent at the same second
+type searchHit struct {
+	Seq     int64
+	Message *api.MessageEvent
+}
+
+// messageDocument is a message as stored in ES
+type messageDocument struct {
+	*api.MessageEvent
+	Seq int64 `json:"seq"`
+}
+
+var lastSeq atomic.Int64
+
+// NextSeq returns increasing numbers ordering messages stored at the same second; they are microseconds since epoch
+// unless messages come faster, so that they keep increasing across restarts and fit in float64 of decoded JSON
+func NextSeq() int64 {
+	for {
+		last := lastSeq.Load()
+		next := time.Now().UnixMicro()
+		if next <= last {
+			next = last + 1
+		}
+		if lastSeq.CompareAndSwap(last, next) {
+			return next
+		}
+	}
+}
+
+func (s *MessageStore) buildSearchQuery(query *api.HistoryQuery, order string, cursor *api.HistoryCursor) (*bytes.Reader, error) {
 	var q searchQuery
 
-	if soughtPhrase != "" {
-		m := &must{}
-		m.Match.Text.Query = soughtPhrase
-		m.Match.Text.Fuzziness = "AUTO"
-		q.Query.Bool.Must = m
+	if query.Search != "" {
+		m := &match{}
+		m.Text.Query = query.Search
+		m.Text.Fuzziness = "AUTO"
+		q.Query.Bool.Must = &must{Match: m}
 	}
 
 	filter := filters{
-		Term: &term{Channel: &termFilterValue{Value: channel}},
+		Term: &term{Channel: &termFilterValue{Value: query.Channel}},
 	}
 	q.Query.Bool.Filter = []filters{filter}
+	if query.From > 0 {
+		var rf range_
+		rf.Timestamp.Gte = query.From
+		q.Query.Bool.Filter = append(q.Query.Bool.Filter, filters{Range: &rf})
+	}
+	if len(query.ExcludedAuthors) > 0 {
+		q.Query.Bool.MustNot = []filters{{Terms: &terms{Author: query.ExcludedAuthors}}}
+	}
 
-	var ts timestampOrder
-	ts.Timestamp.Order = "desc"
-	q.Sort = []timestampOrder{ts}
+	// messages indexed before seq was stored come first among messages sent at the same second
+	q.Sort = []sortOrder{orderBy("timestamp", order), {"seq": {Order: order, Missing: 0, UnmappedType: "long"}}}
+	if cursor != nil {
+		q.SearchAfter = []interface{}{cursor.Timestamp, cursor.Seq}
+	}
+	// one more message than requested tells if there is a next page
+	q.Size = query.Limit + 1
 
 	qJson, err := json.Marshal(&q)
 	if err != nil {
@@ -100,13 +224,12 @@ func (s *MessageStore) buildSearchQuery(channel, soughtPhrase string) (*bytes.Re
 	return bytes.NewReader(qJson), nil
 }
 
-func (s *MessageStore) runSearchQuery(ctx context.Context, query io.Reader) (api.ChannelHistory, error) {
+func (s *MessageStore) runSearchQuery(ctx context.Context, query io.Reader) ([]searchHit, error) {
 
 	res, err := s.es.Search(
 		s.es.Search.WithContext(ctx),
 		s.es.Search.WithIndex(s.indexName),
 		s.es.Search.WithBody(query),
-		s.es.Search.WithTrackTotalHits(true),
 		s.es.Search.WithPretty(),
 	)
 	if err != nil {
@@ -122,7 +245,7 @@ func (s *MessageStore) runSearchQuery(ctx context.Context, query io.Reader) (api
 			return nil, err
 		} else {
 			log.Printf("error returned from es: %s", e["error"].(map[string]interface{})["reason"])
-			return nil, err
+			return nil, fmt.Errorf("es responded with %s", res.Status())
 		}
 	}
 
@@ -131,12 +254,14 @@ func (s *MessageStore) runSearchQuery(ctx context.Context, query io.Reader) (api
 		log.Printf("error parsing the es response: %s", err)
 		return nil, err
 	}
-	var results api.ChannelHistory
+	var results []searchHit
 	for _, hit := range r["hits"].(map[string]interface{})["hits"].([]interface{}) {
 		msg := &api.MessageEvent{}
-		err := mapstructure.Decode(hit.(map[string]interface{})["_source"], &msg)
+		source := hit.(map[string]interface{})["_source"]
+		err := mapstructure.Decode(source, &msg)
 		if err == nil {
-			results = append(results, msg)
+			seq, _ := source.(map[string]interface{})["seq"].(float64)
+			results = append(results, searchHit{Seq: int64(seq), Message: msg})
 		} else {
 			log.Printf("error decoding message from es: %s", err)
 			return nil, err
@@ -146,28 +271,416 @@ func (s *MessageStore) runSearchQuery(ctx context.Context, query io.Reader) (api
 	return results, nil
 }
 
-func (s *MessageStore) FindMessages(ctx context.Context, channel, phrase string) (api.ChannelHistory, error) {
-	query, err := s.buildSearchQuery(channel, phrase)
+func (s *MessageStore) FindMessages(ctx context.Context, query *api.HistoryQuery) (*api.ChannelHistoryPage, error) {
+	if query.Limit <= 0 {
+		return nil, api.ErrInvalidRequest
+	}
+	if query.Before != "" && query.After != "" {
+		return nil, api.ErrConflictingCursors
+	}
+	// messages after the cursor are sought oldest first, starting right next to it
+	order, encodedCursor := "desc", query.Before
+	if query.After != "" {
+		order, encodedCursor = "asc", query.After
+	}
+	var cursor *api.HistoryCursor
+	if encodedCursor != "" {
+		var err error
+		if cursor, err = api.DecodeCursor(encodedCursor); err != nil {
+			return nil, err
+		}
+	}
+
+	q, err := s.buildSearchQuery(query, order, cursor)
+	if err != nil {
+		return nil, api.ErrInvalidRequest
+	}
+	hits, err := s.runSearchQuery(ctx, q)
+	if err != nil {
+		return nil, api.ErrInternal
+	}
+
+	page := &api.ChannelHistoryPage{Messages: make(api.ChannelHistory, 0, len(hits))}
+	if len(hits) > query.Limit {
+		hits = hits[:query.Limit]
+		last := hits[len(hits)-1]
+		page.NextCursor = api.EncodeCursor(&api.HistoryCursor{Timestamp: last.Message.Timestamp, Seq: last.Seq})
+	}
+	for _, hit := range hits {
+		page.Messages = append(page.Messages, hit.Message)
+	}
+	if order == "asc" {
+		for i, j := 0, len(page.Messages)-1; i < j; i, j = i+1, j-1 {
+			page.Messages[i], page.Messages[j] = page.Messages[j], page.Messages[i]
+		}
+	}
+	return page, nil
+}
+
+func (s *MessageStore) SearchMessages(ctx context.Context, req *api.SearchMessagesRequest) (*api.SearchMessagesResponse, error) {
+	if req.Offset < 0 || req.Limit < 0 || req.Offset+req.Limit > maxResultWindow {
+		return nil, api.ErrInvalidRequest
+	}
+	var q searchQuery
+	if req.Query != "" {
+		q.Query.Bool.Must = &must{SimpleQueryString: &simpleQueryString{Query: req.Query, Fields: []string{"text"}, DefaultOperator: "and"}}
+		q.Highlight = &highlight{Fields: map[string]struct{}{"text": {}}}
+	}
+	q.Query.Bool.Filter = []filters{{Terms: &terms{Channel: req.Channels}}}
+	if len(req.Authors) > 0 {
+		q.Query.Bool.Filter = append(q.Query.Bool.Filter, filters{Terms: &terms{Author: req.Authors}})
+	}
+	if req.From > 0 || req.To > 0 {
+		var rf range_
+		rf.Timestamp.Gte = req.From
+		rf.Timestamp.Lte = req.To
+		q.Query.Bool.Filter = append(q.Query.Bool.Filter, filters{Range: &rf})
+	}
+	if req.Sort == api.SortByTime {
+		q.Sort = []sortOrder{orderBy("timestamp", "desc")}
+		q.TrackScores = true
+	} else {
+		q.Sort = []sortOrder{orderBy("_score", "desc"), orderBy("timestamp", "desc")}
+	}
+	q.TrackTotalHits = true
+	q.From = req.Offset
+	q.Size = req.Limit
+	qJson, err := json.Marshal(&q)
 	if err != nil {
 		return nil, api.ErrInvalidRequest
 	}
-	results, err := s.runSearchQuery(ctx, query)
+
+	res, err := s.es.Search(
+		s.es.Search.WithContext(ctx),
+		s.es.Search.WithIndex(s.indexName),
+		s.es.Search.WithBody(bytes.NewReader(qJson)),
+	)
 	if err != nil {
+		log.Printf("Error getting response: %s", err)
 		return nil, api.ErrInternal
 	}
+	defer res.Body.Close()
+	if res.IsError() {
+		log.Printf("error returned from es: %s", res.String())
+		return nil, api.ErrInternal
+	}
+	var r struct {
+		Hits struct {
+			Total struct {
+				Value int64 `json:"value"`
+			} `json:"total"`
+			Hits []struct {
+				Score     float64           `json:"_score"`
+				Source    *api.MessageEvent `json:"_source"`
+				Highlight struct {
+					Text []string `json:"text"`
+				} `json:"highlight"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		log.Printf("error parsing the es response: %s", err)
+		return nil, api.ErrInternal
+	}
+	results := &api.SearchMessagesResponse{Total: r.Hits.Total.Value, Results: make([]*api.SearchResult, len(r.Hits.Hits))}
+	for i, hit := range r.Hits.Hits {
+		results.Results[i] = &api.SearchResult{Message: hit.Source, Score: hit.Score, Highlights: hit.Highlight.Text}
+	}
 	return results, nil
 }
+
+// FindMessagesByAuthor returns all messages of the author in all channels, oldest first
+func (s *MessageStore) FindMessagesByAuthor(ctx context.Context, author string, authorID int64) (api.ChannelHistory, error) {
+	var q searchQuery
+	q.Query.Bool.Filter = []filters{authorFilter(author, authorID)}
+	q.Sort = []sortOrder{orderBy("timestamp", "asc")}
+	q.Size = scrollPageSize
+	qJson, err := json.Marshal(&q)
+	if err != nil {
+		return nil, api.ErrInvalidRequest
+	}
+
+	res, err := s.es.Search(
+		s.es.Search.WithContext(ctx),
+		s.es.Search.WithIndex(s.indexName),
+		s.es.Search.WithBody(bytes.NewReader(qJson)),
+		s.es.Search.WithScroll(scrollKeepAlive),
+	)
+	if err != nil {
+		log.Printf("Error getting response: %s", err)
+		return nil, api.ErrInternal
+	}
+	var results api.ChannelHistory
+	var scrollID string
+	defer func() {
+		if scrollID != "" {
+			s.es.ClearScroll(s.es.ClearScroll.WithContext(ctx), s.es.ClearScroll.WithScrollID(scrollID))
+		}
+	}()
+	for {
+		var page api.ChannelHistory
+		scrollID, page, err = decodeScrollPage(res)
+		if err != nil {
+			return nil, api.ErrInternal
+		}
+		if len(page) == 0 {
+			return results, nil
+		}
+		results = append(results, page...)
+		res, err = s.es.Scroll(
+			s.es.Scroll.WithContext(ctx),
+			s.es.Scroll.WithScrollID(scrollID),
+			s.es.Scroll.WithScroll(scrollKeepAlive),
+		)
+		if err != nil {
+			log.Printf("Error getting response: %s", err)
+			return nil, api.ErrInternal
+		}
+	}
+}
+
+func decodeScrollPage(res *esapi.Response) (string, api.ChannelHistory, error) {
+	defer res.Body.Close()
+	if res.IsError() {
+		log.Printf("error returned from es: %s", res.String())
+		return "", nil, fmt.Errorf("es responded with %s", res.Status())
+	}
+	var r struct {
+		ScrollID string `json:"_scroll_id"`
+		Hits     struct {
+			Hits []struct {
+				Source *api.MessageEvent `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		log.Printf("error parsing the es response: %s", err)
+		return "", nil, err
+	}
+	page := make(api.ChannelHistory, len(r.Hits.Hits))
+	for i, hit := range r.Hits.Hits {
+		page[i] = hit.Source
+	}
+	return r.ScrollID, page, nil
+}
+
+// AnonymizeMessagesByAuthor replaces author of all their messages with api.DeletedUserNick
+func (s *MessageStore) AnonymizeMessagesByAuthor(ctx context.Context, author string, authorID int64) error {
+	var q updateByQuery
+	q.Query.Bool.Filter = []filters{authorFilter(author, authorID)}
+	q.Script.Source = "ctx._source.author = params.author; ctx._source.remove('author_id')"
+	q.Script.Lang = "painless"
+	q.Script.Params = map[string]any{"author": api.DeletedUserNick}
+	return s.updateByQuery(ctx, &q)
+}
+
+// RenameAuthor sets the new nick as author of all messages sent under the old one, storing the author's id along
+func (s *MessageStore) RenameAuthor(ctx context.Context, author string, authorID int64, newNick string) error {
+	var q updateByQuery
+	q.Query.Bool.Filter = []filters{authorFilter(author, authorID)}
+	q.Script.Source = "ctx._source.author = params.author; ctx._source.author_id = params.author_id"
+	q.Script.Lang = "painless"
+	q.Script.Params = map[string]any{"author": newNick, "author_id": authorID}
+	return s.updateByQuery(ctx, &q)
+}
+
+func (s *MessageStore) updateByQuery(ctx context.Context, q *updateByQuery) error {
+	qJson, err := json.Marshal(q)
+	if err != nil {
+		return api.ErrInvalidRequest
+	}
+
+	for attempt := 0; attempt < maxUpdateByQueryAttempts; attempt++ {
+		conflicts, err := s.runUpdateByQuery(ctx, qJson)
+		if err != nil {
+			return err
+		}
+		if conflicts == 0 {
+			return nil
+		}
+		log.Printf("%d messages changed during update by query, retrying", conflicts)
+	}
+	log.Printf("messages still changed concurrently after %d attempts of update by query", maxUpdateByQueryAttempts)
+	return api.ErrInternal
+}
+
+// runUpdateByQuery returns the number of documents skipped because of version conflicts
+func (s *MessageStore) runUpdateByQuery(ctx context.Context, qJson []byte) (int, error) {
+	res, err := s.es.UpdateByQuery(
+		[]string{s.indexName},
+		s.es.UpdateByQuery.WithContext(ctx),
+		s.es.UpdateByQuery.WithBody(bytes.NewReader(qJson)),
+		s.es.UpdateByQuery.WithConflicts("proceed"),
+		s.es.UpdateByQuery.WithRefresh(true),
+	)
+	if err != nil {
+		log.Printf("Error getting response: %s", err)
+		return 0, api.ErrInternal
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		log.Printf("error returned from es: %s", res.String())
+		return 0, api.ErrInternal
+	}
+	var r struct {
+		VersionConflicts int               `json:"version_conflicts"`
+		Failures         []json.RawMessage `json:"failures"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		log.Printf("error parsing the es response: %s", err)
+		return 0, api.ErrInternal
+	}
+	if len(r.Failures) > 0 {
+		log.Printf("update by query failed for %d messages: %s", len(r.Failures), r.Failures[0])
+		return 0, api.ErrInternal
+	}
+	return r.VersionConflicts, nil
+}
+
+// ExpiredMessages selects messages sent before the timestamp, in given channels or all channels but the excluded ones
+type ExpiredMessages struct {
+	Channels         []string
+	ExcludedChannels []string
+	Before           int64
+}
+
+// MessagePurger deletes messages past their retention
+type MessagePurger interface {
+	// DeleteMessages returns the number of deleted messages
+	DeleteMessages(ctx context.Context, expired *ExpiredMessages) (int64, error)
+}
+
+func (s *MessageStore) DeleteMessages(ctx context.Context, expired *ExpiredMessages) (int64, error) {
+	var q deleteByQuery
+	var rf range_
+	rf.Timestamp.Lt = expired.Before
+	q.Query.Bool.Filter = []filters{{Range: &rf}}
+	if len(expired.Channels) > 0 {
+		q.Query.Bool.Filter = append(q.Query.Bool.Filter, filters{Terms: &terms{Channel: expired.Channels}})
+	}
+	if len(expired.ExcludedChannels) > 0 {
+		q.Query.Bool.MustNot = []filters{{Terms: &terms{Channel: expired.ExcludedChannels}}}
+	}
+	qJson, err := json.Marshal(&q)
+	if err != nil {
+		return 0, api.ErrInvalidRequest
+	}
+
+	res, err := s.es.DeleteByQuery(
+		[]string{s.indexName},
+		bytes.NewReader(qJson),
+		s.es.DeleteByQuery.WithContext(ctx),
+		s.es.DeleteByQuery.WithConflicts("proceed"),
+		s.es.DeleteByQuery.WithRefresh(true),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("could not delete messages due to DB error: %v", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return 0, fmt.Errorf("es responded with %s", res.String())
+	}
+	var r struct {
+		Deleted int64 `json:"deleted"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return 0, fmt.Errorf("could not unmarshal response from DB when deleting messages: %v", err)
+	}
+	return r.Deleted, nil
+}
+
+// BulkMessage is a message queued for indexing; its id is chosen upfront, so that retried batches don't duplicate messages,
+// and so is its seq, so that spooled messages keep their order
+type BulkMessage struct {
+	ID      string            `json:"id"`
+	Seq     int64             `json:"seq,omitempty"`
+	Message *api.MessageEvent `json:"message"`
+}
+
+// BulkIndexer indexes batches of messages
+type BulkIndexer interface {
+	// BulkIndex returns messages which failed temporarily and may be retried; messages rejected by ES are dropped
+	BulkIndex(ctx context.Context, batch []*BulkMessage) ([]*BulkMessage, error)
+}
+
+type bulkAction struct {
+	Index struct {
+		ID string `json:"_id"`
+	} `json:"index"`
+}
+
+func (s *MessageStore) BulkIndex(ctx context.Context, batch []*BulkMessage) ([]*BulkMessage, error) {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, msg := range batch {
+		var action bulkAction
+		action.Index.ID = msg.ID
+		if err := enc.Encode(&action); err != nil {
+			return nil, err
+		}
+		seq := msg.Seq
+		if seq == 0 {
+			// spooled before seq was chosen upfront
+			seq = NextSeq()
+		}
+		if err := enc.Encode(&messageDocument{MessageEvent: msg.Message, Seq: seq}); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := s.es.Bulk(
+		&body,
+		s.es.Bulk.WithContext(ctx),
+		s.es.Bulk.WithIndex(s.indexName),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not index messages due to DB error: %v", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("es responded with %s", res.Status())
+	}
+	var r struct {
+		Errors bool `json:"errors"`
+		Items  []struct {
+			Index struct {
+				Status int `json:"status"`
+				Error  struct {
+					Reason string `json:"reason"`
+				} `json:"error"`
+			} `json:"index"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("could not unmarshal response from DB when indexing messages: %v", err)
+	}
+	if !r.Errors {
+		return nil, nil
+	}
+	var failed []*BulkMessage
+	for i, item := range r.Items {
+		if i >= len(batch) || item.Index.Status < http.StatusMultipleChoices {
+			continue
+		}
+		if item.Index.Status == http.StatusTooManyRequests || item.Index.Status >= http.StatusInternalServerError {
+			failed = append(failed, batch[i])
+		} else {
+			log.Printf("warning: message %s rejected by es: %s", batch[i].ID, item.Index.Error.Reason)
+		}
+	}
+	return failed, nil
+}
+
 func (s *MessageStore) IndexMessage(msg *api.MessageEvent) (string, error) {
-	data, err := json.Marshal(msg)
+	data, err := json.Marshal(&messageDocument{MessageEvent: msg, Seq: NextSeq()})
 	if err != nil {
 		return "", api.ErrInvalidRequest
 	}
 
 	req := esapi.IndexRequest{
-		Index:        s.indexName,
-		DocumentType: "message",
-		Body:         bytes.NewReader(data),
-		Refresh:      "true",
+		Index:   s.indexName,
+		Body:    bytes.NewReader(data),
+		Refresh: "true",
 	}
 
 	res, err := req.Do(context.Background(), s.es)
@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+)
+
+// messagesIndexTemplate holds settings & mappings of every monthly index of messages
+//
+//go:embed messages_index.json
+var messagesIndexTemplate []byte
+
+const (
+	messagesIndexMonthLayout = "2006.01"
+	messagesTemplatePriority = 100
+)
+
+// MessagesIndex manages indices of messages. The app reads and writes through the alias only;
+// a new index is started every month, the older ones stay behind the alias for reading.
+type MessagesIndex struct {
+	es    *elasticsearch.Client
+	alias string
+}
+
+func NewMessagesIndex(es *elasticsearch.Client, alias string) *MessagesIndex {
+	return &MessagesIndex{es, alias}
+}
+
+// Setup installs the index template and creates index of the current month if the alias doesn't exist yet.
+// A concrete index named like the alias, left by versions writing to a single index, is migrated first.
+func (m *MessagesIndex) Setup(ctx context.Context, now time.Time) error {
+	template, err := json.Marshal(map[string]any{
+		"index_patterns": []string{m.alias + "-*"},
+		"template":       json.RawMessage(messagesIndexTemplate),
+		"priority":       messagesTemplatePriority,
+	})
+	if err != nil {
+		return err
+	}
+	res, err := m.es.Indices.PutIndexTemplate(m.alias, bytes.NewReader(template), m.es.Indices.PutIndexTemplate.WithContext(ctx))
+	if err := checkIndexResponse(res, err); err != nil {
+		return fmt.Errorf("could not install template of messages index: %w", err)
+	}
+
+	res, err = m.es.Indices.ExistsAlias([]string{m.alias}, m.es.Indices.ExistsAlias.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("could not check alias of messages index: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return m.Rollover(ctx, now)
+	}
+
+	res, err = m.es.Indices.Exists([]string{m.alias}, m.es.Indices.Exists.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("could not check messages index: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return m.migrate(ctx, now)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"aliases": map[string]any{m.alias: map[string]bool{"is_write_index": true}},
+	})
+	if err != nil {
+		return err
+	}
+	res, err = m.es.Indices.Create(m.indexOf(now), m.es.Indices.Create.WithBody(bytes.NewReader(body)), m.es.Indices.Create.WithContext(ctx))
+	if err := checkIndexResponse(res, err); err != nil {
+		return fmt.Errorf("could not create messages index: %w", err)
+	}
+	return nil
+}
+
+// migrate reindexes messages of the concrete index named like the alias into index of the current month,
+// then deletes the old index and adds the alias in one step, so that the name is never left unresolved.
+// It runs before the app starts writing, messages written by other instances meanwhile are not carried over.
+func (m *MessagesIndex) migrate(ctx context.Context, now time.Time) error {
+	target := m.indexOf(now)
+	log.Printf("migrating messages from index %s to %s", m.alias, target)
+	res, err := m.es.Indices.Exists([]string{target}, m.es.Indices.Exists.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("could not check messages index: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		// created up front, so that the template applies even if auto creation of indices is disabled
+		res, err = m.es.Indices.Create(target, m.es.Indices.Create.WithContext(ctx))
+		if err := checkIndexResponse(res, err); err != nil {
+			return fmt.Errorf("could not create messages index: %w", err)
+		}
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"source": map[string]string{"index": m.alias},
+		"dest":   map[string]string{"index": target},
+	})
+	if err != nil {
+		return err
+	}
+	res, err = m.es.Reindex(bytes.NewReader(body),
+		m.es.Reindex.WithWaitForCompletion(true),
+		m.es.Reindex.WithRefresh(true),
+		m.es.Reindex.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("could not reindex messages into %s: %w", target, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("could not reindex messages into %s: es responded with %s", target, res.String())
+	}
+	var reindexed struct {
+		Total    int               `json:"total"`
+		Failures []json.RawMessage `json:"failures"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&reindexed); err != nil {
+		return fmt.Errorf("could not decode reindex response: %w", err)
+	}
+	// the old index is kept, so that the migration can be repeated
+	if len(reindexed.Failures) > 0 {
+		return fmt.Errorf("could not reindex messages into %s: %d failures, first: %s", target, len(reindexed.Failures), reindexed.Failures[0])
+	}
+
+	body, err = json.Marshal(map[string]any{
+		"actions": []map[string]any{
+			{"remove_index": map[string]string{"index": m.alias}},
+			{"add": map[string]any{"index": target, "alias": m.alias, "is_write_index": true}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	res, err = m.es.Indices.UpdateAliases(bytes.NewReader(body), m.es.Indices.UpdateAliases.WithContext(ctx))
+	if err := checkIndexResponse(res, err); err != nil {
+		return fmt.Errorf("could not replace index %s with alias: %w", m.alias, err)
+	}
+	log.Printf("migrated %d messages to %s, %s is now an alias", reindexed.Total, target, m.alias)
+	return nil
+}
+
+// Rollover switches the alias to a new write index if the current one was started in a previous month
+func (m *MessagesIndex) Rollover(ctx context.Context, now time.Time) error {
+	current, err := m.writeIndex(ctx)
+	if err != nil {
+		return err
+	}
+	next := m.indexOf(now)
+	// names sort by month, so a clock set back doesn't roll over to an older index
+	if current >= next {
+		return nil
+	}
+	res, err := m.es.Indices.Rollover(m.alias, m.es.Indices.Rollover.WithNewIndex(next), m.es.Indices.Rollover.WithContext(ctx))
+	if err := checkIndexResponse(res, err); err != nil {
+		return fmt.Errorf("could not roll over messages index to %s: %w", next, err)
+	}
+	log.Printf("messages are now written to %s", next)
+	return nil
+}
+
+// RunRollover checks in intervals if the month has changed, until the context is done
+func (m *MessagesIndex) RunRollover(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := m.Rollover(ctx, now); err != nil {
+				log.Printf("error rolling over messages index: %v", err)
+			}
+		}
+	}
+}
+
+func (m *MessagesIndex) indexOf(t time.Time) string {
+	return m.alias + "-" + t.UTC().Format(messagesIndexMonthLayout)
+}
+
+func (m *MessagesIndex) writeIndex(ctx context.Context) (string, error) {
+	res, err := m.es.Indices.GetAlias(m.es.Indices.GetAlias.WithName(m.alias), m.es.Indices.GetAlias.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("could not get alias of messages index: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return "", fmt.Errorf("could not get alias of messages index: es responded with %s", res.Status())
+	}
+	var indices map[string]struct {
+		Aliases map[string]struct {
+			IsWriteIndex bool `json:"is_write_index"`
+		} `json:"aliases"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&indices); err != nil {
+		return "", fmt.Errorf("could not decode alias of messages index: %w", err)
+	}
+	for index, v := range indices {
+		// an alias of a single index has no write index flag
+		if v.Aliases[m.alias].IsWriteIndex || len(indices) == 1 {
+			return index, nil
+		}
+	}
+	return "", fmt.Errorf("alias %s has no write index", m.alias)
+}
+
+func checkIndexResponse(res *esapi.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("es responded with %s", res.String())
+	}
+	return nil
+}
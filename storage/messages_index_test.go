@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kacperf531/sockchat/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessagesIndex(t *testing.T) {
+	es := mustSetUpES(t)
+	ctx := context.Background()
+	messagesIndex := setUpMessagesIndex(t, es, "test_messages_index")
+	store := NewMessageStore(es, "test_messages_index")
+	now := time.Now()
+
+	t.Run("writes to index of the current month", func(t *testing.T) {
+		index, err := messagesIndex.writeIndex(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "test_messages_index-"+now.UTC().Format("2006.01"), index)
+	})
+
+	t.Run("setup is idempotent", func(t *testing.T) {
+		require.NoError(t, messagesIndex.Setup(ctx, now))
+		index, err := messagesIndex.writeIndex(ctx)
+		require.NoError(t, err)
+		require.Equal(t, messagesIndex.indexOf(now), index)
+	})
+
+	t.Run("rolls over to index of the next month", func(t *testing.T) {
+		_, err := store.IndexMessage(&api.MessageEvent{Channel: "Foo", Author: "Bar", Text: "before rollover", Timestamp: now.Unix()})
+		require.NoError(t, err)
+
+		nextMonth := now.AddDate(0, 1, 0)
+		require.NoError(t, messagesIndex.Rollover(ctx, nextMonth))
+		index, err := messagesIndex.writeIndex(ctx)
+		require.NoError(t, err)
+		require.Equal(t, messagesIndex.indexOf(nextMonth), index)
+
+		_, err = store.IndexMessage(&api.MessageEvent{Channel: "Foo", Author: "Bar", Text: "after rollover", Timestamp: now.Unix() + 1})
+		require.NoError(t, err)
+
+		page, err := store.FindMessages(ctx, &api.HistoryQuery{Channel: "Foo", Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, page.Messages, 2)
+	})
+
+	t.Run("doesn't roll back to an older month", func(t *testing.T) {
+		require.NoError(t, messagesIndex.Rollover(ctx, now))
+		index, err := messagesIndex.writeIndex(ctx)
+		require.NoError(t, err)
+		require.Equal(t, messagesIndex.indexOf(now.AddDate(0, 1, 0)), index)
+	})
+}
+
+func TestMessagesIndexMigration(t *testing.T) {
+	es := mustSetUpES(t)
+	ctx := context.Background()
+	alias := "test_messages_index_migration"
+	_, err := es.Indices.Delete([]string{alias, alias + "-*"}, es.Indices.Delete.WithIgnoreUnavailable(true))
+	require.NoError(t, err)
+	res, err := es.Indices.Create(alias, es.Indices.Create.WithBody(bytes.NewReader(messagesIndexTemplate)))
+	require.NoError(t, checkIndexResponse(res, err))
+	store := NewMessageStore(es, alias)
+	_, err = store.IndexMessage(&api.MessageEvent{Channel: "Foo", Author: "Bar", Text: "before migration", Timestamp: 1})
+	require.NoError(t, err)
+
+	messagesIndex := NewMessagesIndex(es, alias)
+	now := time.Now()
+	require.NoError(t, messagesIndex.Setup(ctx, now))
+
+	t.Run("replaces the index with alias of index of the current month", func(t *testing.T) {
+		index, err := messagesIndex.writeIndex(ctx)
+		require.NoError(t, err)
+		require.Equal(t, messagesIndex.indexOf(now), index)
+	})
+
+	t.Run("keeps messages of the old index", func(t *testing.T) {
+		page, err := store.FindMessages(ctx, &api.HistoryQuery{Channel: "Foo", Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, page.Messages, 1)
+		require.Equal(t, "before migration", page.Messages[0].Text)
+	})
+
+	t.Run("setup after migration is idempotent", func(t *testing.T) {
+		require.NoError(t, messagesIndex.Setup(ctx, now))
+		_, err = store.IndexMessage(&api.MessageEvent{Channel: "Foo", Author: "Bar", Text: "after migration", Timestamp: 2})
+		require.NoError(t, err)
+		page, err := store.FindMessages(ctx, &api.HistoryQuery{Channel: "Foo", Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, page.Messages, 2)
+	})
+}
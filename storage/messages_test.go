@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
 
@@ -15,6 +16,7 @@ func TestMessageStore(t *testing.T) {
 	godotenv.Load("../.env")
 
 	es := mustSetUpES(t)
+	setUpMessagesIndex(t, es, "test_messages")
 	store := &MessageStore{es, "test_messages"}
 
 	t.Run("can index new message into ES", func(t *testing.T) {
@@ -23,19 +25,158 @@ func TestMessageStore(t *testing.T) {
 	})
 
 	t.Run("can get messages by channel", func(t *testing.T) {
-		messages, err := store.FindMessages(context.Background(), "Foo", "")
+		page, err := store.FindMessages(context.Background(), &api.HistoryQuery{Channel: "Foo", Limit: 10})
 		require.NoError(t, err)
-		require.NotEmpty(t, messages)
+		require.NotEmpty(t, page.Messages)
 	})
 
 	t.Run("can search messages in channel by phrase", func(t *testing.T) {
 		// positive case
-		messages, err := store.FindMessages(context.Background(), "Foo", "FooBarBax")
+		page, err := store.FindMessages(context.Background(), &api.HistoryQuery{Channel: "Foo", Search: "FooBarBax", Limit: 10})
 		require.NoError(t, err)
-		require.NotEmpty(t, messages)
+		require.NotEmpty(t, page.Messages)
 
 		// negative case
-		messages, err = store.FindMessages(context.Background(), "Foo", "FooBarQux")
+		page, err = store.FindMessages(context.Background(), &api.HistoryQuery{Channel: "Foo", Search: "FooBarQux", Limit: 10})
+		require.NoError(t, err)
+		require.Empty(t, page.Messages)
+	})
+
+	t.Run("can exclude messages of given authors", func(t *testing.T) {
+		page, err := store.FindMessages(context.Background(), &api.HistoryQuery{Channel: "Foo", Search: "FooBarBaz", ExcludedAuthors: []string{"Bar"}, Limit: 10})
+		require.NoError(t, err)
+		require.Empty(t, page.Messages)
+	})
+
+	t.Run("can page through messages with cursors", func(t *testing.T) {
+		channel := fmt.Sprintf("Paged%d", time.Now().UnixNano())
+		sent := time.Now().Unix()
+		// messages sent within the same second are ordered as they were stored
+		for _, text := range []string{"one", "two", "three"} {
+			_, err := store.IndexMessage(&api.MessageEvent{Channel: channel, Author: "Bar", Text: text, Timestamp: sent})
+			require.NoError(t, err)
+		}
+
+		first, err := store.FindMessages(context.Background(), &api.HistoryQuery{Channel: channel, Limit: 2})
+		require.NoError(t, err)
+		require.Len(t, first.Messages, 2)
+		require.Equal(t, "three", first.Messages[0].Text)
+		require.Equal(t, "two", first.Messages[1].Text)
+		require.NotEmpty(t, first.NextCursor)
+
+		second, err := store.FindMessages(context.Background(), &api.HistoryQuery{Channel: channel, Limit: 2, Before: first.NextCursor})
+		require.NoError(t, err)
+		require.Len(t, second.Messages, 1)
+		require.Equal(t, "one", second.Messages[0].Text)
+		require.Empty(t, second.NextCursor)
+
+		newer, err := store.FindMessages(context.Background(), &api.HistoryQuery{Channel: channel, Limit: 2, After: first.NextCursor})
+		require.NoError(t, err)
+		require.Equal(t, first.Messages[:1], newer.Messages)
+	})
+
+	t.Run("can search messages with filters and highlights", func(t *testing.T) {
+		channel := fmt.Sprintf("Searched%d", time.Now().UnixNano())
+		_, err := store.IndexMessage(&api.MessageEvent{Channel: channel, Author: "Foo", Text: "quick brown fox", Timestamp: 100})
+		require.NoError(t, err)
+		_, err = store.IndexMessage(&api.MessageEvent{Channel: channel, Author: "Bar", Text: "lazy brown dog", Timestamp: 200})
+		require.NoError(t, err)
+
+		res, err := store.SearchMessages(context.Background(), &api.SearchMessagesRequest{Query: `"brown fox"`, Channels: []string{channel}, Limit: 10})
+		require.NoError(t, err)
+		require.Equal(t, int64(1), res.Total)
+		require.Equal(t, "Foo", res.Results[0].Message.Author)
+		require.Positive(t, res.Results[0].Score)
+		require.Contains(t, res.Results[0].Highlights[0], "<em>brown</em>")
+
+		res, err = store.SearchMessages(context.Background(), &api.SearchMessagesRequest{Query: "brown -fox", Channels: []string{channel}, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, res.Results, 1)
+		require.Equal(t, "Bar", res.Results[0].Message.Author)
+
+		res, err = store.SearchMessages(context.Background(), &api.SearchMessagesRequest{Channels: []string{channel}, Authors: []string{"Foo", "Bar"}, From: 150, Sort: api.SortByTime, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, res.Results, 1)
+		require.Equal(t, int64(200), res.Results[0].Message.Timestamp)
+	})
+
+	t.Run("can bulk index messages with given ids", func(t *testing.T) {
+		channel := fmt.Sprintf("Bulk%d", time.Now().UnixNano())
+		batch := []*BulkMessage{
+			{ID: channel + "-1", Message: &api.MessageEvent{Channel: channel, Author: "Foo", Text: "first", Timestamp: 1}},
+			{ID: channel + "-2", Message: &api.MessageEvent{Channel: channel, Author: "Foo", Text: "second", Timestamp: 2}},
+		}
+		failed, err := store.BulkIndex(context.Background(), batch)
+		require.NoError(t, err)
+		require.Empty(t, failed)
+		// retried batches overwrite messages instead of duplicating them
+		_, err = store.BulkIndex(context.Background(), batch)
+		require.NoError(t, err)
+
+		_, err = es.Indices.Refresh(es.Indices.Refresh.WithIndex(store.indexName))
+		require.NoError(t, err)
+		page, err := store.FindMessages(context.Background(), &api.HistoryQuery{Channel: channel, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, page.Messages, 2)
+		require.Equal(t, "second", page.Messages[0].Text)
+	})
+
+	t.Run("can delete expired messages", func(t *testing.T) {
+		expiring := fmt.Sprintf("Expiring%d", time.Now().UnixNano())
+		kept := fmt.Sprintf("Kept%d", time.Now().UnixNano())
+		for _, msg := range []*api.MessageEvent{
+			{Channel: expiring, Author: "Foo", Text: "old", Timestamp: 100},
+			{Channel: expiring, Author: "Foo", Text: "new", Timestamp: 300},
+			{Channel: kept, Author: "Foo", Text: "old", Timestamp: 100},
+		} {
+			_, err := store.IndexMessage(msg)
+			require.NoError(t, err)
+		}
+
+		deleted, err := store.DeleteMessages(context.Background(), &ExpiredMessages{Channels: []string{expiring, kept}, ExcludedChannels: []string{kept}, Before: 200})
+		require.NoError(t, err)
+		require.Equal(t, int64(1), deleted)
+		page, err := store.FindMessages(context.Background(), &api.HistoryQuery{Channel: expiring, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, page.Messages, 1)
+		require.Equal(t, "new", page.Messages[0].Text)
+	})
+
+	t.Run("rejects malformed cursor", func(t *testing.T) {
+		_, err := store.FindMessages(context.Background(), &api.HistoryQuery{Channel: "Foo", Limit: 10, Before: "not a cursor"})
+		require.ErrorIs(t, err, api.ErrInvalidCursor)
+	})
+
+	t.Run("can rename author of messages", func(t *testing.T) {
+		author := fmt.Sprintf("Author%d", time.Now().UnixNano())
+		_, err := store.IndexMessage(&api.MessageEvent{Channel: "Foo", Author: author, Text: "legacy", Timestamp: time.Now().Unix()})
+		require.NoError(t, err)
+		_, err = store.IndexMessage(&api.MessageEvent{Channel: "Foo", Author: author, AuthorID: 42, Text: "with id", Timestamp: time.Now().Unix()})
+		require.NoError(t, err)
+
+		require.NoError(t, store.RenameAuthor(context.Background(), author, 42, author+"Renamed"))
+		messages, err := store.FindMessagesByAuthor(context.Background(), author+"Renamed", 42)
+		require.NoError(t, err)
+		require.Len(t, messages, 2)
+		for _, msg := range messages {
+			require.Equal(t, int64(42), msg.AuthorID)
+		}
+	})
+
+	t.Run("can get and anonymize messages by author", func(t *testing.T) {
+		author := fmt.Sprintf("Author%d", time.Now().UnixNano())
+		_, err := store.IndexMessage(&api.MessageEvent{Channel: "Foo", Author: author, Text: "first", Timestamp: time.Now().Unix()})
+		require.NoError(t, err)
+		_, err = store.IndexMessage(&api.MessageEvent{Channel: "Bar", Author: author, Text: "second", Timestamp: time.Now().Unix() + 1})
+		require.NoError(t, err)
+
+		messages, err := store.FindMessagesByAuthor(context.Background(), author, 0)
+		require.NoError(t, err)
+		require.Len(t, messages, 2)
+		require.Equal(t, "first", messages[0].Text)
+
+		require.NoError(t, store.AnonymizeMessagesByAuthor(context.Background(), author, 0))
+		messages, err = store.FindMessagesByAuthor(context.Background(), author, 0)
 		require.NoError(t, err)
 		require.Empty(t, messages)
 	})
@@ -0,0 +1,243 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations of the MySQL schema are embedded as pairs of migrations/<version>_<name>.up.sql and .down.sql scripts.
+// Every statement of a script has to end with a semicolon at the end of a line.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+const (
+	createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT NOT NULL,
+		name      VARCHAR(255) NOT NULL,
+		applied_at      BIGINT NOT NULL,
+		PRIMARY KEY (version)
+	  );`
+	// migrationsLock makes instances started at the same time apply migrations one after another
+	migrationsLock        = "sockchat_schema_migrations"
+	migrationsLockTimeout = 60 // seconds
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+// MigrationStatus tells if and when the migration was applied to the DB
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt int64
+}
+
+// Migrator applies and reverts migrations, keeping track of applied ones in the schema_migrations table.
+// MySQL commits schema changes implicitly, so a migration failing halfway may need fixing by hand.
+type Migrator struct {
+	db         *sql.DB
+	migrations []*Migration
+}
+
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db, migrations}, nil
+}
+
+// loadMigrations reads migrations from the files, ordered by version
+func loadMigrations(files fs.FS) ([]*Migration, error) {
+	paths, err := fs.Glob(files, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, p := range paths {
+		base := strings.TrimSuffix(path.Base(p), ".sql")
+		base, up := strings.CutSuffix(base, ".up")
+		base, down := strings.CutSuffix(base, ".down")
+		versionPart, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionPart)
+		if !ok || err != nil || version <= 0 || up == down {
+			return nil, fmt.Errorf("invalid migration file name %q, expected <version>_<name>.up.sql or .down.sql", p)
+		}
+		script, err := fs.ReadFile(files, p)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		} else if migration.Name != name {
+			return nil, fmt.Errorf("migrations %q and %q have the same version", migration.Name, name)
+		}
+		if up {
+			migration.up = string(script)
+		} else {
+			migration.down = string(script)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.up == "" || migration.down == "" {
+			return nil, fmt.Errorf("migration %d %s needs both up and down scripts", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// splitStatements splits the script into statements, as the driver executes one statement at a time
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		current.WriteString(line + "\n")
+		if strings.HasSuffix(strings.TrimSpace(line), ";") {
+			statements = append(statements, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
+
+// Up applies all migrations not applied yet and returns how many were applied
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+	err := m.withLock(ctx, func(conn *sql.Conn, done map[int]int64) error {
+		for _, migration := range m.migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+			if err := runScript(ctx, conn, migration.up); err != nil {
+				return fmt.Errorf("could not apply migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+			const stmt = "INSERT INTO schema_migrations(version, name, applied_at) VALUES (?, ?, ?);"
+			if _, err := conn.ExecContext(ctx, stmt, migration.Version, migration.Name, time.Now().Unix()); err != nil {
+				return fmt.Errorf("could not record migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+			log.Printf("applied migration %d %s", migration.Version, migration.Name)
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts up to the given number of the latest applied migrations and returns how many were reverted
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	reverted := 0
+	err := m.withLock(ctx, func(conn *sql.Conn, done map[int]int64) error {
+		for i := len(m.migrations) - 1; i >= 0 && reverted < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := done[migration.Version]; !ok {
+				continue
+			}
+			if err := runScript(ctx, conn, migration.down); err != nil {
+				return fmt.Errorf("could not revert migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+			if _, err := conn.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?;", migration.Version); err != nil {
+				return fmt.Errorf("could not record reverting migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+			log.Printf("reverted migration %d %s", migration.Version, migration.Name)
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Reset reverts all migrations and applies them again, leaving empty tables
+func (m *Migrator) Reset(ctx context.Context) error {
+	if _, err := m.Down(ctx, len(m.migrations)); err != nil {
+		return err
+	}
+	_, err := m.Up(ctx)
+	return err
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]*MigrationStatus, error) {
+	var statuses []*MigrationStatus
+	err := m.withLock(ctx, func(conn *sql.Conn, done map[int]int64) error {
+		for _, migration := range m.migrations {
+			appliedAt, applied := done[migration.Version]
+			statuses = append(statuses, &MigrationStatus{Version: migration.Version, Name: migration.Name, Applied: applied, AppliedAt: appliedAt})
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// withLock runs fn on a single connection holding the migrations lock, with times of applied migrations by version
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn, done map[int]int64) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?);", migrationsLock, migrationsLockTimeout).Scan(&locked); err != nil {
+		return fmt.Errorf("could not lock migrations: %w", err)
+	}
+	if locked.Int64 != 1 {
+		return fmt.Errorf("could not lock migrations within %d seconds", migrationsLockTimeout)
+	}
+	defer conn.ExecContext(context.Background(), "DO RELEASE_LOCK(?);", migrationsLock)
+
+	if _, err := conn.ExecContext(ctx, createMigrationsTable); err != nil {
+		return fmt.Errorf("could not create migrations table: %w", err)
+	}
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations;")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	done := make(map[int]int64)
+	for rows.Next() {
+		var version int
+		var appliedAt int64
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return err
+		}
+		done[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	return fn(conn, done)
+}
+
+func runScript(ctx context.Context, conn *sql.Conn, script string) error {
+	for _, stmt := range splitStatements(script) {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
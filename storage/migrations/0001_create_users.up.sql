@@ -1,7 +1,7 @@
-CREATE TABLE users (
+CREATE TABLE IF NOT EXISTS users (
 		id INT NOT NULL AUTO_INCREMENT,
 		nick      VARCHAR(255) NOT NULL UNIQUE,
 		pw_hash     VARCHAR(255) NOT NULL,
 		description      VARCHAR(255) NOT NULL,
 		PRIMARY KEY (id)
-	  );
\ No newline at end of file
+	  );
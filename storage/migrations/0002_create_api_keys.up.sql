@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+		id INT NOT NULL AUTO_INCREMENT,
+		owner      VARCHAR(255) NOT NULL,
+		name      VARCHAR(255) NOT NULL,
+		key_hash     CHAR(64) NOT NULL UNIQUE,
+		scopes      VARCHAR(255) NOT NULL,
+		created_at      BIGINT NOT NULL,
+		revoked      BOOLEAN NOT NULL DEFAULT FALSE,
+		PRIMARY KEY (id),
+		INDEX (owner)
+	  );
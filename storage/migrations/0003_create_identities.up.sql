@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS identities (
+		id INT NOT NULL AUTO_INCREMENT,
+		issuer      VARCHAR(255) NOT NULL,
+		subject      VARCHAR(255) NOT NULL,
+		nick      VARCHAR(255) NOT NULL,
+		PRIMARY KEY (id),
+		UNIQUE KEY (issuer, subject)
+	  );
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS blocks (
+		id INT NOT NULL AUTO_INCREMENT,
+		blocker      VARCHAR(255) NOT NULL,
+		blocked      VARCHAR(255) NOT NULL,
+		PRIMARY KEY (id),
+		UNIQUE KEY (blocker, blocked),
+		INDEX(blocked)
+	  );
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS preferences (
+		nick      VARCHAR(255) NOT NULL,
+		theme      VARCHAR(32) NOT NULL DEFAULT '',
+		language      VARCHAR(35) NOT NULL DEFAULT '',
+		notification_level      VARCHAR(16) NOT NULL DEFAULT '',
+		muted_channels      VARCHAR(8192) NOT NULL DEFAULT '',
+		channel_notifications      VARCHAR(8192) NOT NULL DEFAULT '',
+		PRIMARY KEY (nick)
+	  );
@@ -0,0 +1,4 @@
+ALTER TABLE users
+		DROP COLUMN totp_secret,
+		DROP COLUMN totp_enabled,
+		DROP COLUMN recovery_codes;
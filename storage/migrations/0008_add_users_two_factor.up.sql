@@ -0,0 +1,4 @@
+ALTER TABLE users
+		ADD COLUMN totp_secret VARCHAR(255) NOT NULL DEFAULT '',
+		ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+		ADD COLUMN recovery_codes VARCHAR(1024) NOT NULL DEFAULT '';
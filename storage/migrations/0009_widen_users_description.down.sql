@@ -0,0 +1 @@
+ALTER TABLE users MODIFY COLUMN description VARCHAR(255) NOT NULL;
@@ -0,0 +1,6 @@
+ALTER TABLE users
+		DROP COLUMN display_name,
+		DROP COLUMN pronouns,
+		DROP COLUMN timezone,
+		DROP COLUMN avatar,
+		DROP COLUMN custom_fields;
@@ -0,0 +1,6 @@
+ALTER TABLE users
+		ADD COLUMN display_name VARCHAR(64) NOT NULL DEFAULT '',
+		ADD COLUMN pronouns VARCHAR(32) NOT NULL DEFAULT '',
+		ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT '',
+		ADD COLUMN avatar VARCHAR(255) NOT NULL DEFAULT '',
+		ADD COLUMN custom_fields VARCHAR(4096) NOT NULL DEFAULT '';
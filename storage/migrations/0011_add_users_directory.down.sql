@@ -0,0 +1,3 @@
+ALTER TABLE users
+		DROP INDEX users_display_name,
+		DROP COLUMN hidden_from_directory;
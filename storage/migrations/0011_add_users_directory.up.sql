@@ -0,0 +1,3 @@
+ALTER TABLE users
+		ADD COLUMN hidden_from_directory BOOLEAN NOT NULL DEFAULT FALSE,
+		ADD INDEX users_display_name (display_name);
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/joho/godotenv"
+	"github.com/kacperf531/sockchat/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	t.Run("embedded migrations are valid", func(t *testing.T) {
+		migrations, err := loadMigrations(migrationFiles)
+		require.NoError(t, err)
+		require.NotEmpty(t, migrations)
+		for i, migration := range migrations {
+			assert.Equal(t, i+1, migration.Version)
+		}
+	})
+
+	t.Run("orders migrations by version", func(t *testing.T) {
+		migrations, err := loadMigrations(fstest.MapFS{
+			"migrations/0010_add_foo.up.sql":        {Data: []byte("ALTER TABLE users ADD COLUMN foo INT;")},
+			"migrations/0010_add_foo.down.sql":      {Data: []byte("ALTER TABLE users DROP COLUMN foo;")},
+			"migrations/0002_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id INT);")},
+			"migrations/0002_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		})
+		require.NoError(t, err)
+		require.Len(t, migrations, 2)
+		assert.Equal(t, "create_users", migrations[0].Name)
+		assert.Equal(t, 10, migrations[1].Version)
+		assert.Equal(t, "ALTER TABLE users DROP COLUMN foo;", migrations[1].down)
+	})
+
+	invalid := map[string]fstest.MapFS{
+		"missing down script": {
+			"migrations/0001_create_users.up.sql": {Data: []byte("CREATE TABLE users (id INT);")},
+		},
+		"duplicate version": {
+			"migrations/0001_create_users.up.sql":    {Data: []byte("CREATE TABLE users (id INT);")},
+			"migrations/0001_create_users.down.sql":  {Data: []byte("DROP TABLE users;")},
+			"migrations/0001_create_blocks.up.sql":   {Data: []byte("CREATE TABLE blocks (id INT);")},
+			"migrations/0001_create_blocks.down.sql": {Data: []byte("DROP TABLE blocks;")},
+		},
+		"no version": {
+			"migrations/create_users.up.sql": {Data: []byte("CREATE TABLE users (id INT);")},
+		},
+		"no direction": {
+			"migrations/0001_create_users.sql": {Data: []byte("CREATE TABLE users (id INT);")},
+		},
+	}
+	for name, files := range invalid {
+		t.Run("rejects "+name, func(t *testing.T) {
+			_, err := loadMigrations(files)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := "CREATE TABLE foo (\n\tbar INT\n);\n\nCREATE INDEX baz ON foo (bar);  \nDROP TABLE qux"
+	assert.Equal(t, []string{"CREATE TABLE foo (\n\tbar INT\n);", "CREATE INDEX baz ON foo (bar);", "DROP TABLE qux"}, splitStatements(script))
+}
+
+func TestMigrator(t *testing.T) {
+	godotenv.Load("../.env")
+
+	db := mustSetUpTestDB(t)
+	defer db.Close()
+	migrator, err := NewMigrator(db)
+	require.NoError(t, err)
+	ctx := context.TODO()
+
+	t.Run("all migrations are applied after setup", func(t *testing.T) {
+		applied, err := migrator.Up(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, applied)
+		statuses, err := migrator.Status(ctx)
+		require.NoError(t, err)
+		for _, status := range statuses {
+			assert.True(t, status.Applied, status.Name)
+			assert.NotZero(t, status.AppliedAt)
+		}
+	})
+
+	t.Run("reverts and re-applies the latest migration", func(t *testing.T) {
+		reverted, err := migrator.Down(ctx, 1)
+		require.NoError(t, err)
+		require.Equal(t, 1, reverted)
+		statuses, err := migrator.Status(ctx)
+		require.NoError(t, err)
+		assert.False(t, statuses[len(statuses)-1].Applied)
+
+		applied, err := migrator.Up(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, applied)
+	})
+
+	t.Run("applying migrations keeps existing data", func(t *testing.T) {
+		store := NewUserStore(db)
+		createUserFoo(t, store)
+		_, err := migrator.Down(ctx, 1)
+		require.NoError(t, err)
+		_, err = migrator.Up(ctx)
+		require.NoError(t, err)
+		_, err = store.SelectUser(ctx, "Foo")
+		require.NoError(t, err)
+	})
+}
+
+// baselineUsersTable is the schema of DBs set up before migrations were introduced
+const baselineUsersTable = `CREATE TABLE users (
+		id INT NOT NULL AUTO_INCREMENT,
+		nick      VARCHAR(255) NOT NULL UNIQUE,
+		pw_hash     VARCHAR(255) NOT NULL,
+		description      VARCHAR(255) NOT NULL,
+		PRIMARY KEY (id)
+	  );`
+
+func TestMigratorFromBaselineSchema(t *testing.T) {
+	godotenv.Load("../.env")
+
+	db := mustSetUpTestDB(t)
+	defer db.Close()
+	migrator, err := NewMigrator(db)
+	require.NoError(t, err)
+	ctx := context.TODO()
+
+	_, err = migrator.Down(ctx, len(migrator.migrations))
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, "DROP TABLE schema_migrations;")
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, baselineUsersTable)
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, "INSERT INTO users (nick, pw_hash, description) VALUES ('Foo', 'Bar', 'desc');")
+	require.NoError(t, err)
+
+	t.Run("all migrations are applied to the baseline table", func(t *testing.T) {
+		applied, err := migrator.Up(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, len(migrator.migrations), applied)
+	})
+
+	t.Run("existing users get defaults of the new columns", func(t *testing.T) {
+		store := NewUserStore(db)
+		user, err := store.SelectUser(ctx, "Foo")
+		require.NoError(t, err)
+		assert.Equal(t, "desc", user.Description)
+		assert.Equal(t, api.RoleUser, user.Role)
+		assert.False(t, user.Disabled)
+		assert.False(t, user.HiddenFromDirectory)
+		assert.Empty(t, user.DisplayName)
+
+		require.NoError(t, store.UpdatePublicProfile(ctx, &api.PublicProfile{Nick: "Foo", Description: strings.Repeat("a", 1024), DisplayName: "Foo"}))
+	})
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/kacperf531/sockchat/api"
+)
+
+type PreferencesStore interface {
+	// SelectPreferences returns zero value preferences if the user never saved any
+	SelectPreferences(ctx context.Context, nick string) (*api.Preferences, error)
+	UpsertPreferences(ctx context.Context, nick string, prefs *api.Preferences) error
+	DeletePreferences(ctx context.Context, nick string) error
+}
+
+func NewPreferencesStore(db *sql.DB) PreferencesStore {
+	return &preferencesStore{
+		db: db,
+	}
+}
+
+type preferencesStore struct {
+	db *sql.DB
+}
+
+func (s *preferencesStore) SelectPreferences(ctx context.Context, nick string) (*api.Preferences, error) {
+	var prefs api.Preferences
+	var mutedChannels, channelNotifications string
+	err := s.db.QueryRowContext(ctx, "SELECT theme, language, notification_level, muted_channels, channel_notifications FROM preferences WHERE nick = ?;", nick).
+		Scan(&prefs.Theme, &prefs.Language, &prefs.NotificationLevel, &mutedChannels, &channelNotifications)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &prefs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get row: %w", err)
+	}
+	if mutedChannels != "" {
+		if err := json.Unmarshal([]byte(mutedChannels), &prefs.MutedChannels); err != nil {
+			return nil, fmt.Errorf("could not decode muted channels: %w", err)
+		}
+	}
+	if channelNotifications != "" {
+		if err := json.Unmarshal([]byte(channelNotifications), &prefs.ChannelNotifications); err != nil {
+			return nil, fmt.Errorf("could not decode channel notifications: %w", err)
+		}
+	}
+
+	return &prefs, nil
+}
+
+func (s *preferencesStore) UpsertPreferences(ctx context.Context, nick string, prefs *api.Preferences) error {
+	const stmt = `INSERT INTO preferences(nick, theme, language, notification_level, muted_channels, channel_notifications) VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE theme = VALUES(theme), language = VALUES(language), notification_level = VALUES(notification_level),
+		muted_channels = VALUES(muted_channels), channel_notifications = VALUES(channel_notifications);  `
+
+	var mutedChannels, channelNotifications []byte
+	var err error
+	if len(prefs.MutedChannels) > 0 {
+		if mutedChannels, err = json.Marshal(prefs.MutedChannels); err != nil {
+			return fmt.Errorf("could not encode muted channels: %w", err)
+		}
+	}
+	if len(prefs.ChannelNotifications) > 0 {
+		if channelNotifications, err = json.Marshal(prefs.ChannelNotifications); err != nil {
+			return fmt.Errorf("could not encode channel notifications: %w", err)
+		}
+	}
+
+	if _, err := s.db.ExecContext(ctx, stmt, nick, prefs.Theme, prefs.Language, prefs.NotificationLevel, string(mutedChannels), string(channelNotifications)); err != nil {
+		return fmt.Errorf("could not upsert row: %w", err)
+	}
+
+	return nil
+}
+
+func (s *preferencesStore) DeletePreferences(ctx context.Context, nick string) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM preferences WHERE nick = ?;  ", nick); err != nil {
+		return fmt.Errorf("could not delete row: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/joho/godotenv"
+	"github.com/kacperf531/sockchat/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreferencesStore(t *testing.T) {
+	godotenv.Load("../.env")
+
+	db := mustSetUpTestDB(t)
+	defer db.Close()
+
+	store := NewPreferencesStore(db)
+	prefs := &api.Preferences{
+		Theme:                "dark",
+		Language:             "pl",
+		NotificationLevel:    api.NotifyMentions,
+		MutedChannels:        []string{"random"},
+		ChannelNotifications: map[string]api.NotificationLevel{"general": api.NotifyAll},
+	}
+
+	t.Run("returns empty preferences of user who never saved any", func(t *testing.T) {
+		selected, err := store.SelectPreferences(context.TODO(), "Foo")
+		require.NoError(t, err)
+		assert.Equal(t, &api.Preferences{}, selected)
+	})
+
+	t.Run("inserts and updates preferences", func(t *testing.T) {
+		require.NoError(t, store.UpsertPreferences(context.TODO(), "Foo", &api.Preferences{Theme: "light"}))
+		require.NoError(t, store.UpsertPreferences(context.TODO(), "Foo", prefs))
+		selected, err := store.SelectPreferences(context.TODO(), "Foo")
+		require.NoError(t, err)
+		assert.Equal(t, prefs, selected)
+	})
+
+	t.Run("deletes preferences", func(t *testing.T) {
+		require.NoError(t, store.DeletePreferences(context.TODO(), "Foo"))
+		selected, err := store.SelectPreferences(context.TODO(), "Foo")
+		require.NoError(t, err)
+		assert.Equal(t, &api.Preferences{}, selected)
+	})
+}
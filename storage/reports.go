@@ -150,9 +150,6 @@ func newMessageCountDistribution(res *dateRanges) []api.DistributionEntry {
 }
 
 func buildUserActivityQuery(opts *api.UserActivityReportOptions) (*searchQuery, error) {
-	var tf term
-	tf.Author = &termFilterValue{Value: opts.Author}
-
 	var rf range_
 	if opts.From.After(opts.To) {
 		return nil, api.ErrInvalidRange
@@ -169,7 +166,7 @@ func buildUserActivityQuery(opts *api.UserActivityReportOptions) (*searchQuery,
 	aggs.Channels.Aggs = buildDateHistogramAggs(opts)
 
 	var q searchQuery
-	q.Query.Bool.Filter = []filters{{Term: &tf}, {Range: &rf}}
+	q.Query.Bool.Filter = []filters{authorFilter(opts.Author, opts.AuthorID), {Range: &rf}}
 	q.Aggs = aggs
 
 	return &q, nil
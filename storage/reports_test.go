@@ -2,12 +2,10 @@ package storage
 
 import (
 	"context"
-	"os"
 	"testing"
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v7"
-	"github.com/elastic/go-elasticsearch/v7/esapi"
 	"github.com/kacperf531/sockchat/api"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -76,8 +74,25 @@ func TestUserActivityReport(t *testing.T) {
 	es := mustSetUpES(t)
 	setUpTestIndex(t, es, testIndexName)
 
-	userReports := &UserReports{es, testIndexName}
+	testUserActivityReport(t, &UserReports{es, testIndexName})
+}
+
+func TestSQLUserActivityReport(t *testing.T) {
+	for backend, dsn := range sqlBackends() {
+		t.Run(backend, func(t *testing.T) {
+			store := mustSetUpSQLMessageStore(t, backend, dsn)
+			for _, msg := range testMessages {
+				_, err := store.IndexMessage(msg)
+				require.NoError(t, err)
+			}
+			userReports, err := NewSQLReportsService(store.db, backend)
+			require.NoError(t, err)
+			testUserActivityReport(t, userReports)
+		})
+	}
+}
 
+func testUserActivityReport(t *testing.T, userReports api.SockchatReportsService) {
 	t.Run("returns report for user activity without group_by & to - records count is equal to number of channels", func(t *testing.T) {
 		from, _ := time.Parse(time.RFC3339, testPeriodStart)
 		to, _ := time.Parse(time.RFC3339, testPeriodEnd)
@@ -179,30 +194,22 @@ func TestUserActivityReport(t *testing.T) {
 
 func setUpTestIndex(t *testing.T, es *elasticsearch.Client, indexName string) {
 	t.Helper()
-	_, err := es.Indices.Delete([]string{indexName})
-	require.NoError(t, err)
-
-	mapping, err := os.Open("timestamp_mapping.json")
-	if err != nil {
-		t.Error("could not load mapping file")
-	}
-	defer mapping.Close()
-
-	require.NoError(t, err)
-	indexReq := esapi.IndicesCreateRequest{
-		Index: indexName,
-	}
-	_, err = indexReq.Do(context.Background(), es)
-	require.NoError(t, err)
+	setUpMessagesIndex(t, es, indexName)
 
 	messageStore := NewMessageStore(es, indexName)
 	for _, msg := range testMessages {
 		_, err := messageStore.IndexMessage(msg)
 		require.NoError(t, err)
 	}
-	_, err = esapi.IndicesPutMappingRequest{Index: []string{indexName}, Body: mapping}.Do(context.Background(), es)
-	require.NoError(t, err)
-	_, err = esapi.UpdateByQueryRequest{Index: []string{indexName}}.Do(context.Background(), es)
+}
+
+// setUpMessagesIndex drops indices left behind the alias by previous runs and sets the alias up from scratch
+func setUpMessagesIndex(t *testing.T, es *elasticsearch.Client, alias string) *MessagesIndex {
+	t.Helper()
+	_, err := es.Indices.Delete([]string{alias, alias + "-*"}, es.Indices.Delete.WithIgnoreUnavailable(true))
 	require.NoError(t, err)
-	time.Sleep(2 * time.Second) // wait for ES reindex
+
+	messagesIndex := NewMessagesIndex(es, alias)
+	require.NoError(t, messagesIndex.Setup(context.Background(), time.Now()))
+	return messagesIndex
 }
@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Spool keeps batches of messages on disk until they are indexed, so that they survive ES outages and restarts
+type Spool interface {
+	Push(batch []*BulkMessage) error
+	// Peek returns the oldest batch together with the key it is removed by; the batch is nil if the spool is empty
+	Peek() (string, []*BulkMessage, error)
+	Remove(key string) error
+	Len() (int, error)
+	// Rewrite stores again every batch in which rewrite changed a message; rewrite reports whether it changed the message
+	Rewrite(rewrite func(msg *BulkMessage) bool) error
+}
+
+const spoolFileSuffix = ".ndjson"
+
+// NewFileSpool stores each batch as a file of JSON lines in the directory, which is created if it does not exist
+func NewFileSpool(dir string) (Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create spool directory: %w", err)
+	}
+	return &fileSpool{dir: dir}, nil
+}
+
+type fileSpool struct {
+	dir string
+	seq atomic.Int64
+}
+
+func (s *fileSpool) Push(batch []*BulkMessage) error {
+	// names sort in the order batches were pushed
+	key := fmt.Sprintf("%020d-%010d%s", time.Now().UnixNano(), s.seq.Add(1), spoolFileSuffix)
+	return s.write(key, batch)
+}
+
+// write stores the batch under the key, replacing the batch stored there before
+func (s *fileSpool) write(key string, batch []*BulkMessage) error {
+	// write to a temporary file first, so that Peek never sees partially written batches
+	tmp, err := os.CreateTemp(s.dir, ".spool-*")
+	if err != nil {
+		return fmt.Errorf("could not create spool file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, msg := range batch {
+		if err := enc.Encode(msg); err != nil {
+			tmp.Close()
+			return fmt.Errorf("could not encode spooled message: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write spool file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write spool file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not write spool file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, key)); err != nil {
+		return fmt.Errorf("could not store spool file: %w", err)
+	}
+	return nil
+}
+
+func (s *fileSpool) Peek() (string, []*BulkMessage, error) {
+	keys, err := s.keys()
+	if err != nil || len(keys) == 0 {
+		return "", nil, err
+	}
+	batch, err := s.read(keys[0])
+	return keys[0], batch, err
+}
+
+// read returns the batch stored under the key
+func (s *fileSpool) read(key string) ([]*BulkMessage, error) {
+	f, err := os.Open(filepath.Join(s.dir, key))
+	if err != nil {
+		return nil, fmt.Errorf("could not open spool file: %w", err)
+	}
+	defer f.Close()
+	batch := []*BulkMessage{}
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var msg BulkMessage
+		if err := dec.Decode(&msg); err != nil {
+			return nil, fmt.Errorf("could not decode spool file %s: %w", key, err)
+		}
+		batch = append(batch, &msg)
+	}
+	return batch, nil
+}
+
+func (s *fileSpool) Remove(key string) error {
+	if err := os.Remove(filepath.Join(s.dir, filepath.Base(key))); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not remove spool file: %w", err)
+	}
+	return nil
+}
+
+func (s *fileSpool) Rewrite(rewrite func(msg *BulkMessage) bool) error {
+	keys, err := s.keys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		batch, err := s.read(key)
+		if os.IsNotExist(errors.Unwrap(err)) {
+			// the batch was indexed in the meantime
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		changed := false
+		for _, msg := range batch {
+			if rewrite(msg) {
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		if err := s.write(key, batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fileSpool) Len() (int, error) {
+	keys, err := s.keys()
+	return len(keys), err
+}
+
+// keys returns names of spooled batches, oldest first
+func (s *fileSpool) keys() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not list spool directory: %w", err)
+	}
+	keys := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), spoolFileSuffix) {
+			keys = append(keys, entry.Name())
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kacperf531/sockchat/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSpool(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := NewFileSpool(dir)
+	require.NoError(t, err)
+
+	t.Run("returns nothing when empty", func(t *testing.T) {
+		key, batch, err := spool.Peek()
+		require.NoError(t, err)
+		require.Empty(t, key)
+		require.Nil(t, batch)
+	})
+
+	t.Run("returns batches in the order they were pushed", func(t *testing.T) {
+		first := []*BulkMessage{{ID: "1", Message: &api.MessageEvent{Text: "foo"}}, {ID: "2", Message: &api.MessageEvent{Text: "bar"}}}
+		second := []*BulkMessage{{ID: "3", Message: &api.MessageEvent{Text: "baz"}}}
+		require.NoError(t, spool.Push(first))
+		require.NoError(t, spool.Push(second))
+		n, err := spool.Len()
+		require.NoError(t, err)
+		require.Equal(t, 2, n)
+
+		key, batch, err := spool.Peek()
+		require.NoError(t, err)
+		require.Equal(t, first, batch)
+		require.NoError(t, spool.Remove(key))
+
+		key, batch, err = spool.Peek()
+		require.NoError(t, err)
+		require.Equal(t, second, batch)
+		require.NoError(t, spool.Remove(key))
+
+		n, err = spool.Len()
+		require.NoError(t, err)
+		require.Zero(t, n)
+	})
+
+	t.Run("survives reopening", func(t *testing.T) {
+		require.NoError(t, spool.Push([]*BulkMessage{{ID: "4", Message: &api.MessageEvent{Text: "qux"}}}))
+		reopened, err := NewFileSpool(dir)
+		require.NoError(t, err)
+		_, batch, err := reopened.Peek()
+		require.NoError(t, err)
+		require.Equal(t, "4", batch[0].ID)
+	})
+
+	t.Run("ignores temporary files", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, ".spool-partial"), []byte("{"), 0o644))
+		n, err := spool.Len()
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+	})
+
+	t.Run("rewrites messages in place keeping the order of batches", func(t *testing.T) {
+		require.NoError(t, spool.Push([]*BulkMessage{{ID: "5", Message: &api.MessageEvent{Author: "Foo", Text: "quux"}}}))
+		var rewritten []string
+		require.NoError(t, spool.Rewrite(func(msg *BulkMessage) bool {
+			if msg.Message.Author != "Foo" {
+				return false
+			}
+			msg.Message.Author = "Bar"
+			rewritten = append(rewritten, msg.ID)
+			return true
+		}))
+		require.Equal(t, []string{"5"}, rewritten)
+
+		key, batch, err := spool.Peek()
+		require.NoError(t, err)
+		require.Equal(t, "4", batch[0].ID)
+		require.NoError(t, spool.Remove(key))
+		_, batch, err = spool.Peek()
+		require.NoError(t, err)
+		require.Equal(t, "Bar", batch[0].Message.Author)
+		require.Equal(t, "quux", batch[0].Message.Text)
+	})
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"database/sql"
+	_ "embed"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	_ "github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// SQL backends of messages
+const (
+	SQLite   = "sqlite"
+	Postgres = "postgres"
+
+	// sqliteDriverName is the sqlite3 driver with functions used by full-text search registered
+	sqliteDriverName = "sqlite3_sockchat"
+)
+
+var (
+	//go:embed create-messages-sqlite.sql
+	createMessagesSQLite string
+	//go:embed create-messages-postgres.sql
+	createMessagesPostgres string
+)
+
+// sqlDialect holds the parts of queries which differ between the backends.
+// Full-text expressions take the text query as their only parameter.
+type sqlDialect struct {
+	driver        string
+	createTables  string
+	numberedBinds bool
+	matches       string
+	score         string
+	highlight     string
+	or            string
+}
+
+var sqlDialects = map[string]*sqlDialect{
+	SQLite: {
+		driver:       sqliteDriverName,
+		createTables: createMessagesSQLite,
+		matches:      "m.seq IN (SELECT docid FROM messages_fts WHERE messages_fts MATCH ?)",
+		score:        "(SELECT match_rank(matchinfo(messages_fts, 'pcnx')) FROM messages_fts WHERE messages_fts MATCH ? AND docid = m.seq)",
+		highlight:    "(SELECT snippet(messages_fts, '<em>', '</em>', '...', -1, 32) FROM messages_fts WHERE messages_fts MATCH ? AND docid = m.seq)",
+		or:           " OR ",
+	},
+	Postgres: {
+		driver:        Postgres,
+		createTables:  createMessagesPostgres,
+		numberedBinds: true,
+		matches:       "m.text_search @@ websearch_to_tsquery('simple', ?)",
+		score:         "ts_rank(m.text_search, websearch_to_tsquery('simple', ?))",
+		highlight:     "ts_headline('simple', m.text, websearch_to_tsquery('simple', ?), 'StartSel=<em>, StopSel=</em>')",
+		or:            " or ",
+	},
+}
+
+func init() {
+	sql.Register(sqliteDriverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("match_rank", matchRank, true)
+		},
+	})
+}
+
+func dialectOf(backend string) (*sqlDialect, error) {
+	dialect, ok := sqlDialects[backend]
+	if !ok {
+		return nil, fmt.Errorf("unknown SQL backend %q, expected %s or %s", backend, SQLite, Postgres)
+	}
+	return dialect, nil
+}
+
+// OpenSQLDB opens database of messages of the backend
+func OpenSQLDB(backend, dsn string) (*sql.DB, error) {
+	dialect, err := dialectOf(backend)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(dialect.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if backend == SQLite {
+		// sqlite allows a single writer, and every connection to :memory: would open a separate database
+		db.SetMaxOpenConns(1)
+	}
+	return db, nil
+}
+
+// rebind replaces ? placeholders with $1, $2... for backends using numbered ones
+func (d *sqlDialect) rebind(query string) string {
+	if !d.numberedBinds {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// matchRank scores sqlite full-text matches with tf-idf, based on matchinfo in 'pcnx' format
+func matchRank(matchinfo []byte) float64 {
+	// matchinfo is an array of 32-bit integers in native byte order
+	info := make([]uint32, len(matchinfo)/4)
+	for i := range info {
+		info[i] = binary.LittleEndian.Uint32(matchinfo[i*4:])
+	}
+	if len(info) < 3 {
+		return 0
+	}
+	phrases, columns, rows := int(info[0]), int(info[1]), float64(info[2])
+	var score float64
+	for i := 0; i < phrases*columns && 3+i*3+2 < len(info); i++ {
+		hitsInRow, rowsWithHits := float64(info[3+i*3]), float64(info[3+i*3+2])
+		if hitsInRow > 0 {
+			score += hitsInRow * math.Log(1+rows/rowsWithHits)
+		}
+	}
+	return score
+}
@@ -0,0 +1,415 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/kacperf531/sockchat/api"
+)
+
+const messageColumns = "m.id, m.channel, m.author, m.author_id, m.text, m.timestamp"
+
+// SQLMessageStore keeps messages in SQLite or Postgres, for deployments which don't need Elasticsearch
+type SQLMessageStore struct {
+	db      *sql.DB
+	dialect *sqlDialect
+}
+
+func NewSQLMessageStore(db *sql.DB, backend string) (*SQLMessageStore, error) {
+	dialect, err := dialectOf(backend)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLMessageStore{db, dialect}, nil
+}
+
+// CreateTables creates tables of messages unless they exist
+func (s *SQLMessageStore) CreateTables(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, s.dialect.createTables); err != nil {
+		return fmt.Errorf("could not create tables of messages: %w", err)
+	}
+	return nil
+}
+
+// sqlConditions collects conditions of WHERE clause together with their parameters
+type sqlConditions struct {
+	conds []string
+	args  []any
+}
+
+func (c *sqlConditions) add(cond string, args ...any) {
+	c.conds = append(c.conds, cond)
+	c.args = append(c.args, args...)
+}
+
+func (c *sqlConditions) in(column string, values []string) {
+	c.add(column+" IN ("+binds(len(values))+")", stringArgs(values)...)
+}
+
+func (c *sqlConditions) notIn(column string, values []string) {
+	c.add(column+" NOT IN ("+binds(len(values))+")", stringArgs(values)...)
+}
+
+func (c *sqlConditions) where() string {
+	if len(c.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(c.conds, " AND ")
+}
+
+func binds(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func stringArgs(values []string) []any {
+	args := make([]any, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
+
+// textQuery is a parsed search query in the syntax of ES simple_query_string: words and "phrases" are all required,
+// unless joined with |; words and phrases prefixed with - must not match.
+type textQuery struct {
+	anyOf  [][]string
+	noneOf []string
+}
+
+func parseTextQuery(query string) *textQuery {
+	q := &textQuery{}
+	or := false
+	for rest := strings.TrimSpace(query); rest != ""; rest = strings.TrimSpace(rest) {
+		if rest[0] == '|' {
+			or = true
+			rest = rest[1:]
+			continue
+		}
+		negated := false
+		switch rest[0] {
+		case '-':
+			negated, rest = true, rest[1:]
+		case '+':
+			rest = rest[1:]
+		}
+		var term string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				term, rest = rest[1:], ""
+			} else {
+				term, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			end := strings.IndexAny(rest, " \t\n|")
+			if end < 0 {
+				end = len(rest)
+			}
+			term, rest = rest[:end], rest[end:]
+		}
+		term = strings.TrimSpace(strings.ReplaceAll(term, `"`, " "))
+		if term == "" {
+			continue
+		}
+		// terms are always quoted, so that the backends don't read them as operators
+		term = `"` + term + `"`
+		switch {
+		case negated:
+			q.noneOf = append(q.noneOf, term)
+		case or && len(q.anyOf) > 0:
+			last := len(q.anyOf) - 1
+			q.anyOf[last] = append(q.anyOf[last], term)
+		default:
+			q.anyOf = append(q.anyOf, []string{term})
+		}
+		or = false
+	}
+	return q
+}
+
+// anyWord matches messages containing any of the words, the way match query of ES does
+func anyWord(query string) *textQuery {
+	q := &textQuery{}
+	var words []string
+	for _, word := range strings.Fields(strings.ReplaceAll(query, `"`, " ")) {
+		words = append(words, `"`+word+`"`)
+	}
+	if len(words) > 0 {
+		q.anyOf = [][]string{words}
+	}
+	return q
+}
+
+func (q *textQuery) positive(d *sqlDialect) string {
+	var terms []string
+	for _, group := range q.anyOf {
+		terms = append(terms, group...)
+	}
+	return strings.Join(terms, d.or)
+}
+
+func (q *textQuery) addConditions(c *sqlConditions, d *sqlDialect) {
+	for _, group := range q.anyOf {
+		alternatives := make([]string, len(group))
+		for i := range group {
+			alternatives[i] = d.matches
+		}
+		c.add("("+strings.Join(alternatives, " OR ")+")", stringArgs(group)...)
+	}
+	for _, term := range q.noneOf {
+		c.add("NOT "+d.matches, term)
+	}
+}
+
+func newMessageID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (s *SQLMessageStore) IndexMessage(msg *api.MessageEvent) (string, error) {
+	id, err := newMessageID()
+	if err != nil {
+		return "", err
+	}
+	if err := s.insertMessage(context.Background(), s.db, id, msg); err != nil {
+		return "", fmt.Errorf("could not save message due to DB error: %v", err)
+	}
+	return id, nil
+}
+
+type sqlExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func (s *SQLMessageStore) insertMessage(ctx context.Context, db sqlExecutor, id string, msg *api.MessageEvent) error {
+	// retried batches come with the same ids, so messages are not duplicated
+	const stmt = "INSERT INTO messages (id, channel, author, author_id, text, timestamp) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT (id) DO NOTHING;"
+	_, err := db.ExecContext(ctx, s.dialect.rebind(stmt), id, msg.Channel, msg.Author, msg.AuthorID, msg.Text, msg.Timestamp)
+	return err
+}
+
+// BulkIndex inserts the batch in a single transaction; a failed batch is retried as a whole
+func (s *SQLMessageStore) BulkIndex(ctx context.Context, batch []*BulkMessage) ([]*BulkMessage, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not index messages due to DB error: %v", err)
+	}
+	defer tx.Rollback()
+	for _, msg := range batch {
+		if err := s.insertMessage(ctx, tx, msg.ID, msg.Message); err != nil {
+			return nil, fmt.Errorf("could not index messages due to DB error: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not index messages due to DB error: %v", err)
+	}
+	return nil, nil
+}
+
+func (s *SQLMessageStore) FindMessages(ctx context.Context, query *api.HistoryQuery) (*api.ChannelHistoryPage, error) {
+	if query.Limit <= 0 {
+		return nil, api.ErrInvalidRequest
+	}
+	if query.Before != "" && query.After != "" {
+		return nil, api.ErrConflictingCursors
+	}
+	// messages after the cursor are sought oldest first, starting right next to it
+	order, encodedCursor := "DESC", query.Before
+	if query.After != "" {
+		order, encodedCursor = "ASC", query.After
+	}
+
+	var c sqlConditions
+	c.add("m.channel = ?", query.Channel)
+	if query.From > 0 {
+		c.add("m.timestamp >= ?", query.From)
+	}
+	if query.Search != "" {
+		anyWord(query.Search).addConditions(&c, s.dialect)
+	}
+	if len(query.ExcludedAuthors) > 0 {
+		c.notIn("m.author", query.ExcludedAuthors)
+	}
+	if encodedCursor != "" {
+		cursor, err := api.DecodeCursor(encodedCursor)
+		if err != nil {
+			return nil, err
+		}
+		if order == "DESC" {
+			c.add("(m.timestamp, m.seq) < (?, ?)", cursor.Timestamp, cursor.Seq)
+		} else {
+			c.add("(m.timestamp, m.seq) > (?, ?)", cursor.Timestamp, cursor.Seq)
+		}
+	}
+	// one more message than requested tells if there is a next page
+	stmt := fmt.Sprintf("SELECT m.seq, %s FROM messages m%s ORDER BY m.timestamp %s, m.seq %s LIMIT ?;", messageColumns, c.where(), order, order)
+	seqs, messages, err := s.selectMessages(ctx, stmt, append(c.args, query.Limit+1)...)
+	if err != nil {
+		log.Printf("error selecting history of %s: %v", query.Channel, err)
+		return nil, api.ErrInternal
+	}
+
+	page := &api.ChannelHistoryPage{Messages: messages}
+	if len(messages) > query.Limit {
+		page.Messages = messages[:query.Limit]
+		last := page.Messages[query.Limit-1]
+		page.NextCursor = api.EncodeCursor(&api.HistoryCursor{Timestamp: last.Timestamp, Seq: seqs[query.Limit-1]})
+	}
+	if order == "ASC" {
+		for i, j := 0, len(page.Messages)-1; i < j; i, j = i+1, j-1 {
+			page.Messages[i], page.Messages[j] = page.Messages[j], page.Messages[i]
+		}
+	}
+	return page, nil
+}
+
+func (s *SQLMessageStore) SearchMessages(ctx context.Context, req *api.SearchMessagesRequest) (*api.SearchMessagesResponse, error) {
+	var c sqlConditions
+	c.in("m.channel", req.Channels)
+	if len(req.Authors) > 0 {
+		c.in("m.author", req.Authors)
+	}
+	if req.From > 0 {
+		c.add("m.timestamp >= ?", req.From)
+	}
+	if req.To > 0 {
+		c.add("m.timestamp <= ?", req.To)
+	}
+	text := parseTextQuery(req.Query)
+	text.addConditions(&c, s.dialect)
+
+	results := &api.SearchMessagesResponse{Results: []*api.SearchResult{}}
+	if err := s.db.QueryRowContext(ctx, s.dialect.rebind("SELECT COUNT(*) FROM messages m"+c.where()+";"), c.args...).Scan(&results.Total); err != nil {
+		log.Printf("error counting found messages: %v", err)
+		return nil, api.ErrInternal
+	}
+
+	// messages matching negated terms only have no score nor highlights
+	scored := len(text.anyOf) > 0
+	columns, order := messageColumns+", 0 AS score, ''", "m.timestamp DESC, m.seq DESC"
+	var args []any
+	if scored {
+		columns = fmt.Sprintf("%s, %s AS score, %s", messageColumns, s.dialect.score, s.dialect.highlight)
+		positive := text.positive(s.dialect)
+		args = append(args, positive, positive)
+		if req.Sort != api.SortByTime {
+			order = "score DESC, " + order
+		}
+	}
+	args = append(append(args, c.args...), req.Limit, req.Offset)
+	stmt := fmt.Sprintf("SELECT %s FROM messages m%s ORDER BY %s LIMIT ? OFFSET ?;", columns, c.where(), order)
+
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(stmt), args...)
+	if err != nil {
+		log.Printf("error searching messages: %v", err)
+		return nil, api.ErrInternal
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var highlight sql.NullString
+		var score sql.NullFloat64
+		msg := &api.MessageEvent{}
+		if err := rows.Scan(&id, &msg.Channel, &msg.Author, &msg.AuthorID, &msg.Text, &msg.Timestamp, &score, &highlight); err != nil {
+			log.Printf("error scanning found message: %v", err)
+			return nil, api.ErrInternal
+		}
+		result := &api.SearchResult{Message: msg, Score: score.Float64}
+		if highlight.String != "" {
+			result.Highlights = []string{highlight.String}
+		}
+		results.Results = append(results.Results, result)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("error searching messages: %v", err)
+		return nil, api.ErrInternal
+	}
+	return results, nil
+}
+
+// FindMessagesByAuthor returns all messages of the author in all channels, oldest first
+func (s *SQLMessageStore) FindMessagesByAuthor(ctx context.Context, author string, authorID int64) (api.ChannelHistory, error) {
+	cond, args := authorCondition(author, authorID)
+	stmt := fmt.Sprintf("SELECT m.seq, %s FROM messages m WHERE %s ORDER BY m.timestamp ASC, m.seq ASC;", messageColumns, cond)
+	_, messages, err := s.selectMessages(ctx, stmt, args...)
+	if err != nil {
+		log.Printf("error selecting messages of %s: %v", author, err)
+		return nil, api.ErrInternal
+	}
+	return messages, nil
+}
+
+func (s *SQLMessageStore) selectMessages(ctx context.Context, stmt string, args ...any) ([]int64, api.ChannelHistory, error) {
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(stmt), args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var seqs []int64
+	messages := api.ChannelHistory{}
+	for rows.Next() {
+		var seq int64
+		var id string
+		msg := &api.MessageEvent{}
+		if err := rows.Scan(&seq, &id, &msg.Channel, &msg.Author, &msg.AuthorID, &msg.Text, &msg.Timestamp); err != nil {
+			return nil, nil, err
+		}
+		seqs = append(seqs, seq)
+		messages = append(messages, msg)
+	}
+	return seqs, messages, rows.Err()
+}
+
+// AnonymizeMessagesByAuthor replaces author of all their messages with api.DeletedUserNick
+func (s *SQLMessageStore) AnonymizeMessagesByAuthor(ctx context.Context, author string, authorID int64) error {
+	cond, args := authorCondition(author, authorID)
+	stmt := "UPDATE messages SET author = ?, author_id = 0 WHERE " + cond + ";"
+	if _, err := s.db.ExecContext(ctx, s.dialect.rebind(stmt), append([]any{api.DeletedUserNick}, args...)...); err != nil {
+		log.Printf("error anonymizing messages of %s: %v", author, err)
+		return api.ErrInternal
+	}
+	return nil
+}
+
+// RenameAuthor sets the new nick as author of all messages sent under the old one, storing the author's id along
+func (s *SQLMessageStore) RenameAuthor(ctx context.Context, author string, authorID int64, newNick string) error {
+	cond, args := authorCondition(author, authorID)
+	stmt := "UPDATE messages SET author = ?, author_id = ? WHERE " + cond + ";"
+	if _, err := s.db.ExecContext(ctx, s.dialect.rebind(stmt), append([]any{newNick, authorID}, args...)...); err != nil {
+		log.Printf("error renaming author %s: %v", author, err)
+		return api.ErrInternal
+	}
+	return nil
+}
+
+// authorCondition matches messages by id of the author; messages stored before ids of authors were kept are matched by nick
+func authorCondition(author string, authorID int64) (string, []any) {
+	if authorID == 0 {
+		return "author = ?", []any{author}
+	}
+	return "(author_id = ? OR author = ?)", []any{authorID, author}
+}
+
+func (s *SQLMessageStore) DeleteMessages(ctx context.Context, expired *ExpiredMessages) (int64, error) {
+	var c sqlConditions
+	c.add("timestamp < ?", expired.Before)
+	if len(expired.Channels) > 0 {
+		c.in("channel", expired.Channels)
+	}
+	if len(expired.ExcludedChannels) > 0 {
+		c.notIn("channel", expired.ExcludedChannels)
+	}
+	res, err := s.db.ExecContext(ctx, s.dialect.rebind("DELETE FROM messages"+c.where()+";"), c.args...)
+	if err != nil {
+		return 0, fmt.Errorf("could not delete messages due to DB error: %v", err)
+	}
+	return res.RowsAffected()
+}
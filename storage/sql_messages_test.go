@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kacperf531/sockchat/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sqlBackends returns databases of SQL backends to test; Postgres is tested when POSTGRES_TEST_DSN is set
+func sqlBackends() map[string]string {
+	backends := map[string]string{SQLite: ":memory:"}
+	if dsn := os.Getenv("POSTGRES_TEST_DSN"); dsn != "" {
+		backends[Postgres] = dsn
+	}
+	return backends
+}
+
+func mustSetUpSQLMessageStore(t *testing.T, backend, dsn string) *SQLMessageStore {
+	t.Helper()
+	db, err := OpenSQLDB(backend, dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec("DROP TABLE IF EXISTS messages;")
+	require.NoError(t, err)
+	if backend == SQLite {
+		_, err = db.Exec("DROP TABLE IF EXISTS messages_fts;")
+		require.NoError(t, err)
+	}
+	store, err := NewSQLMessageStore(db, backend)
+	require.NoError(t, err)
+	require.NoError(t, store.CreateTables(context.Background()))
+	return store
+}
+
+func TestSQLMessageStore(t *testing.T) {
+	for backend, dsn := range sqlBackends() {
+		t.Run(backend, func(t *testing.T) {
+			store := mustSetUpSQLMessageStore(t, backend, dsn)
+			ctx := context.Background()
+
+			t.Run("can get messages by channel", func(t *testing.T) {
+				_, err := store.IndexMessage(&api.MessageEvent{Channel: "Foo", Author: "Bar", Text: "FooBarBaz", Timestamp: time.Now().Unix()})
+				require.NoError(t, err)
+				_, err = store.IndexMessage(&api.MessageEvent{Channel: "Other", Author: "Bar", Text: "elsewhere", Timestamp: time.Now().Unix()})
+				require.NoError(t, err)
+
+				page, err := store.FindMessages(ctx, &api.HistoryQuery{Channel: "Foo", Limit: 10})
+				require.NoError(t, err)
+				require.Len(t, page.Messages, 1)
+				assert.Equal(t, "FooBarBaz", page.Messages[0].Text)
+			})
+
+			t.Run("can search history and exclude authors", func(t *testing.T) {
+				page, err := store.FindMessages(ctx, &api.HistoryQuery{Channel: "Foo", Search: "foobarbaz", Limit: 10})
+				require.NoError(t, err)
+				require.Len(t, page.Messages, 1)
+
+				page, err = store.FindMessages(ctx, &api.HistoryQuery{Channel: "Foo", Search: "FooBarQux", Limit: 10})
+				require.NoError(t, err)
+				require.Empty(t, page.Messages)
+
+				page, err = store.FindMessages(ctx, &api.HistoryQuery{Channel: "Foo", ExcludedAuthors: []string{"Bar"}, Limit: 10})
+				require.NoError(t, err)
+				require.Empty(t, page.Messages)
+			})
+
+			t.Run("can page through messages with cursors", func(t *testing.T) {
+				sent := time.Now().Unix()
+				for _, text := range []string{"one", "two", "three"} {
+					_, err := store.IndexMessage(&api.MessageEvent{Channel: "Paged", Author: "Bar", Text: text, Timestamp: sent})
+					require.NoError(t, err)
+				}
+
+				// messages sent within the same second are ordered as they were stored
+				first, err := store.FindMessages(ctx, &api.HistoryQuery{Channel: "Paged", Limit: 2})
+				require.NoError(t, err)
+				require.Len(t, first.Messages, 2)
+				require.Equal(t, "three", first.Messages[0].Text)
+				require.Equal(t, "two", first.Messages[1].Text)
+				require.NotEmpty(t, first.NextCursor)
+
+				second, err := store.FindMessages(ctx, &api.HistoryQuery{Channel: "Paged", Limit: 2, Before: first.NextCursor})
+				require.NoError(t, err)
+				require.Len(t, second.Messages, 1)
+				require.Equal(t, "one", second.Messages[0].Text)
+				require.Empty(t, second.NextCursor)
+
+				newer, err := store.FindMessages(ctx, &api.HistoryQuery{Channel: "Paged", Limit: 2, After: first.NextCursor})
+				require.NoError(t, err)
+				require.Equal(t, first.Messages[:1], newer.Messages)
+
+				_, err = store.FindMessages(ctx, &api.HistoryQuery{Channel: "Paged", Limit: 2, Before: "not a cursor"})
+				require.ErrorIs(t, err, api.ErrInvalidCursor)
+			})
+
+			t.Run("can search messages with filters and highlights", func(t *testing.T) {
+				for _, msg := range []*api.MessageEvent{
+					{Channel: "Searched", Author: "Foo", Text: "quick brown fox", Timestamp: 100},
+					{Channel: "Searched", Author: "Bar", Text: "lazy brown dog", Timestamp: 200},
+					{Channel: "Searched", Author: "Bar", Text: "brown brown brown fox", Timestamp: 300},
+				} {
+					_, err := store.IndexMessage(msg)
+					require.NoError(t, err)
+				}
+
+				res, err := store.SearchMessages(ctx, &api.SearchMessagesRequest{Query: `"quick brown"`, Channels: []string{"Searched"}, Limit: 10})
+				require.NoError(t, err)
+				require.Equal(t, int64(1), res.Total)
+				require.Equal(t, "Foo", res.Results[0].Message.Author)
+				require.Positive(t, res.Results[0].Score)
+				require.Contains(t, res.Results[0].Highlights[0], "<em>brown</em>")
+
+				res, err = store.SearchMessages(ctx, &api.SearchMessagesRequest{Query: "brown -fox", Channels: []string{"Searched"}, Limit: 10})
+				require.NoError(t, err)
+				require.Len(t, res.Results, 1)
+				require.Equal(t, "lazy brown dog", res.Results[0].Message.Text)
+
+				res, err = store.SearchMessages(ctx, &api.SearchMessagesRequest{Query: "quick | lazy", Channels: []string{"Searched"}, Sort: api.SortByTime, Limit: 10})
+				require.NoError(t, err)
+				require.Len(t, res.Results, 2)
+				require.Equal(t, int64(200), res.Results[0].Message.Timestamp)
+
+				res, err = store.SearchMessages(ctx, &api.SearchMessagesRequest{Query: "brown", Channels: []string{"Searched"}, Sort: api.SortByRelevance, Limit: 10})
+				require.NoError(t, err)
+				require.Len(t, res.Results, 3)
+				require.Equal(t, "brown brown brown fox", res.Results[0].Message.Text)
+
+				res, err = store.SearchMessages(ctx, &api.SearchMessagesRequest{Channels: []string{"Searched"}, Authors: []string{"Foo", "Bar"}, From: 150, To: 250, Sort: api.SortByTime, Limit: 10})
+				require.NoError(t, err)
+				require.Len(t, res.Results, 1)
+				require.Equal(t, int64(200), res.Results[0].Message.Timestamp)
+
+				res, err = store.SearchMessages(ctx, &api.SearchMessagesRequest{Channels: []string{"Searched"}, Sort: api.SortByTime, Offset: 1, Limit: 1})
+				require.NoError(t, err)
+				require.Equal(t, int64(3), res.Total)
+				require.Len(t, res.Results, 1)
+				require.Equal(t, int64(200), res.Results[0].Message.Timestamp)
+			})
+
+			t.Run("can bulk index messages with given ids", func(t *testing.T) {
+				batch := []*BulkMessage{
+					{ID: "bulk-1", Message: &api.MessageEvent{Channel: "Bulk", Author: "Foo", Text: "first", Timestamp: 1}},
+					{ID: "bulk-2", Message: &api.MessageEvent{Channel: "Bulk", Author: "Foo", Text: "second", Timestamp: 2}},
+				}
+				failed, err := store.BulkIndex(ctx, batch)
+				require.NoError(t, err)
+				require.Empty(t, failed)
+				// retried batches don't duplicate messages
+				_, err = store.BulkIndex(ctx, batch)
+				require.NoError(t, err)
+
+				page, err := store.FindMessages(ctx, &api.HistoryQuery{Channel: "Bulk", Limit: 10})
+				require.NoError(t, err)
+				require.Len(t, page.Messages, 2)
+				require.Equal(t, "second", page.Messages[0].Text)
+			})
+
+			t.Run("can rename and anonymize author of messages", func(t *testing.T) {
+				_, err := store.IndexMessage(&api.MessageEvent{Channel: "Foo", Author: "Renamed", Text: "legacy", Timestamp: 1})
+				require.NoError(t, err)
+				_, err = store.IndexMessage(&api.MessageEvent{Channel: "Foo", Author: "Renamed", AuthorID: 42, Text: "with id", Timestamp: 2})
+				require.NoError(t, err)
+
+				require.NoError(t, store.RenameAuthor(ctx, "Renamed", 42, "RenamedAgain"))
+				messages, err := store.FindMessagesByAuthor(ctx, "RenamedAgain", 0)
+				require.NoError(t, err)
+				require.Len(t, messages, 2)
+				assert.Equal(t, "legacy", messages[0].Text)
+				assert.Equal(t, int64(42), messages[0].AuthorID)
+
+				// sent under the previous nick, but stored after the rename
+				_, err = store.IndexMessage(&api.MessageEvent{Channel: "Foo", Author: "Renamed", AuthorID: 42, Text: "late", Timestamp: 3})
+				require.NoError(t, err)
+				messages, err = store.FindMessagesByAuthor(ctx, "RenamedAgain", 42)
+				require.NoError(t, err)
+				require.Len(t, messages, 3)
+
+				require.NoError(t, store.AnonymizeMessagesByAuthor(ctx, "RenamedAgain", 42))
+				messages, err = store.FindMessagesByAuthor(ctx, "RenamedAgain", 42)
+				require.NoError(t, err)
+				require.Empty(t, messages)
+				messages, err = store.FindMessagesByAuthor(ctx, api.DeletedUserNick, 0)
+				require.NoError(t, err)
+				require.Len(t, messages, 3)
+				assert.Zero(t, messages[0].AuthorID)
+			})
+
+			t.Run("can delete expired messages", func(t *testing.T) {
+				for _, msg := range []*api.MessageEvent{
+					{Channel: "Expiring", Author: "Foo", Text: "old", Timestamp: 100},
+					{Channel: "Expiring", Author: "Foo", Text: "new", Timestamp: 300},
+					{Channel: "Kept", Author: "Foo", Text: "old", Timestamp: 100},
+				} {
+					_, err := store.IndexMessage(msg)
+					require.NoError(t, err)
+				}
+
+				deleted, err := store.DeleteMessages(ctx, &ExpiredMessages{Channels: []string{"Expiring", "Kept"}, ExcludedChannels: []string{"Kept"}, Before: 200})
+				require.NoError(t, err)
+				require.Equal(t, int64(1), deleted)
+				page, err := store.FindMessages(ctx, &api.HistoryQuery{Channel: "Expiring", Search: "old", Limit: 10})
+				require.NoError(t, err)
+				require.Empty(t, page.Messages)
+			})
+		})
+	}
+}
+
+func TestParseTextQuery(t *testing.T) {
+	cases := map[string]*textQuery{
+		"quick fox":            {anyOf: [][]string{{`"quick"`}, {`"fox"`}}},
+		`"quick brown" -fox`:   {anyOf: [][]string{{`"quick brown"`}}, noneOf: []string{`"fox"`}},
+		"quick | lazy +dog":    {anyOf: [][]string{{`"quick"`, `"lazy"`}, {`"dog"`}}},
+		`"unterminated phrase`: {anyOf: [][]string{{`"unterminated phrase"`}}},
+		`fo"o  -`:              {anyOf: [][]string{{`"fo o"`}}},
+	}
+	for query, expected := range cases {
+		assert.Equal(t, expected, parseTextQuery(query), query)
+	}
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kacperf531/sockchat/api"
+)
+
+var reportPeriods = map[api.GroupBy]time.Duration{
+	api.GroupByMinute: time.Minute,
+	api.GroupByHour:   time.Hour,
+	api.GroupByDay:    24 * time.Hour,
+}
+
+// SQLUserReports generates the same reports as UserReports from messages kept by SQLMessageStore
+type SQLUserReports struct {
+	db      *sql.DB
+	dialect *sqlDialect
+}
+
+func NewSQLReportsService(db *sql.DB, backend string) (*SQLUserReports, error) {
+	dialect, err := dialectOf(backend)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLUserReports{db, dialect}, nil
+}
+
+func (s *SQLUserReports) GetUserActivityReport(opts *api.UserActivityReportOptions) (*api.UserActivityReport, error) {
+	if opts.From.After(opts.To) {
+		return nil, api.ErrInvalidRange
+	}
+	if opts.To.Sub(opts.From) > MaxReportSizeInDays*24*time.Hour {
+		return nil, api.ErrMaxReportSizeExceeded
+	}
+	var period time.Duration
+	if opts.GroupBy != "" {
+		var ok bool
+		if period, ok = reportPeriods[opts.GroupBy]; !ok {
+			return nil, api.ErrInvalidRequest
+		}
+	}
+
+	var c sqlConditions
+	if opts.AuthorID == 0 {
+		c.add("author = ?", opts.Author)
+	} else {
+		c.add("(author_id = ? OR author = ?)", opts.AuthorID, opts.Author)
+	}
+	c.add("timestamp >= ?", opts.From.Unix())
+	c.add("timestamp <= ?", opts.To.Unix())
+
+	report := &api.UserActivityReport{ChannelActivity: map[string]*api.ChannelActivity{}, From: opts.From, To: opts.To}
+	stmt := fmt.Sprintf("SELECT channel, COUNT(*) AS total FROM messages%s GROUP BY channel ORDER BY total DESC, channel LIMIT %d;", c.where(), MaxChannelsInReport)
+	err := s.query(stmt, c.args, func(rows *sql.Rows) error {
+		activity := &api.ChannelActivity{}
+		var channel string
+		if err := rows.Scan(&channel, &activity.TotalMessages); err != nil {
+			return err
+		}
+		report.ChannelActivity[channel] = activity
+		return nil
+	})
+	if err != nil {
+		log.Printf("error selecting activity of %s: %v", opts.Author, err)
+		return nil, api.ErrInternal
+	}
+	if period == 0 {
+		return report, nil
+	}
+
+	// periods are aligned to the epoch, the same way ES date histogram aligns them in UTC
+	counts := make(map[string]map[int64]int, len(report.ChannelActivity))
+	seconds := int64(period / time.Second)
+	stmt = fmt.Sprintf("SELECT channel, timestamp - timestamp %% %d AS period_start, COUNT(*) FROM messages%s GROUP BY channel, period_start;", seconds, c.where())
+	err = s.query(stmt, c.args, func(rows *sql.Rows) error {
+		var channel string
+		var start int64
+		var count int
+		if err := rows.Scan(&channel, &start, &count); err != nil {
+			return err
+		}
+		if counts[channel] == nil {
+			counts[channel] = map[int64]int{}
+		}
+		counts[channel][start] = count
+		return nil
+	})
+	if err != nil {
+		log.Printf("error selecting activity of %s: %v", opts.Author, err)
+		return nil, api.ErrInternal
+	}
+
+	layout := api.ReportsDateLayout
+	if opts.GroupBy == api.GroupByDay {
+		layout = time.DateOnly
+	}
+	first, last := opts.From.Unix()-opts.From.Unix()%seconds, opts.To.Unix()-opts.To.Unix()%seconds
+	for channel, activity := range report.ChannelActivity {
+		for start := first; start <= last; start += seconds {
+			activity.MessageCountDistribution = append(activity.MessageCountDistribution, api.DistributionEntry{
+				PeriodStart:      time.Unix(start, 0).UTC().Format(layout),
+				MessagesInPeriod: counts[channel][start],
+			})
+		}
+	}
+	return report, nil
+}
+
+func (s *SQLUserReports) query(stmt string, args []any, scan func(*sql.Rows) error) error {
+	rows, err := s.db.Query(s.dialect.rebind(stmt), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
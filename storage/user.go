@@ -3,8 +3,9 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
-	"os"
+	"strings"
 
 	"github.com/VividCortex/mysqlerr"
 	"github.com/go-sql-driver/mysql"
@@ -15,7 +16,20 @@ import (
 type UserStore interface {
 	InsertUser(context.Context, *User) error
 	UpdatePublicProfile(context.Context, *api.PublicProfile) error
+	UpdateAvatar(ctx context.Context, nick, avatar string) error
+	UpdatePasswordHash(ctx context.Context, nick, pwHash string) error
+	// UpdateNick renames the user along with references to the nick in other tables
+	UpdateNick(ctx context.Context, nick, newNick string) error
 	SelectUser(context.Context, string) (*User, error)
+	SelectNicksByIDs(ctx context.Context, ids []int64) (map[int64]string, error)
+	SelectUsers(ctx context.Context, offset, limit int) ([]*User, error)
+	UpdateDisabled(ctx context.Context, nick string, disabled bool) error
+	UpdateRole(ctx context.Context, nick string, role api.Role) error
+	UpdateHiddenFromDirectory(ctx context.Context, nick string, hidden bool) error
+	SearchUsers(ctx context.Context, query *UserQuery) ([]*User, error)
+	SelectTwoFactor(ctx context.Context, nick string) (*TwoFactor, error)
+	UpdateTwoFactor(ctx context.Context, nick string, tf *TwoFactor) error
+	DeleteUser(ctx context.Context, nick string) error
 }
 
 func NewUserStore(db *sql.DB) UserStore {
@@ -29,15 +43,61 @@ type userStore struct {
 }
 
 type User struct {
-	Nick        string
-	PwHash      string
-	Description string
+	ID                  int64 // immutable, unlike the nick
+	Nick                string
+	PwHash              string
+	Description         string
+	DisplayName         string
+	Pronouns            string
+	Timezone            string
+	Avatar              string // key of the image in the blob store
+	CustomFields        map[string]string
+	Role                api.Role
+	Disabled            bool
+	HiddenFromDirectory bool // excluded from SearchUsers results
+}
+
+// UserQuery matches nick or display name by prefix or, if Fuzzy is set,
+// if characters of the phrase appear in them in the same order, with anything in between
+type UserQuery struct {
+	Phrase string
+	Fuzzy  bool
+	Offset int
+	Limit  int
+}
+
+const userColumns = "id, nick, pw_hash, description, display_name, pronouns, timezone, avatar, custom_fields, role, disabled, hidden_from_directory"
+
+func scanUser(row rowScanner) (*User, error) {
+	var user User
+	var customFields string
+	if err := row.Scan(&user.ID, &user.Nick, &user.PwHash, &user.Description, &user.DisplayName, &user.Pronouns, &user.Timezone, &user.Avatar, &customFields, &user.Role, &user.Disabled, &user.HiddenFromDirectory); err != nil {
+		return nil, err
+	}
+	if customFields != "" {
+		if err := json.Unmarshal([]byte(customFields), &user.CustomFields); err != nil {
+			return nil, fmt.Errorf("could not decode custom fields: %w", err)
+		}
+	}
+	return &user, nil
+}
+
+// TwoFactor holds TOTP settings of a user; it is kept apart from User, so that it is never cached
+type TwoFactor struct {
+	// TOTPSecret is encrypted by the caller
+	TOTPSecret         string
+	TOTPEnabled        bool
+	RecoveryCodeHashes []string
 }
 
 func (s *userStore) InsertUser(ctx context.Context, u *User) error {
-	const stmt = "INSERT INTO users(nick, pw_hash, description) VALUES (?, ?, ?);  "
+	const stmt = "INSERT INTO users(nick, pw_hash, description, role) VALUES (?, ?, ?, ?);  "
 
-	res, err := s.db.ExecContext(ctx, stmt, u.Nick, u.PwHash, u.Description)
+	role := u.Role
+	if role == "" {
+		role = api.RoleUser
+	}
+	res, err := s.db.ExecContext(ctx, stmt, u.Nick, u.PwHash, u.Description, role)
 	if err != nil {
 		if driverErr, ok := err.(*mysql.MySQLError); ok {
 			if driverErr.Number == mysqlerr.ER_DUP_ENTRY {
@@ -47,6 +107,29 @@ func (s *userStore) InsertUser(ctx context.Context, u *User) error {
 		return fmt.Errorf("could not insert row: %w", err)
 	}
 
+	if u.ID, err = res.LastInsertId(); err != nil {
+		return fmt.Errorf("could not get inserted id: %w", err)
+	}
+
+	return nil
+}
+
+// UpdatePublicProfile updates all editable fields of the profile; avatar is updated with UpdateAvatar
+func (s *userStore) UpdatePublicProfile(ctx context.Context, u *api.PublicProfile) error {
+	const stmt = "UPDATE users SET description = ?, display_name = ?, pronouns = ?, timezone = ?, custom_fields = ? WHERE nick = ?;  "
+
+	var customFields []byte
+	if len(u.CustomFields) > 0 {
+		var err error
+		if customFields, err = json.Marshal(u.CustomFields); err != nil {
+			return fmt.Errorf("could not encode custom fields: %w", err)
+		}
+	}
+	res, err := s.db.ExecContext(ctx, stmt, u.Description, u.DisplayName, u.Pronouns, u.Timezone, string(customFields), u.Nick)
+	if err != nil {
+		return fmt.Errorf("could not update row: %w", err)
+	}
+
 	if _, err := res.RowsAffected(); err != nil {
 		return fmt.Errorf("could not get affected rows: %w", err)
 	}
@@ -54,10 +137,10 @@ func (s *userStore) InsertUser(ctx context.Context, u *User) error {
 	return nil
 }
 
-func (s *userStore) UpdatePublicProfile(ctx context.Context, u *api.PublicProfile) error {
-	const stmt = "UPDATE users SET description = ? WHERE nick = ?;  "
+func (s *userStore) UpdateAvatar(ctx context.Context, nick, avatar string) error {
+	const stmt = "UPDATE users SET avatar = ? WHERE nick = ?;  "
 
-	res, err := s.db.ExecContext(ctx, stmt, u.Description, u.Nick)
+	res, err := s.db.ExecContext(ctx, stmt, avatar, nick)
 	if err != nil {
 		return fmt.Errorf("could not update row: %w", err)
 	}
@@ -69,21 +152,267 @@ func (s *userStore) UpdatePublicProfile(ctx context.Context, u *api.PublicProfil
 	return nil
 }
 
+func (s *userStore) UpdatePasswordHash(ctx context.Context, nick, pwHash string) error {
+	const stmt = "UPDATE users SET pw_hash = ? WHERE nick = ?;  "
+
+	res, err := s.db.ExecContext(ctx, stmt, pwHash, nick)
+	if err != nil {
+		return fmt.Errorf("could not update row: %w", err)
+	}
+
+	if _, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("could not get affected rows: %w", err)
+	}
+
+	return nil
+}
+
+// nickReferences lists columns of other tables which store nicks of users
+var nickReferences = []struct{ table, column string }{
+	{"api_keys", "owner"},
+	{"identities", "nick"},
+	{"blocks", "blocker"},
+	{"blocks", "blocked"},
+	{"preferences", "nick"},
+}
+
+func (s *userStore) UpdateNick(ctx context.Context, nick, newNick string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "UPDATE users SET nick = ? WHERE nick = ?;  ", newNick, nick)
+	if err != nil {
+		if driverErr, ok := err.(*mysql.MySQLError); ok {
+			if driverErr.Number == mysqlerr.ER_DUP_ENTRY {
+				return api.ErrNickAlreadyUsed
+			}
+		}
+		return fmt.Errorf("could not update row: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return api.ErrUserNotFound
+	}
+	for _, ref := range nickReferences {
+		stmt := fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ?;  ", ref.table, ref.column, ref.column)
+		if _, err := tx.ExecContext(ctx, stmt, newNick, nick); err != nil {
+			return fmt.Errorf("could not update rows of %s: %w", ref.table, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return nil
+}
+
 func (s *userStore) SelectUser(ctx context.Context, nick string) (*User, error) {
-	var user User
-	if err := s.db.QueryRow("SELECT nick, pw_hash, description FROM users WHERE nick = ?;", nick).Scan(&user.Nick, &user.PwHash, &user.Description); err != nil {
+	user, err := scanUser(s.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE nick = ?;", nick))
+	if err != nil {
 		return nil, fmt.Errorf("could not get row: %w", err)
 	}
 
-	return &user, nil
+	return user, nil
+}
+
+// SelectNicksByIDs returns current nicks of the users; unknown ids are skipped
+func (s *userStore) SelectNicksByIDs(ctx context.Context, ids []int64) (map[int64]string, error) {
+	nicks := make(map[int64]string, len(ids))
+	if len(ids) == 0 {
+		return nicks, nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	rows, err := s.db.QueryContext(ctx, "SELECT id, nick FROM users WHERE id IN ("+placeholders+");", args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not get rows: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var nick string
+		if err := rows.Scan(&id, &nick); err != nil {
+			return nil, fmt.Errorf("could not get row: %w", err)
+		}
+		nicks[id] = nick
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not get rows: %w", err)
+	}
+
+	return nicks, nil
 }
 
-func ResetUsersTable(db *sql.DB) error {
-	db.Exec("DROP TABLE IF EXISTS users;")
-	commandBytes, err := os.ReadFile("../storage/create-users.sql")
+func (s *userStore) SelectUsers(ctx context.Context, offset, limit int) ([]*User, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+userColumns+" FROM users ORDER BY id LIMIT ? OFFSET ?;", limit, offset)
 	if err != nil {
-		return fmt.Errorf("could not load script for creating users")
+		return nil, fmt.Errorf("could not get rows: %w", err)
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not get row: %w", err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not get rows: %w", err)
 	}
-	_, err = db.Exec(string(commandBytes))
-	return err
+
+	return users, nil
+}
+
+func (s *userStore) UpdateDisabled(ctx context.Context, nick string, disabled bool) error {
+	const stmt = "UPDATE users SET disabled = ? WHERE nick = ?;  "
+
+	res, err := s.db.ExecContext(ctx, stmt, disabled, nick)
+	if err != nil {
+		return fmt.Errorf("could not update row: %w", err)
+	}
+
+	if _, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("could not get affected rows: %w", err)
+	}
+
+	return nil
+}
+
+func (s *userStore) UpdateRole(ctx context.Context, nick string, role api.Role) error {
+	const stmt = "UPDATE users SET role = ? WHERE nick = ?;  "
+
+	res, err := s.db.ExecContext(ctx, stmt, role, nick)
+	if err != nil {
+		return fmt.Errorf("could not update row: %w", err)
+	}
+
+	if _, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("could not get affected rows: %w", err)
+	}
+
+	return nil
+}
+
+func (s *userStore) UpdateHiddenFromDirectory(ctx context.Context, nick string, hidden bool) error {
+	const stmt = "UPDATE users SET hidden_from_directory = ? WHERE nick = ?;  "
+
+	res, err := s.db.ExecContext(ctx, stmt, hidden, nick)
+	if err != nil {
+		return fmt.Errorf("could not update row: %w", err)
+	}
+
+	if _, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("could not get affected rows: %w", err)
+	}
+
+	return nil
+}
+
+// SearchUsers returns enabled users listed in the directory, those matching by prefix first
+func (s *userStore) SearchUsers(ctx context.Context, query *UserQuery) ([]*User, error) {
+	const stmt = "SELECT " + userColumns + " FROM users" +
+		" WHERE hidden_from_directory = FALSE AND disabled = FALSE AND (nick LIKE ? OR display_name LIKE ?)" +
+		" ORDER BY (nick LIKE ? OR display_name LIKE ?) DESC, nick LIMIT ? OFFSET ?;"
+
+	prefixPattern := escapeLikePattern(query.Phrase) + "%"
+	pattern := prefixPattern
+	if query.Fuzzy {
+		pattern = fuzzyLikePattern(query.Phrase)
+	}
+	rows, err := s.db.QueryContext(ctx, stmt, pattern, pattern, prefixPattern, prefixPattern, query.Limit, query.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("could not get rows: %w", err)
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not get row: %w", err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not get rows: %w", err)
+	}
+
+	return users, nil
+}
+
+var likePatternEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func escapeLikePattern(phrase string) string {
+	return likePatternEscaper.Replace(phrase)
+}
+
+// fuzzyLikePattern turns "abc" into "%a%b%c%"
+func fuzzyLikePattern(phrase string) string {
+	var pattern strings.Builder
+	pattern.WriteString("%")
+	for _, r := range phrase {
+		pattern.WriteString(escapeLikePattern(string(r)))
+		pattern.WriteString("%")
+	}
+	return pattern.String()
+}
+
+func (s *userStore) SelectTwoFactor(ctx context.Context, nick string) (*TwoFactor, error) {
+	var tf TwoFactor
+	var recoveryCodes string
+	if err := s.db.QueryRowContext(ctx, "SELECT totp_secret, totp_enabled, recovery_codes FROM users WHERE nick = ?;", nick).Scan(&tf.TOTPSecret, &tf.TOTPEnabled, &recoveryCodes); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, api.ErrUserNotFound
+		}
+		return nil, fmt.Errorf("could not get row: %w", err)
+	}
+	tf.RecoveryCodeHashes = strings.Fields(recoveryCodes)
+
+	return &tf, nil
+}
+
+func (s *userStore) UpdateTwoFactor(ctx context.Context, nick string, tf *TwoFactor) error {
+	const stmt = "UPDATE users SET totp_secret = ?, totp_enabled = ?, recovery_codes = ? WHERE nick = ?;  "
+
+	res, err := s.db.ExecContext(ctx, stmt, tf.TOTPSecret, tf.TOTPEnabled, strings.Join(tf.RecoveryCodeHashes, " "), nick)
+	if err != nil {
+		return fmt.Errorf("could not update row: %w", err)
+	}
+
+	if _, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("could not get affected rows: %w", err)
+	}
+
+	return nil
+}
+
+func (s *userStore) DeleteUser(ctx context.Context, nick string) error {
+	const stmt = "DELETE FROM users WHERE nick = ?;  "
+
+	res, err := s.db.ExecContext(ctx, stmt, nick)
+	if err != nil {
+		return fmt.Errorf("could not delete row: %w", err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return api.ErrUserNotFound
+	}
+
+	return nil
 }
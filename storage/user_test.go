@@ -32,10 +32,42 @@ func TestUserStore(t *testing.T) {
 			userExists = true
 		}
 		err := store.UpdatePublicProfile(context.TODO(), &api.PublicProfile{
-			Nick:        "Foo",
-			Description: "Baz",
+			Nick:         "Foo",
+			Description:  "Baz",
+			DisplayName:  "Mr. Foo",
+			Pronouns:     "he/him",
+			Timezone:     "Europe/Warsaw",
+			CustomFields: map[string]string{"website": "https://foo.example"},
 		})
 		require.NoError(t, err)
+		user, err := store.SelectUser(context.TODO(), "Foo")
+		require.NoError(t, err)
+		assert.Equal(t, "Mr. Foo", user.DisplayName)
+		assert.Equal(t, "he/him", user.Pronouns)
+		assert.Equal(t, "Europe/Warsaw", user.Timezone)
+		assert.Equal(t, map[string]string{"website": "https://foo.example"}, user.CustomFields)
+	})
+
+	t.Run("updates existing user's avatar in DB", func(t *testing.T) {
+		if !userExists {
+			createUserFoo(t, store)
+			userExists = true
+		}
+		require.NoError(t, store.UpdateAvatar(context.TODO(), "Foo", "avatar.png"))
+		user, err := store.SelectUser(context.TODO(), "Foo")
+		require.NoError(t, err)
+		assert.Equal(t, "avatar.png", user.Avatar)
+	})
+
+	t.Run("updates existing user's password hash in DB", func(t *testing.T) {
+		if !userExists {
+			createUserFoo(t, store)
+			userExists = true
+		}
+		require.NoError(t, store.UpdatePasswordHash(context.TODO(), "Foo", "Qux"))
+		user, err := store.SelectUser(context.TODO(), "Foo")
+		require.NoError(t, err)
+		assert.Equal(t, "Qux", user.PwHash)
 	})
 
 	t.Run("returns existing user's info in DB", func(t *testing.T) {
@@ -46,8 +78,126 @@ func TestUserStore(t *testing.T) {
 		user, err := store.SelectUser(context.TODO(), "Foo")
 		require.NoError(t, err)
 		assert.Equal(t, "Foo", user.Nick)
+		assert.Equal(t, api.RoleUser, user.Role)
+		assert.False(t, user.Disabled)
 	})
 
+	t.Run("disables existing user and lists it in DB", func(t *testing.T) {
+		if !userExists {
+			createUserFoo(t, store)
+			userExists = true
+		}
+		require.NoError(t, store.UpdateDisabled(context.TODO(), "Foo", true))
+		users, err := store.SelectUsers(context.TODO(), 0, 10)
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		assert.Equal(t, "Foo", users[0].Nick)
+		assert.True(t, users[0].Disabled)
+	})
+
+	t.Run("updates existing user's two-factor settings in DB", func(t *testing.T) {
+		if !userExists {
+			createUserFoo(t, store)
+			userExists = true
+		}
+		tf, err := store.SelectTwoFactor(context.TODO(), "Foo")
+		require.NoError(t, err)
+		assert.Equal(t, &TwoFactor{RecoveryCodeHashes: []string{}}, tf)
+
+		updated := &TwoFactor{TOTPSecret: "encrypted", TOTPEnabled: true, RecoveryCodeHashes: []string{"foo", "bar"}}
+		require.NoError(t, store.UpdateTwoFactor(context.TODO(), "Foo", updated))
+		tf, err = store.SelectTwoFactor(context.TODO(), "Foo")
+		require.NoError(t, err)
+		assert.Equal(t, updated, tf)
+
+		_, err = store.SelectTwoFactor(context.TODO(), "NonExisting")
+		assert.Equal(t, api.ErrUserNotFound, err)
+	})
+
+	t.Run("deletes existing user from DB", func(t *testing.T) {
+		if !userExists {
+			createUserFoo(t, store)
+		}
+		require.NoError(t, store.DeleteUser(context.TODO(), "Foo"))
+		userExists = false
+		_, err := store.SelectUser(context.TODO(), "Foo")
+		assert.Error(t, err)
+		assert.Equal(t, api.ErrUserNotFound, store.DeleteUser(context.TODO(), "Foo"))
+	})
+
+	t.Run("searches users visible in directory", func(t *testing.T) {
+		for _, nick := range []string{"alice", "alfred", "malice", "al_hidden"} {
+			require.NoError(t, store.InsertUser(context.TODO(), &User{Nick: nick, PwHash: "Bar"}))
+		}
+		require.NoError(t, store.UpdatePublicProfile(context.TODO(), &api.PublicProfile{Nick: "malice", DisplayName: "Alice M."}))
+		require.NoError(t, store.UpdateHiddenFromDirectory(context.TODO(), "al_hidden", true))
+		nicks := func(users []*User) []string {
+			result := []string{}
+			for _, u := range users {
+				result = append(result, u.Nick)
+			}
+			return result
+		}
+
+		users, err := store.SearchUsers(context.TODO(), &UserQuery{Phrase: "ali", Limit: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"alice", "malice"}, nicks(users))
+
+		users, err = store.SearchUsers(context.TODO(), &UserQuery{Phrase: "afd", Fuzzy: true, Limit: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"alfred"}, nicks(users))
+
+		users, err = store.SearchUsers(context.TODO(), &UserQuery{Phrase: "al_", Limit: 10})
+		require.NoError(t, err)
+		assert.Empty(t, users)
+
+		users, err = store.SearchUsers(context.TODO(), &UserQuery{Phrase: "al", Offset: 1, Limit: 1})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"alice"}, nicks(users))
+	})
+
+}
+
+func TestUserStoreNickChange(t *testing.T) {
+	godotenv.Load("../.env")
+
+	db := mustSetUpTestDB(t)
+	defer db.Close()
+
+	store := NewUserStore(db)
+	foo := createUserFoo(t, store)
+	require.NotZero(t, foo.ID)
+	require.NoError(t, store.InsertUser(context.TODO(), &User{Nick: "Bar", PwHash: "Bar", Description: "desc"}))
+	require.NoError(t, NewIdentityStore(db).InsertIdentity(context.TODO(), &Identity{Issuer: "https://issuer.example.com", Subject: "foo", Nick: "Foo"}))
+	blocks := NewBlockStore(db)
+	require.NoError(t, blocks.InsertBlock(context.TODO(), "Bar", "Foo"))
+
+	t.Run("renames user keeping their id", func(t *testing.T) {
+		require.NoError(t, store.UpdateNick(context.TODO(), "Foo", "Qux"))
+		user, err := store.SelectUser(context.TODO(), "Qux")
+		require.NoError(t, err)
+		assert.Equal(t, foo.ID, user.ID)
+		_, err = store.SelectUser(context.TODO(), "Foo")
+		assert.Error(t, err)
+
+		nicks, err := store.SelectNicksByIDs(context.TODO(), []int64{foo.ID, foo.ID + 1000})
+		require.NoError(t, err)
+		assert.Equal(t, map[int64]string{foo.ID: "Qux"}, nicks)
+	})
+
+	t.Run("renames references to the nick in other tables", func(t *testing.T) {
+		identity, err := NewIdentityStore(db).SelectIdentity(context.TODO(), "https://issuer.example.com", "foo")
+		require.NoError(t, err)
+		assert.Equal(t, "Qux", identity.Nick)
+		blocked, err := blocks.SelectBlockedNicks(context.TODO(), "Bar")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Qux"}, blocked)
+	})
+
+	t.Run("can not rename user to a nick already in use", func(t *testing.T) {
+		assert.Equal(t, api.ErrNickAlreadyUsed, store.UpdateNick(context.TODO(), "Qux", "Bar"))
+		assert.Equal(t, api.ErrUserNotFound, store.UpdateNick(context.TODO(), "Foo", "Baz"))
+	})
 }
 
 func mustSetUpTestDB(t *testing.T) *sql.DB {
@@ -57,9 +207,13 @@ func mustSetUpTestDB(t *testing.T) *sql.DB {
 		t.Errorf("could not connect to the DB due to an error: %v", err)
 	}
 
-	err = ResetUsersTable(db)
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		t.Fatalf("could not load migrations: %v", err)
+	}
+	err = migrator.Reset(context.TODO())
 	if err != nil {
-		t.Errorf("error setting up the table %v", err)
+		t.Errorf("error setting up the tables %v", err)
 	}
 	return db
 }
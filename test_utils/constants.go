@@ -3,6 +3,9 @@ package test_utils
 const (
 	ValidUserNick         = "SpecialTestUser"
 	ValidUser2Nick        = "VerySpecialTestUser"
+	ValidServiceNick      = "SpecialTestService"
+	ValidAdminNick        = "SpecialTestAdmin"
+	ValidUser3Nick        = "YetAnotherSpecialTestUser" // disabled and re-enabled by admin tests only
 	ValidUserPassword     = "foo420"
 	ValidUserPasswordHash = "$2a$10$Xl002E7Vj5qM1RHMiM06KOCHofpLcPTIj7LeyZgTf62txoOBvoyia"
 	ValidUserDescription  = "I am a very special test user"
@@ -0,0 +1,171 @@
+package test_utils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	TestOIDCClientID     = "sockchat"
+	TestOIDCClientSecret = "sockchat-secret"
+	testOIDCKeyID        = "test-key"
+)
+
+// TestOIDCIssuer is an in-process OpenID Connect provider, which signs in the user set with SetUser without asking
+type TestOIDCIssuer struct {
+	*httptest.Server
+	key      *rsa.PrivateKey
+	subject  string
+	username string
+	audience string
+	codes    map[string]authorizationRequest
+	lock     sync.Mutex
+}
+
+type authorizationRequest struct {
+	nonce         string
+	codeChallenge string
+}
+
+func NewTestOIDCIssuer(t testing.TB) *TestOIDCIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("could not generate issuer key: %v", err)
+	}
+	issuer := &TestOIDCIssuer{key: key, audience: TestOIDCClientID, codes: make(map[string]authorizationRequest)}
+	router := http.NewServeMux()
+	router.HandleFunc("/.well-known/openid-configuration", issuer.serveDiscovery)
+	router.HandleFunc("/jwks", issuer.serveJWKS)
+	router.HandleFunc("/authorize", issuer.serveAuthorize)
+	router.HandleFunc("/token", issuer.serveToken)
+	issuer.Server = httptest.NewServer(router)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+// SetUser sets subject and preferred username of the user signing in
+func (i *TestOIDCIssuer) SetUser(subject, username string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.subject = subject
+	i.username = username
+}
+
+// SetAudience makes the issuer sign ID tokens for another client
+func (i *TestOIDCIssuer) SetAudience(audience string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.audience = audience
+}
+
+// Authorize visits authorization URL like a browser would and returns query of the redirect to the client
+func (i *TestOIDCIssuer) Authorize(t testing.TB, authURL string) url.Values {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("could not visit authorization URL: %v", err)
+	}
+	res.Body.Close()
+	location, err := res.Location()
+	if err != nil {
+		t.Fatalf("authorization endpoint did not redirect: %v", err)
+	}
+	return location.Query()
+}
+
+func (i *TestOIDCIssuer) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 i.URL,
+		"authorization_endpoint": i.URL + "/authorize",
+		"token_endpoint":         i.URL + "/token",
+		"jwks_uri":               i.URL + "/jwks",
+	})
+}
+
+func (i *TestOIDCIssuer) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": testOIDCKeyID,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(i.key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(i.key.E)).Bytes()),
+	}}})
+}
+
+func (i *TestOIDCIssuer) serveAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURL, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || query.Get("client_id") != TestOIDCClientID || query.Get("code_challenge_method") != "S256" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	codeBytes := make([]byte, 16)
+	rand.Read(codeBytes)
+	code := hex.EncodeToString(codeBytes)
+	i.lock.Lock()
+	i.codes[code] = authorizationRequest{nonce: query.Get("nonce"), codeChallenge: query.Get("code_challenge")}
+	i.lock.Unlock()
+	redirectQuery := redirectURL.Query()
+	redirectQuery.Set("code", code)
+	redirectQuery.Set("state", query.Get("state"))
+	redirectURL.RawQuery = redirectQuery.Encode()
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
+
+func (i *TestOIDCIssuer) serveToken(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok || clientID != TestOIDCClientID || clientSecret != TestOIDCClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	authorization, found := i.codes[r.PostFormValue("code")]
+	delete(i.codes, r.PostFormValue("code"))
+	challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !found || r.PostFormValue("grant_type") != "authorization_code" || base64.RawURLEncoding.EncodeToString(challenge[:]) != authorization.codeChallenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                i.URL,
+		"sub":                i.subject,
+		"aud":                i.audience,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              authorization.nonce,
+		"preferred_username": i.username,
+	})
+	token.Header["kid"] = testOIDCKeyID
+	idToken, err := token.SignedString(i.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"access_token": "unused", "token_type": "Bearer", "id_token": idToken})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -2,21 +2,26 @@ package test_utils
 
 import (
 	"context"
+	"sort"
+	"strings"
 	"sync"
+	"testing"
 	"time"
+	"unicode/utf8"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/kacperf531/sockchat/api"
 	"github.com/kacperf531/sockchat/storage"
 	"github.com/redis/go-redis/v9"
 )
 
-var TestingRedisClient = redis.NewClient(
-	&redis.Options{
-		Addr:     "localhost:6379",
-		Password: "",
-		DB:       1,
-	},
-)
+// NewTestingRedisClient connects to an in-memory Redis which is stopped when the test ends,
+// so that unit tests need no external services
+func NewTestingRedisClient(t testing.TB) *redis.Client {
+	t.Helper()
+	redisServer := miniredis.RunT(t)
+	return redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+}
 
 // StubChannelStore implements ChannelStore for testing purposes
 type StubChannelStore struct{}
@@ -59,20 +64,153 @@ func (store *StubChannelStore) MessageChannel(message *api.MessageEvent) error {
 	return nil
 }
 
+func (store *StubChannelStore) AddObserver(name string, observer api.SockchatChannelObserver) error {
+	if name == "not_exists" {
+		return api.ErrChannelDoesNotExist
+	}
+	observer.Write(api.NewSocketMessage(api.NewMessageEvent, api.MessageEvent{Channel: name, Author: ValidUserNick, Text: "observed"}))
+	return nil
+}
+
+func (store *StubChannelStore) RemoveObserver(name string, observer api.SockchatChannelObserver) {
+}
+
+func (store *StubChannelStore) NotifyNickChanged(user api.SockchatUserHandler, oldNick string) {
+	user.Write(api.NewSocketMessage(api.NickChangedEvent, api.NickChangeEvent{OldNick: oldNick, NewNick: user.GetNick()}))
+}
+
+func (store *StubChannelStore) ChannelsOf(nick string) []string {
+	return []string{ChannelWithUser}
+}
+
 type StubMessageStore struct {
 	Messages api.ChannelHistory
 	lock     sync.Mutex
 }
 
-func (s *StubMessageStore) FindMessages(ctx context.Context, channel, soughtPhrase string) (api.ChannelHistory, error) {
-	if soughtPhrase != "" {
+// FindMessages treats stored messages as sorted newest first; cursors point at positions in the slice
+func (s *StubMessageStore) FindMessages(ctx context.Context, query *api.HistoryQuery) (*api.ChannelHistoryPage, error) {
+	page := &api.ChannelHistoryPage{Messages: api.ChannelHistory{}}
+	if query.Search != "" {
 		// just assume that the results are filtered out
-		return nil, nil
+		return page, nil
+	}
+	position := -1
+	if query.Before != "" || query.After != "" {
+		cursor, err := api.DecodeCursor(query.Before + query.After)
+		if err != nil {
+			return nil, err
+		}
+		position = int(cursor.Seq)
+	}
+	excluded := make(map[string]bool, len(query.ExcludedAuthors))
+	for _, author := range query.ExcludedAuthors {
+		excluded[author] = true
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// simplified stub - channel filtering is in ES
+	var positions []int
+	for i, msg := range s.Messages {
+		if excluded[msg.Author] || msg.Timestamp < query.From {
+			continue
+		}
+		if query.After != "" && i >= position || query.Before != "" && i <= position {
+			continue
+		}
+		positions = append(positions, i)
+	}
+	if query.Limit > 0 && len(positions) > query.Limit {
+		next := query.Limit - 1
+		if query.After != "" {
+			positions = positions[len(positions)-query.Limit:]
+			next = 0
+		} else {
+			positions = positions[:query.Limit]
+		}
+		i := positions[next]
+		page.NextCursor = api.EncodeCursor(&api.HistoryCursor{Timestamp: s.Messages[i].Timestamp, Seq: int64(i)})
+	}
+	for _, i := range positions {
+		page.Messages = append(page.Messages, s.Messages[i])
+	}
+	return page, nil
+}
+
+// SearchMessages matches messages containing the query, ignoring search syntax; the whole text is returned as highlight
+func (s *StubMessageStore) SearchMessages(ctx context.Context, req *api.SearchMessagesRequest) (*api.SearchMessagesResponse, error) {
+	channels := make(map[string]bool, len(req.Channels))
+	for _, channel := range req.Channels {
+		channels[channel] = true
+	}
+	authors := make(map[string]bool, len(req.Authors))
+	for _, author := range req.Authors {
+		authors[author] = true
 	}
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	// simplified stub - channel filtering & sorting logic is in ES
-	return s.Messages, nil
+	res := &api.SearchMessagesResponse{Results: []*api.SearchResult{}}
+	for _, msg := range s.Messages {
+		if !channels[msg.Channel] || len(authors) > 0 && !authors[msg.Author] {
+			continue
+		}
+		if msg.Timestamp < req.From || req.To > 0 && msg.Timestamp > req.To {
+			continue
+		}
+		result := &api.SearchResult{Message: msg}
+		if req.Query != "" {
+			if !strings.Contains(strings.ToLower(msg.Text), strings.ToLower(req.Query)) {
+				continue
+			}
+			result.Score = 1
+			result.Highlights = []string{msg.Text}
+		}
+		res.Total++
+		res.Results = append(res.Results, result)
+	}
+	offset := req.Offset
+	if offset > len(res.Results) {
+		offset = len(res.Results)
+	}
+	res.Results = res.Results[offset:]
+	if req.Limit < len(res.Results) {
+		res.Results = res.Results[:req.Limit]
+	}
+	return res, nil
+}
+
+func (s *StubMessageStore) FindMessagesByAuthor(ctx context.Context, author string, authorID int64) (api.ChannelHistory, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	messages := api.ChannelHistory{}
+	for _, msg := range s.Messages {
+		if msg.Author == author || (authorID != 0 && msg.AuthorID == authorID) {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
+
+func (s *StubMessageStore) AnonymizeMessagesByAuthor(ctx context.Context, author string, authorID int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, msg := range s.Messages {
+		if msg.Author == author || (authorID != 0 && msg.AuthorID == authorID) {
+			msg.Author, msg.AuthorID = api.DeletedUserNick, 0
+		}
+	}
+	return nil
+}
+
+func (s *StubMessageStore) RenameAuthor(ctx context.Context, author string, authorID int64, newNick string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, msg := range s.Messages {
+		if msg.Author == author || (authorID != 0 && msg.AuthorID == authorID) {
+			msg.Author, msg.AuthorID = newNick, authorID
+		}
+	}
+	return nil
 }
 
 func (s *StubMessageStore) IndexMessage(*api.MessageEvent) (string, error) {
@@ -82,13 +220,37 @@ func (s *StubMessageStore) IndexMessage(*api.MessageEvent) (string, error) {
 	return "", nil
 }
 
-// Test double which spies create/update calls and stubs select request
+// Test double which spies create/update calls and stubs select request; inserted users can be selected too
 type UserStoreDouble struct {
-	CreateCalls []*storage.User
-	UpdateCalls []*api.PublicProfile
+	CreateCalls         []*storage.User
+	createLock          sync.RWMutex
+	UpdateCalls         []*api.PublicProfile
+	PasswordUpdateCalls []string
+	disabled            map[string]bool
+	disabledLock        sync.RWMutex
+	roles               map[string]api.Role
+	rolesLock           sync.RWMutex
+	twoFactor           map[string]*storage.TwoFactor
+	twoFactorLock       sync.RWMutex
+	deleted             map[string]bool
+	deletedLock         sync.RWMutex
+	avatars             map[string]string
+	avatarsLock         sync.RWMutex
+	hidden              map[string]bool
+	hiddenLock          sync.RWMutex
+	renames             map[string]string // current nick -> nick the user had in other fields of the double
+	renamesLock         sync.RWMutex
 }
 
+// ids of stubbed users; inserted users get ids from firstInsertedUserID on
+var stubbedUserIDs = map[string]int64{ValidUserNick: 1, ValidUser2Nick: 2, ValidUser3Nick: 3, ValidServiceNick: 4, ValidAdminNick: 5}
+
+const firstInsertedUserID = 100
+
 func (s *UserStoreDouble) InsertUser(ctx context.Context, u *storage.User) error {
+	s.createLock.Lock()
+	defer s.createLock.Unlock()
+	u.ID = firstInsertedUserID + int64(len(s.CreateCalls))
 	s.CreateCalls = append(s.CreateCalls, u)
 	if u.Nick == "already_exists" {
 		return api.ErrNickAlreadyUsed
@@ -101,23 +263,297 @@ func (s *UserStoreDouble) UpdatePublicProfile(ctx context.Context, u *api.Public
 	return nil
 }
 
+func (s *UserStoreDouble) UpdateAvatar(ctx context.Context, nick, avatar string) error {
+	s.avatarsLock.Lock()
+	defer s.avatarsLock.Unlock()
+	if s.avatars == nil {
+		s.avatars = make(map[string]string)
+	}
+	s.avatars[nick] = avatar
+	return nil
+}
+
+func (s *UserStoreDouble) UpdatePasswordHash(ctx context.Context, nick, pwHash string) error {
+	s.PasswordUpdateCalls = append(s.PasswordUpdateCalls, nick)
+	return nil
+}
+
 func (s *UserStoreDouble) SelectUser(ctx context.Context, nick string) (*storage.User, error) {
+	original, ok := s.originalNick(nick)
+	if !ok {
+		return nil, api.ErrUserNotFound
+	}
+	user, err := s.selectUser(original)
+	if err != nil {
+		return nil, err
+	}
+	if id, ok := stubbedUserIDs[original]; ok {
+		user.ID = id
+	}
+	user.Nick = nick
+	// description is stubbed by selectUser, other profile fields come from the latest update of the user
+	for i := len(s.UpdateCalls) - 1; i >= 0; i-- {
+		if u := s.UpdateCalls[i]; u.Nick == nick {
+			user.DisplayName, user.Pronouns, user.Timezone, user.CustomFields = u.DisplayName, u.Pronouns, u.Timezone, u.CustomFields
+			break
+		}
+	}
+	s.avatarsLock.RLock()
+	user.Avatar = s.avatars[nick]
+	s.avatarsLock.RUnlock()
+	s.hiddenLock.RLock()
+	user.HiddenFromDirectory = s.hidden[nick]
+	s.hiddenLock.RUnlock()
+	s.rolesLock.RLock()
+	if role, ok := s.roles[nick]; ok {
+		user.Role = role
+	}
+	s.rolesLock.RUnlock()
+	return user, nil
+}
+
+func (s *UserStoreDouble) selectUser(nick string) (*storage.User, error) {
 	var description string
 	if len(s.UpdateCalls) > 0 {
 		description = s.UpdateCalls[0].Description
 	} else {
 		description = ValidUserDescription
 	}
+	s.deletedLock.RLock()
+	deleted := s.deleted[nick]
+	s.deletedLock.RUnlock()
+	if deleted {
+		return nil, api.ErrUserNotFound
+	}
+	s.disabledLock.RLock()
+	disabled := s.disabled[nick]
+	s.disabledLock.RUnlock()
 	if nick == ValidUserNick {
-		return &storage.User{Nick: ValidUserNick, PwHash: ValidUserPasswordHash, Description: description}, nil
+		return &storage.User{Nick: ValidUserNick, PwHash: ValidUserPasswordHash, Description: description, Disabled: disabled}, nil
 	}
 	if nick == ValidUser2Nick {
-		return &storage.User{Nick: ValidUser2Nick, PwHash: ValidUserPasswordHash, Description: description}, nil
+		return &storage.User{Nick: ValidUser2Nick, PwHash: ValidUserPasswordHash, Description: description, Disabled: disabled}, nil
+	}
+	if nick == ValidUser3Nick {
+		return &storage.User{Nick: ValidUser3Nick, PwHash: ValidUserPasswordHash, Description: description, Disabled: disabled}, nil
+	}
+	if nick == ValidServiceNick {
+		return &storage.User{Nick: ValidServiceNick, PwHash: ValidUserPasswordHash, Description: description, Role: api.RoleService, Disabled: disabled}, nil
+	}
+	if nick == ValidAdminNick {
+		return &storage.User{Nick: ValidAdminNick, PwHash: ValidUserPasswordHash, Description: description, Role: api.RoleAdmin, Disabled: disabled}, nil
+	}
+	s.createLock.RLock()
+	defer s.createLock.RUnlock()
+	for _, u := range s.CreateCalls {
+		if u.Nick == nick && nick != "already_exists" {
+			inserted := *u
+			inserted.Disabled = disabled
+			return &inserted, nil
+		}
 	}
 	return nil, api.ErrUserNotFound
 
 }
 
+// originalNick returns nick under which the user is stored in the double; false if the user was renamed since
+func (s *UserStoreDouble) originalNick(nick string) (string, bool) {
+	s.renamesLock.RLock()
+	defer s.renamesLock.RUnlock()
+	if original, ok := s.renames[nick]; ok {
+		return original, true
+	}
+	for _, original := range s.renames {
+		if original == nick {
+			return "", false
+		}
+	}
+	return nick, true
+}
+
+func (s *UserStoreDouble) UpdateNick(ctx context.Context, nick, newNick string) error {
+	user, err := s.SelectUser(ctx, nick)
+	if err != nil {
+		return err
+	}
+	if _, err := s.SelectUser(ctx, newNick); err == nil || newNick == "already_exists" {
+		return api.ErrNickAlreadyUsed
+	}
+	original, _ := s.originalNick(user.Nick)
+	s.renamesLock.Lock()
+	defer s.renamesLock.Unlock()
+	if s.renames == nil {
+		s.renames = make(map[string]string)
+	}
+	delete(s.renames, nick)
+	s.renames[newNick] = original
+	return nil
+}
+
+func (s *UserStoreDouble) SelectNicksByIDs(ctx context.Context, ids []int64) (map[int64]string, error) {
+	var nicks []string
+	for nick := range stubbedUserIDs {
+		nicks = append(nicks, nick)
+	}
+	s.createLock.RLock()
+	for _, u := range s.CreateCalls {
+		nicks = append(nicks, u.Nick)
+	}
+	s.createLock.RUnlock()
+	s.renamesLock.RLock()
+	for nick := range s.renames {
+		nicks = append(nicks, nick)
+	}
+	s.renamesLock.RUnlock()
+
+	wanted := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	found := make(map[int64]string)
+	for _, nick := range nicks {
+		if u, err := s.SelectUser(ctx, nick); err == nil && wanted[u.ID] {
+			found[u.ID] = u.Nick
+		}
+	}
+	return found, nil
+}
+
+func (s *UserStoreDouble) SelectUsers(ctx context.Context, offset, limit int) ([]*storage.User, error) {
+	var users []*storage.User
+	for _, nick := range []string{ValidUserNick, ValidUser2Nick, ValidUser3Nick, ValidServiceNick, ValidAdminNick} {
+		u, _ := s.SelectUser(ctx, nick)
+		users = append(users, u)
+	}
+	if offset >= len(users) {
+		return []*storage.User{}, nil
+	}
+	users = users[offset:]
+	if limit < len(users) {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (s *UserStoreDouble) UpdateDisabled(ctx context.Context, nick string, disabled bool) error {
+	s.disabledLock.Lock()
+	defer s.disabledLock.Unlock()
+	if s.disabled == nil {
+		s.disabled = make(map[string]bool)
+	}
+	s.disabled[nick] = disabled
+	return nil
+}
+
+func (s *UserStoreDouble) UpdateRole(ctx context.Context, nick string, role api.Role) error {
+	s.rolesLock.Lock()
+	defer s.rolesLock.Unlock()
+	if s.roles == nil {
+		s.roles = make(map[string]api.Role)
+	}
+	s.roles[nick] = role
+	return nil
+}
+
+func (s *UserStoreDouble) UpdateHiddenFromDirectory(ctx context.Context, nick string, hidden bool) error {
+	s.hiddenLock.Lock()
+	defer s.hiddenLock.Unlock()
+	if s.hidden == nil {
+		s.hidden = make(map[string]bool)
+	}
+	s.hidden[nick] = hidden
+	return nil
+}
+
+// SearchUsers mimics the db query on stubbed and inserted users
+func (s *UserStoreDouble) SearchUsers(ctx context.Context, query *storage.UserQuery) ([]*storage.User, error) {
+	nicks := []string{ValidUserNick, ValidUser2Nick, ValidUser3Nick, ValidServiceNick, ValidAdminNick}
+	s.createLock.RLock()
+	for _, u := range s.CreateCalls {
+		nicks = append(nicks, u.Nick)
+	}
+	s.createLock.RUnlock()
+	var prefixMatches, otherMatches []*storage.User
+	for _, nick := range nicks {
+		u, err := s.SelectUser(ctx, nick)
+		if err != nil || u.Disabled || u.HiddenFromDirectory {
+			continue
+		}
+		if matchesPrefix(u, query.Phrase) {
+			prefixMatches = append(prefixMatches, u)
+		} else if query.Fuzzy && (matchesFuzzy(u.Nick, query.Phrase) || matchesFuzzy(u.DisplayName, query.Phrase)) {
+			otherMatches = append(otherMatches, u)
+		}
+	}
+	byNick := func(users []*storage.User) {
+		sort.Slice(users, func(i, j int) bool { return strings.ToLower(users[i].Nick) < strings.ToLower(users[j].Nick) })
+	}
+	byNick(prefixMatches)
+	byNick(otherMatches)
+	users := append(prefixMatches, otherMatches...)
+	if query.Offset >= len(users) {
+		return []*storage.User{}, nil
+	}
+	users = users[query.Offset:]
+	if query.Limit < len(users) {
+		users = users[:query.Limit]
+	}
+	return users, nil
+}
+
+func matchesPrefix(u *storage.User, phrase string) bool {
+	phrase = strings.ToLower(phrase)
+	return strings.HasPrefix(strings.ToLower(u.Nick), phrase) || strings.HasPrefix(strings.ToLower(u.DisplayName), phrase)
+}
+
+func matchesFuzzy(s, phrase string) bool {
+	s = strings.ToLower(s)
+	for _, r := range strings.ToLower(phrase) {
+		i := strings.IndexRune(s, r)
+		if i < 0 {
+			return false
+		}
+		s = s[i+utf8.RuneLen(r):]
+	}
+	return true
+}
+
+func (s *UserStoreDouble) DeleteUser(ctx context.Context, nick string) error {
+	if _, err := s.SelectUser(ctx, nick); err != nil {
+		return err
+	}
+	s.deletedLock.Lock()
+	defer s.deletedLock.Unlock()
+	if s.deleted == nil {
+		s.deleted = make(map[string]bool)
+	}
+	s.deleted[nick] = true
+	return nil
+}
+
+func (s *UserStoreDouble) SelectTwoFactor(ctx context.Context, nick string) (*storage.TwoFactor, error) {
+	if _, err := s.SelectUser(ctx, nick); err != nil {
+		return nil, err
+	}
+	s.twoFactorLock.RLock()
+	defer s.twoFactorLock.RUnlock()
+	if tf, ok := s.twoFactor[nick]; ok {
+		copied := *tf
+		return &copied, nil
+	}
+	return &storage.TwoFactor{}, nil
+}
+
+func (s *UserStoreDouble) UpdateTwoFactor(ctx context.Context, nick string, tf *storage.TwoFactor) error {
+	s.twoFactorLock.Lock()
+	defer s.twoFactorLock.Unlock()
+	if s.twoFactor == nil {
+		s.twoFactor = make(map[string]*storage.TwoFactor)
+	}
+	s.twoFactor[nick] = tf
+	return nil
+}
+
 type StubReportsService struct{}
 
 func (s *StubReportsService) GetUserActivityReport(opts *api.UserActivityReportOptions) (*api.UserActivityReport, error) {
@@ -139,3 +575,268 @@ func (s *StubReportsService) GetUserActivityReport(opts *api.UserActivityReportO
 		From: opts.From,
 		To:   opts.To}, nil
 }
+
+// In-memory API key store
+type APIKeyStoreDouble struct {
+	keys []*storage.APIKey
+	lock sync.Mutex
+}
+
+func (s *APIKeyStoreDouble) InsertAPIKey(ctx context.Context, k *storage.APIKey) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	k.ID = int64(len(s.keys) + 1)
+	stored := *k
+	s.keys = append(s.keys, &stored)
+	return nil
+}
+
+func (s *APIKeyStoreDouble) SelectAPIKeysByOwner(ctx context.Context, owner string) ([]*storage.APIKey, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	keys := []*storage.APIKey{}
+	for _, k := range s.keys {
+		if k.Owner == owner && !k.Revoked {
+			stored := *k
+			keys = append(keys, &stored)
+		}
+	}
+	return keys, nil
+}
+
+func (s *APIKeyStoreDouble) SelectAPIKeyByHash(ctx context.Context, keyHash string) (*storage.APIKey, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, k := range s.keys {
+		if k.KeyHash == keyHash {
+			stored := *k
+			return &stored, nil
+		}
+	}
+	return nil, api.ErrAPIKeyNotFound
+}
+
+func (s *APIKeyStoreDouble) RevokeAPIKey(ctx context.Context, owner string, id int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, k := range s.keys {
+		if k.ID == id && k.Owner == owner && !k.Revoked {
+			k.Revoked = true
+			return nil
+		}
+	}
+	return api.ErrAPIKeyNotFound
+}
+
+func (s *APIKeyStoreDouble) DeleteAPIKeysByOwner(ctx context.Context, owner string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	kept := []*storage.APIKey{}
+	for _, k := range s.keys {
+		if k.Owner != owner {
+			kept = append(kept, k)
+		}
+	}
+	s.keys = kept
+	return nil
+}
+
+// In-memory identity store
+type IdentityStoreDouble struct {
+	identities []*storage.Identity
+	lock       sync.Mutex
+}
+
+func (s *IdentityStoreDouble) InsertIdentity(ctx context.Context, i *storage.Identity) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, stored := range s.identities {
+		if stored.Issuer == i.Issuer && stored.Subject == i.Subject {
+			return api.ErrIdentityAlreadyLinked
+		}
+	}
+	stored := *i
+	s.identities = append(s.identities, &stored)
+	return nil
+}
+
+func (s *IdentityStoreDouble) SelectIdentity(ctx context.Context, issuer, subject string) (*storage.Identity, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, i := range s.identities {
+		if i.Issuer == issuer && i.Subject == subject {
+			stored := *i
+			return &stored, nil
+		}
+	}
+	return nil, api.ErrIdentityNotFound
+}
+
+func (s *IdentityStoreDouble) DeleteIdentitiesByNick(ctx context.Context, nick string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	kept := []*storage.Identity{}
+	for _, i := range s.identities {
+		if i.Nick != nick {
+			kept = append(kept, i)
+		}
+	}
+	s.identities = kept
+	return nil
+}
+
+// In-memory block store
+type BlockStoreDouble struct {
+	blocks map[string]map[string]bool
+	lock   sync.Mutex
+}
+
+func (s *BlockStoreDouble) InsertBlock(ctx context.Context, blocker, blocked string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.blocks == nil {
+		s.blocks = make(map[string]map[string]bool)
+	}
+	if s.blocks[blocker] == nil {
+		s.blocks[blocker] = make(map[string]bool)
+	}
+	s.blocks[blocker][blocked] = true
+	return nil
+}
+
+func (s *BlockStoreDouble) DeleteBlock(ctx context.Context, blocker, blocked string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.blocks[blocker], blocked)
+	return nil
+}
+
+func (s *BlockStoreDouble) SelectBlockedNicks(ctx context.Context, blocker string) ([]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	nicks := []string{}
+	for nick := range s.blocks[blocker] {
+		nicks = append(nicks, nick)
+	}
+	sort.Strings(nicks)
+	return nicks, nil
+}
+
+func (s *BlockStoreDouble) DeleteBlocksByNick(ctx context.Context, nick string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.blocks, nick)
+	for _, blocked := range s.blocks {
+		delete(blocked, nick)
+	}
+	return nil
+}
+
+type PreferencesStoreDouble struct {
+	preferences map[string]*api.Preferences
+	lock        sync.Mutex
+}
+
+func (s *PreferencesStoreDouble) SelectPreferences(ctx context.Context, nick string) (*api.Preferences, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if prefs, ok := s.preferences[nick]; ok {
+		selected := *prefs
+		return &selected, nil
+	}
+	return &api.Preferences{}, nil
+}
+
+func (s *PreferencesStoreDouble) UpsertPreferences(ctx context.Context, nick string, prefs *api.Preferences) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.preferences == nil {
+		s.preferences = make(map[string]*api.Preferences)
+	}
+	stored := *prefs
+	s.preferences[nick] = &stored
+	return nil
+}
+
+func (s *PreferencesStoreDouble) DeletePreferences(ctx context.Context, nick string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.preferences, nick)
+	return nil
+}
+
+// BulkIndexerDouble fails whole batches while it is down; failed messages are not indexed
+type BulkIndexerDouble struct {
+	indexed []*storage.BulkMessage
+	down    bool
+	calls   int
+	lock    sync.Mutex
+}
+
+func (s *BulkIndexerDouble) BulkIndex(ctx context.Context, batch []*storage.BulkMessage) ([]*storage.BulkMessage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.calls++
+	if s.down {
+		return nil, api.ErrInternal
+	}
+	s.indexed = append(s.indexed, batch...)
+	return nil, nil
+}
+
+func (s *BulkIndexerDouble) SetDown(down bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.down = down
+}
+
+func (s *BulkIndexerDouble) Indexed() []*storage.BulkMessage {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]*storage.BulkMessage{}, s.indexed...)
+}
+
+func (s *BulkIndexerDouble) Calls() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.calls
+}
+
+// MessagePurgerDouble deletes matching messages from the slice
+type MessagePurgerDouble struct {
+	Messages api.ChannelHistory
+	Err      error
+	lock     sync.Mutex
+}
+
+func (s *MessagePurgerDouble) DeleteMessages(ctx context.Context, expired *storage.ExpiredMessages) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.Err != nil {
+		return 0, s.Err
+	}
+	matches := func(channels []string, channel string) bool {
+		for _, c := range channels {
+			if c == channel {
+				return true
+			}
+		}
+		return false
+	}
+	kept := api.ChannelHistory{}
+	for _, msg := range s.Messages {
+		if msg.Timestamp < expired.Before && (len(expired.Channels) == 0 || matches(expired.Channels, msg.Channel)) && !matches(expired.ExcludedChannels, msg.Channel) {
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	deleted := int64(len(s.Messages) - len(kept))
+	s.Messages = kept
+	return deleted, nil
+}
+
+func (s *MessagePurgerDouble) Remaining() api.ChannelHistory {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append(api.ChannelHistory{}, s.Messages...)
+}
@@ -0,0 +1,320 @@
+package sockchat
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kacperf531/sockchat/api"
+	"github.com/kacperf531/sockchat/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	TOTPPeriod         = 30 * time.Second
+	TOTPDigits         = 6
+	DefaultTOTPIssuer  = "sockchat"
+	RecoveryCodesCount = 10
+
+	// number of periods before and after the current one in which codes are still accepted
+	totpSkew          = 1
+	totpModulus       = 1_000_000 // 10^TOTPDigits
+	totpSecretSize    = 20
+	totpUsedKeyPrefix = "totp_used:"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TwoFactorService manages TOTP (RFC 6238) second factor of users.
+// Secrets are stored encrypted with EncryptionKey (AES-128, 192 or 256) and recovery codes are stored as hashes.
+// Used codes are remembered in Redis, so that every code is accepted only once.
+type TwoFactorService struct {
+	Profiles      *ProfileService
+	Cache         *redis.Client
+	Audit         api.SockchatAuditLog
+	EncryptionKey []byte
+	Issuer        string
+}
+
+// Enroll generates a new secret, which is not required at login until it is confirmed
+func (s *TwoFactorService) Enroll(ctx context.Context, nick string) (*api.TOTPEnrollment, error) {
+	tf, err := s.getTwoFactor(ctx, nick)
+	if err != nil {
+		return nil, err
+	}
+	if tf.TOTPEnabled {
+		return nil, api.ErrTOTPAlreadyEnabled
+	}
+	secretBytes := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secretBytes); err != nil {
+		log.Printf("error generating TOTP secret: %v", err)
+		return nil, api.ErrInternal
+	}
+	secret := totpEncoding.EncodeToString(secretBytes)
+	encrypted, err := s.encrypt(secret)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.updateTwoFactor(ctx, nick, &storage.TwoFactor{TOTPSecret: encrypted}); err != nil {
+		return nil, err
+	}
+	return &api.TOTPEnrollment{Secret: secret, URI: s.uri(nick, secret)}, nil
+}
+
+// Confirm enables TOTP once the user proves to have the secret and returns recovery codes
+func (s *TwoFactorService) Confirm(ctx context.Context, nick, code string) (*api.RecoveryCodes, error) {
+	tf, err := s.getTwoFactor(ctx, nick)
+	if err != nil {
+		return nil, err
+	}
+	if tf.TOTPEnabled {
+		return nil, api.ErrTOTPAlreadyEnabled
+	}
+	if tf.TOTPSecret == "" {
+		return nil, api.ErrTOTPNotEnrolled
+	}
+	secret, err := s.decrypt(tf.TOTPSecret)
+	if err != nil {
+		return nil, err
+	}
+	if !s.validateTOTP(ctx, nick, secret, code) {
+		return nil, api.ErrInvalidOTP
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.updateTwoFactor(ctx, nick, &storage.TwoFactor{TOTPSecret: tf.TOTPSecret, TOTPEnabled: true, RecoveryCodeHashes: hashes}); err != nil {
+		return nil, err
+	}
+	s.record(ctx, api.AuditTOTPEnabled, nick)
+	return &api.RecoveryCodes{RecoveryCodes: codes}, nil
+}
+
+// Disable removes the secret and recovery codes; it requires a valid TOTP or recovery code
+func (s *TwoFactorService) Disable(ctx context.Context, nick, code string) error {
+	tf, err := s.getTwoFactor(ctx, nick)
+	if err != nil {
+		return err
+	}
+	if !tf.TOTPEnabled {
+		return api.ErrTOTPNotEnabled
+	}
+	if err := s.verifyCode(ctx, nick, tf, code); err != nil {
+		return err
+	}
+	if err := s.updateTwoFactor(ctx, nick, &storage.TwoFactor{}); err != nil {
+		return err
+	}
+	s.record(ctx, api.AuditTOTPDisabled, nick)
+	return nil
+}
+
+func (s *TwoFactorService) Verify(ctx context.Context, nick, code string) error {
+	tf, err := s.getTwoFactor(ctx, nick)
+	if err != nil {
+		return err
+	}
+	if !tf.TOTPEnabled {
+		return nil
+	}
+	return s.verifyCode(ctx, nick, tf, code)
+}
+
+// verifyCode accepts a TOTP code or consumes a recovery code
+func (s *TwoFactorService) verifyCode(ctx context.Context, nick string, tf *storage.TwoFactor, code string) error {
+	if code == "" {
+		return api.ErrOTPRequired
+	}
+	secret, err := s.decrypt(tf.TOTPSecret)
+	if err != nil {
+		return err
+	}
+	if s.validateTOTP(ctx, nick, secret, code) {
+		return nil
+	}
+	hash := recoveryCodeHash(code)
+	for i, h := range tf.RecoveryCodeHashes {
+		if !hmac.Equal([]byte(h), []byte(hash)) || !s.markUsed(ctx, nick, hash) {
+			continue
+		}
+		remaining := append(tf.RecoveryCodeHashes[:i:i], tf.RecoveryCodeHashes[i+1:]...)
+		return s.updateTwoFactor(ctx, nick, &storage.TwoFactor{TOTPSecret: tf.TOTPSecret, TOTPEnabled: true, RecoveryCodeHashes: remaining})
+	}
+	return api.ErrInvalidOTP
+}
+
+// validateTOTP checks the code against current period (with skew) and marks it as used
+func (s *TwoFactorService) validateTOTP(ctx context.Context, nick, secret, code string) bool {
+	if len(code) != TOTPDigits {
+		return false
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		log.Printf("error decoding TOTP secret of %s: %v", nick, err)
+		return false
+	}
+	counter := time.Now().Unix() / int64(TOTPPeriod.Seconds())
+	for offset := int64(-totpSkew); offset <= totpSkew; offset++ {
+		if hmac.Equal([]byte(totpCode(key, counter+offset)), []byte(code)) {
+			return s.markUsed(ctx, nick, strconv.FormatInt(counter+offset, 10))
+		}
+	}
+	return false
+}
+
+// markUsed returns false if the code was already used
+func (s *TwoFactorService) markUsed(ctx context.Context, nick, code string) bool {
+	ok, err := s.Cache.SetNX(ctx, totpUsedKeyPrefix+nick+":"+code, 1, (2*totpSkew+1)*TOTPPeriod).Result()
+	if err != nil {
+		log.Printf("error storing used TOTP code: %v", err)
+		return false
+	}
+	return ok
+}
+
+func (s *TwoFactorService) getTwoFactor(ctx context.Context, nick string) (*storage.TwoFactor, error) {
+	if nick == "" {
+		return nil, api.ErrNickRequired
+	}
+	tf, err := s.Profiles.Store.SelectTwoFactor(ctx, nick)
+	if err != nil {
+		if err == api.ErrUserNotFound {
+			return nil, err
+		}
+		log.Printf("error selecting two-factor settings from db: %v", err)
+		return nil, api.ErrInternal
+	}
+	return tf, nil
+}
+
+func (s *TwoFactorService) updateTwoFactor(ctx context.Context, nick string, tf *storage.TwoFactor) error {
+	if err := s.Profiles.Store.UpdateTwoFactor(ctx, nick, tf); err != nil {
+		log.Printf("error updating two-factor settings in db: %v", err)
+		return api.ErrInternal
+	}
+	return nil
+}
+
+func (s *TwoFactorService) uri(nick, secret string) string {
+	issuer := s.Issuer
+	if issuer == "" {
+		issuer = DefaultTOTPIssuer
+	}
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", strconv.Itoa(TOTPDigits))
+	query.Set("period", strconv.Itoa(int(TOTPPeriod.Seconds())))
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+nick) + "?" + query.Encode()
+}
+
+func (s *TwoFactorService) encrypt(secret string) (string, error) {
+	gcm, err := s.cipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		log.Printf("error generating nonce: %v", err)
+		return "", api.ErrInternal
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(secret), nil)), nil
+}
+
+func (s *TwoFactorService) decrypt(encrypted string) (string, error) {
+	gcm, err := s.cipher()
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil || len(data) < gcm.NonceSize() {
+		log.Printf("error decoding encrypted TOTP secret: %v", err)
+		return "", api.ErrInternal
+	}
+	secret, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		log.Printf("error decrypting TOTP secret: %v", err)
+		return "", api.ErrInternal
+	}
+	return string(secret), nil
+}
+
+func (s *TwoFactorService) cipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.EncryptionKey)
+	if err != nil {
+		log.Printf("error creating TOTP secret cipher: %v", err)
+		return nil, api.ErrInternal
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		log.Printf("error creating TOTP secret cipher: %v", err)
+		return nil, api.ErrInternal
+	}
+	return gcm, nil
+}
+
+func (s *TwoFactorService) record(ctx context.Context, eventType, nick string) {
+	if s.Audit == nil {
+		return
+	}
+	s.Audit.Record(ctx, &api.AuditEvent{Type: eventType, Nick: nick, Timestamp: time.Now()})
+}
+
+// GenerateTOTPCode returns code for the base32-encoded secret valid at the given time
+func GenerateTOTPCode(secret string, at time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	return totpCode(key, at.Unix()/int64(TOTPPeriod.Seconds())), nil
+}
+
+// totpCode implements HOTP (RFC 4226) with SHA-1
+func totpCode(key []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", TOTPDigits, value%totpModulus)
+}
+
+func newRecoveryCodes() (codes []string, hashes []string, err error) {
+	for i := 0; i < RecoveryCodesCount; i++ {
+		codeBytes := make([]byte, 5)
+		if _, err := rand.Read(codeBytes); err != nil {
+			log.Printf("error generating recovery code: %v", err)
+			return nil, nil, api.ErrInternal
+		}
+		code := hex.EncodeToString(codeBytes)
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, recoveryCodeHash(code))
+	}
+	return codes, hashes, nil
+}
+
+// recoveryCodeHash ignores case and dashes, so that codes can be typed in loosely
+func recoveryCodeHash(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	hash := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(hash[:])
+}
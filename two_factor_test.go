@@ -0,0 +1,117 @@
+package sockchat
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kacperf531/sockchat/api"
+	"github.com/kacperf531/sockchat/test_utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateTOTPCode(t *testing.T) {
+	// RFC 6238 test vectors (SHA-1), truncated to 6 digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	for at, want := range map[int64]string{59: "287082", 1111111109: "081804", 2000000000: "279037"} {
+		code, err := GenerateTOTPCode(secret, time.Unix(at, 0))
+		require.NoError(t, err)
+		assert.Equal(t, want, code)
+	}
+}
+
+func TestTwoFactorService(t *testing.T) {
+	cache := test_utils.NewTestingRedisClient(t)
+	ctx := context.Background()
+	store := &test_utils.UserStoreDouble{}
+	service := &TwoFactorService{
+		Profiles:      &ProfileService{Store: store, Cache: cache},
+		Cache:         cache,
+		EncryptionKey: []byte("0123456789abcdef0123456789abcdef"),
+	}
+	nick := test_utils.ValidUser2Nick
+
+	t.Run("does not require code before enrollment", func(t *testing.T) {
+		assert.NoError(t, service.Verify(ctx, nick, ""))
+	})
+
+	t.Run("returns error for confirming without enrollment", func(t *testing.T) {
+		_, err := service.Confirm(ctx, nick, "123456")
+		assert.Equal(t, api.ErrTOTPNotEnrolled, err)
+	})
+
+	enrollment, err := service.Enroll(ctx, nick)
+	require.NoError(t, err)
+
+	t.Run("returns otpauth URI with the secret", func(t *testing.T) {
+		uri, err := url.Parse(enrollment.URI)
+		require.NoError(t, err)
+		assert.Equal(t, "otpauth", uri.Scheme)
+		assert.Equal(t, "/"+DefaultTOTPIssuer+":"+nick, uri.Path)
+		assert.Equal(t, enrollment.Secret, uri.Query().Get("secret"))
+	})
+
+	t.Run("stores the secret encrypted", func(t *testing.T) {
+		tf, err := store.SelectTwoFactor(ctx, nick)
+		require.NoError(t, err)
+		assert.False(t, tf.TOTPEnabled)
+		assert.NotContains(t, tf.TOTPSecret, enrollment.Secret)
+	})
+
+	t.Run("does not require code until enrollment is confirmed", func(t *testing.T) {
+		assert.NoError(t, service.Verify(ctx, nick, ""))
+	})
+
+	t.Run("rejects invalid confirmation code", func(t *testing.T) {
+		_, err := service.Confirm(ctx, nick, "000000x")
+		assert.Equal(t, api.ErrInvalidOTP, err)
+	})
+
+	code, err := GenerateTOTPCode(enrollment.Secret, time.Now())
+	require.NoError(t, err)
+	recoveryCodes, err := service.Confirm(ctx, nick, code)
+	require.NoError(t, err)
+	require.Len(t, recoveryCodes.RecoveryCodes, RecoveryCodesCount)
+
+	t.Run("requires code once enabled", func(t *testing.T) {
+		assert.Equal(t, api.ErrOTPRequired, service.Verify(ctx, nick, ""))
+		assert.Equal(t, api.ErrInvalidOTP, service.Verify(ctx, nick, "123"))
+	})
+
+	t.Run("returns error for enrolling again", func(t *testing.T) {
+		_, err := service.Enroll(ctx, nick)
+		assert.Equal(t, api.ErrTOTPAlreadyEnabled, err)
+	})
+
+	t.Run("accepts code only once", func(t *testing.T) {
+		assert.Equal(t, api.ErrInvalidOTP, service.Verify(ctx, nick, code))
+		nextCode, err := GenerateTOTPCode(enrollment.Secret, time.Now().Add(TOTPPeriod))
+		require.NoError(t, err)
+		assert.NoError(t, service.Verify(ctx, nick, nextCode))
+		assert.Equal(t, api.ErrInvalidOTP, service.Verify(ctx, nick, nextCode))
+	})
+
+	t.Run("accepts recovery code only once", func(t *testing.T) {
+		recoveryCode := strings.ToUpper(recoveryCodes.RecoveryCodes[0])
+		assert.NoError(t, service.Verify(ctx, nick, recoveryCode))
+		assert.Equal(t, api.ErrInvalidOTP, service.Verify(ctx, nick, recoveryCode))
+		tf, err := store.SelectTwoFactor(ctx, nick)
+		require.NoError(t, err)
+		assert.Len(t, tf.RecoveryCodeHashes, RecoveryCodesCount-1)
+	})
+
+	t.Run("disables TOTP with recovery code", func(t *testing.T) {
+		assert.Equal(t, api.ErrInvalidOTP, service.Disable(ctx, nick, "foo"))
+		require.NoError(t, service.Disable(ctx, nick, recoveryCodes.RecoveryCodes[1]))
+		assert.NoError(t, service.Verify(ctx, nick, ""))
+		assert.Equal(t, api.ErrTOTPNotEnabled, service.Disable(ctx, nick, recoveryCodes.RecoveryCodes[2]))
+	})
+
+	t.Run("returns error for non-existing user", func(t *testing.T) {
+		_, err := service.Enroll(ctx, "NonExistingUser")
+		assert.Equal(t, api.ErrUserNotFound, err)
+	})
+}
@@ -1,6 +1,7 @@
 package sockchat
 
 import (
+	"context"
 	"log"
 	"sync"
 	"time"
@@ -8,12 +9,26 @@ import (
 	"github.com/kacperf531/sockchat/api"
 )
 
+const (
+	// blockListTimeout bounds block & unblock requests made over websocket
+	blockListTimeout     = 5 * time.Second
+	profileLookupTimeout = 5 * time.Second
+	// preferencesTimeout bounds reading & updating preferences over websocket
+	preferencesTimeout = 5 * time.Second
+)
+
 // ConnectedUsersPool is responsible for tracking all user handlers
 type ConnectedUsersPool struct {
 	handlers     map[string]api.SockchatUserHandler
 	connections  map[api.SockchatWebsocketConnection]string
 	lock         sync.RWMutex
 	channelStore api.SockchatChannelStore
+	// BlockList is optional; without it users can not block each other
+	BlockList api.SockchatBlockList
+	// Profiles is optional; without it messages are stored without id of their author
+	Profiles api.SockchatProfileStore
+	// Preferences is optional; without it preferences can not be managed over websocket
+	Preferences api.SockchatPreferences
 }
 
 func NewConnectedUsersPool(channelStore api.SockchatChannelStore) *ConnectedUsersPool {
@@ -43,8 +58,28 @@ func (m *ConnectedUsersPool) AddConnection(conn api.SockchatWebsocketConnection,
 	m.connections[conn] = nick
 }
 
+// GetConnectionHandler returns handler of the user who opened the connection, whatever their current nick is
+func (m *ConnectedUsersPool) GetConnectionHandler(conn api.SockchatWebsocketConnection) (api.SockchatUserHandler, bool) {
+	m.lock.RLock()
+	nick, ok := m.connections[conn]
+	m.lock.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return m.GetHandler(nick)
+}
+
 func (m *ConnectedUsersPool) addHandler(nick string) api.SockchatUserHandler {
-	handler := NewUserHandler(nick, m.channelStore)
+	var id int64
+	if m.Profiles != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), profileLookupTimeout)
+		defer cancel()
+		var err error
+		if id, err = m.Profiles.GetUserID(ctx, nick); err != nil {
+			log.Printf("warning: could not get id of %s: %v", nick, err)
+		}
+	}
+	handler := NewUserHandler(nick, id, m.channelStore, m.BlockList, m.Preferences)
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	m.handlers[nick] = handler
@@ -63,6 +98,9 @@ func (m *ConnectedUsersPool) RemoveConnection(conn api.SockchatWebsocketConnecti
 
 	if handler.GetActiveConnectionsCount() > 1 {
 		handler.RemoveConnection(conn)
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		delete(m.connections, conn)
 	} else {
 		m.lock.Lock()
 		defer m.lock.Unlock()
@@ -72,13 +110,62 @@ func (m *ConnectedUsersPool) RemoveConnection(conn api.SockchatWebsocketConnecti
 	}
 }
 
+// RenameUser moves connections of the user to the new nick and notifies members of channels the user is in
+func (m *ConnectedUsersPool) RenameUser(nick, newNick string) {
+	m.lock.Lock()
+	handler, ok := m.handlers[nick]
+	if !ok {
+		m.lock.Unlock()
+		return
+	}
+	delete(m.handlers, nick)
+	m.handlers[newNick] = handler
+	for conn, connNick := range m.connections {
+		if connNick == nick {
+			m.connections[conn] = newNick
+		}
+	}
+	m.lock.Unlock()
+
+	handler.SetNick(newNick)
+	m.channelStore.NotifyNickChanged(handler, nick)
+}
+
+// DisconnectUser notifies the user and closes all of their connections; returns number of closed connections.
+// Connections are removed from the pool by their serving loops once they are closed.
+func (m *ConnectedUsersPool) DisconnectUser(nick string) int {
+	handler, ok := m.GetHandler(nick)
+	if !ok {
+		return 0
+	}
+	m.lock.RLock()
+	var connections []api.SockchatWebsocketConnection
+	for conn, connNick := range m.connections {
+		if connNick == nick {
+			connections = append(connections, conn)
+		}
+	}
+	m.lock.RUnlock()
+
+	handler.Write(api.NewSocketMessage(api.DisconnectedEvent, "{}"))
+	for _, conn := range connections {
+		if err := conn.Close(); err != nil {
+			log.Printf("error closing connection of %s: %v", nick, err)
+		}
+	}
+	return len(connections)
+}
+
 // UserHandler manages connections of a single connected user
 type UserHandler struct {
 	nick         string
+	id           int64
 	connections  map[api.SockchatWebsocketConnection]bool
 	requests     chan *UserHandlerRequest
 	lock         sync.RWMutex
 	channelStore api.SockchatChannelStore
+	blockList    api.SockchatBlockList
+	preferences  api.SockchatPreferences
 }
 
 type UserHandlerRequest struct {
@@ -87,12 +174,16 @@ type UserHandlerRequest struct {
 	errCallback chan error
 }
 
-func NewUserHandler(nick string, store api.SockchatChannelStore) *UserHandler {
+// NewUserHandler creates handler of the user; id is stored along with messages of the user, zero if unknown
+func NewUserHandler(nick string, id int64, store api.SockchatChannelStore, blockList api.SockchatBlockList, preferences api.SockchatPreferences) *UserHandler {
 	handler := UserHandler{
 		nick:         nick,
+		id:           id,
 		connections:  make(map[api.SockchatWebsocketConnection]bool),
 		requests:     make(chan *UserHandlerRequest),
 		channelStore: store,
+		blockList:    blockList,
+		preferences:  preferences,
 	}
 	go handler.HandleRequests()
 	return &handler
@@ -125,9 +216,55 @@ func (u *UserHandler) HandleRequests() {
 				req.errCallback <- api.ErrUserNotInChannel
 				continue
 			}
-			req.errCallback <- u.channelStore.MessageChannel(&api.MessageEvent{Text: reqFields.Text, Channel: reqFields.Channel, Author: u.GetNick(), Timestamp: time.Now().Unix()})
+			req.errCallback <- u.channelStore.MessageChannel(&api.MessageEvent{Text: reqFields.Text, Channel: reqFields.Channel, Author: u.GetNick(), AuthorID: u.id, Timestamp: time.Now().Unix()})
+		case api.BlockAction, api.UnblockAction:
+			req.errCallback <- u.changeBlockList(req.action, req.payload.(*api.BlockRequest).Nick)
+		case api.GetPreferencesAction, api.UpdatePreferencesAction:
+			req.errCallback <- u.syncPreferences(req.action, req.payload)
+		}
+	}
+}
+
+func (u *UserHandler) changeBlockList(action, nick string) error {
+	if u.blockList == nil {
+		return api.ErrInternal
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), blockListTimeout)
+	defer cancel()
+	var err error
+	event := api.UserBlockedEvent
+	if action == api.BlockAction {
+		err = u.blockList.Block(ctx, u.GetNick(), nick)
+	} else {
+		event = api.UserUnblockedEvent
+		err = u.blockList.Unblock(ctx, u.GetNick(), nick)
+	}
+	if err == nil {
+		go u.Write(api.NewSocketMessage(event, api.BlockRequest{Nick: nick}))
+	}
+	return err
+}
+
+// syncPreferences writes preferences of the user to all of their connections, after updating them if requested
+func (u *UserHandler) syncPreferences(action string, payload any) error {
+	if u.preferences == nil {
+		return api.ErrInternal
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), preferencesTimeout)
+	defer cancel()
+	event := api.PreferencesEvent
+	if action == api.UpdatePreferencesAction {
+		event = api.PreferencesUpdatedEvent
+		if err := u.preferences.UpdatePreferences(ctx, u.GetNick(), payload.(*api.Preferences)); err != nil {
+			return err
 		}
 	}
+	prefs, err := u.preferences.GetPreferences(ctx, u.GetNick())
+	if err != nil {
+		return err
+	}
+	go u.Write(api.NewSocketMessage(event, prefs))
+	return nil
 }
 
 func (u *UserHandler) MakeRequest(action string, payload any) error {
@@ -169,5 +306,13 @@ func (u *UserHandler) GetActiveConnectionsCount() int {
 }
 
 func (u *UserHandler) GetNick() string {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
 	return u.nick
 }
+
+func (u *UserHandler) SetNick(nick string) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.nick = nick
+}
@@ -1,16 +1,22 @@
 package sockchat
 
 import (
+	"context"
 	"testing"
+	"time"
 
+	"github.com/kacperf531/sockchat/api"
 	"github.com/kacperf531/sockchat/services"
 	"github.com/kacperf531/sockchat/test_utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestUserManager(t *testing.T) {
 	t.Parallel()
 
+	cache := test_utils.NewTestingRedisClient(t)
+
 	messageStore := &test_utils.StubMessageStore{}
 	store := NewChannelStore(messageStore)
 	userManager := NewConnectedUsersPool(store)
@@ -34,4 +40,105 @@ func TestUserManager(t *testing.T) {
 		_, handlerExists := userManager.GetHandler("dummy")
 		assert.True(t, handlerExists)
 	})
+
+	t.Run("All connections of disconnected user are notified and closed", func(t *testing.T) {
+		conn := &spyConnection{}
+		otherConn := &spyConnection{}
+		userManager.AddConnection(conn, "disconnected")
+		userManager.AddConnection(otherConn, "disconnected")
+
+		assert.Equal(t, 2, userManager.DisconnectUser("disconnected"))
+		for _, c := range []*spyConnection{conn, otherConn} {
+			assert.True(t, c.closed)
+			assert.Equal(t, api.DisconnectedEvent, c.written[0].Action)
+		}
+		assert.Zero(t, userManager.DisconnectUser("not_connected"))
+	})
+
+	t.Run("User can block and unblock others over websocket", func(t *testing.T) {
+		profiles := &ProfileService{Store: &test_utils.UserStoreDouble{}, Cache: cache}
+		blockList := &BlockListService{Store: &test_utils.BlockStoreDouble{}, Profiles: profiles}
+		handler := NewUserHandler(test_utils.ValidUserNick, 1, store, blockList, nil)
+
+		assert.NoError(t, handler.MakeRequest(api.BlockAction, &api.BlockRequest{Nick: test_utils.ValidUser2Nick}))
+		assert.True(t, blockList.IsBlocked(context.Background(), test_utils.ValidUserNick, test_utils.ValidUser2Nick))
+		assert.Equal(t, api.ErrCannotBlockSelf, handler.MakeRequest(api.BlockAction, &api.BlockRequest{Nick: test_utils.ValidUserNick}))
+		assert.NoError(t, handler.MakeRequest(api.UnblockAction, &api.BlockRequest{Nick: test_utils.ValidUser2Nick}))
+		assert.False(t, blockList.IsBlocked(context.Background(), test_utils.ValidUserNick, test_utils.ValidUser2Nick))
+	})
+
+	t.Run("Preferences updated over websocket are sent to all connections of the user", func(t *testing.T) {
+		preferences := &PreferencesService{Store: &test_utils.PreferencesStoreDouble{}, Cache: cache}
+		handler := NewUserHandler("preferences_user", 0, store, nil, preferences)
+		conn := &chanConnection{received: make(chan api.SocketMessage, 1)}
+		otherConn := &chanConnection{received: make(chan api.SocketMessage, 1)}
+		handler.AddConnection(conn)
+		handler.AddConnection(otherConn)
+
+		require.NoError(t, handler.MakeRequest(api.UpdatePreferencesAction, &api.Preferences{Theme: "dark"}))
+		for _, c := range []*chanConnection{conn, otherConn} {
+			select {
+			case msg := <-c.received:
+				assert.Equal(t, api.PreferencesUpdatedEvent, msg.Action)
+				prefs, err := api.UnmarshalPreferences(msg.Payload)
+				require.NoError(t, err)
+				assert.Equal(t, "dark", prefs.Theme)
+			case <-time.After(200 * time.Millisecond):
+				t.Fatal("preferences were not synced")
+			}
+		}
+		assert.Equal(t, api.ErrInvalidNotification, handler.MakeRequest(api.UpdatePreferencesAction, &api.Preferences{NotificationLevel: "sometimes"}))
+	})
+
+	t.Run("Renamed user keeps connections and channel members are notified", func(t *testing.T) {
+		require.NoError(t, store.CreateChannel("Renames"))
+		renamedConn := &chanConnection{received: make(chan api.SocketMessage, 2)}
+		memberConn := &chanConnection{received: make(chan api.SocketMessage, 2)}
+		userManager.AddConnection(renamedConn, "before_rename")
+		userManager.AddConnection(memberConn, "member")
+		renamed, _ := userManager.GetHandler("before_rename")
+		member, _ := userManager.GetHandler("member")
+		require.NoError(t, store.AddUserToChannel("Renames", renamed))
+		<-renamedConn.received // join event
+		require.NoError(t, store.AddUserToChannel("Renames", member))
+		<-renamedConn.received
+		<-memberConn.received
+
+		userManager.RenameUser("before_rename", "after_rename")
+		_, oldExists := userManager.GetHandler("before_rename")
+		assert.False(t, oldExists)
+		handler, ok := userManager.GetConnectionHandler(renamedConn)
+		require.True(t, ok)
+		assert.Equal(t, "after_rename", handler.GetNick())
+
+		select {
+		case msg := <-memberConn.received:
+			assert.Equal(t, api.NickChangedEvent, msg.Action)
+			assert.JSONEq(t, `{"channel": "Renames", "old_nick": "before_rename", "new_nick": "after_rename"}`, string(msg.Payload))
+		case <-time.After(200 * time.Millisecond):
+			t.Fatal("nick change was not broadcast")
+		}
+	})
+}
+
+type spyConnection struct {
+	written []api.SocketMessage
+	closed  bool
+}
+
+func (c *spyConnection) WriteSocketMsg(m api.SocketMessage) {
+	c.written = append(c.written, m)
+}
+
+func (c *spyConnection) ReadSocketMsg() (*api.SocketMessage, error) {
+	return nil, nil
+}
+
+func (c *spyConnection) ReadMsg() ([]byte, error) {
+	return nil, nil
+}
+
+func (c *spyConnection) Close() error {
+	c.closed = true
+	return nil
 }
@@ -4,7 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"log"
+	"net/http"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/kacperf531/sockchat/api"
 	"github.com/kacperf531/sockchat/storage"
@@ -12,17 +16,66 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	DefaultListAccountsLimit = 100
+	MaxListAccountsLimit     = 1000
+
+	MaxDescriptionLength      = 1024
+	MaxDisplayNameLength      = 64
+	MaxPronounsLength         = 32
+	MaxTimezoneLength         = 64
+	MaxCustomFields           = 5
+	MaxCustomFieldNameLength  = 32
+	MaxCustomFieldValueLength = 255
+
+	DefaultSearchUsersLimit = 20
+	MaxSearchUsersLimit     = 100
+	MaxSearchQueryLength    = 64
+
+	// profileCacheKeyPrefix keeps cached profiles apart from other keys, as nicks are chosen by users
+	profileCacheKeyPrefix = "profile:"
+	profileCacheTTL       = 10 * time.Second
+	// profileVersionKeyPrefix keys counters of updates of profiles, which outlive reads that started before an update
+	profileVersionKeyPrefix = "profile_version:"
+	profileVersionTTL       = time.Hour
+)
+
+// avatarExtensions lists accepted image types, as detected by http.DetectContentType
+var avatarExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
 type ProfileService struct {
-	Store storage.UserStore
-	Cache *redis.Client
+	Store   storage.UserStore
+	Cache   *redis.Client
+	Avatars storage.BlobStore
+}
+
+// ValidateNick rejects nicks which could be mistaken for something else, e.g. `:` separates parts of Redis keys
+// and brackets mark placeholders such as api.DeletedUserNick
+func ValidateNick(nick string) error {
+	if nick == "" {
+		return api.ErrNickRequired
+	}
+	if strings.IndexFunc(nick, isForbiddenNickRune) >= 0 {
+		return api.ErrInvalidNick
+	}
+	return nil
+}
+
+func isForbiddenNickRune(r rune) bool {
+	return r == ':' || r == '[' || r == ']' || unicode.IsControl(r)
 }
 
 func (s *ProfileService) Create(ctx context.Context, u *api.CreateProfileRequest) error {
 	if u.Password == "" {
 		return api.ErrPasswordRequired
 	}
-	if u.Nick == "" {
-		return api.ErrNickRequired
+	if err := ValidateNick(u.Nick); err != nil {
+		return err
 	}
 	pwHash, err := bcrypt.GenerateFromPassword([]byte(u.Password), 10)
 	if err != nil {
@@ -42,8 +95,34 @@ func (s *ProfileService) Create(ctx context.Context, u *api.CreateProfileRequest
 	return nil
 }
 
+// CreateServiceAccount registers an integration's user; with no password set it can authenticate with API keys only
+func (s *ProfileService) CreateServiceAccount(ctx context.Context, req *api.CreateServiceAccountRequest) error {
+	if err := ValidateNick(req.Nick); err != nil {
+		return err
+	}
+	userEntry := storage.User{Nick: req.Nick, Description: req.Description, Role: api.RoleService}
+	if err := s.Store.InsertUser(ctx, &userEntry); err != nil {
+		if err == api.ErrNickAlreadyUsed {
+			return err
+		}
+		log.Printf("error adding new service account to db: %v", err)
+		return api.ErrInternal
+	}
+	return nil
+}
+
 func (s *ProfileService) Edit(ctx context.Context, nick string, req *api.EditProfileRequest) error {
-	userEntry := api.PublicProfile{Nick: nick, Description: req.Description}
+	if err := validateProfile(req); err != nil {
+		return err
+	}
+	userEntry := api.PublicProfile{
+		Nick:         nick,
+		Description:  req.Description,
+		DisplayName:  req.DisplayName,
+		Pronouns:     req.Pronouns,
+		Timezone:     req.Timezone,
+		CustomFields: req.CustomFields,
+	}
 
 	err := s.Store.UpdatePublicProfile(ctx, &userEntry)
 	if err != nil {
@@ -54,6 +133,118 @@ func (s *ProfileService) Edit(ctx context.Context, nick string, req *api.EditPro
 	return nil
 }
 
+func validateProfile(req *api.EditProfileRequest) error {
+	if utf8.RuneCountInString(req.Description) > MaxDescriptionLength ||
+		utf8.RuneCountInString(req.DisplayName) > MaxDisplayNameLength ||
+		utf8.RuneCountInString(req.Pronouns) > MaxPronounsLength ||
+		len(req.Timezone) > MaxTimezoneLength {
+		return api.ErrProfileFieldTooLong
+	}
+	if req.Timezone != "" {
+		// LoadLocation also accepts "Local", which means nothing to other users
+		if _, err := time.LoadLocation(req.Timezone); err != nil || req.Timezone == "Local" {
+			return api.ErrInvalidTimezone
+		}
+	}
+	if len(req.CustomFields) > MaxCustomFields {
+		return api.ErrTooManyCustomFields
+	}
+	for name, value := range req.CustomFields {
+		if strings.TrimSpace(name) == "" {
+			return api.ErrCustomFieldNameEmpty
+		}
+		if utf8.RuneCountInString(name) > MaxCustomFieldNameLength || utf8.RuneCountInString(value) > MaxCustomFieldValueLength {
+			return api.ErrProfileFieldTooLong
+		}
+	}
+	return nil
+}
+
+// SetAvatar stores the image in the blob store under a new key and removes the previous avatar
+func (s *ProfileService) SetAvatar(ctx context.Context, nick string, image []byte) (*api.PublicProfile, error) {
+	if len(image) > api.MaxAvatarSize {
+		return nil, api.ErrAvatarTooLarge
+	}
+	extension, ok := avatarExtensions[http.DetectContentType(image)]
+	if !ok {
+		return nil, api.ErrUnsupportedAvatarType
+	}
+	if s.Avatars == nil {
+		log.Print("error setting avatar: blob store is not configured")
+		return nil, api.ErrInternal
+	}
+	// previous avatar is read from the db, as the cached one may be outdated
+	userData, err := s.Store.SelectUser(ctx, nick)
+	if err != nil {
+		return nil, api.ErrUserNotFound
+	}
+	key, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	key += extension
+	if err := s.Avatars.PutBlob(ctx, key, image); err != nil {
+		log.Printf("error storing avatar: %v", err)
+		return nil, api.ErrInternal
+	}
+	if err := s.Store.UpdateAvatar(ctx, nick, key); err != nil {
+		log.Printf("error updating avatar in db: %v", err)
+		s.deleteAvatar(ctx, key)
+		return nil, api.ErrInternal
+	}
+	s.removeFromCache(ctx, nick)
+	s.deleteAvatar(ctx, userData.Avatar)
+	return s.GetProfile(ctx, nick)
+}
+
+func (s *ProfileService) GetAvatar(ctx context.Context, key string) (*api.Avatar, error) {
+	if s.Avatars == nil {
+		return nil, api.ErrBlobNotFound
+	}
+	image, err := s.Avatars.GetBlob(ctx, key)
+	if err != nil {
+		if err == api.ErrBlobNotFound {
+			return nil, err
+		}
+		log.Printf("error reading avatar: %v", err)
+		return nil, api.ErrInternal
+	}
+	return &api.Avatar{ContentType: http.DetectContentType(image), Image: image}, nil
+}
+
+func (s *ProfileService) deleteAvatar(ctx context.Context, key string) {
+	if key == "" || s.Avatars == nil {
+		return
+	}
+	if err := s.Avatars.DeleteBlob(ctx, key); err != nil {
+		log.Printf("warning: could not delete avatar %s: %v", key, err)
+	}
+}
+
+func (s *ProfileService) ChangePassword(ctx context.Context, nick string, req *api.ChangePasswordRequest) error {
+	if req.NewPassword == "" {
+		return api.ErrPasswordRequired
+	}
+	if !s.IsAuthValid(ctx, nick, req.OldPassword) {
+		return api.ErrInvalidPassword
+	}
+	return s.setPassword(ctx, nick, req.NewPassword)
+}
+
+func (s *ProfileService) setPassword(ctx context.Context, nick, password string) error {
+	pwHash, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+	if err != nil {
+		log.Printf("error occured during generating password hash: %v", err)
+		return api.ErrInternal
+	}
+	if err := s.Store.UpdatePasswordHash(ctx, nick, string(pwHash)); err != nil {
+		log.Printf("error updating password in db: %v", err)
+		return api.ErrInternal
+	}
+	s.removeFromCache(ctx, nick)
+	return nil
+}
+
 func (s *ProfileService) IsAuthValid(ctx context.Context, nick, password string) bool {
 	if nick == "" || password == "" {
 		return false
@@ -62,6 +253,9 @@ func (s *ProfileService) IsAuthValid(ctx context.Context, nick, password string)
 	if err != nil {
 		return false
 	}
+	if userData.Disabled {
+		return false
+	}
 	if err := bcrypt.CompareHashAndPassword([]byte(userData.PwHash), []byte(password)); err != nil {
 		return false
 	}
@@ -79,17 +273,230 @@ func (s *ProfileService) GetProfile(ctx context.Context, nick string) (*api.Publ
 		}
 		return nil, api.ErrInternal
 	}
-	return &api.PublicProfile{Nick: userData.Nick, Description: userData.Description}, nil
+	return profileFromStorage(userData), nil
+}
+
+func (s *ProfileService) GetRole(ctx context.Context, nick string) (api.Role, error) {
+	if nick == "" {
+		return "", api.ErrNickRequired
+	}
+	userData, err := s.getUserData(ctx, nick)
+	if err != nil {
+		if err == api.ErrUserNotFound {
+			return "", err
+		}
+		return "", api.ErrInternal
+	}
+	if userData.Role == "" {
+		return api.RoleUser, nil
+	}
+	return userData.Role, nil
+}
+
+func (s *ProfileService) GetAccount(ctx context.Context, nick string) (*api.Account, error) {
+	if nick == "" {
+		return nil, api.ErrNickRequired
+	}
+	userData, err := s.getUserData(ctx, nick)
+	if err != nil {
+		if err == api.ErrUserNotFound {
+			return nil, err
+		}
+		return nil, api.ErrInternal
+	}
+	return accountFromStorage(userData), nil
+}
+
+func (s *ProfileService) ListAccounts(ctx context.Context, offset, limit int) ([]*api.Account, error) {
+	if offset < 0 || limit < 0 {
+		return nil, api.ErrInvalidRequest
+	}
+	if limit == 0 {
+		limit = DefaultListAccountsLimit
+	}
+	if limit > MaxListAccountsLimit {
+		limit = MaxListAccountsLimit
+	}
+	users, err := s.Store.SelectUsers(ctx, offset, limit)
+	if err != nil {
+		log.Printf("error selecting users from db: %v", err)
+		return nil, api.ErrInternal
+	}
+	accounts := make([]*api.Account, len(users))
+	for i, u := range users {
+		accounts[i] = accountFromStorage(u)
+	}
+	return accounts, nil
+}
+
+func (s *ProfileService) SearchProfiles(ctx context.Context, req *api.SearchUsersRequest) ([]*api.PublicProfile, error) {
+	if req.Offset < 0 || req.Limit < 0 || len(req.Query) > MaxSearchQueryLength {
+		return nil, api.ErrInvalidRequest
+	}
+	limit := req.Limit
+	if limit == 0 {
+		limit = DefaultSearchUsersLimit
+	}
+	if limit > MaxSearchUsersLimit {
+		limit = MaxSearchUsersLimit
+	}
+	users, err := s.Store.SearchUsers(ctx, &storage.UserQuery{Phrase: strings.TrimSpace(req.Query), Fuzzy: req.Fuzzy, Offset: req.Offset, Limit: limit})
+	if err != nil {
+		log.Printf("error searching users in db: %v", err)
+		return nil, api.ErrInternal
+	}
+	profiles := make([]*api.PublicProfile, len(users))
+	for i, u := range users {
+		profiles[i] = profileFromStorage(u)
+	}
+	return profiles, nil
+}
+
+// ChangeNick renames the user; their id stays the same
+func (s *ProfileService) ChangeNick(ctx context.Context, nick, newNick string) error {
+	if err := ValidateNick(newNick); err != nil {
+		return err
+	}
+	if newNick == nick {
+		return api.ErrNickAlreadyUsed
+	}
+	if err := s.Store.UpdateNick(ctx, nick, newNick); err != nil {
+		if err == api.ErrNickAlreadyUsed || err == api.ErrUserNotFound {
+			return err
+		}
+		log.Printf("error changing nick in db: %v", err)
+		return api.ErrInternal
+	}
+	s.removeFromCache(ctx, nick)
+	s.removeFromCache(ctx, newNick)
+	return nil
+}
+
+func (s *ProfileService) GetUserID(ctx context.Context, nick string) (int64, error) {
+	if nick == "" {
+		return 0, api.ErrNickRequired
+	}
+	userData, err := s.getUserData(ctx, nick)
+	if err != nil {
+		if err == api.ErrUserNotFound {
+			return 0, err
+		}
+		return 0, api.ErrInternal
+	}
+	return userData.ID, nil
+}
+
+// ResolveNicks returns current nicks of users with given ids; ids of deleted users are skipped
+func (s *ProfileService) ResolveNicks(ctx context.Context, ids []int64) (map[int64]string, error) {
+	nicks, err := s.Store.SelectNicksByIDs(ctx, ids)
+	if err != nil {
+		log.Printf("error selecting nicks from db: %v", err)
+		return nil, api.ErrInternal
+	}
+	return nicks, nil
+}
+
+func (s *ProfileService) SetHiddenFromDirectory(ctx context.Context, nick string, hidden bool) error {
+	if err := s.Store.UpdateHiddenFromDirectory(ctx, nick, hidden); err != nil {
+		log.Printf("error updating user in db: %v", err)
+		return api.ErrInternal
+	}
+	s.removeFromCache(ctx, nick)
+	return nil
+}
+
+// SetDisabled disables or re-enables the account; disabled accounts can not log in
+func (s *ProfileService) SetDisabled(ctx context.Context, nick string, disabled bool) error {
+	if _, err := s.GetAccount(ctx, nick); err != nil {
+		return err
+	}
+	if err := s.Store.UpdateDisabled(ctx, nick, disabled); err != nil {
+		log.Printf("error updating user in db: %v", err)
+		return api.ErrInternal
+	}
+	s.removeFromCache(ctx, nick)
+	return nil
+}
+
+// SetRole grants the global role to the user
+func (s *ProfileService) SetRole(ctx context.Context, nick string, role api.Role) error {
+	if !isValidRole(role) {
+		return api.ErrInvalidRole
+	}
+	if _, err := s.GetAccount(ctx, nick); err != nil {
+		return err
+	}
+	if err := s.Store.UpdateRole(ctx, nick, role); err != nil {
+		log.Printf("error updating user in db: %v", err)
+		return api.ErrInternal
+	}
+	s.removeFromCache(ctx, nick)
+	return nil
+}
+
+func isValidRole(role api.Role) bool {
+	for _, r := range api.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Delete removes the user from the db and the cache, along with their avatar
+func (s *ProfileService) Delete(ctx context.Context, nick string) error {
+	userData, err := s.Store.SelectUser(ctx, nick)
+	if err != nil {
+		return api.ErrUserNotFound
+	}
+	if err := s.Store.DeleteUser(ctx, nick); err != nil {
+		if err == api.ErrUserNotFound {
+			return err
+		}
+		log.Printf("error deleting user from db: %v", err)
+		return api.ErrInternal
+	}
+	s.removeFromCache(ctx, nick)
+	s.deleteAvatar(ctx, userData.Avatar)
+	return nil
+}
+
+func profileFromStorage(u *storage.User) *api.PublicProfile {
+	profile := &api.PublicProfile{
+		Nick:         u.Nick,
+		Description:  u.Description,
+		DisplayName:  u.DisplayName,
+		Pronouns:     u.Pronouns,
+		Timezone:     u.Timezone,
+		CustomFields: u.CustomFields,
+	}
+	if u.Avatar != "" {
+		profile.AvatarURL = api.AvatarsPath + u.Avatar
+	}
+	return profile
+}
+
+func accountFromStorage(u *storage.User) *api.Account {
+	role := u.Role
+	if role == "" {
+		role = api.RoleUser
+	}
+	return &api.Account{Nick: u.Nick, Role: role, Disabled: u.Disabled}
 }
 
 func (s *ProfileService) getUserData(ctx context.Context, nick string) (*storage.User, error) {
 	userData, err := s.getFromCache(ctx, nick)
 	if err == redis.Nil {
+		// the version is read before the user, so that a user selected before an update is not cached after it
+		version, err := s.Cache.Get(ctx, profileVersionKey(nick)).Result()
+		if err != nil && err != redis.Nil {
+			return nil, err
+		}
 		userData, err := s.Store.SelectUser(ctx, nick)
 		if err != nil {
 			return nil, api.ErrUserNotFound
 		}
-		go s.setInCache(ctx, userData)
+		s.setInCache(ctx, nick, version, userData)
 		return userData, nil
 	}
 	if err != nil {
@@ -98,20 +505,57 @@ func (s *ProfileService) getUserData(ctx context.Context, nick string) (*storage
 	return userData, nil
 }
 
-func (s *ProfileService) setInCache(ctx context.Context, u *storage.User) {
+// setInCache caches the user unless the profile was updated since the version was read
+func (s *ProfileService) setInCache(ctx context.Context, nick, version string, u *storage.User) {
 	v, err := json.Marshal(u)
 	if err != nil {
 		log.Print("warning: error marshaling user data for cache")
+		return
+	}
+	versionKey := profileVersionKey(nick)
+	err = s.Cache.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, versionKey).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if current != version {
+			return nil
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, profileCacheKey(nick), v, profileCacheTTL)
+			return nil
+		})
+		return err
+	}, versionKey)
+	if err != nil && err != redis.TxFailedErr {
+		log.Printf("warning: could not cache user data: %v", err)
 	}
-	s.Cache.Set(ctx, u.Nick, v, 10*time.Second)
 }
 
+// removeFromCache bumps the version of the profile first, so that reads which selected the user before are not cached
 func (s *ProfileService) removeFromCache(ctx context.Context, nick string) {
-	s.Cache.Del(ctx, nick)
+	versionKey := profileVersionKey(nick)
+	if _, err := s.Cache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Incr(ctx, versionKey)
+		pipe.Expire(ctx, versionKey, profileVersionTTL)
+		pipe.Del(ctx, profileCacheKey(nick))
+		return nil
+	}); err != nil {
+		log.Printf("warning: could not remove user data from cache: %v", err)
+	}
+}
+
+// profileCacheKey is case-insensitive like nicks, so that removing the profile reaches entries cached under any case
+func profileCacheKey(nick string) string {
+	return profileCacheKeyPrefix + strings.ToLower(nick)
+}
+
+func profileVersionKey(nick string) string {
+	return profileVersionKeyPrefix + strings.ToLower(nick)
 }
 
 func (s *ProfileService) getFromCache(ctx context.Context, nick string) (*storage.User, error) {
-	userData, err := s.Cache.Get(ctx, nick).Result()
+	userData, err := s.Cache.Get(ctx, profileCacheKey(nick)).Result()
 	if err != nil {
 		return nil, err
 	}
@@ -2,18 +2,26 @@ package sockchat
 
 import (
 	"context"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/kacperf531/sockchat/api"
+	"github.com/kacperf531/sockchat/storage"
 	"github.com/kacperf531/sockchat/test_utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestUserProfile(t *testing.T) {
 	t.Parallel()
 
+	cache := test_utils.NewTestingRedisClient(t)
+
 	store := test_utils.UserStoreDouble{}
-	service := ProfileService{&store, test_utils.TestingRedisClient}
+	avatars, err := storage.NewLocalBlobStore(t.TempDir())
+	require.NoError(t, err)
+	service := ProfileService{Store: &store, Cache: cache, Avatars: avatars}
 
 	t.Run("Calls to insert new user when request is OK", func(t *testing.T) {
 		newUser := &api.CreateProfileRequest{Nick: "x69", Password: "foo420", Description: "description goes here"}
@@ -39,6 +47,32 @@ func TestUserProfile(t *testing.T) {
 		}
 	})
 
+	t.Run("Returns error on nick with forbidden characters", func(t *testing.T) {
+		for _, nick := range []string{"login_lockout:nick:victim", "new\nline", api.DeletedUserNick} {
+			err := service.Create(context.TODO(), &api.CreateProfileRequest{Nick: nick, Password: "foo420"})
+			assert.Equal(t, api.ErrInvalidNick, err, nick)
+		}
+	})
+
+	t.Run("Service account is created with service role and can not log in with password", func(t *testing.T) {
+		require.NoError(t, service.CreateServiceAccount(context.TODO(), &api.CreateServiceAccountRequest{Nick: "ServiceAccount"}))
+		role, err := service.GetRole(context.TODO(), "ServiceAccount")
+		require.NoError(t, err)
+		assert.Equal(t, api.RoleService, role)
+		assert.False(t, service.IsAuthValid(context.TODO(), "ServiceAccount", ""))
+		assert.False(t, service.IsAuthValid(context.TODO(), "ServiceAccount", "any"))
+		assert.Equal(t, api.ErrInvalidNick, service.CreateServiceAccount(context.TODO(), &api.CreateServiceAccountRequest{Nick: "bad:nick"}))
+	})
+
+	t.Run("SetRole grants valid roles to existing users only", func(t *testing.T) {
+		require.NoError(t, service.SetRole(context.TODO(), "x69", api.RoleAdmin))
+		role, err := service.GetRole(context.TODO(), "x69")
+		require.NoError(t, err)
+		assert.Equal(t, api.RoleAdmin, role)
+		assert.Equal(t, api.ErrInvalidRole, service.SetRole(context.TODO(), "x69", "superuser"))
+		assert.Equal(t, api.ErrUserNotFound, service.SetRole(context.TODO(), "NonExistingUser", api.RoleAdmin))
+	})
+
 	t.Run("Calls to update existing user when edit request is OK", func(t *testing.T) {
 		req := &api.EditProfileRequest{Description: "Bar"}
 		err := service.Edit(context.TODO(), "dummy", req)
@@ -46,9 +80,175 @@ func TestUserProfile(t *testing.T) {
 		assert.Equal(t, req.Description, store.UpdateCalls[0].Description)
 	})
 
+	t.Run("Returns error on invalid profile fields", func(t *testing.T) {
+		invalidRequests := map[error]*api.EditProfileRequest{
+			api.ErrProfileFieldTooLong:  {DisplayName: strings.Repeat("x", MaxDisplayNameLength+1)},
+			api.ErrInvalidTimezone:      {Timezone: "Mars/Olympus_Mons"},
+			api.ErrTooManyCustomFields:  {CustomFields: map[string]string{"a": "1", "b": "2", "c": "3", "d": "4", "e": "5", "f": "6"}},
+			api.ErrCustomFieldNameEmpty: {CustomFields: map[string]string{" ": "foo"}},
+		}
+		for expected, req := range invalidRequests {
+			assert.ErrorIs(t, service.Edit(context.TODO(), "dummy", req), expected)
+		}
+	})
+
+	t.Run("Stores avatar and replaces previous one", func(t *testing.T) {
+		nick := "AvatarTestUser"
+		require.NoError(t, service.Create(context.TODO(), &api.CreateProfileRequest{Nick: nick, Password: "foo420"}))
+		png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 64)...)
+
+		profile, err := service.SetAvatar(context.TODO(), nick, png)
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(profile.AvatarURL, api.AvatarsPath), profile.AvatarURL)
+		firstKey := strings.TrimPrefix(profile.AvatarURL, api.AvatarsPath)
+		avatar, err := service.GetAvatar(context.TODO(), firstKey)
+		require.NoError(t, err)
+		assert.Equal(t, &api.Avatar{ContentType: "image/png", Image: png}, avatar)
+
+		profile, err = service.SetAvatar(context.TODO(), nick, png)
+		require.NoError(t, err)
+		assert.NotEqual(t, api.AvatarsPath+firstKey, profile.AvatarURL)
+		_, err = service.GetAvatar(context.TODO(), firstKey)
+		assert.ErrorIs(t, err, api.ErrBlobNotFound)
+	})
+
+	t.Run("Rejects avatar which is too large or not an image", func(t *testing.T) {
+		_, err := service.SetAvatar(context.TODO(), test_utils.ValidUserNick, []byte("<html></html>"))
+		assert.ErrorIs(t, err, api.ErrUnsupportedAvatarType)
+		_, err = service.SetAvatar(context.TODO(), test_utils.ValidUserNick, make([]byte, api.MaxAvatarSize+1))
+		assert.ErrorIs(t, err, api.ErrAvatarTooLarge)
+	})
+
+	t.Run("SearchProfiles matches prefix of nick or fuzzy pattern", func(t *testing.T) {
+		profiles, err := service.SearchProfiles(context.TODO(), &api.SearchUsersRequest{Query: "veryspecial"})
+		require.NoError(t, err)
+		require.Len(t, profiles, 1)
+		assert.Equal(t, test_utils.ValidUser2Nick, profiles[0].Nick)
+
+		profiles, err = service.SearchProfiles(context.TODO(), &api.SearchUsersRequest{Query: "vyspu", Fuzzy: true})
+		require.NoError(t, err)
+		require.Len(t, profiles, 1)
+		assert.Equal(t, test_utils.ValidUser2Nick, profiles[0].Nick)
+
+		_, err = service.SearchProfiles(context.TODO(), &api.SearchUsersRequest{Offset: -1})
+		assert.ErrorIs(t, err, api.ErrInvalidRequest)
+	})
+
+	t.Run("SearchProfiles skips users hidden from directory", func(t *testing.T) {
+		require.NoError(t, service.SetHiddenFromDirectory(context.TODO(), test_utils.ValidServiceNick, true))
+		profiles, err := service.SearchProfiles(context.TODO(), &api.SearchUsersRequest{Query: "specialtests"})
+		require.NoError(t, err)
+		assert.Empty(t, profiles)
+	})
+
+	t.Run("Calls to update password hash when old password is valid", func(t *testing.T) {
+		req := &api.ChangePasswordRequest{OldPassword: test_utils.ValidUserPassword, NewPassword: "bar420"}
+		err := service.ChangePassword(context.TODO(), test_utils.ValidUserNick, req)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{test_utils.ValidUserNick}, store.PasswordUpdateCalls)
+	})
+
+	t.Run("Returns error on password change with invalid old password", func(t *testing.T) {
+		req := &api.ChangePasswordRequest{OldPassword: "wrong", NewPassword: "bar420"}
+		err := service.ChangePassword(context.TODO(), test_utils.ValidUser2Nick, req)
+		assert.EqualError(t, err, api.ErrInvalidPassword.Error())
+	})
+
+	t.Run("Disabled user can not log in", func(t *testing.T) {
+		require.NoError(t, service.SetDisabled(context.TODO(), test_utils.ValidUser3Nick, true))
+		assert.False(t, service.IsAuthValid(context.TODO(), test_utils.ValidUser3Nick, test_utils.ValidUserPassword))
+		account, err := service.GetAccount(context.TODO(), test_utils.ValidUser3Nick)
+		require.NoError(t, err)
+		assert.True(t, account.Disabled)
+		require.NoError(t, service.SetDisabled(context.TODO(), test_utils.ValidUser3Nick, false))
+	})
+
+	t.Run("ListAccounts returns page of accounts", func(t *testing.T) {
+		accounts, err := service.ListAccounts(context.TODO(), 1, 1)
+		require.NoError(t, err)
+		assert.Equal(t, []*api.Account{{Nick: test_utils.ValidUser2Nick, Role: api.RoleUser}}, accounts)
+	})
+
+	t.Run("Profiles are cached under a namespaced key, not the bare nick", func(t *testing.T) {
+		_, err := service.GetProfile(context.TODO(), test_utils.ValidUser2Nick)
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			return cache.Exists(context.TODO(), profileCacheKey(test_utils.ValidUser2Nick)).Val() == 1
+		}, time.Second, 10*time.Millisecond)
+		assert.Zero(t, cache.Exists(context.TODO(), test_utils.ValidUser2Nick).Val())
+	})
+
 	t.Run("GetProfile returns error for non-existing user", func(t *testing.T) {
 		_, err := service.GetProfile(context.TODO(), "NonExistingUser")
 		assert.Error(t, err)
 	})
 
+	t.Run("ChangeNick keeps id of the user", func(t *testing.T) {
+		id, err := service.GetUserID(context.TODO(), test_utils.ValidUser3Nick)
+		require.NoError(t, err)
+		require.NoError(t, service.ChangeNick(context.TODO(), test_utils.ValidUser3Nick, "RenamedUser3"))
+
+		newID, err := service.GetUserID(context.TODO(), "RenamedUser3")
+		require.NoError(t, err)
+		assert.Equal(t, id, newID)
+		_, err = service.GetProfile(context.TODO(), test_utils.ValidUser3Nick)
+		assert.Equal(t, api.ErrUserNotFound, err)
+		nicks, err := service.ResolveNicks(context.TODO(), []int64{id})
+		require.NoError(t, err)
+		assert.Equal(t, map[int64]string{id: "RenamedUser3"}, nicks)
+	})
+
+	t.Run("ChangeNick returns error on empty or taken nick", func(t *testing.T) {
+		assert.Equal(t, api.ErrNickRequired, service.ChangeNick(context.TODO(), test_utils.ValidUser2Nick, ""))
+		assert.Equal(t, api.ErrInvalidNick, service.ChangeNick(context.TODO(), test_utils.ValidUser2Nick, "sessions_epoch:"+test_utils.ValidUserNick))
+		assert.Equal(t, api.ErrInvalidNick, service.ChangeNick(context.TODO(), test_utils.ValidUser2Nick, api.DeletedUserNick))
+		assert.Equal(t, api.ErrNickAlreadyUsed, service.ChangeNick(context.TODO(), test_utils.ValidUser2Nick, test_utils.ValidUserNick))
+		assert.Equal(t, api.ErrNickAlreadyUsed, service.ChangeNick(context.TODO(), test_utils.ValidUser2Nick, test_utils.ValidUser2Nick))
+	})
+
+}
+
+// racingUserStore runs the update once, right after the user was selected and before they are cached
+type racingUserStore struct {
+	*test_utils.UserStoreDouble
+	update func()
+}
+
+func (s *racingUserStore) SelectUser(ctx context.Context, nick string) (*storage.User, error) {
+	user, err := s.UserStoreDouble.SelectUser(ctx, nick)
+	if update := s.update; update != nil {
+		s.update = nil
+		update()
+	}
+	return user, err
+}
+
+func TestProfileCacheInvalidation(t *testing.T) {
+	t.Parallel()
+
+	cache := test_utils.NewTestingRedisClient(t)
+	store := &racingUserStore{UserStoreDouble: &test_utils.UserStoreDouble{}}
+	service := &ProfileService{Store: store, Cache: cache}
+	ctx := context.Background()
+
+	t.Run("user selected before being disabled is not cached as enabled", func(t *testing.T) {
+		store.update = func() {
+			require.NoError(t, service.SetDisabled(ctx, test_utils.ValidUserNick, true))
+		}
+		assert.True(t, service.IsAuthValid(ctx, test_utils.ValidUserNick, test_utils.ValidUserPassword))
+		assert.False(t, service.IsAuthValid(ctx, test_utils.ValidUserNick, test_utils.ValidUserPassword))
+	})
+
+	t.Run("user selected before a role change is not cached with the old role", func(t *testing.T) {
+		store.update = func() {
+			require.NoError(t, service.SetRole(ctx, test_utils.ValidUser2Nick, api.RoleAdmin))
+		}
+		account, err := service.GetAccount(ctx, test_utils.ValidUser2Nick)
+		require.NoError(t, err)
+		assert.Equal(t, api.RoleUser, account.Role)
+		account, err = service.GetAccount(ctx, test_utils.ValidUser2Nick)
+		require.NoError(t, err)
+		assert.Equal(t, api.RoleAdmin, account.Role)
+	})
+
 }
@@ -0,0 +1,26 @@
+//go:build dev
+
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// the embedded Redis is linked into development builds only: go build -tags dev
+var devInMemory = flag.Bool("dev-inmemory", false, "keep all data in memory, so that no MySQL, Redis or Elasticsearch is needed")
+
+// devBackends keeps all data in memory when asked to with --dev-inmemory, the cache in an embedded Redis
+func devBackends() *backends {
+	if !*devInMemory {
+		return nil
+	}
+	redisServer, err := miniredis.Run()
+	if err != nil {
+		log.Fatalf("could not start in-memory redis: %v", err)
+	}
+	return newInMemoryBackends(redis.NewClient(&redis.Options{Addr: redisServer.Addr()}))
+}
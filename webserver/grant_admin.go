@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/kacperf531/sockchat"
+	"github.com/kacperf531/sockchat/api"
+	"github.com/kacperf531/sockchat/storage"
+)
+
+// runGrantAdminCommand handles `grant-admin <nick>`, which bootstraps the first admin; further roles are granted over the admin API
+func runGrantAdminCommand(args []string) {
+	if len(args) != 1 {
+		log.Fatal("expected nick of the user to grant admin role to: grant-admin <nick>")
+	}
+	mySqlDb := mustConnectToMySql()
+	TestMySqlConnection(mySqlDb)
+	cache := mustInitializeRedisClient()
+	TestRedisConnection(cache)
+	profiles := &sockchat.ProfileService{Store: storage.NewUserStore(mySqlDb), Cache: cache}
+	if err := profiles.SetRole(context.Background(), args[0], api.RoleAdmin); err != nil {
+		log.Fatalf("could not grant admin role to %q: %v", args[0], err)
+	}
+	fmt.Printf("granted admin role to %s\n", args[0])
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"os"
+
+	"github.com/kacperf531/sockchat/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// newInMemoryBackends keeps users in memory and messages in in-memory SQLite, caching in the given Redis;
+// all data is lost on exit. The embedded Redis is started by development builds and tests, see dev.go
+func newInMemoryBackends(cache *redis.Client) *backends {
+	log.Print("running in in-memory development mode, data will not be persisted")
+	setInMemoryDefaults()
+
+	store := storage.NewMemoryStore()
+	messageStore, userReports := mustInitializeSQLMessageStore(storage.SQLite, ":memory:")
+
+	return &backends{
+		cache:       cache,
+		users:       store,
+		apiKeys:     store,
+		identities:  store,
+		blocks:      store,
+		preferences: store,
+		messages:    messageStore,
+		reports:     userReports}
+}
+
+// setInMemoryDefaults fills in settings which are required but have no sensible default outside of development
+func setInMemoryDefaults() {
+	for _, dir := range []string{"AVATARS_DIR", "INDEXING_SPOOL_DIR"} {
+		if os.Getenv(dir) != "" {
+			continue
+		}
+		path, err := os.MkdirTemp("", "sockchat-")
+		if err != nil {
+			log.Fatalf("could not create a temporary directory for %s: %v", dir, err)
+		}
+		os.Setenv(dir, path)
+	}
+	// both secrets are accepted as 32 random bytes in hex
+	for _, secret := range []string{"SESSION_SECRET", "TOTP_ENCRYPTION_KEY"} {
+		if os.Getenv(secret) != "" {
+			continue
+		}
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatalf("could not generate %s: %v", secret, err)
+		}
+		os.Setenv(secret, hex.EncodeToString(key))
+	}
+}
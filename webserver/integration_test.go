@@ -2,49 +2,46 @@ package main
 
 import (
 	"context"
-	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
-	"github.com/kacperf531/sockchat"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/kacperf531/sockchat/api"
 	"github.com/kacperf531/sockchat/services"
 	"github.com/kacperf531/sockchat/test_utils"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func setUpTestServer(t *testing.T) *httptest.Server {
+// setUpInMemoryServer assembles the whole server on in-memory backends, with test users registered and channel foo created
+func setUpInMemoryServer(t *testing.T) *server {
 	t.Helper()
+	t.Setenv("AVATARS_DIR", t.TempDir())
+	t.Setenv("INDEXING_SPOOL_DIR", t.TempDir())
+
+	redisServer := miniredis.RunT(t)
+	srv := mustAssembleServer(newInMemoryBackends(redis.NewClient(&redis.Options{Addr: redisServer.Addr()})))
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go srv.indexing.Run(ctx)
+
+	for _, nick := range []string{test_utils.ValidUserNick, test_utils.ValidUser2Nick} {
+		require.NoError(t, srv.core.UserProfiles.Create(ctx, &api.CreateProfileRequest{Nick: nick, Password: test_utils.ValidUserPassword}))
+	}
+	require.NoError(t, srv.core.ChatChannels.CreateChannel("foo"))
+	return srv
+}
 
-	messageStore := &test_utils.StubMessageStore{}
-	channelStore := sockchat.NewChannelStore(messageStore)
-	channelStore.CreateChannel("foo")
-	userStore := &test_utils.UserStoreDouble{}
-	userCache := test_utils.TestingRedisClient
-	userProfileService := &sockchat.ProfileService{Store: userStore, Cache: userCache}
-	connectedUsers := sockchat.NewConnectedUsersPool(channelStore)
-	authService := &services.SockchatAuthService{UserProfiles: userProfileService}
-	coreService := &services.SockchatCoreService{
-		UserProfiles:   userProfileService,
-		Messages:       messageStore,
-		ChatChannels:   channelStore,
-		ConnectedUsers: connectedUsers}
-
-	httpRouter := http.NewServeMux()
-	webAPI := services.NewWebAPI(coreService, authService)
-	webAPI.HandleRequests(httpRouter)
-	messagingAPI := &services.MessagingAPI{TimeoutAuthorized: defaultTimeoutAuthorized, TimeoutUnauthorized: defaultTimeoutUnauthorized, ConnectedUsers: connectedUsers, UserProfiles: userProfileService}
-	messagingAPI.HandleRequests(httpRouter)
-
-	return httptest.NewServer(httpRouter)
+func setUpTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(setUpInMemoryServer(t).router)
 }
 
 // Functional test of critical features of a single user case
-// User profiles remain stubbed to avoid the need for a MySQL setup
 func TestAuthorizedUserFlow(t *testing.T) {
 	server := setUpTestServer(t)
-	test_utils.TestingRedisClient.FlushAll(context.TODO())
 	defer server.Close()
 
 	wsURL := test_utils.GetWsURL(server.URL)
@@ -90,7 +87,6 @@ func TestAuthorizedUserFlow(t *testing.T) {
 
 func TestMultipleConnectionsSync(t *testing.T) {
 	server := setUpTestServer(t)
-	test_utils.TestingRedisClient.FlushAll(context.TODO())
 	defer server.Close()
 
 	wsURL := test_utils.GetWsURL(server.URL)
@@ -150,7 +146,6 @@ func TestMultipleConnectionsSync(t *testing.T) {
 func TestMultipleUsersSync(t *testing.T) {
 
 	server := setUpTestServer(t)
-	test_utils.TestingRedisClient.FlushAll(context.TODO())
 	defer server.Close()
 
 	wsURL := test_utils.GetWsURL(server.URL)
@@ -213,3 +208,40 @@ func TestMultipleUsersSync(t *testing.T) {
 	})
 
 }
+
+func TestInMemoryMessageHistory(t *testing.T) {
+	srv := setUpInMemoryServer(t)
+	server := httptest.NewServer(srv.router)
+	defer server.Close()
+
+	ws := test_utils.NewTestWS(t, test_utils.GetWsURL(server.URL))
+	defer ws.Close()
+	ws.Write(t, api.NewSocketMessage(api.LoginAction, api.LoginRequest{Nick: test_utils.ValidUserNick, Password: test_utils.ValidUserPassword}))
+	ws.AssertEventReceivedWithin(t, "logged_in:"+test_utils.ValidUserNick, 2*time.Second)
+	ws.Write(t, api.NewSocketMessage(api.JoinAction, api.ChannelRequest{Name: "foo"}))
+	ws.AssertEventReceivedWithin(t, api.UserJoinedChannelEvent, 2*time.Second)
+	for _, text := range []string{"quick brown fox", "lazy dog"} {
+		ws.Write(t, api.NewSocketMessage(api.SendMessageAction, api.SendMessageRequest{Channel: "foo", Text: text}))
+		ws.AssertEventReceivedWithin(t, api.NewMessageEvent, 2*time.Second)
+	}
+
+	t.Run("sent messages are indexed and can be searched", func(t *testing.T) {
+		search := &services.SearchMessagesWrapper{Nick: test_utils.ValidUserNick, Request: &api.SearchMessagesRequest{Query: "fox", Channels: []string{"foo"}, Limit: 10}}
+		var res *api.SearchMessagesResponse
+		require.Eventually(t, func() bool {
+			var err error
+			res, err = srv.core.SearchMessages(search, context.Background())
+			return err == nil && res.Total > 0
+		}, 5*time.Second, 50*time.Millisecond)
+		require.Len(t, res.Results, 1)
+		assert.Equal(t, "quick brown fox", res.Results[0].Message.Text)
+	})
+
+	t.Run("channel history returns both messages, latest first", func(t *testing.T) {
+		history := &services.ChannelHistoryWrapper{Nick: test_utils.ValidUserNick, Request: &api.GetChannelHistoryRequest{Channel: "foo"}}
+		require.Eventually(t, func() bool {
+			res, err := srv.core.GetChannelHistory(history, context.Background())
+			return err == nil && len(res.Messages) == 2 && res.Messages[0].Text == "lazy dog"
+		}, 5*time.Second, 50*time.Millisecond)
+	})
+}
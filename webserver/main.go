@@ -1,16 +1,24 @@
 package main
 
 import (
+	"compress/flate"
+	"context"
 	"database/sql"
+	"encoding/hex"
+	"expvar"
+	"flag"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v7"
 	"github.com/joho/godotenv"
 	"github.com/kacperf531/sockchat"
+	"github.com/kacperf531/sockchat/api"
 	"github.com/kacperf531/sockchat/services"
 	"github.com/kacperf531/sockchat/storage"
 	"github.com/redis/go-redis/v9"
@@ -19,47 +27,171 @@ import (
 const (
 	defaultTimeoutUnauthorized = 1 * time.Minute
 	defaultTimeoutAuthorized   = 10 * time.Minute
+	defaultCompressionLevel    = flate.BestSpeed
+	defaultCompressionMinSize  = 256
 	grpcPort                   = 50051
+	oidcRequestTimeout         = 10 * time.Second
+	messagesRolloverInterval   = time.Hour
 )
 
 func main() {
+	flag.Parse()
 	godotenv.Load("../.env")
 	log.SetFlags(log.Ldate | log.Ltime | log.Llongfile)
 
-	TestRedisConnection()
-	TestElasticSearchConnection()
+	switch flag.Arg(0) {
+	case "migrate":
+		runMigrateCommand(flag.Args()[1:])
+		return
+	case "grant-admin":
+		runGrantAdminCommand(flag.Args()[1:])
+		return
+	}
+
+	b := devBackends()
+	if b == nil {
+		b = mustInitializeBackends()
+	}
+	srv := mustAssembleServer(b)
+
+	grpcAPI := services.NewSockchatGRPCServer(srv.core, srv.auth, b.reports)
+	services.ServeGRPC(grpcAPI, grpcPort)
+
+	expvar.Publish("indexing", expvar.Func(func() any { return srv.indexing.Stats() }))
+	expvar.Publish("retention", expvar.Func(func() any { return srv.retention.Stats() }))
+	expvar.Publish("websocket_compression", expvar.Func(func() any { return srv.messaging.CompressionStats() }))
+
+	go func() {
+		log.Fatal(http.ListenAndServe(":8080", srv.router))
+	}()
+	// messages still queued for indexing are flushed or spooled before exit
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if b.messagesIndex != nil {
+		go b.messagesIndex.RunRollover(ctx, messagesRolloverInterval)
+	}
+	go srv.retention.Run(ctx)
+	srv.indexing.Run(ctx)
+}
+
+// backends are the stores and the cache the server keeps its data in
+type backends struct {
+	cache         *redis.Client
+	users         storage.UserStore
+	apiKeys       storage.APIKeyStore
+	identities    storage.IdentityStore
+	blocks        storage.BlockStore
+	preferences   storage.PreferencesStore
+	messages      messageBackend
+	reports       api.SockchatReportsService
+	messagesIndex *storage.MessagesIndex
+}
+
+func mustInitializeBackends() *backends {
+	cache := mustInitializeRedisClient()
+	TestRedisConnection(cache)
 
 	mySqlDb := mustConnectToMySql()
 	TestMySqlConnection(mySqlDb)
-	resetMySqlForDevEnvironment(mySqlDb)
-	es := mustInitializeElasticSearchClient()
+	mustMigrateMySql(mySqlDb)
+	messageStore, userReports, messagesIndex := mustInitializeMessageStore()
+
+	return &backends{
+		cache:         cache,
+		users:         storage.NewUserStore(mySqlDb),
+		apiKeys:       storage.NewAPIKeyStore(mySqlDb),
+		identities:    storage.NewIdentityStore(mySqlDb),
+		blocks:        storage.NewBlockStore(mySqlDb),
+		preferences:   storage.NewPreferencesStore(mySqlDb),
+		messages:      messageStore,
+		reports:       userReports,
+		messagesIndex: messagesIndex}
+}
+
+// server holds the HTTP routes and what main needs to serve gRPC and run background jobs
+type server struct {
+	router    *http.ServeMux
+	core      *services.SockchatCoreService
+	auth      *services.SockchatAuthService
+	indexing  *sockchat.IndexingPipeline
+	retention *sockchat.RetentionJob
+	messaging *services.MessagingAPI
+}
 
-	messageStore := storage.NewMessageStore(es, os.Getenv("ES_MESSAGES_INDEX"))
-	channelStore := sockchat.NewChannelStore(messageStore)
-	userStore := storage.NewUserStore(mySqlDb)
+func mustAssembleServer(b *backends) *server {
+	channelStore := sockchat.NewChannelStore(b.messages)
+	indexing := mustInitializeIndexingPipeline(b.messages)
+	channelStore.Indexer = indexing
+	retention := mustInitializeRetentionJob(b.messages)
 
-	userCache := mustInitializeRedisClient()
-	userProfileService := &sockchat.ProfileService{Store: userStore, Cache: userCache}
+	userProfileService := &sockchat.ProfileService{Store: b.users, Cache: b.cache, Avatars: mustInitializeAvatarStore()}
+	sessionService := mustInitializeSessionService(b.cache)
+	blockList := &sockchat.BlockListService{Store: b.blocks, Profiles: userProfileService}
+	channelStore.BlockList = blockList
 	connectedUsers := sockchat.NewConnectedUsersPool(channelStore)
+	connectedUsers.BlockList = blockList
+	connectedUsers.Profiles = userProfileService
+	preferences := &sockchat.PreferencesService{Store: b.preferences, Cache: b.cache}
+	connectedUsers.Preferences = preferences
+	passwordResets := &sockchat.PasswordResetService{Profiles: userProfileService, Cache: b.cache, Notifier: &sockchat.LogNotifier{}, Sessions: sessionService}
 
-	authService := &services.SockchatAuthService{UserProfiles: userProfileService}
+	auditLog := &sockchat.LogAuditLog{}
+	loginGuard := &sockchat.LoginGuard{Cache: b.cache, Audit: auditLog}
+	apiKeys := &sockchat.APIKeyService{Store: b.apiKeys}
+	twoFactor := mustInitializeTwoFactorService(userProfileService, b.cache, auditLog)
+	authService := &services.SockchatAuthService{UserProfiles: userProfileService, Sessions: sessionService, LoginGuard: loginGuard, APIKeys: apiKeys, TwoFactor: twoFactor}
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		authService.OIDC = &sockchat.OIDCService{
+			Issuer:       issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+			Profiles:     userProfileService,
+			Identities:   b.identities,
+			Cache:        b.cache,
+			Audit:        auditLog,
+			HTTPClient:   &http.Client{Timeout: oidcRequestTimeout}}
+	}
+	// authors are changed in messages waiting to be indexed too
+	messages := &sockchat.PipelinedMessageStore{SockchatMessageStore: b.messages, Pipeline: indexing}
 	coreService := &services.SockchatCoreService{
 		UserProfiles:   userProfileService,
-		Messages:       messageStore,
+		Messages:       messages,
 		ChatChannels:   channelStore,
-		ConnectedUsers: connectedUsers}
-	userReports := storage.NewReportsService(es, os.Getenv("ES_MESSAGES_INDEX"))
+		ConnectedUsers: connectedUsers,
+		PasswordResets: passwordResets,
+		PersonalData: &sockchat.PersonalDataService{
+			Profiles:   userProfileService,
+			Messages:   messages,
+			Sessions:   sessionService,
+			APIKeys:    b.apiKeys,
+			Identities: b.identities,
+			Blocks:     blockList,
+			Prefs:      preferences,
+			Audit:      auditLog},
+		BlockList:   blockList,
+		Preferences: preferences,
+		Sessions:    sessionService,
+		Audit:       auditLog,
+		Retention:   retention.Policy}
 
 	httpRouter := http.NewServeMux()
 
 	webAPI := services.NewWebAPI(coreService, authService)
 	webAPI.HandleRequests(httpRouter)
-	grpcAPI := services.NewSockchatGRPCServer(coreService, authService, userReports)
-	services.ServeGRPC(grpcAPI, grpcPort)
-	messagingAPI := &services.MessagingAPI{TimeoutAuthorized: defaultTimeoutAuthorized, TimeoutUnauthorized: defaultTimeoutUnauthorized, ConnectedUsers: connectedUsers, UserProfiles: userProfileService}
+	messagingAPI := &services.MessagingAPI{
+		TimeoutAuthorized:   defaultTimeoutAuthorized,
+		TimeoutUnauthorized: defaultTimeoutUnauthorized,
+		ConnectedUsers:      connectedUsers,
+		UserProfiles:        userProfileService,
+		Sessions:            sessionService,
+		LoginGuard:          loginGuard,
+		APIKeys:             apiKeys,
+		TwoFactor:           twoFactor,
+		Compression:         mustReadCompressionOptions()}
 	messagingAPI.HandleRequests(httpRouter)
 
-	log.Fatal(http.ListenAndServe(":8080", httpRouter))
+	return &server{router: httpRouter, core: coreService, auth: authService, indexing: indexing, retention: retention, messaging: messagingAPI}
 }
 
 func mustConnectToMySql() *sql.DB {
@@ -77,13 +209,56 @@ func TestMySqlConnection(mysqlDB *sql.DB) {
 	}
 }
 
-func resetMySqlForDevEnvironment(mysqlDB *sql.DB) {
-	if os.Getenv("ENVIRONMENT") == "DEV" {
-		err := storage.ResetUsersTable(mysqlDB)
-		if err != nil {
-			log.Fatalf("error setting up the users table %v", err)
-		}
+// mustMigrateMySql brings the schema of the DB up to date before the server starts
+func mustMigrateMySql(mysqlDB *sql.DB) {
+	migrator, err := storage.NewMigrator(mysqlDB)
+	if err != nil {
+		log.Fatalf("could not load migrations: %v", err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		log.Fatalf("could not migrate the db: %v", err)
+	}
+}
+
+// messageBackend is a store of messages together with what background jobs need from it
+type messageBackend interface {
+	api.SockchatMessageStore
+	storage.BulkIndexer
+	storage.MessagePurger
+}
+
+// mustInitializeMessageStore keeps messages in ES unless MESSAGE_STORE selects an SQL backend with MESSAGE_STORE_DSN;
+// the index of messages is returned for ES only
+func mustInitializeMessageStore() (messageBackend, api.SockchatReportsService, *storage.MessagesIndex) {
+	backend := os.Getenv("MESSAGE_STORE")
+	if backend == "" || backend == "elasticsearch" {
+		TestElasticSearchConnection()
+		es := mustInitializeElasticSearchClient()
+		messagesIndex := mustSetUpMessagesIndex(es)
+		return storage.NewMessageStore(es, os.Getenv("ES_MESSAGES_INDEX")), storage.NewReportsService(es, os.Getenv("ES_MESSAGES_INDEX")), messagesIndex
 	}
+
+	messageStore, userReports := mustInitializeSQLMessageStore(backend, os.Getenv("MESSAGE_STORE_DSN"))
+	return messageStore, userReports, nil
+}
+
+func mustInitializeSQLMessageStore(backend, dsn string) (*storage.SQLMessageStore, *storage.SQLUserReports) {
+	db, err := storage.OpenSQLDB(backend, dsn)
+	if err != nil {
+		log.Fatalf("could not open db of messages: %v", err)
+	}
+	store, err := storage.NewSQLMessageStore(db, backend)
+	if err != nil {
+		log.Fatalf("could not create message store: %v", err)
+	}
+	if err := store.CreateTables(context.Background()); err != nil {
+		log.Fatalf("could not set up db of messages: %v", err)
+	}
+	reports, err := storage.NewSQLReportsService(db, backend)
+	if err != nil {
+		log.Fatalf("could not create reports service: %v", err)
+	}
+	return store, reports
 }
 
 func mustInitializeElasticSearchClient() *elasticsearch.Client {
@@ -94,6 +269,15 @@ func mustInitializeElasticSearchClient() *elasticsearch.Client {
 	return es
 }
 
+// mustSetUpMessagesIndex makes ES_MESSAGES_INDEX an alias of monthly indices of messages
+func mustSetUpMessagesIndex(es *elasticsearch.Client) *storage.MessagesIndex {
+	messagesIndex := storage.NewMessagesIndex(es, os.Getenv("ES_MESSAGES_INDEX"))
+	if err := messagesIndex.Setup(context.Background(), time.Now()); err != nil {
+		log.Fatalf("could not set up messages index: %v", err)
+	}
+	return messagesIndex
+}
+
 func mustInitializeRedisClient() *redis.Client {
 	dbIndex, err := strconv.Atoi(os.Getenv("REDIS_DB"))
 	if err != nil {
@@ -107,3 +291,73 @@ func mustInitializeRedisClient() *redis.Client {
 		},
 	)
 }
+
+// mustReadCompressionOptions reads optional WS_COMPRESSION_LEVEL, a flate level from -2 (Huffman only) to 9,
+// and WS_COMPRESSION_MIN_SIZE, the size in bytes from which messages are compressed
+func mustReadCompressionOptions() services.CompressionOptions {
+	options := services.CompressionOptions{Level: defaultCompressionLevel, Threshold: defaultCompressionMinSize}
+	if level := os.Getenv("WS_COMPRESSION_LEVEL"); level != "" {
+		var err error
+		options.Level, err = strconv.Atoi(level)
+		if err != nil || options.Level < flate.HuffmanOnly || options.Level > flate.BestCompression {
+			log.Fatalf("WS_COMPRESSION_LEVEL must be a number from %d to %d", flate.HuffmanOnly, flate.BestCompression)
+		}
+	}
+	if minSize := os.Getenv("WS_COMPRESSION_MIN_SIZE"); minSize != "" {
+		var err error
+		options.Threshold, err = strconv.Atoi(minSize)
+		if err != nil || options.Threshold < 0 {
+			log.Fatal("WS_COMPRESSION_MIN_SIZE must be a non-negative number of bytes")
+		}
+	}
+	return options
+}
+
+func mustInitializeAvatarStore() storage.BlobStore {
+	dir := os.Getenv("AVATARS_DIR")
+	if dir == "" {
+		log.Fatal("AVATARS_DIR must be set to a directory for uploaded avatars")
+	}
+	store, err := storage.NewLocalBlobStore(dir)
+	if err != nil {
+		log.Fatalf("could not initialize avatar store: %v", err)
+	}
+	return store
+}
+
+func mustInitializeIndexingPipeline(messageStore storage.BulkIndexer) *sockchat.IndexingPipeline {
+	dir := os.Getenv("INDEXING_SPOOL_DIR")
+	if dir == "" {
+		log.Fatal("INDEXING_SPOOL_DIR must be set to a directory for messages waiting to be indexed")
+	}
+	spool, err := storage.NewFileSpool(dir)
+	if err != nil {
+		log.Fatalf("could not initialize indexing spool: %v", err)
+	}
+	return &sockchat.IndexingPipeline{Store: messageStore, Spool: spool}
+}
+
+// mustInitializeRetentionJob reads MESSAGE_RETENTION, e.g. "90d", and per-channel MESSAGE_RETENTION_CHANNELS, e.g. "general=30d,random=7d"
+func mustInitializeRetentionJob(messageStore storage.MessagePurger) *sockchat.RetentionJob {
+	policy, err := sockchat.ParseRetentionPolicy(os.Getenv("MESSAGE_RETENTION"), os.Getenv("MESSAGE_RETENTION_CHANNELS"))
+	if err != nil {
+		log.Fatalf("could not parse message retention: %v", err)
+	}
+	return &sockchat.RetentionJob{Policy: policy, Store: messageStore}
+}
+
+func mustInitializeSessionService(cache *redis.Client) *sockchat.SessionService {
+	secret := os.Getenv("SESSION_SECRET")
+	if secret == "" {
+		log.Fatal("SESSION_SECRET must be set to sign session tokens")
+	}
+	return &sockchat.SessionService{Secret: []byte(secret), Cache: cache}
+}
+
+func mustInitializeTwoFactorService(profiles *sockchat.ProfileService, cache *redis.Client, audit *sockchat.LogAuditLog) *sockchat.TwoFactorService {
+	key, err := hex.DecodeString(os.Getenv("TOTP_ENCRYPTION_KEY"))
+	if err != nil || len(key) != 32 {
+		log.Fatal("TOTP_ENCRYPTION_KEY must be set to 64 hex characters (AES-256 key) to encrypt TOTP secrets")
+	}
+	return &sockchat.TwoFactorService{Profiles: profiles, Cache: cache, Audit: audit, EncryptionKey: key}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/kacperf531/sockchat/storage"
+)
+
+// runMigrateCommand handles `migrate [up]`, `migrate down [steps]` and `migrate status` run against the MySQL DB
+func runMigrateCommand(args []string) {
+	mySqlDb := mustConnectToMySql()
+	TestMySqlConnection(mySqlDb)
+	migrator, err := storage.NewMigrator(mySqlDb)
+	if err != nil {
+		log.Fatalf("could not load migrations: %v", err)
+	}
+	ctx := context.Background()
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			log.Fatalf("could not migrate the db: %v", err)
+		}
+		fmt.Printf("applied %d migrations\n", applied)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatalf("invalid number of migrations to revert: %q", args[1])
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		if err != nil {
+			log.Fatalf("could not revert migrations: %v", err)
+		}
+		fmt.Printf("reverted %d migrations\n", reverted)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			log.Fatalf("could not read migrations: %v", err)
+		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = time.Unix(status.AppliedAt, 0).Format(time.RFC3339)
+			}
+			fmt.Printf("%04d %-32s %s\n", status.Version, status.Name, appliedAt)
+		}
+	default:
+		log.Fatalf("unknown migrate command %q, expected up, down [steps] or status", command)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !dev
+
+package main
+
+// devBackends is nil in production builds; --dev-inmemory is available in builds with -tags dev
+func devBackends() *backends {
+	return nil
+}
@@ -7,21 +7,13 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
-func TestRedisConnection() {
+func TestRedisConnection(rdb *redis.Client) {
 	fmt.Print("\n###############################\n")
 	fmt.Print("# Testing Redis connection... #\n")
 	fmt.Print("###############################\n")
 
 	ctx := context.Background()
 
-	rdb := redis.NewClient(
-		&redis.Options{
-			Addr:     "localhost:6379",
-			Password: "", // no password set
-			DB:       0,  // use default DB
-		},
-	)
-
 	err := rdb.Set(ctx, "key", "value", 0).Err()
 	if err != nil {
 		panic(err)